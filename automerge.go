@@ -52,6 +52,14 @@ If you wish to modify the document, or access just a subset, use a Path:
 	err := doc.Path("x", "y", 0).Set(&myStruct{Header: "h"})
 	v, err := automerge.As[*myStruct](doc.Path("x", "y", 0).Get())
 
+Paths can also be parsed from strings, either as JSON Pointers or using
+the dotted syntax returned by [Path.String]. Unlike [Doc.Path], [ParsePath]
+returns an error (instead of panicking) on invalid input:
+
+	p, err := automerge.ParsePath("tasks[3].title") // or "/tasks/3/title"
+	if err != nil { return err }
+	v, err := doc.Path(p).Get()
+
 It is always recommended to write the smallest change to the document, as this
 will improve the experience of other collaborative editors.
 
@@ -117,7 +125,12 @@ making changes in one place and syncing them to another, you can use [Doc.SaveIn
 
 If both peers are making changes you can use a [SyncState] object to keep them
 in sync.  This wraps an underlying efficient sync protocol to minimize both
-round-trips and bandwidth used.
+round-trips and bandwidth used. If the peers are connected by a stream (for example a
+TCP connection) [SyncConn] runs the protocol for you:
+
+	err := automerge.SyncConn(ctx, doc, conn)
+
+Otherwise you can exchange messages yourself:
 
 	//* process 1 *
 	syncState := automerge.NewSyncState(doc)
@@ -169,6 +182,26 @@ the implementor to ensure an absense of bugs.
 		err := doc.Apply(changes)
 	}
 
+# Errors and panics
+
+Methods that read input (such as [Load], [Doc.Apply] or [SyncState.ReceiveMessage])
+return an error if the input is invalid, and never panic. A few methods
+without an error result ([New], [Doc.Save], [Doc.Heads], [SyncState.GenerateMessage]
+and others) panic if the automerge core unexpectedly fails. Each of these has a
+Try variant (such as [TryNew], [Doc.TrySave] or [SyncState.TryGenerateMessage])
+that returns the error instead, for use in long-running servers. The helpers
+in this package (such as [SyncConn], [SyncHub], [SyncMux] and [Journal]) and in
+its subpackages use the Try variants, so failures are returned as errors.
+
+# Memory
+
+Documents, changes and sync states are stored in memory allocated by the
+automerge core, which is freed when the Go garbage collector finalizes them.
+The garbage collector does not see this memory, so processes that handle many
+large documents should call [Doc.Close] (and [Change.Close] and
+[SyncState.Close]) when they are done with them. [Doc.MemoryStats] estimates
+the memory held by a document, and [CheckLeaks] can be used in tests.
+
 [automerge]: https://automerge.org
 [automerge-rs]: https://github.com/automerge/automerge-rs
 */
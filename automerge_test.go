@@ -1,8 +1,10 @@
 package automerge_test
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/hex"
+	"fmt"
 	"runtime"
 	"testing"
 	"time"
@@ -634,6 +636,39 @@ func TestDoc_Changes(t *testing.T) {
 	_, err = automerge.LoadChanges([]byte{'\xf1'})
 	require.Error(t, err)
 	require.Contains(t, err.Error(), "unable to parse")
+
+	// a single change can be loaded without its dependencies
+	ch, err := automerge.LoadChange(diff[0].Save())
+	require.NoError(t, err)
+	require.Equal(t, diff[0].Hash(), ch.Hash())
+	_, err = automerge.LoadChange([]byte{'\xf1'})
+	require.Error(t, err)
+}
+
+func TestDoc_ApplySubset(t *testing.T) {
+	doc := automerge.New()
+	require.NoError(t, doc.Path("a").Set(1))
+	first, err := doc.Commit("a")
+	require.NoError(t, err)
+	require.NoError(t, doc.Path("b").Set(2))
+	_, err = doc.Commit("b")
+	require.NoError(t, err)
+
+	// changes returned together by Changes can be applied one at a time,
+	// without the others in the same result being applied too
+	changes, err := doc.Changes()
+	require.NoError(t, err)
+	require.Len(t, changes, 2)
+
+	doc2 := automerge.New()
+	require.NoError(t, doc2.Apply(changes[0]))
+	require.Equal(t, []automerge.ChangeHash{first}, doc2.Heads())
+	keys, err := doc2.RootMap().Keys()
+	require.NoError(t, err)
+	require.Equal(t, []string{"a"}, keys)
+
+	require.NoError(t, doc2.Apply(changes[1]))
+	require.Equal(t, doc.Heads(), doc2.Heads())
 }
 
 func TestIncremental(t *testing.T) {
@@ -742,6 +777,271 @@ func TestSyncState(t *testing.T) {
 	require.Equal(t, map[string]int{"s": 20, "c": 20}, cV)
 }
 
+func TestSyncState_Introspection(t *testing.T) {
+	doc1 := automerge.New()
+	require.NoError(t, doc1.Path("x").Set(1))
+	_, err := doc1.Commit("x")
+	require.NoError(t, err)
+	doc2 := automerge.New()
+
+	s1 := automerge.NewSyncState(doc1)
+	s2 := automerge.NewSyncState(doc2)
+	require.True(t, s1.Equal(s2))
+
+	_, ok, err := s1.TheirHeads()
+	require.NoError(t, err)
+	require.False(t, ok)
+	_, ok, err = s1.TheirNeeds()
+	require.NoError(t, err)
+	require.False(t, ok)
+	_, ok, err = s1.TheirHaves()
+	require.NoError(t, err)
+	require.False(t, ok)
+	shared, err := s1.SharedHeads()
+	require.NoError(t, err)
+	require.Empty(t, shared)
+	sent, err := s1.LastSentHeads()
+	require.NoError(t, err)
+	require.Empty(t, sent)
+
+	m1, valid := s1.GenerateMessage()
+	require.True(t, valid)
+	require.Equal(t, doc1.Heads(), m1.Heads())
+	sent, err = s1.LastSentHeads()
+	require.NoError(t, err)
+	require.Equal(t, doc1.Heads(), sent)
+	require.Empty(t, m1.Needs())
+	require.Equal(t, []automerge.SyncHave{{LastSync: []automerge.ChangeHash{}}}, m1.Haves())
+	require.False(t, s1.Equal(s2))
+
+	_, err = s2.ReceiveMessage(m1.Bytes())
+	require.NoError(t, err)
+	heads, ok, err := s2.TheirHeads()
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, doc1.Heads(), heads)
+	needs, ok, err := s2.TheirNeeds()
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Empty(t, needs)
+	haves, ok, err := s2.TheirHaves()
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, m1.Haves(), haves)
+
+	m2, valid := s2.GenerateMessage()
+	require.True(t, valid)
+	require.Equal(t, doc1.Heads(), m2.Needs())
+
+	_, err = s1.ReceiveMessage(m2.Bytes())
+	require.NoError(t, err)
+	needs, ok, err = s1.TheirNeeds()
+	require.NoError(t, err)
+	require.True(t, ok)
+	require.Equal(t, doc1.Heads(), needs)
+
+	for {
+		m, valid := s1.GenerateMessage()
+		if !valid {
+			break
+		}
+		_, err = s2.ReceiveMessage(m.Bytes())
+		require.NoError(t, err)
+		if m, valid = s2.GenerateMessage(); !valid {
+			break
+		}
+		_, err = s1.ReceiveMessage(m.Bytes())
+		require.NoError(t, err)
+	}
+	require.Equal(t, doc1.Heads(), doc2.Heads())
+	shared, err = s1.SharedHeads()
+	require.NoError(t, err)
+	require.Equal(t, doc1.Heads(), shared)
+	shared, err = s2.SharedHeads()
+	require.NoError(t, err)
+	require.Equal(t, doc2.Heads(), shared)
+
+	// the accessors return errors instead of panicking
+	require.NoError(t, s1.Close())
+	_, err = s1.SharedHeads()
+	require.ErrorIs(t, err, automerge.ErrClosed)
+	_, _, err = s1.TheirHeads()
+	require.ErrorIs(t, err, automerge.ErrClosed)
+}
+
+func TestDoc_Hooks(t *testing.T) {
+	doc := automerge.New()
+	applied := 0
+	committed := []automerge.ChangeHash{}
+	remove := doc.AddHooks(automerge.Hooks{
+		BeforeCommit: func(d *automerge.Doc) error {
+			v, err := automerge.As[string](d.Path("x").Get())
+			if err == nil && v == "bad" {
+				return fmt.Errorf("rejected")
+			}
+			return err
+		},
+		AfterCommit: func(d *automerge.Doc, ch automerge.ChangeHash) {
+			committed = append(committed, ch)
+		},
+		AfterApply: func(d *automerge.Doc, before []automerge.ChangeHash) {
+			applied++
+		},
+	})
+
+	require.NoError(t, doc.Path("x").Set("good"))
+	good, err := doc.Commit("good")
+	require.NoError(t, err)
+	require.Equal(t, []automerge.ChangeHash{good}, committed)
+
+	require.NoError(t, doc.Path("x").Set("bad"))
+	_, err = doc.Commit("bad")
+	require.EqualError(t, err, "rejected")
+	v, err := automerge.As[string](doc.Path("x").Get())
+	require.NoError(t, err)
+	require.Equal(t, "good", v)
+	require.Len(t, committed, 1)
+
+	// implicit commits run the hooks too
+	require.NoError(t, doc.Path("x").Set("bad"))
+	_, err = doc.TrySave()
+	require.EqualError(t, err, "rejected")
+	require.NoError(t, doc.Path("x").Set("implicit"))
+	require.Equal(t, doc.Heads(), committed[1:])
+
+	other := automerge.New()
+	require.NoError(t, other.Path("y").Set(1))
+	_, err = other.Commit("other")
+	require.NoError(t, err)
+
+	changes, err := other.Changes()
+	require.NoError(t, err)
+	require.NoError(t, doc.Apply(changes...))
+	require.Equal(t, 1, applied)
+	require.NoError(t, doc.Apply(changes...))
+	require.Equal(t, 1, applied)
+
+	remove()
+	require.NoError(t, other.Path("y").Set(2))
+	_, err = other.Commit("other")
+	require.NoError(t, err)
+	_, err = doc.Merge(other)
+	require.NoError(t, err)
+	require.Equal(t, 1, applied)
+}
+
+func TestTryVariants(t *testing.T) {
+	doc, err := automerge.TryNew()
+	require.NoError(t, err)
+	actor, err := doc.TryActorID()
+	require.NoError(t, err)
+	require.Equal(t, doc.ActorID(), actor)
+
+	require.NoError(t, doc.Path("list").Set([]any{"a", automerge.NewCounter(1)}))
+	h, err := doc.Commit("list")
+	require.NoError(t, err)
+
+	heads, err := doc.TryHeads()
+	require.NoError(t, err)
+	require.Equal(t, []automerge.ChangeHash{h}, heads)
+
+	saved, err := doc.TrySave()
+	require.NoError(t, err)
+	require.Equal(t, doc.Save(), saved)
+	incremental, err := doc.TrySaveIncremental()
+	require.NoError(t, err)
+	require.Empty(t, incremental)
+
+	ch, err := doc.Change(h)
+	require.NoError(t, err)
+	chActor, err := ch.TryActorID()
+	require.NoError(t, err)
+	require.Equal(t, actor, chActor)
+	deps, err := ch.TryDependencies()
+	require.NoError(t, err)
+	require.Empty(t, deps)
+
+	v, err := doc.Path("list").Get()
+	require.NoError(t, err)
+	i, err := v.TryInterface()
+	require.NoError(t, err)
+	require.Equal(t, []any{"a", int64(1)}, i)
+
+	ss := automerge.NewSyncState(doc)
+	msg, valid, err := ss.TryGenerateMessage()
+	require.NoError(t, err)
+	require.True(t, valid)
+	chs, err := msg.TryChanges()
+	require.NoError(t, err)
+	require.Empty(t, chs)
+	msgHeads, err := msg.TryHeads()
+	require.NoError(t, err)
+	require.Equal(t, heads, msgHeads)
+	needs, err := msg.TryNeeds()
+	require.NoError(t, err)
+	require.Empty(t, needs)
+	haves, err := msg.TryHaves()
+	require.NoError(t, err)
+	require.Equal(t, msg.Haves(), haves)
+	b, err := msg.TryBytes()
+	require.NoError(t, err)
+	require.Equal(t, msg.Bytes(), b)
+	ssBytes, err := ss.TrySave()
+	require.NoError(t, err)
+	require.Equal(t, ss.Save(), ssBytes)
+	ss2, err := automerge.TryNewSyncState(doc)
+	require.NoError(t, err)
+	require.Equal(t, doc, ss2.Doc)
+
+	id, err := automerge.TryNewActorID()
+	require.NoError(t, err)
+	require.Len(t, id, 32)
+}
+
+func TestTryVariants_Errors(t *testing.T) {
+	doc := automerge.New()
+	require.NoError(t, doc.Path("list").Set([]any{"a"}))
+	h, err := doc.Commit("list")
+	require.NoError(t, err)
+	ch, err := doc.Change(h)
+	require.NoError(t, err)
+	v, err := doc.Path("list").Get()
+	require.NoError(t, err)
+	ss := automerge.NewSyncState(doc)
+	require.NoError(t, ss.Close())
+	require.NoError(t, ch.Close())
+	require.NoError(t, doc.Close())
+
+	_, err = doc.TryHeads()
+	require.ErrorIs(t, err, automerge.ErrClosed)
+	_, err = doc.TrySave()
+	require.ErrorIs(t, err, automerge.ErrClosed)
+	_, err = doc.TrySaveIncremental()
+	require.ErrorIs(t, err, automerge.ErrClosed)
+	_, err = doc.TryActorID()
+	require.ErrorIs(t, err, automerge.ErrClosed)
+	_, err = ch.TryActorID()
+	require.ErrorIs(t, err, automerge.ErrClosed)
+	_, err = ch.TryDependencies()
+	require.ErrorIs(t, err, automerge.ErrClosed)
+	_, err = v.TryInterface()
+	require.ErrorIs(t, err, automerge.ErrClosed)
+	_, err = ss.TrySave()
+	require.ErrorIs(t, err, automerge.ErrClosed)
+	_, _, err = ss.TryGenerateMessage()
+	require.ErrorIs(t, err, automerge.ErrClosed)
+
+	// the panicking variants panic with the same error
+	require.PanicsWithError(t, "automerge: document is closed", func() { doc.Heads() })
+	require.Panics(t, func() { ss.Save() })
+
+	// and the helpers built on them return it
+	_, err = automerge.As[[]string](v)
+	require.ErrorIs(t, err, automerge.ErrClosed)
+	c1, _ := automerge.NewMessagePipe()
+	require.ErrorIs(t, automerge.SyncMessageConn(context.Background(), doc, c1), automerge.ErrClosed)
+}
+
 /*
 func FuzzLoad(f *testing.F) {
 	testcases := [][]byte{
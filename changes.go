@@ -39,48 +39,112 @@ type Change struct {
 	cChange *C.AMchange
 }
 
-// ActorID identifies the actor that made the change hex-encoded
+var errChangeClosed = newError(ErrClosed, "automerge: change is closed")
+
+// Close frees the native memory used by the change immediately, instead of
+// waiting for the garbage collector. Changes returned together (for example by
+// [Doc.Changes]) share memory, which is freed once all of them are closed.
+// After Close, methods that return errors return one matching [ErrClosed],
+// and other methods return zero values. Closing a change more than once has
+// no effect, but Close must not be called concurrently with other methods.
+func (c *Change) Close() error {
+	if c.cChange == nil {
+		return nil
+	}
+	c.cChange = nil
+	c.item.result.release()
+	return nil
+}
+
+// ActorID identifies the actor that made the change hex-encoded.
+// It panics if the actor id cannot be read, see [Change.TryActorID].
 func (c *Change) ActorID() string {
+	if c.cChange == nil {
+		return ""
+	}
+	return must(c.TryActorID())
+}
+
+// TryActorID is like [Change.ActorID] but returns an error instead of panicking
+func (c *Change) TryActorID() (string, error) {
 	defer runtime.KeepAlive(c)
-	return must(wrap(C.AMchangeActorId(c.cChange)).item()).actorID().String()
+	if c.cChange == nil {
+		return "", errChangeClosed
+	}
+	item, err := wrap(C.AMchangeActorId(c.cChange)).item()
+	if err != nil {
+		return "", err
+	}
+	return item.actorID().String(), nil
 }
 
 // ActorSeq is 1 for the first change by a given
 // actor, 2 for the next, and so on.
 func (c *Change) ActorSeq() uint64 {
 	defer runtime.KeepAlive(c)
+	if c.cChange == nil {
+		return 0
+	}
 	return uint64(C.AMchangeSeq(c.cChange))
 }
 
 // Hash identifies the change by the SHA-256 of its binary representation
 func (c *Change) Hash() ChangeHash {
 	defer runtime.KeepAlive(c)
+	if c.cChange == nil {
+		return ChangeHash{}
+	}
 	return *(*ChangeHash)(fromByteSpan(C.AMchangeHash(c.cChange)))
 }
 
 // Dependencies returns the hashes of all changes that this change
 // directly depends on.
+// It panics if the dependencies cannot be read, see [Change.TryDependencies].
 func (c *Change) Dependencies() []ChangeHash {
+	if c.cChange == nil {
+		return nil
+	}
+	return must(c.TryDependencies())
+}
+
+// TryDependencies is like [Change.Dependencies] but returns an error instead
+// of panicking
+func (c *Change) TryDependencies() ([]ChangeHash, error) {
 	defer runtime.KeepAlive(c)
-	items := must(wrap(C.AMchangeDeps(c.cChange)).items())
-	return mapItems(items, func(i *item) ChangeHash { return i.changeHash() })
+	if c.cChange == nil {
+		return nil, errChangeClosed
+	}
+	items, err := wrap(C.AMchangeDeps(c.cChange)).items()
+	if err != nil {
+		return nil, err
+	}
+	return mapItems(items, func(i *item) ChangeHash { return i.changeHash() }), nil
 }
 
 // Message returns the commit message (if any)
 func (c *Change) Message() string {
 	defer runtime.KeepAlive(c)
+	if c.cChange == nil {
+		return ""
+	}
 	return fromByteSpanStr(C.AMchangeMessage(c.cChange))
 }
 
 // Timestamp returns the commit time (or the zero time if one was not set)
 func (c *Change) Timestamp() time.Time {
 	defer runtime.KeepAlive(c)
+	if c.cChange == nil {
+		return time.Time{}
+	}
 	return time.UnixMilli(int64(C.AMchangeTime(c.cChange)))
 }
 
 // Save exports the change for transferring between systems
 func (c *Change) Save() []byte {
 	defer runtime.KeepAlive(c)
+	if c.cChange == nil {
+		return nil
+	}
 	return fromByteSpan(C.AMchangeRawBytes(c.cChange))
 }
 
@@ -91,11 +155,24 @@ func LoadChanges(raw []byte) ([]*Change, error) {
 
 	ret, err := wrap(C.AMchangeLoadDocument(cBytes.src, cBytes.count)).items()
 	if err != nil {
-		return nil, err
+		return nil, markCorrupt(err)
 	}
 	return mapItems(ret, func(i *item) *Change { return i.change() }), nil
 }
 
+// LoadChange loads a single change from the bytes returned by [Change.Save].
+// Unlike [LoadChanges] the change's dependencies need not be present.
+func LoadChange(raw []byte) (*Change, error) {
+	cBytes, free := toByteSpan(raw)
+	defer free()
+
+	ret, err := wrap(C.AMchangeFromBytes(cBytes.src, cBytes.count)).item()
+	if err != nil {
+		return nil, markCorrupt(err)
+	}
+	return ret.change(), nil
+}
+
 // SaveChanges saves multiple changes to bytes (see also [LoadChanges])
 func SaveChanges(cs []*Change) []byte {
 	out := []byte{}
@@ -14,82 +14,17 @@ import (
 	"strings"
 	"time"
 	"unicode/utf8"
-)
-
-// from: https://github.com/aviate-labs/leb128/blob/v0.1.0/leb.go#L13
-func leb128(n uint64) []byte {
-	leb := make([]byte, 0)
-	if n == 0 {
-		return []byte{0}
-	}
-	for n != 0x00 {
-		b := byte(n & 0x7F)
-		n >>= 7
-		if n != 0x00 {
-			b |= 0x80
-		}
-		leb = append(leb, b)
-	}
-	return leb
-}
 
-func readULEB(b []byte) (uint64, []byte, error) {
-	var n uint64
-	var i int
-	for {
-		if len(b) == 0 {
-			return 0, nil, fmt.Errorf("failed to find end of LEB")
-		}
-
-		c := b[0]
-		b = b[1:]
-		n |= uint64(c&0x7f) << (i * 7)
-		i++
-		if c&0x80 == 0 {
-			if c == 0 && i > 1 {
-				return n, b, fmt.Errorf("overly long LEB")
-			}
-			if i > 10 {
-				return n, b, fmt.Errorf("LEB > 64-bit")
-			}
-			if i == 10 && c&0x7e > 0 {
-				return n, b, fmt.Errorf("LEB > 64-bit")
-			}
-			return n, b, nil
-		}
-	}
-}
+	"github.com/automerge/automerge-go/internal/columnar"
+)
 
-func readSLEB(b []byte) (int64, []byte, error) {
-	var n uint64
-	var i int
-	var prev byte
-	for {
-		if len(b) == 0 {
-			return 0, nil, fmt.Errorf("failed to find end of LEB")
-		}
+var (
+	readULEB = columnar.ReadULEB
+	readSLEB = columnar.ReadSLEB
+)
 
-		c := b[0]
-		b = b[1:]
-		n |= uint64(c&0x7f) << (i * 7)
-		i++
-		if c&0x80 == 0 {
-			if c&0x40 > 0 {
-				n |= (math.MaxUint64 << (i * 7))
-			}
-			if i > 1 && (prev&0x40 == 0 && c == 0 || prev&0x40 > 0 && c == 0x7f) {
-				return int64(n), b, fmt.Errorf("overly long LEB")
-			}
-			if i > 10 {
-				return int64(n), b, fmt.Errorf("LEB > 64-bit")
-			}
-			if i == 10 && c&0x7f != 0 && c&0x7f != 0x7f {
-				return int64(n), b, fmt.Errorf("LEB > 64-bit")
-			}
-			return int64(n), b, nil
-		}
-		prev = c
-	}
+func leb128(n uint64) []byte {
+	return columnar.AppendULEB(nil, n)
 }
 
 var magicBytes = [4]byte{133, 111, 74, 131}
@@ -229,9 +164,7 @@ func compressChunk(b []byte) []byte {
 func decompressChunk(b []byte) []byte {
 	chunk := validateChunk(b, 2)
 
-	r := flate.NewReader(bytes.NewReader(chunk))
-
-	out, err := io.ReadAll(r)
+	out, err := columnar.Inflate(chunk, columnar.InflateLimit(len(chunk)))
 	if err != nil {
 		inputErr("failed to decompress: %v", err)
 	}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/automerge/automerge-go/ws"
+)
+
+func main() {
+	dir := ""
+	tcpAddr := ""
+	wsAddr := ""
+	metricsAddr := ""
+	shutdownTimeout := time.Duration(0)
+	opts := serverOptions{}
+
+	flag.Usage = func() {
+		fmt.Print(`usage: automerge-relay --dir=<dir> [--tcp=<addr>] [--ws=<addr>] [--metrics=<addr>]
+automerge-relay stores automerge documents in a directory, and syncs them with
+any number of clients.
+
+Clients connect over TCP (using the framing of automerge.SyncConn) or over a
+WebSocket at /sync, and use automerge.SyncMux to sync many documents over one
+connection. Documents are created the first time a client subscribes to them.
+`)
+		flag.PrintDefaults()
+	}
+	flag.StringVar(&dir, "dir", "", "directory to store documents in (required)")
+	flag.StringVar(&tcpAddr, "tcp", "", "address to accept TCP connections on, e.g. :7070")
+	flag.StringVar(&wsAddr, "ws", "", "address to accept WebSocket connections on, e.g. :8080")
+	flag.StringVar(&metricsAddr, "metrics", "127.0.0.1:9090", "address to serve metrics on (empty to disable)")
+	flag.DurationVar(&shutdownTimeout, "shutdown-timeout", 10*time.Second, "how long to wait for connections to close on shutdown")
+	flag.IntVar(&opts.maxDocuments, "max-documents", 10000, "maximum number of documents to store (0 for no limit)")
+	flag.IntVar(&opts.maxSubscriptions, "max-subscriptions", 1000, "maximum number of documents one connection can sync (0 for no limit)")
+	flag.Parse()
+
+	if dir == "" || (tcpAddr == "" && wsAddr == "") {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		log.Fatal(err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	m := &metrics{}
+	s, err := newServer(dir, m, opts)
+	if err != nil {
+		log.Fatal(err)
+	}
+	errs := make(chan error, 3)
+	closers := []func() error{}
+
+	if tcpAddr != "" {
+		l, err := net.Listen("tcp", tcpAddr)
+		if err != nil {
+			log.Fatal(err)
+		}
+		log.Printf("accepting TCP connections on %s", l.Addr())
+		closers = append(closers, l.Close)
+		go func() { errs <- s.serveTCP(l) }()
+	}
+
+	servers := []*http.Server{}
+	if wsAddr != "" {
+		mux := http.NewServeMux()
+		// clients are not browsers, so the origin is not checked
+		mux.Handle("/sync", s.wsHandler(ws.Upgrader{CheckOrigin: func(*http.Request) bool { return true }}))
+		servers = append(servers, &http.Server{Addr: wsAddr, Handler: mux})
+		log.Printf("accepting WebSocket connections on %s/sync", wsAddr)
+	}
+	if metricsAddr != "" {
+		mux := http.NewServeMux()
+		mux.Handle("/metrics", m)
+		servers = append(servers, &http.Server{Addr: metricsAddr, Handler: mux})
+		log.Printf("serving metrics on %s/metrics", metricsAddr)
+	}
+	for _, hs := range servers {
+		hs := hs
+		go func() {
+			if err := hs.ListenAndServe(); !errors.Is(err, http.ErrServerClosed) {
+				errs <- err
+			}
+		}()
+	}
+
+	select {
+	case <-ctx.Done():
+		log.Printf("shutting down")
+	case err := <-errs:
+		log.Printf("error: %v", err)
+	}
+
+	// stop accepting new connections, then disconnect existing ones
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	for _, c := range closers {
+		c()
+	}
+	for _, hs := range servers {
+		hs.Shutdown(shutdownCtx)
+	}
+	if err := s.shutdown(shutdownCtx); err != nil {
+		log.Printf("shutdown: %v", err)
+		os.Exit(1)
+	}
+}
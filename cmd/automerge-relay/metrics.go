@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync/atomic"
+)
+
+// metrics are exposed in the Prometheus text format
+type metrics struct {
+	connections      atomic.Int64
+	connectionsTotal atomic.Int64
+	documents        atomic.Int64
+	messagesReceived atomic.Int64
+	messagesSent     atomic.Int64
+	bytesReceived    atomic.Int64
+	bytesSent        atomic.Int64
+	errors           atomic.Int64
+}
+
+func (m *metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	for _, metric := range []struct {
+		name, typ, help string
+		value           *atomic.Int64
+	}{
+		{"automerge_relay_connections", "gauge", "Number of connected clients.", &m.connections},
+		{"automerge_relay_connections_total", "counter", "Number of clients that have connected.", &m.connectionsTotal},
+		{"automerge_relay_documents", "gauge", "Number of documents loaded.", &m.documents},
+		{"automerge_relay_messages_received_total", "counter", "Number of messages received from clients.", &m.messagesReceived},
+		{"automerge_relay_messages_sent_total", "counter", "Number of messages sent to clients.", &m.messagesSent},
+		{"automerge_relay_received_bytes_total", "counter", "Bytes of messages received from clients.", &m.bytesReceived},
+		{"automerge_relay_sent_bytes_total", "counter", "Bytes of messages sent to clients.", &m.bytesSent},
+		{"automerge_relay_errors_total", "counter", "Number of connection and storage errors.", &m.errors},
+	} {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %d\n", metric.name, metric.help, metric.name, metric.typ, metric.name, metric.value.Load())
+	}
+}
@@ -0,0 +1,372 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/automerge/automerge-go"
+	"github.com/automerge/automerge-go/ws"
+)
+
+// validID restricts document IDs to names that are safe to use as file names
+var validID = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9_.-]{0,127}$`)
+
+// server keeps a journaled copy of each document that a client has
+// subscribed to, and syncs it with every connected client.
+type server struct {
+	dir     string
+	opts    serverOptions
+	metrics *metrics
+
+	m      sync.Mutex
+	docs   map[string]*document
+	stored int
+
+	// conns tracks running connections for graceful shutdown
+	ctx     context.Context
+	cancel  context.CancelFunc
+	conns   sync.WaitGroup
+	closing bool
+}
+
+// serverOptions limit the resources that clients can use
+type serverOptions struct {
+	// maxDocuments is the number of documents that can be stored,
+	// new documents are rejected once it is reached
+	maxDocuments int
+	// maxSubscriptions is the number of documents one connection can sync
+	maxSubscriptions int
+}
+
+// document is a loaded document. The journal is opened and closed without
+// holding server.m; ready is closed once it has been opened (or failed to
+// open, in which case err is set), and closed once it has been closed.
+type document struct {
+	journal *automerge.Journal
+	err     error
+	refs    int
+	ready   chan struct{}
+	closing bool
+	closed  chan struct{}
+}
+
+func newServer(dir string, m *metrics, opts serverOptions) (*server, error) {
+	stored, err := countDocuments(dir)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	return &server{dir: dir, opts: opts, metrics: m, docs: map[string]*document{}, stored: stored, ctx: ctx, cancel: cancel}, nil
+}
+
+// countDocuments returns the number of documents stored in dir
+func countDocuments(dir string) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+	ids := map[string]bool{}
+	for _, e := range entries {
+		name := strings.TrimSuffix(e.Name(), ".log")
+		if id := strings.TrimSuffix(name, ".automerge"); id != name && validID.MatchString(id) {
+			ids[id] = true
+		}
+	}
+	return len(ids), nil
+}
+
+func (s *server) path(id string) string {
+	return filepath.Join(s.dir, id+".automerge")
+}
+
+// exists reports whether the document is stored
+func (s *server) exists(id string) bool {
+	for _, p := range []string{s.path(id), s.path(id) + ".log"} {
+		if _, err := os.Stat(p); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// acquire loads a document (creating it if necessary) and keeps it
+// loaded until release is called.
+func (s *server) acquire(id string) (*automerge.Doc, error) {
+	if !validID.MatchString(id) {
+		return nil, fmt.Errorf("invalid document ID %q", id)
+	}
+	for {
+		s.m.Lock()
+		if s.closing {
+			s.m.Unlock()
+			return nil, fmt.Errorf("server is shutting down")
+		}
+		d := s.docs[id]
+		if d != nil && d.closing {
+			// wait for the journal to be closed before opening it again
+			s.m.Unlock()
+			<-d.closed
+			continue
+		}
+		if d != nil {
+			d.refs++
+			s.m.Unlock()
+			<-d.ready
+			if d.err != nil {
+				return nil, d.err
+			}
+			return d.journal.Doc(), nil
+		}
+
+		d = &document{refs: 1, ready: make(chan struct{}), closed: make(chan struct{})}
+		s.docs[id] = d
+		s.m.Unlock()
+
+		d.journal, d.err = s.open(id)
+		if d.err != nil {
+			s.m.Lock()
+			delete(s.docs, id)
+			s.m.Unlock()
+			close(d.ready)
+			close(d.closed)
+			return nil, d.err
+		}
+		s.metrics.documents.Add(1)
+		close(d.ready)
+		return d.journal.Doc(), nil
+	}
+}
+
+// open opens the journal for a document, if the limit on the number
+// of documents allows it
+func (s *server) open(id string) (*automerge.Journal, error) {
+	created := false
+	if !s.exists(id) {
+		s.m.Lock()
+		if s.opts.maxDocuments > 0 && s.stored >= s.opts.maxDocuments {
+			s.m.Unlock()
+			return nil, fmt.Errorf("cannot create %q: too many documents", id)
+		}
+		s.stored++
+		s.m.Unlock()
+		created = true
+	}
+	j, err := automerge.OpenJournaled(s.path(id), automerge.JournalOptions{
+		OnError: func(err error) {
+			s.metrics.errors.Add(1)
+			log.Printf("document %s: %v", id, err)
+		},
+	})
+	if err != nil && created && !s.exists(id) {
+		s.m.Lock()
+		s.stored--
+		s.m.Unlock()
+	}
+	return j, err
+}
+
+// release unloads a document once no connection is using it
+func (s *server) release(id string) {
+	s.m.Lock()
+	d := s.docs[id]
+	if d == nil || d.closing {
+		s.m.Unlock()
+		return
+	}
+	d.refs--
+	if d.refs > 0 {
+		s.m.Unlock()
+		return
+	}
+	d.closing = true
+	s.m.Unlock()
+
+	closeDocument(id, d)
+	s.metrics.documents.Add(-1)
+
+	s.m.Lock()
+	delete(s.docs, id)
+	s.m.Unlock()
+	close(d.closed)
+}
+
+func closeDocument(id string, d *document) {
+	err := d.journal.Checkpoint()
+	if e := d.journal.Close(); err == nil {
+		err = e
+	}
+	if err != nil {
+		log.Printf("document %s: %v", id, err)
+	}
+}
+
+// serve syncs documents with one client until it disconnects or
+// the server shuts down.
+func (s *server) serve(conn automerge.MessageConn, closer io.Closer, remote string) {
+	defer closer.Close()
+	s.m.Lock()
+	if s.closing {
+		s.m.Unlock()
+		return
+	}
+	s.conns.Add(1)
+	s.m.Unlock()
+	defer s.conns.Done()
+	s.metrics.connections.Add(1)
+	s.metrics.connectionsTotal.Add(1)
+	defer s.metrics.connections.Add(-1)
+
+	// m protects the documents acquired for this connection
+	var m sync.Mutex
+	acquired := []string{}
+	subscribed := 0
+	done := false
+
+	// the mux closes the connection, and waits for its reader, before Run returns
+	cc := &countingConn{conn: conn, closer: closer, metrics: s.metrics}
+	mux := automerge.NewSyncMux(cc, automerge.SyncMuxOptions{
+		OnSubscribe: func(id string) (*automerge.Doc, error) {
+			m.Lock()
+			if s.opts.maxSubscriptions > 0 && subscribed >= s.opts.maxSubscriptions {
+				m.Unlock()
+				log.Printf("%s: too many documents subscribed", remote)
+				return nil, fmt.Errorf("too many documents subscribed")
+			}
+			subscribed++
+			m.Unlock()
+
+			doc, err := s.acquire(id)
+			if err != nil {
+				m.Lock()
+				subscribed--
+				m.Unlock()
+				log.Printf("%s: %v", remote, err)
+				return nil, err
+			}
+			m.Lock()
+			if done {
+				m.Unlock()
+				s.release(id)
+				return nil, fmt.Errorf("connection closed")
+			}
+			acquired = append(acquired, id)
+			m.Unlock()
+			return doc, nil
+		},
+	})
+
+	// closing the connection unblocks the mux on shutdown, even while it is writing
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-s.ctx.Done():
+			closer.Close()
+		case <-stop:
+		}
+	}()
+
+	err := mux.Run(s.ctx)
+	// Run has unsubscribed (removing its hooks from documents that other
+	// clients keep loaded), so the documents can be released
+	m.Lock()
+	done = true
+	ids := acquired
+	m.Unlock()
+	for _, id := range ids {
+		s.release(id)
+	}
+	if err != nil && s.ctx.Err() == nil && !errors.Is(err, net.ErrClosed) {
+		s.metrics.errors.Add(1)
+		log.Printf("%s: %v", remote, err)
+	}
+}
+
+// serveTCP accepts connections that use the framing of [automerge.SyncConn]
+func (s *server) serveTCP(l net.Listener) error {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go s.serve(automerge.NewFramedConn(c), c, c.RemoteAddr().String())
+	}
+}
+
+// wsHandler accepts WebSocket connections
+func (s *server) wsHandler(u ws.Upgrader) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c, err := u.Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		s.serve(c, c, r.RemoteAddr)
+	})
+}
+
+// shutdown disconnects all clients and waits for their connections to
+// finish, at most until ctx is done. Each document is checkpointed and closed
+// when the last connection using it finishes, so a document is never closed
+// while a connection could still apply changes to it. If ctx is done first,
+// the documents that are still in use stay open, and their journals continue
+// to record every change.
+func (s *server) shutdown(ctx context.Context) error {
+	s.m.Lock()
+	s.closing = true
+	s.m.Unlock()
+	s.cancel()
+	done := make(chan struct{})
+	go func() {
+		s.conns.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// countingConn records message metrics
+type countingConn struct {
+	conn    automerge.MessageConn
+	closer  io.Closer
+	metrics *metrics
+}
+
+func (c *countingConn) Close() error {
+	return c.closer.Close()
+}
+
+func (c *countingConn) ReadMessage() ([]byte, error) {
+	msg, err := c.conn.ReadMessage()
+	if err == nil {
+		c.metrics.messagesReceived.Add(1)
+		c.metrics.bytesReceived.Add(int64(len(msg)))
+	}
+	return msg, err
+}
+
+func (c *countingConn) WriteMessage(msg []byte) error {
+	err := c.conn.WriteMessage(msg)
+	if err == nil {
+		c.metrics.messagesSent.Add(1)
+		c.metrics.bytesSent.Add(int64(len(msg)))
+	}
+	return err
+}
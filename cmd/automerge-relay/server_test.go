@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/automerge/automerge-go"
+	"github.com/automerge/automerge-go/ws"
+	"github.com/stretchr/testify/require"
+)
+
+// startServer runs a relay that stores documents in dir, and returns
+// its TCP address, its WebSocket URL and a function that shuts it down.
+func startServer(t *testing.T, dir string, opts serverOptions) (string, string, func() error) {
+	s, err := newServer(dir, &metrics{}, opts)
+	require.NoError(t, err)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go s.serveTCP(l)
+	hs := httptest.NewServer(s.wsHandler(ws.Upgrader{CheckOrigin: func(*http.Request) bool { return true }}))
+
+	stop := func() error {
+		l.Close()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err := s.shutdown(ctx)
+		hs.Close()
+		return err
+	}
+	t.Cleanup(func() { stop() })
+	return l.Addr().String(), "ws" + strings.TrimPrefix(hs.URL, "http") + "/sync", stop
+}
+
+// connect runs a SyncMux over TCP (if addr has no scheme) or a WebSocket
+func connect(t *testing.T, addr string, opts ...automerge.SyncMuxOptions) (*automerge.SyncMux, <-chan error) {
+	var conn automerge.MessageConn
+	if strings.HasPrefix(addr, "ws://") {
+		c, err := ws.Dial(context.Background(), addr)
+		require.NoError(t, err)
+		t.Cleanup(func() { c.Close() })
+		conn = c
+	} else {
+		c, err := net.Dial("tcp", addr)
+		require.NoError(t, err)
+		t.Cleanup(func() { c.Close() })
+		conn = automerge.NewFramedConn(c)
+	}
+	mux := automerge.NewSyncMux(conn, opts...)
+	done := make(chan error, 1)
+	go func() { done <- mux.Run(context.Background()) }()
+	return mux, done
+}
+
+func getString(doc *automerge.Doc, key string) string {
+	v, err := automerge.As[string](doc.Path(key).Get())
+	if err != nil {
+		return ""
+	}
+	return v
+}
+
+func set(t *testing.T, doc *automerge.Doc, key, value string) {
+	require.NoError(t, doc.Path(key).Set(value))
+	_, err := doc.Commit("set " + key)
+	require.NoError(t, err)
+}
+
+func TestServer(t *testing.T) {
+	dir := t.TempDir()
+	tcpAddr, wsURL, stop := startServer(t, dir, serverOptions{})
+
+	// a TCP client and a WebSocket client sync through the relay
+	tcpDoc := automerge.New()
+	set(t, tcpDoc, "tcp", "hello")
+	tcpMux, tcpDone := connect(t, tcpAddr)
+	require.NoError(t, tcpMux.Subscribe("notes", tcpDoc))
+
+	wsDoc := automerge.New()
+	set(t, wsDoc, "ws", "world")
+	wsMux, wsDone := connect(t, wsURL)
+	require.NoError(t, wsMux.Subscribe("notes", wsDoc))
+
+	require.Eventually(t, func() bool {
+		return getString(wsDoc, "tcp") == "hello" && getString(tcpDoc, "ws") == "world"
+	}, 5*time.Second, 5*time.Millisecond)
+
+	set(t, wsDoc, "ws", "again")
+	require.Eventually(t, func() bool { return getString(tcpDoc, "ws") == "again" }, 5*time.Second, 5*time.Millisecond)
+
+	// shutdown disconnects the clients and checkpoints the document
+	require.NoError(t, stop())
+	for _, done := range []<-chan error{tcpDone, wsDone} {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("client was not disconnected")
+		}
+	}
+	log, err := os.ReadFile(filepath.Join(dir, "notes.automerge.log"))
+	require.NoError(t, err)
+	require.Empty(t, log)
+
+	// a restarted relay has the document
+	tcpAddr, _, _ = startServer(t, dir, serverOptions{})
+	doc := automerge.New()
+	mux, _ := connect(t, tcpAddr)
+	require.NoError(t, mux.Subscribe("notes", doc))
+	require.Eventually(t, func() bool {
+		return getString(doc, "tcp") == "hello" && getString(doc, "ws") == "again"
+	}, 5*time.Second, 5*time.Millisecond)
+	require.Equal(t, tcpDoc.Heads(), doc.Heads())
+}
+
+// unavailable returns the next document rejected by the relay
+func unavailable(t *testing.T, ch <-chan string) string {
+	select {
+	case id := <-ch:
+		return id
+	case <-time.After(5 * time.Second):
+		t.Fatal("no document was rejected")
+		return ""
+	}
+}
+
+func TestServer_Limits(t *testing.T) {
+	t.Run("documents", func(t *testing.T) {
+		dir := t.TempDir()
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "existing.automerge.log"), nil, 0o644))
+		tcpAddr, _, _ := startServer(t, dir, serverOptions{maxDocuments: 2})
+
+		rejected := make(chan string, 10)
+		mux, _ := connect(t, tcpAddr, automerge.SyncMuxOptions{
+			OnUnavailable: func(id string) { rejected <- id },
+		})
+		// one more document can be created, and existing ones can be opened
+		for _, id := range []string{"new", "existing", "another"} {
+			doc := automerge.New()
+			set(t, doc, "x", id)
+			require.NoError(t, mux.Subscribe(id, doc))
+		}
+		require.Equal(t, "another", unavailable(t, rejected))
+		_, err := os.Stat(filepath.Join(dir, "another.automerge.log"))
+		require.ErrorIs(t, err, os.ErrNotExist)
+	})
+
+	t.Run("subscriptions", func(t *testing.T) {
+		tcpAddr, _, _ := startServer(t, t.TempDir(), serverOptions{maxSubscriptions: 2})
+
+		rejected := make(chan string, 10)
+		mux, _ := connect(t, tcpAddr, automerge.SyncMuxOptions{
+			OnUnavailable: func(id string) { rejected <- id },
+		})
+		for _, id := range []string{"a", "b"} {
+			doc := automerge.New()
+			set(t, doc, "x", id)
+			require.NoError(t, mux.Subscribe(id, doc))
+			time.Sleep(50 * time.Millisecond)
+		}
+		doc := automerge.New()
+		set(t, doc, "x", "c")
+		require.NoError(t, mux.Subscribe("c", doc))
+		require.Equal(t, "c", unavailable(t, rejected))
+	})
+}
+
+func TestServer_Release(t *testing.T) {
+	s, err := newServer(t.TempDir(), &metrics{}, serverOptions{})
+	require.NoError(t, err)
+	c1, c2 := automerge.NewMessagePipe()
+	done := make(chan struct{})
+	go func() {
+		s.serve(c1, c1, "test")
+		close(done)
+	}()
+
+	// a client that asks for documents and disconnects straight away
+	for i := 0; i < 20; i++ {
+		doc := automerge.New()
+		set(t, doc, "x", "y")
+		msg, _ := automerge.NewSyncState(doc).GenerateMessage()
+		id := fmt.Sprint("doc-", i)
+		frame := append([]byte{0, byte(len(id))}, id...)
+		require.NoError(t, c2.WriteMessage(append(frame, msg.Bytes()...)))
+	}
+	c2.Close()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("connection was not closed")
+	}
+
+	// every document it loaded has been released
+	s.m.Lock()
+	defer s.m.Unlock()
+	require.Empty(t, s.docs)
+}
@@ -0,0 +1,357 @@
+package automerge
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/automerge/automerge-go/internal/columnar"
+)
+
+// This file decodes the columnar encoding used by the automerge binary
+// format (https://automerge.org/automerge-binary-format-spec/). The
+// primitives are shared with cmd/automerge-debug in internal/columnar.
+
+// maxValuesPerByte limits how many values the columns of a chunk may expand
+// to, relative to the size of the input, so that a small hostile chunk
+// cannot exhaust memory. A run of n values takes a few bytes however large
+// n is, so this leaves plenty of room for long runs in real documents.
+const maxValuesPerByte = 256
+
+// minValueLimit is the limit on the number of values for small inputs
+const minValueLimit = 1 << 16
+
+// budget limits the memory used to decode the chunk read from n bytes
+type budget struct {
+	inflated int
+	values   int
+}
+
+func newBudget(n int) *budget {
+	b := &budget{inflated: columnar.InflateLimit(n), values: minValueLimit}
+	if n < math.MaxInt/maxValuesPerByte && n*maxValuesPerByte > b.values {
+		b.values = n * maxValuesPerByte
+	}
+	return b
+}
+
+// take uses n values from the budget
+func (b *budget) take(n uint64) error {
+	if n > uint64(b.values) {
+		return fmt.Errorf("too many values in column")
+	}
+	b.values -= int(n)
+	return nil
+}
+
+// inflate decompresses data within the budget
+func (b *budget) inflate(data []byte) ([]byte, error) {
+	out, err := columnar.Inflate(data, b.inflated)
+	if err != nil {
+		return nil, err
+	}
+	b.inflated -= len(out)
+	return out, nil
+}
+
+// colDeflate is set in the spec of compressed columns
+const colDeflate = 8
+
+// Column specs (with the deflate bit cleared) of operation columns
+const (
+	colObjActor   = 1
+	colObjCounter = 2
+	colKeyActor   = 17
+	colKeyCounter = 19
+	colKeyString  = 21
+	colIDActor    = 33
+	colIDCounter  = 35
+	colInsert     = 52
+	colAction     = 66
+	colValMeta    = 86
+	colVal        = 87
+	colPredGroup  = 112
+	colPredActor  = 113
+	colPredCtr    = 115
+	colSuccGroup  = 128
+	colSuccActor  = 129
+	colSuccCtr    = 131
+	colExpand     = 148
+	colMarkName   = 165
+)
+
+var (
+	readULEB = columnar.ReadULEB
+	readSLEB = columnar.ReadSLEB
+)
+
+// readPrefixed reads a uleb length followed by that many bytes
+func readPrefixed(b []byte) ([]byte, []byte, error) {
+	l, rest, err := readULEB(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	if l > uint64(len(rest)) {
+		return nil, nil, fmt.Errorf("length %d is longer than remaining data", l)
+	}
+	return rest[:l], rest[l:], nil
+}
+
+// nullable is a value in a column that may contain nulls
+type nullable[T any] struct {
+	v  T
+	ok bool
+}
+
+func decodeRLE[T any](bud *budget, b []byte, read func([]byte) (T, []byte, error)) ([]nullable[T], error) {
+	out := []nullable[T]{}
+	for len(b) > 0 {
+		n, rest, err := readSLEB(b)
+		if err != nil {
+			return nil, err
+		}
+		b = rest
+
+		var count uint64
+		switch {
+		case n == 0:
+			if count, b, err = readULEB(b); err != nil {
+				return nil, err
+			}
+		case n > 0:
+			count = uint64(n)
+		default:
+			count = uint64(-n)
+		}
+		if err := bud.take(count); err != nil {
+			return nil, err
+		}
+
+		switch {
+		case n == 0:
+			for i := uint64(0); i < count; i++ {
+				out = append(out, nullable[T]{})
+			}
+		case n > 0:
+			v, rest, err := read(b)
+			if err != nil {
+				return nil, err
+			}
+			b = rest
+			for i := uint64(0); i < count; i++ {
+				out = append(out, nullable[T]{v, true})
+			}
+		default:
+			for ; n < 0; n++ {
+				v, rest, err := read(b)
+				if err != nil {
+					return nil, err
+				}
+				b = rest
+				out = append(out, nullable[T]{v, true})
+			}
+		}
+	}
+	return out, nil
+}
+
+func decodeULEBColumn(bud *budget, b []byte) ([]nullable[uint64], error) {
+	return decodeRLE(bud, b, readULEB)
+}
+
+func decodeStringColumn(bud *budget, b []byte) ([]nullable[string], error) {
+	return decodeRLE(bud, b, func(b []byte) (string, []byte, error) {
+		s, rest, err := readPrefixed(b)
+		return string(s), rest, err
+	})
+}
+
+// decodeDeltaColumn decodes a column of run-length encoded differences
+// between consecutive (non-null) values.
+func decodeDeltaColumn(bud *budget, b []byte) ([]nullable[int64], error) {
+	deltas, err := decodeRLE(bud, b, readSLEB)
+	if err != nil {
+		return nil, err
+	}
+	v := int64(0)
+	for i := range deltas {
+		if deltas[i].ok {
+			v += deltas[i].v
+			deltas[i].v = v
+		}
+	}
+	return deltas, nil
+}
+
+// decodeBoolColumn decodes alternating runs of false and true values
+func decodeBoolColumn(bud *budget, b []byte) ([]bool, error) {
+	out := []bool{}
+	v := false
+	for len(b) > 0 {
+		n, rest, err := readULEB(b)
+		if err != nil {
+			return nil, err
+		}
+		if err := bud.take(n); err != nil {
+			return nil, err
+		}
+		for i := uint64(0); i < n; i++ {
+			out = append(out, v)
+		}
+		v = !v
+		b = rest
+	}
+	return out, nil
+}
+
+type columnMeta struct {
+	spec, length uint64
+}
+
+// readColumnMeta reads the specs and lengths of the columns in a chunk
+func readColumnMeta(b []byte) ([]columnMeta, []byte, error) {
+	n, b, err := readULEB(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	metas := []columnMeta{}
+	for i := uint64(0); i < n; i++ {
+		var m columnMeta
+		if m.spec, b, err = readULEB(b); err != nil {
+			return nil, nil, err
+		}
+		if m.length, b, err = readULEB(b); err != nil {
+			return nil, nil, err
+		}
+		metas = append(metas, m)
+	}
+	return metas, b, nil
+}
+
+// readColumns reads the data of each column from b. Deflated columns are
+// decompressed, and the deflate bit is cleared from the returned specs.
+func readColumns(bud *budget, metas []columnMeta, b []byte) (map[uint64][]byte, []byte, error) {
+	cols := map[uint64][]byte{}
+	for _, m := range metas {
+		if m.length > uint64(len(b)) {
+			return nil, nil, fmt.Errorf("column %d is longer than remaining data", m.spec>>4)
+		}
+		data := b[:m.length]
+		b = b[m.length:]
+		if m.spec&colDeflate != 0 {
+			var err error
+			if data, err = bud.inflate(data); err != nil {
+				return nil, nil, err
+			}
+		}
+		cols[m.spec&^colDeflate] = data
+	}
+	return cols, b, nil
+}
+
+// readChunk reads one chunk from b, returning its type and contents.
+// Compressed change chunks are decompressed and returned as change chunks.
+func readChunk(bud *budget, b []byte) (typ byte, chunk []byte, rest []byte, err error) {
+	if len(b) < 9 || !bytes.Equal(b[:4], chunkMagic) {
+		return 0, nil, nil, fmt.Errorf("invalid chunk")
+	}
+	typ = b[8]
+	chunk, rest, err = readPrefixed(b[9:])
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	if typ == 2 {
+		typ = 1
+		if chunk, err = bud.inflate(chunk); err != nil {
+			return 0, nil, nil, err
+		}
+	}
+	return typ, chunk, rest, nil
+}
+
+// readActor reads a length-prefixed actor ID and returns it hex-encoded
+func readActor(b []byte) (string, []byte, error) {
+	a, rest, err := readPrefixed(b)
+	if err != nil {
+		return "", nil, err
+	}
+	return fmt.Sprintf("%x", a), rest, nil
+}
+
+func readActors(b []byte, actors []string) ([]string, []byte, error) {
+	n, b, err := readULEB(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	for i := uint64(0); i < n; i++ {
+		var a string
+		if a, b, err = readActor(b); err != nil {
+			return nil, nil, err
+		}
+		actors = append(actors, a)
+	}
+	return actors, b, nil
+}
+
+func readHashes(b []byte) ([]ChangeHash, []byte, error) {
+	n, b, err := readULEB(b)
+	if err != nil {
+		return nil, nil, err
+	}
+	if n > uint64(len(b)/32) {
+		return nil, nil, fmt.Errorf("not enough data for %d hashes", n)
+	}
+	hashes := []ChangeHash{}
+	for i := uint64(0); i < n; i++ {
+		hashes = append(hashes, *(*ChangeHash)(b[:32]))
+		b = b[32:]
+	}
+	return hashes, b, nil
+}
+
+// readValue decodes a value with the given value metadata
+func readValue(meta uint64, b []byte) (Kind, any, []byte, error) {
+	l := meta >> 4
+	if l > uint64(len(b)) {
+		return 0, nil, nil, fmt.Errorf("value is longer than remaining data")
+	}
+	v, rest := b[:l], b[l:]
+	var err error
+	switch meta & 0x0f {
+	case 0:
+		return KindNull, nil, rest, nil
+	case 1:
+		return KindBool, false, rest, nil
+	case 2:
+		return KindBool, true, rest, nil
+	case 3:
+		var u uint64
+		u, _, err = readULEB(v)
+		return KindUint64, u, rest, err
+	case 4:
+		var i int64
+		i, _, err = readSLEB(v)
+		return KindInt64, i, rest, err
+	case 5:
+		if len(v) != 8 {
+			return 0, nil, nil, fmt.Errorf("invalid float length %d", len(v))
+		}
+		return KindFloat64, math.Float64frombits(binary.LittleEndian.Uint64(v)), rest, nil
+	case 6:
+		return KindStr, string(v), rest, nil
+	case 7:
+		return KindBytes, append([]byte{}, v...), rest, nil
+	case 8:
+		var i int64
+		i, _, err = readSLEB(v)
+		return KindCounter, i, rest, err
+	case 9:
+		var i int64
+		i, _, err = readSLEB(v)
+		return KindTime, time.UnixMilli(i), rest, err
+	default:
+		return KindUnknown, append([]byte{}, v...), rest, nil
+	}
+}
@@ -32,7 +32,7 @@ func (c *Counter) Get() (int64, error) {
 	} else if c.path != nil {
 		v, err = c.path.Get()
 	} else {
-		return 0, fmt.Errorf("automerge.Counter: tried to read from detached counter")
+		return 0, newError(ErrDetached, "automerge.Counter: tried to read from detached counter")
 	}
 	if err != nil {
 		return 0, err
@@ -43,7 +43,7 @@ func (c *Counter) Get() (int64, error) {
 	case KindCounter:
 		return v.Counter().val, nil
 	default:
-		return 0, fmt.Errorf("automerge.Counter: tried to read non-counter %#v", v.val)
+		return 0, kindMismatch(c.path, KindCounter, v.Kind(), "automerge.Counter: tried to read non-counter %#v", v.val)
 	}
 }
 
@@ -51,7 +51,7 @@ func (c *Counter) Get() (int64, error) {
 func (c *Counter) Inc(delta int64) error {
 	if c.m == nil && c.l == nil {
 		if c.path == nil {
-			return fmt.Errorf("automerge.Counter: tried to write to detached counter")
+			return newError(ErrDetached, "automerge.Counter: tried to write to detached counter")
 		}
 
 		c2, err := c.path.ensureCounter()
@@ -20,8 +20,18 @@ func (ai *actorID) String() string {
 }
 
 // NewActorID generates a new unique actor id.
+// It panics if the actor id cannot be created, see [TryNewActorID].
 func NewActorID() string {
-	return must(wrap(C.AMactorIdInit()).item()).actorID().String()
+	return must(TryNewActorID())
+}
+
+// TryNewActorID is like [NewActorID] but returns an error instead of panicking
+func TryNewActorID() (string, error) {
+	item, err := wrap(C.AMactorIdInit()).item()
+	if err != nil {
+		return "", err
+	}
+	return item.actorID().String(), nil
 }
 
 // Doc represents an automerge document. You can read and write the
@@ -35,7 +45,28 @@ type Doc struct {
 	item *item
 	cDoc *C.AMdoc
 
-	m sync.Mutex
+	m     sync.Mutex
+	hooks []*Hooks
+
+	signer     Signer
+	verifier   Verifier
+	policy     Policy
+	signatures map[ChangeHash][]byte
+
+	// checkM serialises checkIncoming, and checking holds the state that
+	// policies keep while one batch of changes is checked.
+	checkM   sync.Mutex
+	checking map[any]any
+
+	// pendingSignatures are signatures received for changes that are not
+	// yet in the document, see AddSignatures.
+	pendingSignatures map[ChangeHash][][]byte
+	pendingOrder      []ChangeHash
+	pendingBytes      int
+
+	// committing is set on the copy of the document that is passed to
+	// BeforeCommit hooks, see runBeforeCommit.
+	committing bool
 }
 
 func (d *Doc) lock() (*C.AMdoc, func()) {
@@ -49,9 +80,19 @@ func (d *Doc) lock() (*C.AMdoc, func()) {
 	}
 }
 
-// New creates a new empty document
+// New creates a new empty document.
+// It panics if the document cannot be created, see [TryNew].
 func New() *Doc {
-	return must(wrap(C.AMcreate(nil)).item()).doc()
+	return must(TryNew())
+}
+
+// TryNew is like [New] but returns an error instead of panicking
+func TryNew() (*Doc, error) {
+	item, err := wrap(C.AMcreate(nil)).item()
+	if err != nil {
+		return nil, err
+	}
+	return item.doc(), nil
 }
 
 // Load loads a document from its serialized form
@@ -61,17 +102,30 @@ func Load(b []byte) (*Doc, error) {
 
 	item, err := wrap(C.AMload(cbytes.src, cbytes.count)).item()
 	if err != nil {
-		return nil, err
+		return nil, markCorrupt(err)
 	}
 	return item.doc(), nil
 }
 
-// Save exports a document to its serialized form
+// Save exports a document to its serialized form.
+// It panics if the document cannot be saved, see [Doc.TrySave].
 func (d *Doc) Save() []byte {
-	cDoc, unlock := d.lock()
+	return must(d.TrySave())
+}
+
+// TrySave is like [Doc.Save] but returns an error instead of panicking
+func (d *Doc) TrySave() ([]byte, error) {
+	cDoc, unlock, err := d.lockCommitted()
+	if err != nil {
+		return nil, err
+	}
 	defer unlock()
 
-	return must(wrap(C.AMsave(cDoc)).item()).bytes()
+	item, err := wrap(C.AMsave(cDoc)).item()
+	if err != nil {
+		return nil, err
+	}
+	return item.bytes(), nil
 }
 
 // RootMap returns the root of the document as a Map
@@ -86,7 +140,8 @@ func (d *Doc) Root() *Value {
 }
 
 // Path returns a [*Path] that points to a position in the doc.
-// Path will panic unless each path component is a string or an int.
+// Path will panic unless each path component is a string, an int,
+// or a [*Path] (such as one returned by [ParsePath]).
 // Calling Path with no arguments returns a path to the [Doc.Root].
 func (d *Doc) Path(path ...any) *Path {
 	return (&Path{d: d}).Path(path...)
@@ -104,25 +159,52 @@ type CommitOptions struct {
 // The returned ChangeHash is the new head of the document.
 // Note: You should call commit immediately after modifying the document
 // as most methods that inspect or modify the documents' history
-// will automatically commit any outstanding changes.
+// will automatically commit any outstanding changes. Those implicit commits
+// run the same [Hooks] as Commit, and if a BeforeCommit hook rejects them the
+// method that caused the commit returns the error.
+// If a [Signer] is configured the change is signed before the document is
+// unlocked, and if signing fails the change is discarded (like a rejected
+// commit) and Commit returns the error.
 func (d *Doc) Commit(msg string, opts ...CommitOptions) (ChangeHash, error) {
-	cDoc, unlock := d.lock()
-	defer unlock()
+	if d.committing {
+		return ChangeHash{}, errCommitInHook
+	}
 
 	allowEmpty := false
-	time := time.Now()
+	t := time.Now()
 	for _, o := range opts {
 		if o.AllowEmpty {
 			allowEmpty = true
 		}
 		if o.Time != nil {
-			time = *o.Time
+			t = *o.Time
 		}
 	}
 
+	cDoc, unlock := d.lock()
+	defer unlock()
+
+	ch, err := d.commit(cDoc, msg, allowEmpty, t)
+	if err != nil {
+		return ChangeHash{}, err
+	}
+	unlock()
+	d.runAfterCommit(ch)
+	return ch, nil
+}
+
+var errCommitInHook = fmt.Errorf("automerge: cannot commit from a BeforeCommit hook")
+
+// commit must be called with the document locked. It runs the BeforeCommit
+// hooks, commits the pending operations and signs the new change.
+func (d *Doc) commit(cDoc *C.AMdoc, msg string, allowEmpty bool, t time.Time) (ChangeHash, error) {
+	if err := d.runBeforeCommit(cDoc); err != nil {
+		return ChangeHash{}, err
+	}
+
 	millis := (*C.int64_t)(C.NULL)
-	if !time.IsZero() {
-		m := time.UnixMilli()
+	if !t.IsZero() {
+		m := t.UnixMilli()
 		millis = (*C.int64_t)(&m)
 	}
 
@@ -137,9 +219,9 @@ func (d *Doc) Commit(msg string, opts ...CommitOptions) (ChangeHash, error) {
 	if err != nil {
 		return ChangeHash{}, err
 	}
-	if err == nil && item.Kind() == KindVoid {
+	if item.Kind() == KindVoid {
 		if !allowEmpty {
-			return ChangeHash{}, fmt.Errorf("Commit is empty")
+			return ChangeHash{}, newError(ErrEmptyCommit, "Commit is empty")
 		}
 		item, err = wrap(C.AMemptyChange(cDoc, cMsg, millis)).item()
 		if err != nil {
@@ -147,7 +229,93 @@ func (d *Doc) Commit(msg string, opts ...CommitOptions) (ChangeHash, error) {
 		}
 	}
 
-	return item.changeHash(), nil
+	ch := item.changeHash()
+	if err := d.sign(cDoc, ch); err != nil {
+		return ChangeHash{}, err
+	}
+	return ch, nil
+}
+
+// discard removes the change ch, which must be the last local change, from
+// the document by replacing the document with a fork at the change's
+// dependencies. It must be called with the document locked, and before
+// the change can have been read by anything else.
+func (d *Doc) discard(cDoc *C.AMdoc, ch ChangeHash) error {
+	cHash, free := toByteSpan(ch[:])
+	defer free()
+	changeItem, err := wrap(C.AMgetChangeByHash(cDoc, cHash.src, cHash.count)).item()
+	if err != nil {
+		return err
+	}
+	deps, err := changeItem.change().TryDependencies()
+	if err != nil {
+		return err
+	}
+	actor, err := wrap(C.AMgetActorId(cDoc)).item()
+	if err != nil {
+		return err
+	}
+	cActor := actor.actorID().cActorID
+
+	var forked *item
+	if len(deps) == 0 {
+		forked, err = wrap(C.AMcreate(cActor)).item()
+	} else {
+		var depItems []*item
+		if depItems, err = itemsFromChangeHashes(deps); err != nil {
+			return err
+		}
+		cDeps, free := createItems(depItems)
+		defer free()
+		if forked, err = wrap(C.AMfork(cDoc, cDeps)).item(); err == nil {
+			var cForked *C.AMdoc
+			C.AMitemToDoc(forked.cItem, &cForked)
+			err = wrap(C.AMsetActorId(cForked, cActor)).void()
+		}
+	}
+	runtime.KeepAlive(actor)
+	if err != nil {
+		return err
+	}
+
+	old := d.item
+	if !C.AMitemToDoc(forked.cItem, &d.cDoc) {
+		forked.failCast(kindDoc)
+	}
+	forked.result.retain()
+	d.item = forked
+	old.result.release()
+	return nil
+}
+
+// lockCommitted locks the document like lock, and then commits any pending
+// operations in the same way as [Doc.Commit]. It must be used instead of lock
+// before calling into the automerge core in any way that would otherwise
+// commit them implicitly (without running hooks). The returned unlock
+// function runs the AfterCommit hooks once the document is unlocked.
+func (d *Doc) lockCommitted() (*C.AMdoc, func(), error) {
+	cDoc, unlock := d.lock()
+	if cDoc == nil || C.AMpendingOps(cDoc) == 0 {
+		return cDoc, unlock, nil
+	}
+	if d.committing {
+		unlock()
+		return nil, nil, errCommitInHook
+	}
+
+	ch, err := d.commit(cDoc, "", false, time.Now())
+	if err != nil {
+		unlock()
+		return nil, nil, err
+	}
+	done := false
+	return cDoc, func() {
+		if !done {
+			done = true
+			unlock()
+			d.runAfterCommit(ch)
+		}
+	}, nil
 }
 
 // Heads returns the hashes of the current heads for the document.
@@ -157,19 +325,38 @@ func (d *Doc) Commit(msg string, opts ...CommitOptions) (ChangeHash, error) {
 // length will be greater that one.
 // If you'd like to merge independent changes together call [Doc.Commit]
 // passing a [CommitOptions] with AllowEmpty set to true.
+// It panics if the heads cannot be read, see [Doc.TryHeads].
 func (d *Doc) Heads() []ChangeHash {
-	cDoc, unlock := d.lock()
+	return must(d.TryHeads())
+}
+
+// TryHeads is like [Doc.Heads] but returns an error instead of panicking
+func (d *Doc) TryHeads() ([]ChangeHash, error) {
+	cDoc, unlock, err := d.lockCommitted()
+	if err != nil {
+		return nil, err
+	}
 	defer unlock()
 
-	items := must(wrap(C.AMgetHeads(cDoc)).items())
+	return getHeads(cDoc)
+}
+
+func getHeads(cDoc *C.AMdoc) ([]ChangeHash, error) {
+	items, err := wrap(C.AMgetHeads(cDoc)).items()
+	if err != nil {
+		return nil, err
+	}
 	return mapItems(items, func(i *item) ChangeHash {
 		return i.changeHash()
-	})
+	}), nil
 }
 
 // Change gets a specific change by hash.
 func (d *Doc) Change(ch ChangeHash) (*Change, error) {
-	cDoc, unlock := d.lock()
+	cDoc, unlock, err := d.lockCommitted()
+	if err != nil {
+		return nil, err
+	}
 	defer unlock()
 
 	byteSpan, free := toByteSpan(ch[:])
@@ -181,7 +368,7 @@ func (d *Doc) Change(ch ChangeHash) (*Change, error) {
 	}
 
 	if item.Kind() == KindVoid {
-		return nil, fmt.Errorf("hash %s does not correspond to a change in this document", ch)
+		return nil, newError(ErrUnknownChange, "hash %s does not correspond to a change in this document", ch)
 	}
 
 	return item.change(), nil
@@ -190,7 +377,10 @@ func (d *Doc) Change(ch ChangeHash) (*Change, error) {
 // Changes returns all changes made to the doc since the given heads.
 // If since is empty, returns all changes to recreate the document.
 func (d *Doc) Changes(since ...ChangeHash) ([]*Change, error) {
-	cDoc, unlock := d.lock()
+	cDoc, unlock, err := d.lockCommitted()
+	if err != nil {
+		return nil, err
+	}
 	defer unlock()
 
 	items, err := itemsFromChangeHashes(since)
@@ -215,27 +405,67 @@ func (d *Doc) Apply(chs ...*Change) error {
 		return nil
 	}
 
+	accepted, err := d.checkIncoming(chs, nil)
+	if err != nil {
+		return err
+	}
+	_, err = d.applyChanges(chs, accepted)
+	return err
+}
+
+// applyChanges applies changes that have been checked by checkIncoming,
+// and returns the heads of the document afterwards
+func (d *Doc) applyChanges(chs []*Change, accepted map[ChangeHash][]byte) ([]ChangeHash, error) {
 	items := []*item{}
 	for _, ch := range chs {
+		if ch.cChange == nil {
+			return nil, errChangeClosed
+		}
 		items = append(items, ch.item)
 	}
 
-	cDoc, unlock := d.lock()
+	cDoc, unlock, err := d.lockCommitted()
+	if err != nil {
+		return nil, err
+	}
 	defer unlock()
 	cChs, free := createItems(items)
 	defer free()
 
-	return wrap(C.AMapplyChanges(cDoc, cChs)).void()
+	after := d.prepareAfterApply(cDoc)
+	err = wrap(C.AMapplyChanges(cDoc, cChs)).void()
+	var heads []ChangeHash
+	if err == nil {
+		d.storeSignatures(accepted)
+		heads, err = getHeads(cDoc)
+	}
+	unlock()
+	after.run(d)
+	return heads, markCorrupt(err)
 }
 
 // SaveIncremental exports the changes since the last call to [Doc.Save] or
 // [Doc.SaveIncremental] for passing to [Doc.LoadIncremental] on a different doc.
 // See also [SyncState] for a more managed approach to syncing.
+// It panics if the changes cannot be saved, see [Doc.TrySaveIncremental].
 func (d *Doc) SaveIncremental() []byte {
-	cDoc, unlock := d.lock()
+	return must(d.TrySaveIncremental())
+}
+
+// TrySaveIncremental is like [Doc.SaveIncremental] but returns an error
+// instead of panicking
+func (d *Doc) TrySaveIncremental() ([]byte, error) {
+	cDoc, unlock, err := d.lockCommitted()
+	if err != nil {
+		return nil, err
+	}
 	defer unlock()
 
-	return must(wrap(C.AMsaveIncremental(cDoc)).item()).bytes()
+	item, err := wrap(C.AMsaveIncremental(cDoc)).item()
+	if err != nil {
+		return nil, err
+	}
+	return item.bytes(), nil
 }
 
 // LoadIncremental applies the changes exported by [Doc.SaveIncremental].
@@ -243,14 +473,51 @@ func (d *Doc) SaveIncremental() []byte {
 // is applied to keep the documents in sync.
 // See also [SyncState] for a more managed approach to syncing.
 func (d *Doc) LoadIncremental(raw []byte) error {
-	cDoc, unlock := d.lock()
+	var accepted map[ChangeHash][]byte
+	if d.checksIncoming(nil) {
+		chs, err := splitChanges(raw)
+		if err != nil {
+			return markError(ErrCorruptDocument, err)
+		}
+		if accepted, err = d.checkIncoming(chs, nil); err != nil {
+			return err
+		}
+	}
+
+	cDoc, unlock, err := d.lockCommitted()
+	if err != nil {
+		return err
+	}
 	defer unlock()
 	cBytes, free := toByteSpan(raw)
 	defer free()
 
+	after := d.prepareAfterApply(cDoc)
 	// returns the number of bytes read...
-	_, err := wrap(C.AMloadIncremental(cDoc, cBytes.src, cBytes.count)).item()
-	return err
+	_, err = wrap(C.AMloadIncremental(cDoc, cBytes.src, cBytes.count)).item()
+	if err == nil {
+		d.storeSignatures(accepted)
+	}
+	unlock()
+	after.run(d)
+	return markCorrupt(err)
+}
+
+// Close frees the native memory used by the document immediately, instead
+// of waiting for the garbage collector. After Close, methods on the document
+// (and on the [Map], [List], [Text], [Counter] and [Path] values that refer to
+// it) return an error matching [ErrClosed], or panic with it if they panic on
+// error. Closing a document more than once has no effect.
+func (d *Doc) Close() error {
+	_, unlock := d.lock()
+	defer unlock()
+
+	if d.cDoc == nil || d.committing {
+		return nil
+	}
+	d.cDoc = nil
+	d.item.result.release()
+	return nil
 }
 
 // Fork returns a new, independent, copy of the document
@@ -262,7 +529,10 @@ func (d *Doc) Fork(asOf ...ChangeHash) (*Doc, error) {
 		return nil, err
 	}
 
-	cDoc, unlock := d.lock()
+	cDoc, unlock, err := d.lockCommitted()
+	if err != nil {
+		return nil, err
+	}
 	defer unlock()
 	cAsOf, free := createItems(items)
 	defer free()
@@ -275,14 +545,46 @@ func (d *Doc) Fork(asOf ...ChangeHash) (*Doc, error) {
 }
 
 // Merge extracts all changes from d2 that are not in d
-// and then applies them to d.
+// and then applies them to d. It returns the heads of d afterwards.
 func (d *Doc) Merge(d2 *Doc) ([]ChangeHash, error) {
-	cDoc, unlock := d.lock()
+	if sigs := d2.Signatures(); d.checksIncoming(sigs) {
+		heads, err := d.TryHeads()
+		if err != nil {
+			return nil, err
+		}
+		chs, err := d2.Changes(heads...)
+		if err != nil {
+			// d has changes that d2 does not
+			chs, err = d2.Changes()
+		}
+		if err != nil {
+			return nil, err
+		}
+		accepted, err := d.checkIncoming(chs, sigs)
+		if err != nil {
+			return nil, err
+		}
+		// apply exactly the changes that were checked, as d2 may have
+		// changed since
+		return d.applyChanges(chs, accepted)
+	}
+
+	cDoc, unlock, err := d.lockCommitted()
+	if err != nil {
+		return nil, err
+	}
 	defer unlock()
-	cDoc2, unlock2 := d2.lock()
+	cDoc2, unlock2, err := d2.lockCommitted()
+	if err != nil {
+		return nil, err
+	}
 	defer unlock2()
 
+	after := d.prepareAfterApply(cDoc)
 	items, err := wrap(C.AMmerge(cDoc, cDoc2)).items()
+	unlock2()
+	unlock()
+	after.run(d)
 	if err != nil {
 		return nil, err
 	}
@@ -293,11 +595,21 @@ func (d *Doc) Merge(d2 *Doc) ([]ChangeHash, error) {
 // This is used for all operations that write to the document.
 // By default a random ActorID is generated, but you can customize
 // this with [Doc.SetActorID].
+// It panics if the actor id cannot be read, see [Doc.TryActorID].
 func (d *Doc) ActorID() string {
+	return must(d.TryActorID())
+}
+
+// TryActorID is like [Doc.ActorID] but returns an error instead of panicking
+func (d *Doc) TryActorID() (string, error) {
 	cDoc, unlock := d.lock()
 	defer unlock()
 
-	return must(wrap(C.AMgetActorId(cDoc)).item()).actorID().String()
+	item, err := wrap(C.AMgetActorId(cDoc)).item()
+	if err != nil {
+		return "", err
+	}
+	return item.actorID().String(), nil
 }
 
 // SetActorID updates the current actorId of the doc.
@@ -308,7 +620,10 @@ func (d *Doc) SetActorID(id string) error {
 		return err
 	}
 
-	cDoc, unlock := d.lock()
+	cDoc, unlock, err := d.lockCommitted()
+	if err != nil {
+		return err
+	}
 	defer unlock()
 	defer runtime.KeepAlive(ai)
 
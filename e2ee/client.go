@@ -0,0 +1,178 @@
+package e2ee
+
+import (
+	"context"
+	"crypto/cipher"
+	"sync"
+	"time"
+
+	"github.com/automerge/automerge-go"
+)
+
+// DefaultPollInterval is how often [Client.Run] checks the relay for changes
+const DefaultPollInterval = 5 * time.Second
+
+// ClientOptions configure a [Client]
+type ClientOptions struct {
+	// PollInterval is how often [Client.Run] fetches changes from the relay.
+	// Defaults to [DefaultPollInterval].
+	PollInterval time.Duration
+	// OnError is called by [Client.Run] when syncing fails. Run keeps
+	// retrying, so that a relay that is briefly unavailable is not fatal.
+	OnError func(err error)
+}
+
+// Client syncs one document through a [Relay]
+type Client struct {
+	doc   *automerge.Doc
+	docID string
+	gcm   cipher.AEAD
+	ids   blobIDs
+	relay Relay
+	opts  ClientOptions
+
+	// m ensures only one sync runs at a time, and protects the following
+	m sync.Mutex
+	// hashes maps the blob ID of each change in doc (up to indexed) to the
+	// change's hash
+	hashes  map[automerge.ChangeHash]automerge.ChangeHash
+	indexed []automerge.ChangeHash
+}
+
+// NewClient returns a client that syncs doc, identified on the relay by
+// docID, and encrypted with key (which must be [KeySize] bytes).
+func NewClient(doc *automerge.Doc, docID string, key []byte, relay Relay, opts ...ClientOptions) (*Client, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	c := &Client{doc: doc, docID: docID, gcm: gcm, ids: newBlobIDs(key), relay: relay,
+		hashes: map[automerge.ChangeHash]automerge.ChangeHash{}}
+	for _, o := range opts {
+		if o.PollInterval != 0 {
+			c.opts.PollInterval = o.PollInterval
+		}
+		if o.OnError != nil {
+			c.opts.OnError = o.OnError
+		}
+	}
+	if c.opts.PollInterval <= 0 {
+		c.opts.PollInterval = DefaultPollInterval
+	}
+	return c, nil
+}
+
+// Doc returns the document being synced
+func (c *Client) Doc() *automerge.Doc {
+	return c.doc
+}
+
+// Sync sends local changes that the relay does not have, and then applies
+// any changes from the relay that the document does not have.
+func (c *Client) Sync(ctx context.Context) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if err := c.push(ctx); err != nil {
+		return err
+	}
+	return c.pull(ctx)
+}
+
+// index records the blob IDs of changes added to the document since the
+// last call
+func (c *Client) index() error {
+	heads, err := c.doc.TryHeads()
+	if err != nil {
+		return err
+	}
+	changes, err := c.doc.Changes(c.indexed...)
+	if err != nil {
+		return err
+	}
+	for _, ch := range changes {
+		c.hashes[c.ids.id(ch.Hash())] = ch.Hash()
+	}
+	c.indexed = heads
+	return nil
+}
+
+func (c *Client) push(ctx context.Context) error {
+	heads, err := c.relay.Heads(ctx, c.docID)
+	if err != nil {
+		return err
+	}
+	if err := c.index(); err != nil {
+		return err
+	}
+	// heads we do not have yet were sent by other clients
+	known := []automerge.ChangeHash{}
+	for _, id := range heads {
+		if h, ok := c.hashes[id]; ok {
+			known = append(known, h)
+		}
+	}
+	changes, err := c.doc.Changes(known...)
+	if err != nil || len(changes) == 0 {
+		return err
+	}
+
+	blobs := []Blob{}
+	for _, ch := range changes {
+		b, err := seal(c.gcm, c.ids, c.docID, ch)
+		if err != nil {
+			return err
+		}
+		blobs = append(blobs, b)
+	}
+	return c.relay.Put(ctx, c.docID, blobs)
+}
+
+func (c *Client) pull(ctx context.Context) error {
+	heads, err := c.doc.TryHeads()
+	if err != nil {
+		return err
+	}
+	blobs, err := c.relay.Get(ctx, c.docID, c.ids.ids(heads))
+	if err != nil || len(blobs) == 0 {
+		return err
+	}
+	changes := []*automerge.Change{}
+	for _, b := range blobs {
+		ch, err := open(c.gcm, c.ids, c.docID, b)
+		if err != nil {
+			return err
+		}
+		changes = append(changes, ch)
+	}
+	return c.doc.Apply(changes...)
+}
+
+// Run syncs whenever the document is committed to, and every PollInterval,
+// until ctx is cancelled. It returns ctx.Err().
+func (c *Client) Run(ctx context.Context) error {
+	changed := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+	remove := c.doc.AddHooks(automerge.Hooks{
+		AfterCommit: func(*automerge.Doc, automerge.ChangeHash) { notify() },
+	})
+	defer remove()
+
+	t := time.NewTicker(c.opts.PollInterval)
+	defer t.Stop()
+	for {
+		if err := c.Sync(ctx); err != nil && ctx.Err() == nil && c.opts.OnError != nil {
+			c.opts.OnError(err)
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		case <-t.C:
+		}
+	}
+}
@@ -0,0 +1,234 @@
+// Package e2ee syncs automerge documents through a relay that cannot read them.
+//
+// Each change is encrypted by the client with a key shared by everyone who
+// can access the document, and sent to the relay as a [Blob]. Blobs are
+// identified by an HMAC of the change's hash under the document key, so the
+// relay cannot link them to changes it sees elsewhere. The relay only sees
+// these IDs for each change and its dependencies, which it uses to work out
+// which blobs a client is missing:
+//
+//	relay := e2ee.NewMemoryRelay() // or e2ee.NewHTTPRelay(url)
+//	c, err := e2ee.NewClient(doc, "doc-id", key, relay)
+//	err = c.Sync(ctx)
+//
+// Clients decrypt the blobs they receive, check that each change matches the
+// IDs the relay claimed, and apply it to their document. A relay can be run
+// over HTTP with [Handler].
+//
+// The relay still learns the shape of the history: how many changes there
+// are, how large they are, when they were sent, and which changes are
+// concurrent.
+package e2ee
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/automerge/automerge-go"
+)
+
+// KeySize is the size of a document key in bytes (for AES-256-GCM)
+const KeySize = 32
+
+// NewKey returns a random document key
+func NewKey() []byte {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return key
+}
+
+// Blob is an encrypted change as stored by a relay
+type Blob struct {
+	// Hash is the blob's ID: the HMAC of the change's hash under the
+	// document key. The hashes used by [Relay] are all blob IDs.
+	Hash automerge.ChangeHash
+	// Deps are the IDs of the blobs containing the change's dependencies
+	Deps []automerge.ChangeHash
+	Data []byte
+}
+
+// Relay stores and forwards blobs for many documents.
+// Implementations must be safe to call from multiple goroutines.
+type Relay interface {
+	// Put stores blobs for a document. Blobs that are already stored are ignored.
+	Put(ctx context.Context, docID string, blobs []Blob) error
+	// Get returns the blobs for a document that are not ancestors of have,
+	// with each blob after its dependencies.
+	Get(ctx context.Context, docID string, have []automerge.ChangeHash) ([]Blob, error)
+	// Heads returns the hashes of the blobs that no other blob depends on.
+	Heads(ctx context.Context, docID string) ([]automerge.ChangeHash, error)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("e2ee: key must be %d bytes", KeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// blobIDs computes the IDs of blobs in a document
+type blobIDs []byte
+
+func newBlobIDs(key []byte) blobIDs {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("automerge e2ee blob id"))
+	return mac.Sum(nil)
+}
+
+// id returns the ID of the blob containing the change with the given hash
+func (k blobIDs) id(hash automerge.ChangeHash) automerge.ChangeHash {
+	mac := hmac.New(sha256.New, k)
+	mac.Write(hash[:])
+	return *(*automerge.ChangeHash)(mac.Sum(nil))
+}
+
+func (k blobIDs) ids(hashes []automerge.ChangeHash) []automerge.ChangeHash {
+	ret := make([]automerge.ChangeHash, 0, len(hashes))
+	for _, h := range hashes {
+		ret = append(ret, k.id(h))
+	}
+	return ret
+}
+
+// additionalData binds a blob to its document and ID, so a relay cannot
+// move a blob to another document or claim it is a different change.
+func additionalData(docID string, id automerge.ChangeHash) []byte {
+	return append(append([]byte(docID), 0), id[:]...)
+}
+
+// seal encrypts a change into a blob
+func seal(gcm cipher.AEAD, ids blobIDs, docID string, ch *automerge.Change) (Blob, error) {
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return Blob{}, err
+	}
+	deps, err := ch.TryDependencies()
+	if err != nil {
+		return Blob{}, err
+	}
+	id := ids.id(ch.Hash())
+	return Blob{
+		Hash: id,
+		Deps: ids.ids(deps),
+		Data: gcm.Seal(nonce, nonce, ch.Save(), additionalData(docID, id)),
+	}, nil
+}
+
+// open decrypts a blob, and checks that it contains the change it claims to
+func open(gcm cipher.AEAD, ids blobIDs, docID string, b Blob) (*automerge.Change, error) {
+	n := gcm.NonceSize()
+	if len(b.Data) < n {
+		return nil, fmt.Errorf("e2ee: blob %s is too short", b.Hash)
+	}
+	plain, err := gcm.Open(nil, b.Data[:n], b.Data[n:], additionalData(docID, b.Hash))
+	if err != nil {
+		return nil, fmt.Errorf("e2ee: could not decrypt blob %s: %w", b.Hash, err)
+	}
+	ch, err := automerge.LoadChange(plain)
+	if err != nil {
+		return nil, fmt.Errorf("e2ee: invalid change in blob %s: %w", b.Hash, err)
+	}
+	if ids.id(ch.Hash()) != b.Hash {
+		return nil, fmt.Errorf("e2ee: blob %s does not match its ID", b.Hash)
+	}
+	deps, err := ch.TryDependencies()
+	if err != nil {
+		return nil, fmt.Errorf("e2ee: invalid change in blob %s: %w", b.Hash, err)
+	}
+	if !sameIDs(ids.ids(deps), b.Deps) {
+		return nil, fmt.Errorf("e2ee: blob %s does not match its dependencies", b.Hash)
+	}
+	return ch, nil
+}
+
+func sameIDs(a, b []automerge.ChangeHash) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := map[automerge.ChangeHash]bool{}
+	for _, h := range a {
+		seen[h] = true
+	}
+	for _, h := range b {
+		if !seen[h] {
+			return false
+		}
+	}
+	return true
+}
+
+// encodeBlobs writes blobs as: the hash, the number of dependencies, their
+// hashes, and the length-prefixed data, for each blob in turn.
+func encodeBlobs(blobs []Blob) []byte {
+	out := []byte{}
+	for _, b := range blobs {
+		out = append(out, b.Hash[:]...)
+		out = binary.AppendUvarint(out, uint64(len(b.Deps)))
+		for _, d := range b.Deps {
+			out = append(out, d[:]...)
+		}
+		out = binary.AppendUvarint(out, uint64(len(b.Data)))
+		out = append(out, b.Data...)
+	}
+	return out
+}
+
+func decodeBlobs(in []byte) ([]Blob, error) {
+	ret := []Blob{}
+	uvarint := func() (int, error) {
+		n, l := binary.Uvarint(in)
+		if l <= 0 || n > uint64(len(in)) {
+			return 0, fmt.Errorf("e2ee: invalid blob encoding")
+		}
+		in = in[l:]
+		return int(n), nil
+	}
+	hash := func() (automerge.ChangeHash, error) {
+		h := automerge.ChangeHash{}
+		if len(in) < len(h) {
+			return h, fmt.Errorf("e2ee: invalid blob encoding")
+		}
+		copy(h[:], in)
+		in = in[len(h):]
+		return h, nil
+	}
+
+	for len(in) > 0 {
+		b := Blob{}
+		var err error
+		if b.Hash, err = hash(); err != nil {
+			return nil, err
+		}
+		deps, err := uvarint()
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < deps; i++ {
+			d, err := hash()
+			if err != nil {
+				return nil, err
+			}
+			b.Deps = append(b.Deps, d)
+		}
+		n, err := uvarint()
+		if err != nil || n > len(in) {
+			return nil, fmt.Errorf("e2ee: invalid blob encoding")
+		}
+		b.Data = append([]byte{}, in[:n]...)
+		in = in[n:]
+		ret = append(ret, b)
+	}
+	return ret, nil
+}
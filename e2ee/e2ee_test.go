@@ -0,0 +1,160 @@
+package e2ee_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/automerge/automerge-go"
+	"github.com/automerge/automerge-go/e2ee"
+	"github.com/stretchr/testify/require"
+)
+
+func set(t *testing.T, doc *automerge.Doc, key string, value any) {
+	require.NoError(t, doc.Path(key).Set(value))
+	_, err := doc.Commit("set " + key)
+	require.NoError(t, err)
+}
+
+func get[T any](t *testing.T, doc *automerge.Doc, key string) T {
+	v, err := automerge.As[T](doc.Path(key).Get())
+	require.NoError(t, err)
+	return v
+}
+
+func TestClient(t *testing.T) {
+	ctx := context.Background()
+	key := e2ee.NewKey()
+
+	for name, relay := range map[string]e2ee.Relay{
+		"memory": e2ee.NewMemoryRelay(),
+		"http":   e2ee.NewHTTPRelay(httptest.NewServer(e2ee.NewHandler(e2ee.NewMemoryRelay())).URL),
+	} {
+		t.Run(name, func(t *testing.T) {
+			a, err := e2ee.NewClient(automerge.New(), "doc", key, relay)
+			require.NoError(t, err)
+			b, err := e2ee.NewClient(automerge.New(), "doc", key, relay)
+			require.NoError(t, err)
+
+			set(t, a.Doc(), "secret", "customer data")
+			require.NoError(t, a.Sync(ctx))
+			require.NoError(t, b.Sync(ctx))
+			require.Equal(t, "customer data", get[string](t, b.Doc(), "secret"))
+
+			// concurrent changes converge
+			set(t, a.Doc(), "a", 1)
+			set(t, b.Doc(), "b", 2)
+			require.NoError(t, a.Sync(ctx))
+			require.NoError(t, b.Sync(ctx))
+			require.NoError(t, a.Sync(ctx))
+			require.Equal(t, a.Doc().Heads(), b.Doc().Heads())
+			require.Equal(t, 2, get[int](t, a.Doc(), "b"))
+
+			// the relay only sees ciphertext
+			blobs, err := relay.Get(ctx, "doc", nil)
+			require.NoError(t, err)
+			require.Len(t, blobs, 3)
+			for _, b := range blobs {
+				require.NotContains(t, string(b.Data), "customer data")
+			}
+			heads, err := relay.Heads(ctx, "doc")
+			require.NoError(t, err)
+			require.Len(t, heads, 2)
+
+			// or the hashes of the changes
+			changes, err := a.Doc().Changes()
+			require.NoError(t, err)
+			for _, ch := range changes {
+				require.NotContains(t, heads, ch.Hash())
+				for _, b := range blobs {
+					require.NotEqual(t, ch.Hash(), b.Hash)
+				}
+			}
+
+			blobs, err = relay.Get(ctx, "doc", heads)
+			require.NoError(t, err)
+			require.Empty(t, blobs)
+		})
+	}
+}
+
+func TestClient_Untrusted(t *testing.T) {
+	ctx := context.Background()
+	relay := e2ee.NewMemoryRelay()
+
+	a, err := e2ee.NewClient(automerge.New(), "doc", e2ee.NewKey(), relay)
+	require.NoError(t, err)
+	set(t, a.Doc(), "x", 1)
+	require.NoError(t, a.Sync(ctx))
+
+	// a client with the wrong key cannot read the document
+	b, err := e2ee.NewClient(automerge.New(), "doc", e2ee.NewKey(), relay)
+	require.NoError(t, err)
+	require.ErrorContains(t, b.Sync(ctx), "could not decrypt")
+
+	// blobs moved to another document are rejected
+	key := e2ee.NewKey()
+	c, err := e2ee.NewClient(automerge.New(), "one", key, relay)
+	require.NoError(t, err)
+	set(t, c.Doc(), "x", 1)
+	require.NoError(t, c.Sync(ctx))
+	blobs, err := relay.Get(ctx, "one", nil)
+	require.NoError(t, err)
+	require.NoError(t, relay.Put(ctx, "two", blobs))
+	d, err := e2ee.NewClient(automerge.New(), "two", key, relay)
+	require.NoError(t, err)
+	require.Error(t, d.Sync(ctx))
+
+	// as are blobs whose dependencies were changed
+	blobs[0].Deps = append(blobs[0].Deps, blobs[0].Hash)
+	tampered := e2ee.NewMemoryRelay()
+	require.NoError(t, tampered.Put(ctx, "one", blobs))
+	e, err := e2ee.NewClient(automerge.New(), "one", key, tampered)
+	require.NoError(t, err)
+	require.ErrorContains(t, e.Sync(ctx), "does not match its dependencies")
+
+	_, err = e2ee.NewClient(automerge.New(), "doc", []byte("short"), relay)
+	require.Error(t, err)
+}
+
+func TestClient_Run(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	relay := e2ee.NewMemoryRelay()
+	key := e2ee.NewKey()
+	opts := e2ee.ClientOptions{PollInterval: 10 * time.Millisecond, OnError: func(err error) { t.Error(err) }}
+
+	a, err := e2ee.NewClient(automerge.New(), "doc", key, relay, opts)
+	require.NoError(t, err)
+	b, err := e2ee.NewClient(automerge.New(), "doc", key, relay, opts)
+	require.NoError(t, err)
+	errs := make(chan error, 2)
+	go func() { errs <- a.Run(ctx) }()
+	go func() { errs <- b.Run(ctx) }()
+
+	set(t, a.Doc(), "n", 1)
+	require.Eventually(t, func() bool {
+		v, err := automerge.As[int](b.Doc().Path("n").Get())
+		return err == nil && v == 1
+	}, 5*time.Second, time.Millisecond)
+
+	cancel()
+	require.ErrorIs(t, <-errs, context.Canceled)
+	require.ErrorIs(t, <-errs, context.Canceled)
+}
+
+func TestHTTPRelay_MaxBodySize(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(make([]byte, 32*3))
+	}))
+	defer srv.Close()
+
+	heads, err := e2ee.NewHTTPRelay(srv.URL, e2ee.HTTPRelayOptions{MaxBodySize: 32 * 3}).Heads(context.Background(), "doc")
+	require.NoError(t, err)
+	require.Len(t, heads, 3)
+
+	_, err = e2ee.NewHTTPRelay(srv.URL, e2ee.HTTPRelayOptions{MaxBodySize: 32 * 2}).Heads(context.Background(), "doc")
+	require.ErrorContains(t, err, "exceeds limit")
+}
@@ -0,0 +1,233 @@
+package e2ee
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/automerge/automerge-go"
+)
+
+// DefaultMaxBodySize is the default for [Handler].MaxBodySize and
+// [HTTPRelayOptions].MaxBodySize
+const DefaultMaxBodySize = 64 << 20
+
+// Handler serves a [Relay] over HTTP. The document is identified by the
+// "doc" query parameter:
+//
+//   - POST stores the blobs in the request body.
+//   - GET returns the blobs that are not ancestors of the comma-separated
+//     hex hashes in the "have" query parameter.
+//   - GET with "heads=1" returns the concatenated heads.
+//
+// Use [NewHTTPRelay] to connect to a Handler.
+type Handler struct {
+	Relay Relay
+	// MaxBodySize limits the size of a POST. Defaults to [DefaultMaxBodySize].
+	MaxBodySize int64
+	// OnError is called with errors returned by the relay
+	OnError func(r *http.Request, err error)
+}
+
+// NewHandler returns a [*Handler] that serves relay
+func NewHandler(relay Relay) *Handler {
+	return &Handler{Relay: relay}
+}
+
+func (h *Handler) error(w http.ResponseWriter, r *http.Request, err error) {
+	if h.OnError != nil {
+		h.OnError(r, err)
+	}
+	http.Error(w, "relay error", http.StatusInternalServerError)
+}
+
+// ServeHTTP implements [http.Handler]
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	docID := q.Get("doc")
+	if docID == "" {
+		http.Error(w, "missing doc", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPost:
+		limit := h.MaxBodySize
+		if limit <= 0 {
+			limit = DefaultMaxBodySize
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, limit))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+			return
+		}
+		blobs, err := decodeBlobs(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := h.Relay.Put(r.Context(), docID, blobs); err != nil {
+			h.error(w, r, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+
+	case http.MethodGet:
+		var out []byte
+		if q.Get("heads") != "" {
+			heads, err := h.Relay.Heads(r.Context(), docID)
+			if err != nil {
+				h.error(w, r, err)
+				return
+			}
+			for _, hd := range heads {
+				out = append(out, hd[:]...)
+			}
+		} else {
+			have := []automerge.ChangeHash{}
+			if s := q.Get("have"); s != "" {
+				for _, hex := range strings.Split(s, ",") {
+					ch, err := automerge.NewChangeHash(hex)
+					if err != nil {
+						http.Error(w, err.Error(), http.StatusBadRequest)
+						return
+					}
+					have = append(have, ch)
+				}
+			}
+			blobs, err := h.Relay.Get(r.Context(), docID, have)
+			if err != nil {
+				h.error(w, r, err)
+				return
+			}
+			out = encodeBlobs(blobs)
+		}
+		w.Header().Set("Content-Type", "application/octet-stream")
+		w.Write(out)
+
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// HTTPRelayOptions configure an [HTTPRelay]
+type HTTPRelayOptions struct {
+	// HTTPClient is used to make requests. Defaults to [http.DefaultClient].
+	HTTPClient *http.Client
+	// Header is added to each request, for example to set an Authorization header.
+	Header http.Header
+	// MaxBodySize limits the size of a response. Defaults to [DefaultMaxBodySize].
+	MaxBodySize int64
+}
+
+// HTTPRelay is a [Relay] that connects to a [Handler]
+type HTTPRelay struct {
+	url  string
+	opts HTTPRelayOptions
+}
+
+// NewHTTPRelay returns a relay that makes requests to the [Handler] at rawURL
+func NewHTTPRelay(rawURL string, opts ...HTTPRelayOptions) *HTTPRelay {
+	hr := &HTTPRelay{url: rawURL}
+	for _, o := range opts {
+		if o.HTTPClient != nil {
+			hr.opts.HTTPClient = o.HTTPClient
+		}
+		if o.Header != nil {
+			hr.opts.Header = o.Header
+		}
+		if o.MaxBodySize > 0 {
+			hr.opts.MaxBodySize = o.MaxBodySize
+		}
+	}
+	if hr.opts.HTTPClient == nil {
+		hr.opts.HTTPClient = http.DefaultClient
+	}
+	if hr.opts.MaxBodySize == 0 {
+		hr.opts.MaxBodySize = DefaultMaxBodySize
+	}
+	return hr
+}
+
+func (hr *HTTPRelay) do(ctx context.Context, method string, q url.Values, body []byte) ([]byte, error) {
+	u, err := url.Parse(hr.url)
+	if err != nil {
+		return nil, err
+	}
+	uq := u.Query()
+	for k, v := range q {
+		uq[k] = v
+	}
+	u.RawQuery = uq.Encode()
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range hr.opts.Header {
+		req.Header[k] = v
+	}
+	resp, err := hr.opts.HTTPClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(resp.Body, hr.opts.MaxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode/100 != 2 {
+		return nil, fmt.Errorf("e2ee: %s %s: %s", method, hr.url, resp.Status)
+	}
+	if int64(len(b)) > hr.opts.MaxBodySize {
+		return nil, fmt.Errorf("e2ee: %s %s: response exceeds limit of %d bytes", method, hr.url, hr.opts.MaxBodySize)
+	}
+	return b, nil
+}
+
+// Put implements [Relay]
+func (hr *HTTPRelay) Put(ctx context.Context, docID string, blobs []Blob) error {
+	_, err := hr.do(ctx, http.MethodPost, url.Values{"doc": {docID}}, encodeBlobs(blobs))
+	return err
+}
+
+// Get implements [Relay]
+func (hr *HTTPRelay) Get(ctx context.Context, docID string, have []automerge.ChangeHash) ([]Blob, error) {
+	hs := []string{}
+	for _, h := range have {
+		hs = append(hs, h.String())
+	}
+	q := url.Values{"doc": {docID}}
+	if len(hs) > 0 {
+		q.Set("have", strings.Join(hs, ","))
+	}
+	b, err := hr.do(ctx, http.MethodGet, q, nil)
+	if err != nil {
+		return nil, err
+	}
+	return decodeBlobs(b)
+}
+
+// Heads implements [Relay]
+func (hr *HTTPRelay) Heads(ctx context.Context, docID string) ([]automerge.ChangeHash, error) {
+	b, err := hr.do(ctx, http.MethodGet, url.Values{"doc": {docID}, "heads": {"1"}}, nil)
+	if err != nil {
+		return nil, err
+	}
+	if len(b)%32 != 0 {
+		return nil, fmt.Errorf("e2ee: invalid heads response")
+	}
+	ret := []automerge.ChangeHash{}
+	for len(b) > 0 {
+		h := automerge.ChangeHash{}
+		copy(h[:], b)
+		ret = append(ret, h)
+		b = b[32:]
+	}
+	return ret, nil
+}
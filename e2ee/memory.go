@@ -0,0 +1,105 @@
+package e2ee
+
+import (
+	"context"
+	"sync"
+
+	"github.com/automerge/automerge-go"
+)
+
+// MemoryRelay is a [Relay] that keeps blobs in memory.
+// It is primarily useful for testing.
+type MemoryRelay struct {
+	m    sync.Mutex
+	docs map[string]*memoryDoc
+}
+
+type memoryDoc struct {
+	// blobs are kept in the order they were received, which is a
+	// valid order to apply them in as clients send dependencies first
+	blobs  []Blob
+	byHash map[automerge.ChangeHash]int
+}
+
+// NewMemoryRelay returns an empty [*MemoryRelay]
+func NewMemoryRelay() *MemoryRelay {
+	return &MemoryRelay{docs: map[string]*memoryDoc{}}
+}
+
+// Put implements [Relay]
+func (mr *MemoryRelay) Put(ctx context.Context, docID string, blobs []Blob) error {
+	mr.m.Lock()
+	defer mr.m.Unlock()
+	d := mr.docs[docID]
+	if d == nil {
+		d = &memoryDoc{byHash: map[automerge.ChangeHash]int{}}
+		mr.docs[docID] = d
+	}
+	for _, b := range blobs {
+		if _, ok := d.byHash[b.Hash]; ok {
+			continue
+		}
+		d.byHash[b.Hash] = len(d.blobs)
+		d.blobs = append(d.blobs, Blob{
+			Hash: b.Hash,
+			Deps: append([]automerge.ChangeHash{}, b.Deps...),
+			Data: append([]byte{}, b.Data...),
+		})
+	}
+	return nil
+}
+
+// Get implements [Relay]
+func (mr *MemoryRelay) Get(ctx context.Context, docID string, have []automerge.ChangeHash) ([]Blob, error) {
+	mr.m.Lock()
+	defer mr.m.Unlock()
+	d := mr.docs[docID]
+	if d == nil {
+		return []Blob{}, nil
+	}
+
+	// find all ancestors of have that the relay knows about
+	seen := map[automerge.ChangeHash]bool{}
+	stack := append([]automerge.ChangeHash{}, have...)
+	for len(stack) > 0 {
+		h := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		i, ok := d.byHash[h]
+		if !ok || seen[h] {
+			continue
+		}
+		seen[h] = true
+		stack = append(stack, d.blobs[i].Deps...)
+	}
+
+	ret := []Blob{}
+	for _, b := range d.blobs {
+		if !seen[b.Hash] {
+			ret = append(ret, b)
+		}
+	}
+	return ret, nil
+}
+
+// Heads implements [Relay]
+func (mr *MemoryRelay) Heads(ctx context.Context, docID string) ([]automerge.ChangeHash, error) {
+	mr.m.Lock()
+	defer mr.m.Unlock()
+	d := mr.docs[docID]
+	if d == nil {
+		return []automerge.ChangeHash{}, nil
+	}
+	isDep := map[automerge.ChangeHash]bool{}
+	for _, b := range d.blobs {
+		for _, dep := range b.Deps {
+			isDep[dep] = true
+		}
+	}
+	ret := []automerge.ChangeHash{}
+	for _, b := range d.blobs {
+		if !isDep[b.Hash] {
+			ret = append(ret, b.Hash)
+		}
+	}
+	return ret, nil
+}
@@ -0,0 +1,144 @@
+package automerge
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Errors returned by this package can be inspected with [errors.Is] and
+// [errors.As]. For example an HTTP server might map [ErrCorruptDocument] and
+// [*ErrKindMismatch] to 400, [ErrUnknownChange] to 404, [ErrEmptyCommit] to
+// 409, and other errors to 500.
+var (
+	// ErrDetached is returned when reading or writing a [Map], [List],
+	// [Text], [Counter] or [Path] that is not part of a document.
+	ErrDetached = errors.New("automerge: detached")
+
+	// ErrIndexOutOfRange is returned when reading or writing beyond the
+	// end of a [List] or [Text].
+	ErrIndexOutOfRange = errors.New("automerge: index out of range")
+
+	// ErrCorruptDocument is returned when a document, change or sync
+	// message cannot be decoded, or is not valid.
+	ErrCorruptDocument = errors.New("automerge: corrupt document")
+
+	// ErrUnknownChange is returned when a change hash does not refer to a
+	// change in the document.
+	ErrUnknownChange = errors.New("automerge: unknown change")
+
+	// ErrEmptyCommit is returned by [Doc.Commit] when there are no
+	// operations to commit, unless [CommitOptions] AllowEmpty is set.
+	ErrEmptyCommit = errors.New("automerge: commit is empty")
+
+	// ErrClosed is returned when using a [Doc], [Change] or [SyncState]
+	// after it has been closed.
+	ErrClosed = errors.New("automerge: closed")
+)
+
+// ErrKindMismatch is returned when a value in the document is not of the
+// kind required, for example when calling [Path.Map] on a path that contains
+// a list.
+type ErrKindMismatch struct {
+	Path *Path
+	Want Kind
+	Got  Kind
+
+	msg string
+}
+
+func (e *ErrKindMismatch) Error() string {
+	if e.msg != "" {
+		return e.msg
+	}
+	return fmt.Sprintf("automerge: %#v: expected %v, got %v", e.Path, e.Want, e.Got)
+}
+
+// PathError records an error and the path that caused it
+type PathError struct {
+	Path *Path
+	Err  error
+}
+
+func (e *PathError) Error() string {
+	return fmt.Sprintf("%#v: %v", e.Path, e.Err)
+}
+
+func (e *PathError) Unwrap() error {
+	return e.Err
+}
+
+// automergeError has its own message, matches a sentinel error with
+// [errors.Is], and wraps the underlying cause (if any).
+type automergeError struct {
+	msg      string
+	sentinel error
+	cause    error
+}
+
+func (e *automergeError) Error() string {
+	return e.msg
+}
+
+func (e *automergeError) Is(target error) bool {
+	return target == e.sentinel
+}
+
+func (e *automergeError) Unwrap() error {
+	return e.cause
+}
+
+// newError returns an error with the given message that matches sentinel
+func newError(sentinel error, format string, args ...any) error {
+	return &automergeError{msg: fmt.Sprintf(format, args...), sentinel: sentinel}
+}
+
+// markError returns err unchanged (but matching sentinel), or nil if err is nil
+func markError(sentinel error, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &automergeError{msg: err.Error(), sentinel: sentinel, cause: err}
+}
+
+func kindMismatch(p *Path, want, got Kind, format string, args ...any) error {
+	return &ErrKindMismatch{Path: p, Want: want, Got: got, msg: fmt.Sprintf(format, args...)}
+}
+
+// The automerge core reports failures only as a message (AMresultStatus has
+// no error codes), so coreError recognises the few messages that need a
+// sentinel. TestErrors pins these messages, so that upgrading the core
+// does not silently change which errors match.
+
+// closedMessages are the errors returned by the automerge core when passed
+// the nil pointers left behind by Close.
+var closedMessages = map[string]string{
+	"Invalid `AMdoc*`":       "automerge: document is closed",
+	"Invalid `AMchange*`":    "automerge: change is closed",
+	"Invalid `AMsyncState*`": "automerge: sync state is closed",
+}
+
+// coreError converts an error message from the automerge core into an
+// error, recognising the messages that indicate an index out of range
+// or a closed handle. Other messages match no sentinel until passed to
+// [markCorrupt].
+func coreError(msg string) error {
+	if strings.HasPrefix(msg, "Invalid pos ") {
+		return newError(ErrIndexOutOfRange, "%s", msg)
+	}
+	if closed, ok := closedMessages[msg]; ok {
+		return newError(ErrClosed, "%s", closed)
+	}
+	return &automergeError{msg: msg}
+}
+
+// markCorrupt marks an error from the automerge core as [ErrCorruptDocument],
+// for calls that decode or apply data. Errors that already match a sentinel,
+// and errors that did not come from the core, are returned unchanged.
+func markCorrupt(err error) error {
+	var ae *automergeError
+	if errors.As(err, &ae) && ae.sentinel == nil {
+		return markError(ErrCorruptDocument, err)
+	}
+	return err
+}
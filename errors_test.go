@@ -0,0 +1,105 @@
+package automerge_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/automerge/automerge-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestErrors(t *testing.T) {
+	t.Run("detached", func(t *testing.T) {
+		_, err := automerge.NewMap().Get("a")
+		require.ErrorIs(t, err, automerge.ErrDetached)
+		require.ErrorIs(t, automerge.NewList().Append(1), automerge.ErrDetached)
+		require.ErrorIs(t, automerge.NewCounter(1).Inc(1), automerge.ErrDetached)
+
+		p, err := automerge.NewPath("a")
+		require.NoError(t, err)
+		_, err = p.Get()
+		require.ErrorIs(t, err, automerge.ErrDetached)
+		var pe *automerge.PathError
+		require.ErrorAs(t, err, &pe)
+		require.Equal(t, []any{"a"}, pe.Path.Segments())
+	})
+
+	t.Run("kind mismatch", func(t *testing.T) {
+		doc := automerge.New()
+		require.NoError(t, doc.Path("s").Set("hello"))
+
+		_, err := doc.Path("s", "k").Get()
+		var pe *automerge.PathError
+		require.ErrorAs(t, err, &pe)
+		require.Equal(t, []any{"s", "k"}, pe.Path.Segments())
+
+		var km *automerge.ErrKindMismatch
+		require.ErrorAs(t, err, &km)
+		require.Equal(t, []any{"s"}, km.Path.Segments())
+		require.Equal(t, automerge.KindMap, km.Want)
+		require.Equal(t, automerge.KindStr, km.Got)
+
+		err = doc.Path("s").Counter().Inc(1)
+		require.ErrorAs(t, err, &km)
+		require.Equal(t, automerge.KindCounter, km.Want)
+	})
+
+	t.Run("index out of range", func(t *testing.T) {
+		doc := automerge.New()
+		require.NoError(t, doc.Path("l").Set([]any{1}))
+		require.ErrorIs(t, doc.Path("l").List().Set(5, 1), automerge.ErrIndexOutOfRange)
+		require.ErrorIs(t, doc.Path("l", 5).Set(1), automerge.ErrIndexOutOfRange)
+	})
+
+	t.Run("corrupt document", func(t *testing.T) {
+		_, err := automerge.Load([]byte("not a document"))
+		require.ErrorIs(t, err, automerge.ErrCorruptDocument)
+		_, err = automerge.LoadChange([]byte("not a change"))
+		require.ErrorIs(t, err, automerge.ErrCorruptDocument)
+		_, err = automerge.LoadSyncMessage([]byte("not a message"))
+		require.ErrorIs(t, err, automerge.ErrCorruptDocument)
+		_, err = automerge.DecodeOps([]byte("not a document"))
+		require.ErrorIs(t, err, automerge.ErrCorruptDocument)
+		require.ErrorIs(t, automerge.New().LoadIncremental([]byte("junk")), automerge.ErrCorruptDocument)
+	})
+
+	// the automerge core only reports messages, so this pins the ones
+	// that errors.go recognises
+	t.Run("core messages", func(t *testing.T) {
+		doc := automerge.New()
+		require.NoError(t, doc.Path("t").Set(automerge.NewText("ab")))
+		err := doc.Path("t").Text().Insert(5, "x")
+		require.ErrorIs(t, err, automerge.ErrIndexOutOfRange)
+		require.ErrorContains(t, err, "Invalid pos 5")
+
+		other := automerge.New()
+		require.NoError(t, other.Path("x").Set(1))
+		_, err = other.Commit("x")
+		require.NoError(t, err)
+
+		doc.Close()
+		_, err = doc.TryHeads()
+		require.ErrorIs(t, err, automerge.ErrClosed)
+		require.EqualError(t, err, "automerge: document is closed")
+
+		// loading into a closed document is not a corrupt document
+		err = doc.LoadIncremental(other.Save())
+		require.ErrorIs(t, err, automerge.ErrClosed)
+		require.False(t, errors.Is(err, automerge.ErrCorruptDocument))
+
+		_, err = automerge.Load([]byte("junk"))
+		require.ErrorIs(t, err, automerge.ErrCorruptDocument)
+		require.False(t, errors.Is(err, automerge.ErrIndexOutOfRange) || errors.Is(err, automerge.ErrClosed))
+	})
+
+	t.Run("unknown change", func(t *testing.T) {
+		_, err := automerge.New().Change(automerge.ChangeHash{})
+		require.ErrorIs(t, err, automerge.ErrUnknownChange)
+	})
+
+	t.Run("empty commit", func(t *testing.T) {
+		_, err := automerge.New().Commit("nothing")
+		require.ErrorIs(t, err, automerge.ErrEmptyCommit)
+		require.False(t, errors.Is(err, automerge.ErrCorruptDocument))
+	})
+}
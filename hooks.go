@@ -0,0 +1,149 @@
+package automerge
+
+// #include "automerge.h"
+import "C"
+
+// Hooks let you observe, or veto, changes to a document.
+// Register them with [Doc.AddHooks]; any field may be left nil.
+//
+// BeforeCommit is called with the document locked, and is given a read-only
+// copy of it. AfterCommit and AfterApply are called after the document has
+// been unlocked, so they may read from the document, but they should not
+// write to it.
+type Hooks struct {
+	// BeforeCommit is called before each local change is created, both by
+	// [Doc.Commit] and by methods that commit pending operations implicitly
+	// (such as [Doc.Save]). If it returns an error then all operations since
+	// the last commit are rolled back, and the method returns the error.
+	//
+	// It is called with the document locked, so that no other writes can
+	// happen before the change is created. d is a copy of the document that
+	// may be read from during the call, but not written to or retained; using
+	// the original document from the hook would deadlock.
+	BeforeCommit func(d *Doc) error
+
+	// AfterCommit is called after a local change has been created, with the
+	// hash of the new change. It is called without the document locked.
+	AfterCommit func(d *Doc, ch ChangeHash)
+
+	// AfterApply is called after changes from another peer have been
+	// applied by [Doc.Apply], [Doc.LoadIncremental], [Doc.Merge] or
+	// [SyncState.ReceiveMessage]. It is only called if the heads of the
+	// document changed, and before contains the heads prior to the changes.
+	// It is called without the document locked.
+	AfterApply func(d *Doc, before []ChangeHash)
+}
+
+// AddHooks registers hooks on the document. Hooks are run in the order in
+// which they were added. The returned function removes the hooks again.
+func (d *Doc) AddHooks(h Hooks) (remove func()) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	hp := &h
+	d.hooks = append(d.hooks, hp)
+	return func() {
+		d.m.Lock()
+		defer d.m.Unlock()
+		for i, o := range d.hooks {
+			if o == hp {
+				d.hooks = append(d.hooks[:i:i], d.hooks[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+func (d *Doc) currentHooks() []*Hooks {
+	d.m.Lock()
+	defer d.m.Unlock()
+	return d.hooks
+}
+
+// runBeforeCommit must be called with the document locked. The hooks are
+// passed a copy of the document that shares the native document but not the
+// lock, so that they can read from it; the copy is detached afterwards so
+// that it cannot be used once the document is unlocked.
+func (d *Doc) runBeforeCommit(cDoc *C.AMdoc) error {
+	var view *Doc
+	defer func() {
+		if view != nil {
+			view.m.Lock()
+			view.cDoc = nil
+			view.m.Unlock()
+		}
+	}()
+
+	for _, h := range d.hooks {
+		if h.BeforeCommit == nil {
+			continue
+		}
+		if view == nil {
+			view = &Doc{item: d.item, cDoc: cDoc, committing: true}
+		}
+		if err := h.BeforeCommit(view); err != nil {
+			C.AMrollback(cDoc)
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Doc) runAfterCommit(ch ChangeHash) {
+	for _, h := range d.currentHooks() {
+		if h.AfterCommit != nil {
+			h.AfterCommit(d, ch)
+		}
+	}
+}
+
+// afterApply records the heads of the document before changes are applied
+// so that AfterApply hooks can be run once the document is unlocked.
+type afterApply struct {
+	hooks  []*Hooks
+	before []ChangeHash
+}
+
+// prepareAfterApply must be called with the document locked.
+func (d *Doc) prepareAfterApply(cDoc *C.AMdoc) *afterApply {
+	if len(d.hooks) == 0 {
+		return nil
+	}
+	before, err := getHeads(cDoc)
+	if err != nil {
+		return nil
+	}
+	return &afterApply{hooks: d.hooks, before: before}
+}
+
+// run must be called with the document unlocked.
+func (a *afterApply) run(d *Doc) {
+	if a == nil {
+		return
+	}
+	heads, err := d.TryHeads()
+	if err != nil || sameHeads(a.before, heads) {
+		return
+	}
+	for _, h := range a.hooks {
+		if h.AfterApply != nil {
+			h.AfterApply(d, a.before)
+		}
+	}
+}
+
+func sameHeads(a, b []ChangeHash) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := map[ChangeHash]bool{}
+	for _, h := range a {
+		seen[h] = true
+	}
+	for _, h := range b {
+		if !seen[h] {
+			return false
+		}
+	}
+	return true
+}
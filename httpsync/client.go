@@ -0,0 +1,238 @@
+package httpsync
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/automerge/automerge-go"
+)
+
+// ClientOptions configure a [Client]
+type ClientOptions struct {
+	// HTTPClient is used to make requests. Defaults to [http.DefaultClient].
+	HTTPClient *http.Client
+	// Session identifies this client to the server. Reusing the same session
+	// when reconnecting lets the server resume from its saved sync state.
+	// Defaults to a random ID.
+	Session string
+	// LongPoll uses long-polling instead of Server-Sent Events to receive messages.
+	LongPoll bool
+	// Header is added to each request, for example to set an Authorization header.
+	Header http.Header
+}
+
+// Client is the client side of the HTTP sync protocol. It implements
+// [automerge.MessageConn] so it can be used with [automerge.SyncMessageConn].
+//
+// ReadMessage must only be called from one goroutine at a time, but
+// WriteMessage may be called concurrently with it.
+type Client struct {
+	url  string
+	opts ClientOptions
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// used only by ReadMessage
+	events *bufio.Reader
+	body   io.Closer
+}
+
+// NewClient returns a client for the handler at rawURL
+func NewClient(rawURL string, opts ...ClientOptions) (*Client, error) {
+	o := ClientOptions{}
+	for _, opt := range opts {
+		if opt.HTTPClient != nil {
+			o.HTTPClient = opt.HTTPClient
+		}
+		if opt.Session != "" {
+			o.Session = opt.Session
+		}
+		if opt.LongPoll {
+			o.LongPoll = true
+		}
+		if opt.Header != nil {
+			o.Header = opt.Header
+		}
+	}
+	if o.HTTPClient == nil {
+		o.HTTPClient = http.DefaultClient
+	}
+	if o.Session == "" {
+		b := make([]byte, 16)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		o.Session = hex.EncodeToString(b)
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	q := u.Query()
+	q.Set("session", o.Session)
+	u.RawQuery = q.Encode()
+
+	c := &Client{url: u.String(), opts: o}
+	c.ctx, c.cancel = context.WithCancel(context.Background())
+	return c, nil
+}
+
+// Sync keeps doc in sync with the handler at rawURL until ctx is cancelled
+// or an error occurs.
+func Sync(ctx context.Context, rawURL string, doc *automerge.Doc, opts ...ClientOptions) error {
+	c, err := NewClient(rawURL, opts...)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	return automerge.SyncMessageConn(ctx, doc, c)
+}
+
+// Session returns the client's session ID
+func (c *Client) Session() string {
+	return c.opts.Session
+}
+
+func (c *Client) do(method string, body []byte, accept string) (*http.Response, error) {
+	var r io.Reader
+	if body != nil {
+		r = bytes.NewReader(body)
+	}
+	req, err := http.NewRequestWithContext(c.ctx, method, c.url, r)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range c.opts.Header {
+		req.Header[k] = v
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
+	if accept != "" {
+		req.Header.Set("Accept", accept)
+	}
+
+	resp, err := c.opts.HTTPClient.Do(req)
+	if err != nil {
+		if c.ctx.Err() != nil {
+			return nil, io.EOF
+		}
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNoContent {
+		defer resp.Body.Close()
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("httpsync: %s %s: %s: %s", method, c.url, resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp, nil
+}
+
+// WriteMessage sends a sync message to the server
+func (c *Client) WriteMessage(msg []byte) error {
+	resp, err := c.do(http.MethodPost, msg, "")
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// ReadMessage waits for the next sync message from the server. It returns
+// [io.EOF] once the client is closed.
+func (c *Client) ReadMessage() ([]byte, error) {
+	if c.opts.LongPoll {
+		return c.poll()
+	}
+	return c.readEvent()
+}
+
+func (c *Client) poll() ([]byte, error) {
+	for {
+		resp, err := c.do(http.MethodGet, nil, "")
+		if err != nil {
+			return nil, err
+		}
+		msg, err := io.ReadAll(io.LimitReader(resp.Body, automerge.DefaultMaxSyncMessageSize))
+		resp.Body.Close()
+		if err != nil {
+			return nil, c.closedErr(err)
+		}
+		if resp.StatusCode == http.StatusOK {
+			return msg, nil
+		}
+	}
+}
+
+func (c *Client) readEvent() ([]byte, error) {
+	for {
+		if c.events == nil {
+			resp, err := c.do(http.MethodGet, nil, "text/event-stream")
+			if err != nil {
+				return nil, err
+			}
+			c.events = bufio.NewReader(resp.Body)
+			c.body = resp.Body
+		}
+
+		data, err := readEvent(c.events)
+		if err != nil {
+			c.body.Close()
+			c.events, c.body = nil, nil
+			if err == io.EOF && c.ctx.Err() == nil {
+				// the server closed the stream, reconnect
+				continue
+			}
+			return nil, c.closedErr(err)
+		}
+		if data == "" {
+			continue
+		}
+		msg, err := base64.StdEncoding.DecodeString(data)
+		if err != nil {
+			return nil, fmt.Errorf("httpsync: invalid event: %w", err)
+		}
+		return msg, nil
+	}
+}
+
+// readEvent reads the data of the next Server-Sent Event
+func readEvent(r *bufio.Reader) (string, error) {
+	data := []string{}
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			return strings.Join(data, "\n"), nil
+		}
+		if strings.HasPrefix(line, "data:") {
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " "))
+		}
+	}
+}
+
+func (c *Client) closedErr(err error) error {
+	if c.ctx.Err() != nil {
+		return io.EOF
+	}
+	return err
+}
+
+// Close cancels any outstanding requests
+func (c *Client) Close() error {
+	c.cancel()
+	return nil
+}
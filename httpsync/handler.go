@@ -0,0 +1,388 @@
+// Package httpsync runs the automerge sync protocol over plain HTTP, for
+// environments where WebSockets are not available.
+//
+// A client picks a random session ID and uses the same URL for everything:
+//
+//   - POST sends a sync message (the body) to the server.
+//   - GET with "Accept: text/event-stream" opens a stream of Server-Sent Events.
+//     Each event's data is a base64 encoded sync message.
+//   - GET otherwise long-polls: it returns 200 with the next sync message as the
+//     body, or 204 if there was nothing to send before the poll timed out.
+//
+// The session ID is passed in the "session" query parameter (EventSource cannot
+// set headers). The server keeps a [automerge.SyncState] per session, and can persist it
+// with [automerge.SyncState.Save] so that a client reconnecting with the same
+// session does not need to resend what the server already has.
+//
+// [Client] implements the protocol for Go programs:
+//
+//	err := httpsync.Sync(ctx, "https://example.com/docs/1", doc)
+package httpsync
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/automerge/automerge-go"
+	"github.com/automerge/automerge-go/repo"
+)
+
+// DefaultPollTimeout is how long a long-poll request waits for a message
+const DefaultPollTimeout = 25 * time.Second
+
+// DefaultSessionTimeout is how long an idle session is kept in memory
+const DefaultSessionTimeout = 10 * time.Minute
+
+// maxSessionLength bounds the length of session IDs chosen by clients
+const maxSessionLength = 128
+
+// Handler serves the HTTP sync protocol.
+type Handler struct {
+	// Doc returns the document to sync for a request (for example based on
+	// the URL path). If it returns an error the request fails with 404 Not Found.
+	Doc func(r *http.Request) (*automerge.Doc, error)
+
+	// Storage persists the sync state of each session under the key
+	// ["httpsync", <url path>, <session>]. If nil sessions are only kept in memory.
+	Storage repo.StorageAdapter
+
+	// PollTimeout is how long long-poll requests wait. Defaults to [DefaultPollTimeout].
+	PollTimeout time.Duration
+	// SessionTimeout is how long idle sessions are kept in memory.
+	// Defaults to [DefaultSessionTimeout].
+	SessionTimeout time.Duration
+	// Heartbeat is how often a comment is sent on idle event streams to
+	// keep proxies from closing them. Defaults to PollTimeout.
+	Heartbeat time.Duration
+
+	// OnError is called with errors that cannot be reported to the client,
+	// for example when saving the sync state fails.
+	OnError func(r *http.Request, err error)
+
+	m        sync.Mutex
+	sessions map[string]*session
+}
+
+// NewHandler returns a handler that syncs doc with every client
+func NewHandler(doc *automerge.Doc) *Handler {
+	return &Handler{Doc: func(*http.Request) (*automerge.Doc, error) { return doc, nil }}
+}
+
+type session struct {
+	m        sync.Mutex
+	ss       *automerge.SyncState
+	key      repo.StorageKey
+	received chan struct{}
+	lastUsed time.Time
+	active   int
+}
+
+// ServeHTTP implements [http.Handler]
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("session")
+	if id == "" || len(id) > maxSessionLength {
+		http.Error(w, "missing or invalid session", http.StatusBadRequest)
+		return
+	}
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	doc, err := h.Doc(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	s, err := h.session(r, doc, id)
+	if err != nil {
+		h.release(s)
+		h.reportError(r, err)
+		http.Error(w, "failed to load session", http.StatusInternalServerError)
+		return
+	}
+	defer h.release(s)
+
+	switch {
+	case r.Method == http.MethodPost:
+		h.receive(w, r, s)
+	case r.Header.Get("Accept") == "text/event-stream":
+		h.stream(w, r, s, doc)
+	default:
+		h.poll(w, r, s, doc)
+	}
+}
+
+func (h *Handler) reportError(r *http.Request, err error) {
+	if err != nil && h.OnError != nil {
+		h.OnError(r, err)
+	}
+}
+
+func (h *Handler) pollTimeout() time.Duration {
+	if h.PollTimeout > 0 {
+		return h.PollTimeout
+	}
+	return DefaultPollTimeout
+}
+
+// session returns the in-memory session, loading its sync state from storage if needed.
+func (h *Handler) session(r *http.Request, doc *automerge.Doc, id string) (*session, error) {
+	key := repo.StorageKey{"httpsync", r.URL.Path, id}
+
+	h.m.Lock()
+	if h.sessions == nil {
+		h.sessions = map[string]*session{}
+	}
+	h.expireSessions()
+	s := h.sessions[key.String()]
+	if s == nil {
+		s = &session{key: key, received: make(chan struct{}, 1)}
+		h.sessions[key.String()] = s
+	}
+	s.active++
+	h.m.Unlock()
+
+	s.m.Lock()
+	defer s.m.Unlock()
+	if s.ss != nil && s.ss.Doc == doc {
+		return s, nil
+	}
+
+	ss, err := automerge.TryNewSyncState(doc)
+	if err != nil {
+		return s, err
+	}
+	s.ss = ss
+	if h.Storage == nil {
+		return s, nil
+	}
+	b, err := h.Storage.Load(r.Context(), key)
+	if err != nil || b == nil {
+		return s, err
+	}
+	ss, err = automerge.LoadSyncState(doc, b)
+	if err != nil {
+		// a corrupt sync state only costs an extra round trip
+		h.reportError(r, fmt.Errorf("httpsync: ignoring invalid sync state for %s: %w", key, err))
+		return s, nil
+	}
+	s.ss = ss
+	return s, nil
+}
+
+func (h *Handler) release(s *session) {
+	h.m.Lock()
+	defer h.m.Unlock()
+	s.active--
+	s.lastUsed = time.Now()
+}
+
+// expireSessions must be called with h.m held
+func (h *Handler) expireSessions() {
+	timeout := h.SessionTimeout
+	if timeout <= 0 {
+		timeout = DefaultSessionTimeout
+	}
+	for k, s := range h.sessions {
+		if s.active == 0 && time.Since(s.lastUsed) > timeout {
+			delete(h.sessions, k)
+		}
+	}
+}
+
+// save persists the sync state, and must be called with s.m held
+func (h *Handler) save(r *http.Request, s *session) {
+	if h.Storage == nil {
+		return
+	}
+	// use a fresh context so the state is saved even if the client went away
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	b, err := s.ss.TrySave()
+	if err == nil {
+		err = h.Storage.Save(ctx, s.key, b)
+	}
+	if err != nil {
+		h.reportError(r, fmt.Errorf("httpsync: failed to save sync state for %s: %w", s.key, err))
+	}
+}
+
+func (h *Handler) receive(w http.ResponseWriter, r *http.Request, s *session) {
+	msg, err := io.ReadAll(http.MaxBytesReader(w, r.Body, automerge.DefaultMaxSyncMessageSize))
+	if err != nil {
+		status := http.StatusBadRequest
+		if errors.As(err, new(*http.MaxBytesError)) {
+			status = http.StatusRequestEntityTooLarge
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	s.m.Lock()
+	_, err = s.ss.ReceiveMessage(msg)
+	if err == nil {
+		h.save(r, s)
+	}
+	s.m.Unlock()
+
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// wake any pending GET for this session, as a reply may now be needed
+	select {
+	case s.received <- struct{}{}:
+	default:
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// next returns the next message to send to the client, if any.
+// Once the message has been written, call sent with the result.
+func (h *Handler) next(s *session) ([]byte, error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	msg, valid, err := s.ss.TryGenerateMessage()
+	if err != nil || !valid {
+		return nil, err
+	}
+	return msg.TrySignedBytes()
+}
+
+// sent saves the sync state after a message was written to the client. If
+// writing failed the client may not have received it, so the sync state
+// forgets what it has sent, and the next request starts again from the
+// heads both sides are known to share.
+func (h *Handler) sent(r *http.Request, s *session, err error) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	if err == nil {
+		h.save(r, s)
+		return
+	}
+	b, err := s.ss.TrySave()
+	var ss *automerge.SyncState
+	if err == nil {
+		ss, err = automerge.LoadSyncState(s.ss.Doc, b)
+	}
+	if err != nil {
+		if ss, err = automerge.TryNewSyncState(s.ss.Doc); err != nil {
+			h.reportError(r, fmt.Errorf("httpsync: failed to reset sync state for %s: %w", s.key, err))
+			return
+		}
+	}
+	s.ss.Close()
+	s.ss = ss
+}
+
+// watch returns a channel that receives a value when doc changes
+func watch(doc *automerge.Doc) (<-chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+	remove := doc.AddHooks(automerge.Hooks{
+		AfterCommit: func(*automerge.Doc, automerge.ChangeHash) { notify() },
+		AfterApply:  func(*automerge.Doc, []automerge.ChangeHash) { notify() },
+	})
+	return ch, remove
+}
+
+func (h *Handler) poll(w http.ResponseWriter, r *http.Request, s *session, doc *automerge.Doc) {
+	changed, remove := watch(doc)
+	defer remove()
+
+	timer := time.NewTimer(h.pollTimeout())
+	defer timer.Stop()
+
+	for {
+		msg, err := h.next(s)
+		if err != nil {
+			h.reportError(r, err)
+			http.Error(w, "could not generate sync message", http.StatusInternalServerError)
+			return
+		}
+		if msg != nil {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			_, err := w.Write(msg)
+			h.sent(r, s, err)
+			return
+		}
+		select {
+		case <-changed:
+		case <-s.received:
+		case <-timer.C:
+			w.WriteHeader(http.StatusNoContent)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (h *Handler) stream(w http.ResponseWriter, r *http.Request, s *session, doc *automerge.Doc) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		h.reportError(r, errors.New("httpsync: response does not support flushing"))
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	changed, remove := watch(doc)
+	defer remove()
+
+	heartbeat := h.Heartbeat
+	if heartbeat <= 0 {
+		heartbeat = h.pollTimeout()
+	}
+	ticker := time.NewTicker(heartbeat)
+	defer ticker.Stop()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		msg, err := h.next(s)
+		if err != nil {
+			h.reportError(r, err)
+			return
+		}
+		if msg != nil {
+			_, err := fmt.Fprintf(w, "data: %s\n\n", base64.StdEncoding.EncodeToString(msg))
+			if err == nil {
+				flusher.Flush()
+			}
+			h.sent(r, s, err)
+			if err != nil {
+				return
+			}
+			continue
+		}
+		select {
+		case <-changed:
+		case <-s.received:
+		case <-ticker.C:
+			if _, err := io.WriteString(w, ": heartbeat\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
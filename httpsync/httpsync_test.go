@@ -0,0 +1,159 @@
+package httpsync_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/automerge/automerge-go"
+	"github.com/automerge/automerge-go/httpsync"
+	"github.com/automerge/automerge-go/repo"
+	"github.com/stretchr/testify/require"
+)
+
+func getString(d *automerge.Doc, key string) string {
+	v, _ := automerge.As[string](d.Path(key).Get())
+	return v
+}
+
+func set(t *testing.T, d *automerge.Doc, key, value string) {
+	require.NoError(t, d.Path(key).Set(value))
+	_, err := d.Commit("set " + key)
+	require.NoError(t, err)
+}
+
+func TestSync(t *testing.T) {
+	for _, longPoll := range []bool{false, true} {
+		t.Run(fmt.Sprintf("longPoll=%v", longPoll), func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			serverDoc := automerge.New()
+			set(t, serverDoc, "server", "one")
+
+			// file storage needs the URL path in the key to be escaped
+			storage, err := repo.NewFileStorage(t.TempDir())
+			require.NoError(t, err)
+			errs := make(chan error, 10)
+			newServer := func() *httptest.Server {
+				return httptest.NewServer(&httpsync.Handler{
+					Doc: func(r *http.Request) (*automerge.Doc, error) {
+						if r.URL.Path != "/doc" {
+							return nil, fmt.Errorf("not found")
+						}
+						return serverDoc, nil
+					},
+					Storage:     storage,
+					PollTimeout: 50 * time.Millisecond,
+					Heartbeat:   10 * time.Millisecond,
+					OnError:     func(r *http.Request, err error) { errs <- err },
+				})
+			}
+			s := newServer()
+
+			clientDoc := automerge.New()
+			set(t, clientDoc, "client", "two")
+
+			opts := httpsync.ClientOptions{Session: "session-1", LongPoll: longPoll}
+			done := make(chan error, 1)
+			go func() { done <- httpsync.Sync(ctx, s.URL+"/doc", clientDoc, opts) }()
+
+			require.Eventually(t, func() bool {
+				return getString(clientDoc, "server") == "one" && getString(serverDoc, "client") == "two"
+			}, 5*time.Second, time.Millisecond)
+
+			// changes are pushed after polls time out and heartbeats are sent
+			time.Sleep(100 * time.Millisecond)
+			set(t, serverDoc, "server", "three")
+			require.Eventually(t, func() bool { return getString(clientDoc, "server") == "three" }, 5*time.Second, time.Millisecond)
+			set(t, clientDoc, "client", "four")
+			require.Eventually(t, func() bool { return getString(serverDoc, "client") == "four" }, 5*time.Second, time.Millisecond)
+
+			cancel()
+			require.ErrorIs(t, <-done, context.Canceled)
+			s.Close()
+
+			saved, err := storage.Load(context.Background(), repo.StorageKey{"httpsync", "/doc", "session-1"})
+			require.NoError(t, err)
+			require.NotNil(t, saved)
+
+			// a new server resumes the session from storage
+			ctx, cancel = context.WithCancel(context.Background())
+			defer cancel()
+			s = newServer()
+			defer s.Close()
+			go func() { done <- httpsync.Sync(ctx, s.URL+"/doc", clientDoc, opts) }()
+			set(t, clientDoc, "client", "five")
+			require.Eventually(t, func() bool { return getString(serverDoc, "client") == "five" }, 5*time.Second, time.Millisecond)
+			cancel()
+			<-done
+
+			select {
+			case err := <-errs:
+				t.Fatal(err)
+			default:
+			}
+		})
+	}
+}
+
+func TestHandler_Errors(t *testing.T) {
+	s := httptest.NewServer(&httpsync.Handler{
+		Doc: func(r *http.Request) (*automerge.Doc, error) { return nil, fmt.Errorf("no such document") },
+	})
+	defer s.Close()
+
+	resp, err := http.Get(s.URL + "/doc")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	err = httpsync.Sync(context.Background(), s.URL+"/doc", automerge.New())
+	require.ErrorContains(t, err, "404 Not Found: no such document")
+
+	s2 := httptest.NewServer(httpsync.NewHandler(automerge.New()))
+	defer s2.Close()
+	resp, err = http.Post(s2.URL+"?session=x", "application/octet-stream", nil)
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+	// messages over the size limit are rejected as too large
+	body := io.LimitReader(zeros{}, automerge.DefaultMaxSyncMessageSize+1)
+	rec := httptest.NewRecorder()
+	httpsync.NewHandler(automerge.New()).ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/?session=x", body))
+	require.Equal(t, http.StatusRequestEntityTooLarge, rec.Code)
+}
+
+type zeros struct{}
+
+func (zeros) Read(b []byte) (int, error) {
+	for i := range b {
+		b[i] = 0
+	}
+	return len(b), nil
+}
+
+// failingWriter is a ResponseWriter whose client has gone away
+type failingWriter struct{ *httptest.ResponseRecorder }
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("connection reset") }
+
+func TestHandler_WriteError(t *testing.T) {
+	doc := automerge.New()
+	set(t, doc, "x", "y")
+	h := httpsync.NewHandler(doc)
+	h.PollTimeout = 50 * time.Millisecond
+
+	// a message that could not be written is sent again by the next poll
+	h.ServeHTTP(failingWriter{httptest.NewRecorder()}, httptest.NewRequest(http.MethodGet, "/?session=x", nil))
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?session=x", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	require.NotEmpty(t, rec.Body.Bytes())
+}
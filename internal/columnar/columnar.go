@@ -0,0 +1,120 @@
+// Package columnar reads the primitives of the automerge binary format
+// (https://automerge.org/automerge-binary-format-spec/). It is shared by
+// the automerge package, which decodes operations, and cmd/automerge-debug,
+// which prints every byte of a chunk.
+package columnar
+
+import (
+	"bytes"
+	"compress/flate"
+	"errors"
+	"io"
+	"math"
+)
+
+// ErrTooLarge is returned when decompressed data exceeds its limit
+var ErrTooLarge = errors.New("compressed data is too large")
+
+// Deflate can expand data by a factor of about 1000, these limits keep the
+// size of decompressed data proportional to the size of the input, while
+// leaving room for the very repetitive columns of large documents.
+const (
+	// MaxInflateRatio is how many times larger than its input
+	// decompressed data may be
+	MaxInflateRatio = 64
+	// MinInflateLimit is the limit for small inputs
+	MinInflateLimit = 1 << 20
+)
+
+// InflateLimit returns the maximum size of data decompressed from n bytes
+func InflateLimit(n int) int {
+	if n > math.MaxInt/MaxInflateRatio {
+		return math.MaxInt - 1
+	}
+	if l := n * MaxInflateRatio; l > MinInflateLimit {
+		return l
+	}
+	return MinInflateLimit
+}
+
+// AppendULEB appends the unsigned LEB128 encoding of n to b
+func AppendULEB(b []byte, n uint64) []byte {
+	for n >= 0x80 {
+		b = append(b, byte(n)|0x80)
+		n >>= 7
+	}
+	return append(b, byte(n))
+}
+
+// ReadULEB reads an unsigned LEB128 number from the start of b.
+//
+// If the number is encoded with too many bytes, or does not fit in 64 bits,
+// the (truncated) value and the rest of b are returned along with the error,
+// so that callers which print malformed input can continue past it.
+func ReadULEB(b []byte) (uint64, []byte, error) {
+	var n uint64
+	for i := 0; ; i++ {
+		if len(b) == 0 {
+			return 0, nil, errors.New("failed to find end of LEB")
+		}
+		c := b[0]
+		b = b[1:]
+		if i < 10 {
+			n |= uint64(c&0x7f) << (i * 7)
+		}
+		if c&0x80 == 0 {
+			if i >= 10 || i == 9 && c > 1 {
+				return n, b, errors.New("LEB > 64-bit")
+			}
+			if c == 0 && i > 0 {
+				return n, b, errors.New("overly long LEB")
+			}
+			return n, b, nil
+		}
+	}
+}
+
+// ReadSLEB reads a signed LEB128 number from the start of b. Errors are
+// returned in the same way as [ReadULEB].
+func ReadSLEB(b []byte) (int64, []byte, error) {
+	var n uint64
+	var prev byte
+	for i := 0; ; i++ {
+		if len(b) == 0 {
+			return 0, nil, errors.New("failed to find end of LEB")
+		}
+		c := b[0]
+		b = b[1:]
+		if i < 10 {
+			n |= uint64(c&0x7f) << (i * 7)
+		}
+		if c&0x80 == 0 {
+			if c&0x40 > 0 && i < 9 {
+				n |= math.MaxUint64 << ((i + 1) * 7)
+			}
+			if i >= 10 || i == 9 && c != 0 && c != 0x7f {
+				return int64(n), b, errors.New("LEB > 64-bit")
+			}
+			if i > 0 && (prev&0x40 == 0 && c == 0 || prev&0x40 > 0 && c == 0x7f) {
+				return int64(n), b, errors.New("overly long LEB")
+			}
+			return int64(n), b, nil
+		}
+		prev = c
+	}
+}
+
+// Inflate decompresses deflated data, returning ErrTooLarge if the
+// result would be longer than limit bytes.
+func Inflate(b []byte, limit int) ([]byte, error) {
+	r := flate.NewReader(bytes.NewReader(b))
+	defer r.Close()
+	out, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(out) > limit {
+		return nil, ErrTooLarge
+	}
+	return out, nil
+}
@@ -0,0 +1,67 @@
+package columnar_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"testing"
+
+	"github.com/automerge/automerge-go/internal/columnar"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadLEB(t *testing.T) {
+	for _, n := range []uint64{0, 1, 127, 128, 1 << 35, 1<<64 - 1} {
+		b := columnar.AppendULEB(nil, n)
+		v, rest, err := columnar.ReadULEB(append(b, 9))
+		require.NoError(t, err)
+		require.Equal(t, n, v)
+		require.Equal(t, []byte{9}, rest)
+	}
+
+	for _, tc := range []struct {
+		b []byte
+		v int64
+	}{{[]byte{0}, 0}, {[]byte{0x7f}, -1}, {[]byte{0x3f}, 63}, {[]byte{0xc0, 0}, 64}, {[]byte{0xb0, 0x7f}, -80}} {
+		v, rest, err := columnar.ReadSLEB(tc.b)
+		require.NoError(t, err)
+		require.Equal(t, tc.v, v)
+		require.Empty(t, rest)
+	}
+
+	// malformed numbers are returned with the error, so they can be printed
+	v, rest, err := columnar.ReadULEB([]byte{0x81, 0, 9})
+	require.EqualError(t, err, "overly long LEB")
+	require.Equal(t, uint64(1), v)
+	require.Equal(t, []byte{9}, rest)
+
+	s, _, err := columnar.ReadSLEB([]byte{0xd0, 0x7f})
+	require.EqualError(t, err, "overly long LEB")
+	require.Equal(t, int64(-48), s)
+
+	_, _, err = columnar.ReadULEB(bytes.Repeat([]byte{0xff}, 10))
+	require.EqualError(t, err, "failed to find end of LEB")
+	_, rest, err = columnar.ReadULEB(append(bytes.Repeat([]byte{0xff}, 10), 1, 9))
+	require.EqualError(t, err, "LEB > 64-bit")
+	require.Equal(t, []byte{9}, rest)
+	_, _, err = columnar.ReadSLEB(append(bytes.Repeat([]byte{0xff}, 9), 0x3f))
+	require.EqualError(t, err, "LEB > 64-bit")
+}
+
+func TestInflate(t *testing.T) {
+	buf := &bytes.Buffer{}
+	w, err := flate.NewWriter(buf, flate.BestCompression)
+	require.NoError(t, err)
+	_, err = w.Write(make([]byte, 1000))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+
+	out, err := columnar.Inflate(buf.Bytes(), 1000)
+	require.NoError(t, err)
+	require.Len(t, out, 1000)
+
+	_, err = columnar.Inflate(buf.Bytes(), 999)
+	require.ErrorIs(t, err, columnar.ErrTooLarge)
+
+	require.Equal(t, columnar.MinInflateLimit, columnar.InflateLimit(10))
+	require.Equal(t, 1<<20*columnar.MaxInflateRatio, columnar.InflateLimit(1<<20))
+}
@@ -51,6 +51,7 @@ var (
 	kindObjType     Kind = C.AM_VAL_TYPE_OBJ_TYPE
 	kindSyncState   Kind = C.AM_VAL_TYPE_SYNC_STATE
 	kindSyncMessage Kind = C.AM_VAL_TYPE_SYNC_MESSAGE
+	kindSyncHave    Kind = C.AM_VAL_TYPE_SYNC_HAVE
 	kindMark        Kind = C.AM_VAL_TYPE_MARK
 )
 
@@ -76,6 +77,7 @@ var kindDescriptions = map[Kind]string{
 	kindObjType:     "kindObjType",
 	kindSyncState:   "kindSyncState",
 	kindSyncMessage: "kindSyncMessage",
+	kindSyncHave:    "kindSyncHave",
 	kindMark:        "KindMark",
 }
 
@@ -153,6 +155,7 @@ func (i *item) change() *Change {
 	if !C.AMitemToChange(i.cItem, &c) {
 		i.failCast(kindChange)
 	}
+	i.result.retain()
 	return &Change{item: i, cChange: c}
 }
 
@@ -173,6 +176,7 @@ func (i *item) doc() *Doc {
 	if !C.AMitemToDoc(i.cItem, &d.cDoc) {
 		i.failCast(kindDoc)
 	}
+	i.result.retain()
 	return d
 }
 
@@ -250,6 +254,7 @@ func (i *item) syncState() *SyncState {
 	if !C.AMitemToSyncState(i.cItem, &ss.cSyncState) {
 		i.failCast(kindSyncState)
 	}
+	i.result.retain()
 	return ss
 }
 
@@ -266,6 +271,32 @@ func (i *item) syncMessage() *SyncMessage {
 	return ss
 }
 
+func (i *item) syncHave() (SyncHave, error) {
+	defer runtime.KeepAlive(i)
+
+	var sh *C.AMsyncHave
+	if !C.AMitemToSyncHave(i.cItem, &sh) {
+		i.failCast(kindSyncHave)
+	}
+	items, err := wrap(C.AMsyncHaveLastSync(sh)).items()
+	if err != nil {
+		return SyncHave{}, err
+	}
+	return SyncHave{LastSync: mapItems(items, func(i *item) ChangeHash { return i.changeHash() })}, nil
+}
+
+func syncHaves(items []*item) ([]SyncHave, error) {
+	ret := make([]SyncHave, 0, len(items))
+	for _, i := range items {
+		sh, err := i.syncHave()
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, sh)
+	}
+	return ret, nil
+}
+
 type objID struct {
 	item *item
 
@@ -280,6 +311,21 @@ func (o *objID) objKind(d *Doc) Kind {
 	return Kind(C.AMobjObjType(d.cDoc, o.cObjID)) | kindObjType
 }
 
+// opID returns the ID of the operation that created the object, or the
+// zero OpID for the root
+func (o *objID) opID() OpID {
+	if o == nil {
+		return OpID{}
+	}
+	defer runtime.KeepAlive(o)
+	counter := uint64(C.AMobjIdCounter(o.cObjID))
+	if counter == 0 {
+		return OpID{}
+	}
+	actor := fromByteSpanStr(C.AMactorIdStr(C.AMobjIdActorId(o.cObjID)))
+	return OpID{Counter: counter, Actor: actor}
+}
+
 func itemFromActorID(id string) (*item, error) {
 	bytes, free := toByteSpanStr(id)
 	defer free()
@@ -306,12 +352,15 @@ func createItems(is []*item) (*C.AMitems, func()) {
 		return nil, func() {}
 	}
 
-	result := is[0].result
+	// an item's result may contain other items too (for example if it came
+	// from Doc.Changes), so each item is copied into a result of its own.
+	result := wrap(C.AMitemResult(is[0].cItem))
 	for _, i := range is[1:] {
-		result = wrap(C.AMresultCat(result.cResult, i.result.cResult))
+		result = wrap(C.AMresultCat(result.cResult, wrap(C.AMitemResult(i.cItem)).cResult))
 	}
 	ret := C.AMresultItems(result.cResult)
 	return &ret, func() {
+		runtime.KeepAlive(is)
 		runtime.KeepAlive(result)
 	}
 }
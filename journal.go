@@ -0,0 +1,376 @@
+package automerge
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// DefaultJournalCheckpointSize is the default for [JournalOptions].CheckpointSize
+const DefaultJournalCheckpointSize = 4 << 20
+
+// JournalOptions configure a [Journal]
+type JournalOptions struct {
+	// CheckpointSize is the size in bytes that the log can grow to before
+	// the document is saved and the log is truncated.
+	// Defaults to [DefaultJournalCheckpointSize].
+	CheckpointSize int64
+	// OnError is called if a change cannot be written to the log.
+	// The error is also returned by [Journal.Err], and by the next commit.
+	OnError func(err error)
+}
+
+// Journal makes a document durable by appending every change to a log file
+// as soon as it is committed or applied, and periodically saving a snapshot
+// of the whole document (a checkpoint).
+//
+// The snapshot is stored at the path passed to [OpenJournaled], and the log
+// next to it with a ".log" suffix. Each record in the log contains a length, a
+// CRC-32C checksum and the bytes of the changes (as returned by [SaveChanges]),
+// and the file is synced to disk after every record. If the process crashes
+// while writing a record, the incomplete record is discarded the next time
+// the journal is opened. A damaged record anywhere else in the log is
+// reported as an error matching [ErrCorruptDocument].
+//
+// If a change cannot be written, later commits to the document fail with the
+// error (discarding their operations, as when a [Hooks] BeforeCommit hook
+// fails), and changes from other peers are only kept in memory, until
+// [Journal.Checkpoint] succeeds.
+//
+// Signatures for the changes (see [Doc.SetSigner]) are written to the log in
+// a record after the changes, and saved next to the snapshot with a ".sig"
+// suffix (as returned by [Doc.SaveSignatures]).
+type Journal struct {
+	doc  *Doc
+	path string
+	opts JournalOptions
+
+	m           sync.Mutex
+	log         *os.File
+	size        int64
+	heads       []ChangeHash
+	removeHooks func()
+
+	// errm protects err, which is read by the BeforeCommit hook with the
+	// document locked (so j.m cannot be used)
+	errm sync.Mutex
+	err  error
+}
+
+var journalCRC = crc32.MakeTable(crc32.Castagnoli)
+
+const journalHeaderSize = 8
+
+// OpenJournaled opens (or creates) the journaled document stored at path.
+// The last checkpoint is loaded and then any changes in the log are replayed.
+// Call [Journal.Close] when finished with the document.
+func OpenJournaled(path string, opts ...JournalOptions) (*Journal, error) {
+	j := &Journal{path: path}
+	for _, o := range opts {
+		if o.CheckpointSize != 0 {
+			j.opts.CheckpointSize = o.CheckpointSize
+		}
+		if o.OnError != nil {
+			j.opts.OnError = o.OnError
+		}
+	}
+	if j.opts.CheckpointSize <= 0 {
+		j.opts.CheckpointSize = DefaultJournalCheckpointSize
+	}
+
+	b, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		if j.doc, err = TryNew(); err != nil {
+			return nil, fmt.Errorf("automerge.OpenJournaled: %w", err)
+		}
+	case err != nil:
+		return nil, fmt.Errorf("automerge.OpenJournaled: %w", err)
+	default:
+		if j.doc, err = Load(b); err != nil {
+			return nil, fmt.Errorf("automerge.OpenJournaled: invalid snapshot: %w", err)
+		}
+	}
+
+	b, err = os.ReadFile(j.sigPath())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("automerge.OpenJournaled: %w", err)
+	}
+	if err := j.doc.LoadSignatures(b); err != nil {
+		return nil, fmt.Errorf("automerge.OpenJournaled: invalid signatures: %w", err)
+	}
+
+	j.log, err = os.OpenFile(j.logPath(), os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("automerge.OpenJournaled: %w", err)
+	}
+	if err := j.replay(); err != nil {
+		j.log.Close()
+		return nil, err
+	}
+
+	if j.heads, err = j.doc.TryHeads(); err != nil {
+		j.log.Close()
+		return nil, fmt.Errorf("automerge.OpenJournaled: %w", err)
+	}
+	j.removeHooks = j.doc.AddHooks(Hooks{
+		BeforeCommit: func(*Doc) error { return j.Err() },
+		AfterCommit:  func(*Doc, ChangeHash) { j.append() },
+		AfterApply:   func(*Doc, []ChangeHash) { j.append() },
+	})
+	return j, nil
+}
+
+func (j *Journal) logPath() string {
+	return j.path + ".log"
+}
+
+func (j *Journal) sigPath() string {
+	return j.path + ".sig"
+}
+
+// replay applies the records in the log to the document. If the last record
+// is incomplete (or fails its checksum) it was torn by a crash, and the log
+// is truncated after the last complete record.
+func (j *Journal) replay() error {
+	data, err := io.ReadAll(j.log)
+	if err != nil {
+		return fmt.Errorf("automerge.OpenJournaled: %w", err)
+	}
+
+	valid := 0
+	for len(data)-valid >= journalHeaderSize {
+		n := int(binary.BigEndian.Uint32(data[valid:]))
+		sum := binary.BigEndian.Uint32(data[valid+4:])
+		start := valid + journalHeaderSize
+		if n > len(data)-start {
+			break
+		}
+		if crc32.Checksum(data[start:start+n], journalCRC) != sum {
+			if start+n == len(data) {
+				break
+			}
+			return newError(ErrCorruptDocument, "automerge.OpenJournaled: corrupt log record at offset %d", valid)
+		}
+		if err := j.replayRecord(data[start : start+n]); err != nil {
+			return fmt.Errorf("automerge.OpenJournaled: invalid log record: %w", err)
+		}
+		valid = start + n
+	}
+
+	if valid < len(data) {
+		if err := j.log.Truncate(int64(valid)); err != nil {
+			return fmt.Errorf("automerge.OpenJournaled: %w", err)
+		}
+		if err := j.log.Sync(); err != nil {
+			return fmt.Errorf("automerge.OpenJournaled: %w", err)
+		}
+	}
+	if _, err := j.log.Seek(int64(valid), io.SeekStart); err != nil {
+		return fmt.Errorf("automerge.OpenJournaled: %w", err)
+	}
+	j.size = int64(valid)
+	return nil
+}
+
+// replayRecord applies one record, which contains either changes or the
+// signatures for them.
+func (j *Journal) replayRecord(rec []byte) error {
+	if len(rec) > 0 && rec[0] == signedMessageType {
+		sigs, _, err := splitSignedMessage(rec)
+		if err != nil {
+			return err
+		}
+		j.doc.AddSignatures(sigs)
+		return nil
+	}
+	return j.doc.LoadIncremental(rec)
+}
+
+// Doc returns the journaled document
+func (j *Journal) Doc() *Doc {
+	return j.doc
+}
+
+// Err returns the error encountered writing to the log, if any. Once an
+// error has occurred no further changes are written, and commits fail,
+// until [Journal.Checkpoint] succeeds.
+func (j *Journal) Err() error {
+	j.errm.Lock()
+	defer j.errm.Unlock()
+	return j.err
+}
+
+func (j *Journal) setErr(err error) {
+	j.errm.Lock()
+	defer j.errm.Unlock()
+	j.err = err
+}
+
+// append writes the changes since the last record to the log
+func (j *Journal) append() {
+	j.m.Lock()
+	defer j.m.Unlock()
+	if j.log == nil || j.Err() != nil {
+		return
+	}
+
+	err := j.write()
+	if err == nil && j.size >= j.opts.CheckpointSize {
+		err = j.checkpoint()
+	}
+	if err != nil {
+		err = fmt.Errorf("automerge.Journal: %w", err)
+		j.setErr(err)
+		if j.opts.OnError != nil {
+			j.opts.OnError(err)
+		}
+	}
+}
+
+// write must be called with j.m held
+func (j *Journal) write() error {
+	// the heads are read first so that a concurrent change is written
+	// twice rather than not at all
+	heads, err := j.doc.TryHeads()
+	if err != nil {
+		return err
+	}
+	changes, err := j.doc.Changes(j.heads...)
+	if err != nil || len(changes) == 0 {
+		return err
+	}
+	rec := journalRecord(SaveChanges(changes))
+
+	hashes := make([]ChangeHash, 0, len(changes))
+	for _, ch := range changes {
+		hashes = append(hashes, ch.Hash())
+	}
+	if sigs := j.doc.Signatures(hashes...); len(sigs) > 0 {
+		rec = append(rec, journalRecord(appendSignedMessage(sigs, nil))...)
+	}
+
+	if _, err := j.log.Write(rec); err != nil {
+		return err
+	}
+	if err := j.log.Sync(); err != nil {
+		return err
+	}
+	j.size += int64(len(rec))
+	j.heads = heads
+	return nil
+}
+
+// journalRecord prefixes data with its length and checksum
+func journalRecord(data []byte) []byte {
+	rec := make([]byte, journalHeaderSize, journalHeaderSize+len(data))
+	binary.BigEndian.PutUint32(rec, uint32(len(data)))
+	binary.BigEndian.PutUint32(rec[4:], crc32.Checksum(data, journalCRC))
+	return append(rec, data...)
+}
+
+// Checkpoint saves the document and truncates the log. If writing to the
+// log had failed, a successful checkpoint saves the changes that were not
+// written and clears the error.
+func (j *Journal) Checkpoint() error {
+	j.m.Lock()
+	defer j.m.Unlock()
+	if j.log == nil {
+		return fmt.Errorf("automerge.Journal: closed")
+	}
+	if err := j.checkpoint(); err != nil {
+		return fmt.Errorf("automerge.Journal: %w", err)
+	}
+	j.setErr(nil)
+	return nil
+}
+
+// checkpoint must be called with j.m held. If the process crashes after
+// the snapshot is written but before the log is truncated, the changes in
+// the log are replayed again, which is harmless. The signatures are saved
+// before the snapshot, so that they are never older than it.
+func (j *Journal) checkpoint() error {
+	heads, err := j.doc.TryHeads()
+	if err != nil {
+		return err
+	}
+	data, err := j.doc.TrySave()
+	if err != nil {
+		return err
+	}
+
+	if sigs := j.doc.Signatures(); len(sigs) > 0 {
+		if err := writeFileAtomic(j.sigPath(), EncodeSignatures(sigs)); err != nil {
+			return err
+		}
+	}
+	if err := writeFileAtomic(j.path, data); err != nil {
+		return err
+	}
+
+	if err := j.log.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := j.log.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := j.log.Sync(); err != nil {
+		return err
+	}
+	j.size = 0
+	j.heads = heads
+	return nil
+}
+
+// writeFileAtomic replaces the file at path with data, so that after a crash
+// the file contains either the old or the new data.
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	f, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if d, err := os.Open(dir); err == nil {
+		// not all platforms support syncing a directory
+		d.Sync()
+		d.Close()
+	}
+	return nil
+}
+
+// Close stops journaling the document and closes the log.
+// The document is not checkpointed; call [Journal.Checkpoint] first
+// to make the next open faster.
+func (j *Journal) Close() error {
+	j.m.Lock()
+	defer j.m.Unlock()
+	if j.log == nil {
+		return nil
+	}
+	j.removeHooks()
+	err := j.log.Close()
+	j.log = nil
+	if e := j.Err(); e != nil {
+		return e
+	}
+	return err
+}
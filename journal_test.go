@@ -0,0 +1,180 @@
+package automerge_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/automerge/automerge-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJournal(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "doc.automerge")
+
+	j, err := automerge.OpenJournaled(path)
+	require.NoError(t, err)
+	doc := j.Doc()
+	for i := 0; i < 3; i++ {
+		require.NoError(t, doc.Path("n").Set(i))
+		_, err := doc.Commit("set n")
+		require.NoError(t, err)
+	}
+
+	// remote changes are journaled too
+	other := automerge.New()
+	require.NoError(t, other.Path("remote").Set(true))
+	_, err = other.Commit("remote")
+	require.NoError(t, err)
+	_, err = doc.Merge(other)
+	require.NoError(t, err)
+	heads := doc.Heads()
+
+	// simulate a crash: nothing has been checkpointed
+	_, err = os.Stat(path)
+	require.True(t, os.IsNotExist(err))
+	require.NoError(t, j.Close())
+
+	j, err = automerge.OpenJournaled(path)
+	require.NoError(t, err)
+	require.Equal(t, heads, j.Doc().Heads())
+	remote, err := automerge.As[bool](j.Doc().Path("remote").Get())
+	require.NoError(t, err)
+	require.True(t, remote)
+
+	// a checkpoint saves the document and empties the log
+	require.NoError(t, j.Checkpoint())
+	info, err := os.Stat(path + ".log")
+	require.NoError(t, err)
+	require.Zero(t, info.Size())
+	require.NoError(t, j.Close())
+
+	j, err = automerge.OpenJournaled(path)
+	require.NoError(t, err)
+	require.Equal(t, heads, j.Doc().Heads())
+	require.NoError(t, j.Close())
+}
+
+func TestJournal_TornTail(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "doc.automerge")
+
+	j, err := automerge.OpenJournaled(path)
+	require.NoError(t, err)
+	require.NoError(t, j.Doc().Path("a").Set(1))
+	_, err = j.Doc().Commit("a")
+	require.NoError(t, err)
+	heads := j.Doc().Heads()
+	info, err := os.Stat(path + ".log")
+	require.NoError(t, err)
+	good := info.Size()
+
+	require.NoError(t, j.Doc().Path("b").Set(2))
+	_, err = j.Doc().Commit("b")
+	require.NoError(t, err)
+	require.NoError(t, j.Close())
+
+	// cut the last record short, as if the process died mid-write
+	require.NoError(t, os.Truncate(path+".log", good+10))
+
+	j, err = automerge.OpenJournaled(path)
+	require.NoError(t, err)
+	require.Equal(t, heads, j.Doc().Heads())
+	info, err = os.Stat(path + ".log")
+	require.NoError(t, err)
+	require.Equal(t, good, info.Size())
+
+	// new changes are appended after the last good record
+	require.NoError(t, j.Doc().Path("c").Set(3))
+	_, err = j.Doc().Commit("c")
+	require.NoError(t, err)
+	require.NoError(t, j.Close())
+
+	// a corrupted record is treated the same way
+	b, err := os.ReadFile(path + ".log")
+	require.NoError(t, err)
+	b[len(b)-1] ^= 0xff
+	require.NoError(t, os.WriteFile(path+".log", b, 0o644))
+
+	j, err = automerge.OpenJournaled(path)
+	require.NoError(t, err)
+	require.Equal(t, heads, j.Doc().Heads())
+	require.NoError(t, j.Close())
+
+	// but damage before the last record is reported, not truncated
+	b, err = os.ReadFile(path + ".log")
+	require.NoError(t, err)
+	b[good-1] ^= 0xff
+	b = append(b, b...)
+	require.NoError(t, os.WriteFile(path+".log", b, 0o644))
+
+	_, err = automerge.OpenJournaled(path)
+	require.ErrorIs(t, err, automerge.ErrCorruptDocument)
+	info, err = os.Stat(path + ".log")
+	require.NoError(t, err)
+	require.Equal(t, int64(len(b)), info.Size())
+}
+
+func TestJournal_WriteError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "doc.automerge")
+
+	var reported error
+	j, err := automerge.OpenJournaled(path, automerge.JournalOptions{
+		CheckpointSize: 1,
+		OnError:        func(err error) { reported = err },
+	})
+	require.NoError(t, err)
+
+	// a directory in the way makes the checkpoint fail
+	require.NoError(t, os.Mkdir(path, 0o755))
+	require.NoError(t, j.Doc().Path("a").Set(1))
+	_, err = j.Doc().Commit("a")
+	require.NoError(t, err)
+	require.Error(t, j.Err())
+	require.Equal(t, j.Err(), reported)
+
+	// later commits fail until a checkpoint succeeds
+	require.NoError(t, j.Doc().Path("b").Set(2))
+	_, err = j.Doc().Commit("b")
+	require.ErrorIs(t, err, j.Err())
+	require.Error(t, j.Checkpoint())
+
+	require.NoError(t, os.Remove(path))
+	require.NoError(t, j.Checkpoint())
+	require.NoError(t, j.Err())
+	require.NoError(t, j.Doc().Path("b").Set(2))
+	_, err = j.Doc().Commit("b")
+	require.NoError(t, err)
+	heads := j.Doc().Heads()
+	require.NoError(t, j.Close())
+
+	j, err = automerge.OpenJournaled(path)
+	require.NoError(t, err)
+	require.Equal(t, heads, j.Doc().Heads())
+	require.NoError(t, j.Close())
+}
+
+func TestJournal_Checkpoint(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "doc.automerge")
+
+	j, err := automerge.OpenJournaled(path, automerge.JournalOptions{CheckpointSize: 500})
+	require.NoError(t, err)
+	for i := 0; i < 50; i++ {
+		require.NoError(t, j.Doc().Path("n").Set(i))
+		_, err := j.Doc().Commit("set n")
+		require.NoError(t, err)
+	}
+	require.NoError(t, j.Err())
+
+	info, err := os.Stat(path + ".log")
+	require.NoError(t, err)
+	require.Less(t, info.Size(), int64(500))
+	_, err = os.Stat(path)
+	require.NoError(t, err)
+
+	heads := j.Doc().Heads()
+	require.NoError(t, j.Close())
+	j, err = automerge.OpenJournaled(path)
+	require.NoError(t, err)
+	require.Equal(t, heads, j.Doc().Heads())
+	require.NoError(t, j.Close())
+}
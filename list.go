@@ -51,7 +51,7 @@ func (l *List) Len() int {
 // Values returns a slice of the values in a list
 func (l *List) Values() ([]*Value, error) {
 	if l.doc == nil {
-		return nil, fmt.Errorf("automerge.List: tried to read detached list")
+		return nil, newError(ErrDetached, "automerge.List: tried to read detached list")
 	}
 	if l.path != nil {
 		v, err := l.path.Get()
@@ -64,7 +64,7 @@ func (l *List) Values() ([]*Value, error) {
 		case KindVoid:
 			return nil, nil
 		default:
-			return nil, fmt.Errorf("%#v: tried to read non-list %#v", l.path, v.val)
+			return nil, &PathError{Path: l.path, Err: kindMismatch(l.path, KindList, v.Kind(), "tried to read non-list %#v", v.val)}
 		}
 	}
 	cDoc, cObj, unlock := l.lock()
@@ -85,7 +85,7 @@ func (l *List) Values() ([]*Value, error) {
 // Get returns the value at index i
 func (l *List) Get(i int) (*Value, error) {
 	if l.doc == nil {
-		return nil, fmt.Errorf("automerge.List: tried to read detached list")
+		return nil, newError(ErrDetached, "automerge.List: tried to read detached list")
 	}
 	if l.path != nil {
 		return l.path.Path(i).Get()
@@ -119,7 +119,7 @@ func (l *List) Append(values ...any) error {
 // Set overwrites the value at l[idx] with value.
 func (l *List) Set(idx int, value any) error {
 	if idx < 0 || idx >= l.Len() {
-		return fmt.Errorf("automerge.List: tried to write index %v beyond end of list length %v", idx, l.Len())
+		return newError(ErrIndexOutOfRange, "automerge.List: tried to write index %v beyond end of list length %v", idx, l.Len())
 	}
 	return l.put(C.size_t(idx), false, value)
 }
@@ -127,7 +127,7 @@ func (l *List) Set(idx int, value any) error {
 // Insert inserts the new values just before idx.
 func (l *List) Insert(idx int, value ...any) error {
 	if idx < 0 || idx > l.Len() {
-		return fmt.Errorf("automerge.List: tried to write index %v beyond end of list length %v", idx, l.Len())
+		return newError(ErrIndexOutOfRange, "automerge.List: tried to write index %v beyond end of list length %v", idx, l.Len())
 	}
 	for i, v := range value {
 		if err := l.put(C.size_t(idx+i), true, v); err != nil {
@@ -140,7 +140,7 @@ func (l *List) Insert(idx int, value ...any) error {
 // Delete removes the value at idx and shortens the list.
 func (l *List) Delete(idx int) error {
 	if idx < 0 || idx >= l.Len() {
-		return fmt.Errorf("automerge.List: tried to write index %v beyond end of list length %v", idx, l.Len())
+		return newError(ErrIndexOutOfRange, "automerge.List: tried to write index %v beyond end of list length %v", idx, l.Len())
 	}
 
 	cDoc, cObj, unlock := l.lock()
@@ -158,7 +158,7 @@ func (l *List) inc(i int, delta int64) error {
 
 func (l *List) put(i C.size_t, before bool, value any) error {
 	if l.doc == nil {
-		return fmt.Errorf("automerge.List: tried to write to detached list")
+		return newError(ErrDetached, "automerge.List: tried to write to detached list")
 	}
 	if l.path != nil {
 		l2, err := l.path.ensureList(int(i))
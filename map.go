@@ -51,7 +51,7 @@ func (m *Map) createOnPath(key string) error {
 // a Path.Map() and the path is not traverseable
 func (m *Map) Get(key string) (*Value, error) {
 	if m.doc == nil {
-		return nil, fmt.Errorf("automerge.Map: tried to read detached map")
+		return nil, newError(ErrDetached, "automerge.Map: tried to read detached map")
 	}
 	if m.path != nil {
 		return m.path.Path(key).Get()
@@ -90,7 +90,7 @@ func (m *Map) Len() int {
 // Delete deletes a key and its corresponding value from the map
 func (m *Map) Delete(key string) error {
 	if m.doc == nil {
-		return fmt.Errorf("automerge.Map: tried to write to detached map")
+		return newError(ErrDetached, "automerge.Map: tried to write to detached map")
 	}
 	if err := m.createOnPath(key); err != nil {
 		return err
@@ -110,7 +110,7 @@ func (m *Map) Delete(key string) error {
 // or if this is the first write to a [Path.Map] and the path is not traverseable.
 func (m *Map) Set(key string, value any) error {
 	if m.doc == nil {
-		return fmt.Errorf("automerge.Map: tried to write to detached map")
+		return newError(ErrDetached, "automerge.Map: tried to write to detached map")
 	}
 	if err := m.createOnPath(key); err != nil {
 		return err
@@ -248,7 +248,7 @@ func (m *Map) inc(key string, delta int64) error {
 // Values returns the values of the map
 func (m *Map) Values() (map[string]*Value, error) {
 	if m.doc == nil {
-		return nil, fmt.Errorf("automerge.Map: tried to read detached map")
+		return nil, newError(ErrDetached, "automerge.Map: tried to read detached map")
 	}
 	if m.path != nil {
 		v, err := m.path.Get()
@@ -261,7 +261,7 @@ func (m *Map) Values() (map[string]*Value, error) {
 		case KindVoid:
 			return nil, nil
 		default:
-			return nil, fmt.Errorf("%#v: tried to read non-map %#v", m.path, v.val)
+			return nil, &PathError{Path: m.path, Err: kindMismatch(m.path, KindMap, v.Kind(), "tried to read non-map %#v", v.val)}
 		}
 	}
 
@@ -0,0 +1,101 @@
+package automerge
+
+// #include "automerge.h"
+import "C"
+import (
+	"runtime"
+	"time"
+)
+
+// opBytes is a guess at the memory used by each operation in the automerge
+// core, in addition to the encoded changes it keeps. The core does not report
+// its memory use, so this is a fixed guess and may be far off for a
+// particular document.
+const opBytes = 128
+
+// MemoryStats describes the native memory held by a [Doc]
+type MemoryStats struct {
+	// Changes is the number of changes in the document's history
+	Changes int
+	// Ops is the total number of operations in those changes
+	Ops int
+	// Bytes is a rough estimate of the native memory held by the document:
+	// the size of its encoded changes plus a fixed estimate per operation.
+	// The automerge core does not report its actual memory use, so Bytes
+	// is useful for comparing documents rather than as an exact figure.
+	Bytes int64
+}
+
+// MemoryStats reports a rough estimate of the native memory held by the
+// document (see [MemoryStats].Bytes). The Go garbage collector does not know about this memory, so you
+// may want to [Doc.Close] large documents explicitly, or use the stats to
+// decide which documents to evict from a cache.
+// Computing the stats visits every change, so it is not free for large
+// documents. A closed document reports zero stats.
+func (d *Doc) MemoryStats() MemoryStats {
+	cDoc, unlock, err := d.lockCommitted()
+	if err != nil {
+		return MemoryStats{}
+	}
+	defer unlock()
+
+	r := wrap(C.AMgetChanges(cDoc, nil))
+	defer r.free()
+	items, err := r.items()
+	if err != nil {
+		return MemoryStats{}
+	}
+
+	stats := MemoryStats{}
+	for _, i := range items {
+		var ch *C.AMchange
+		if !C.AMitemToChange(i.cItem, &ch) {
+			continue
+		}
+		stats.Changes++
+		stats.Ops += int(C.AMchangeSize(ch))
+		stats.Bytes += int64(C.AMchangeRawBytes(ch).count)
+	}
+	stats.Bytes += int64(stats.Ops) * opBytes
+	return stats
+}
+
+// CheckLeaks helps tests find native memory that is never released. Call it
+// at the start of a test, and call the function it returns at the end:
+//
+//	defer automerge.CheckLeaks(t)()
+//
+// The returned function runs the garbage collector until all unreachable
+// native memory has been freed, and reports an error if more native values
+// are alive than when CheckLeaks was called (for example because a [Doc] is
+// still referenced from a global cache). It counts values across the whole
+// process, so it should not be used in parallel tests.
+func CheckLeaks(t interface {
+	Helper()
+	Errorf(format string, args ...any)
+}) func() {
+	before := settleResults()
+	return func() {
+		t.Helper()
+		if after := settleResults(); after > before {
+			t.Errorf("automerge: %d native values leaked", after-before)
+		}
+	}
+}
+
+// settleResults runs the garbage collector until the number of live results
+// stops changing (or a second has passed), and returns it.
+func settleResults() int64 {
+	deadline := time.Now().Add(time.Second)
+	live := liveResults.Load()
+	for stable := 0; stable < 3 && time.Now().Before(deadline); {
+		runtime.GC()
+		time.Sleep(time.Millisecond)
+		if n := liveResults.Load(); n != live {
+			live, stable = n, 0
+		} else {
+			stable++
+		}
+	}
+	return live
+}
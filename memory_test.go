@@ -0,0 +1,142 @@
+package automerge_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/automerge/automerge-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDoc_Close(t *testing.T) {
+	defer automerge.CheckLeaks(t)()
+
+	doc := automerge.New()
+	require.NoError(t, doc.Path("a").Set(1))
+	_, err := doc.Commit("a")
+	require.NoError(t, err)
+	m := doc.RootMap()
+
+	require.NoError(t, doc.Close())
+	require.NoError(t, doc.Close())
+
+	_, err = doc.TrySave()
+	require.ErrorIs(t, err, automerge.ErrClosed)
+	_, err = doc.Path("a").Get()
+	require.ErrorIs(t, err, automerge.ErrClosed)
+	require.ErrorIs(t, m.Set("b", 2), automerge.ErrClosed)
+	_, err = doc.Commit("b")
+	require.ErrorIs(t, err, automerge.ErrClosed)
+	require.Equal(t, automerge.MemoryStats{}, doc.MemoryStats())
+	require.Panics(t, func() { doc.Save() })
+}
+
+func TestChange_Close(t *testing.T) {
+	doc := automerge.New()
+	defer doc.Close()
+	for i := 0; i < 2; i++ {
+		require.NoError(t, doc.Path("a").Set(i))
+		_, err := doc.Commit("a")
+		require.NoError(t, err)
+	}
+	chs, err := doc.Changes()
+	require.NoError(t, err)
+	hash := chs[1].Hash()
+
+	require.NoError(t, chs[0].Close())
+	require.NoError(t, chs[0].Close())
+	require.Equal(t, automerge.ChangeHash{}, chs[0].Hash())
+	_, err = chs[0].TryDependencies()
+	require.ErrorIs(t, err, automerge.ErrClosed)
+	_, err = chs[0].TryActorID()
+	require.ErrorIs(t, err, automerge.ErrClosed)
+	require.Empty(t, chs[0].ActorID())
+	require.Empty(t, chs[0].Dependencies())
+	require.Empty(t, chs[0].Message())
+	require.Empty(t, chs[0].Save())
+	require.Zero(t, chs[0].ActorSeq())
+	require.Zero(t, chs[0].Timestamp())
+
+	// the other changes from the same call are still usable
+	require.Equal(t, hash, chs[1].Hash())
+	doc2 := automerge.New()
+	defer doc2.Close()
+	require.ErrorIs(t, doc2.Apply(chs...), automerge.ErrClosed)
+	require.NoError(t, chs[1].Close())
+}
+
+func TestSyncState_Close(t *testing.T) {
+	doc := automerge.New()
+	defer doc.Close()
+	ss := automerge.NewSyncState(doc)
+	require.NoError(t, ss.Close())
+	require.NoError(t, ss.Close())
+
+	_, _, err := ss.TryGenerateMessage()
+	require.ErrorIs(t, err, automerge.ErrClosed)
+	_, err = ss.TrySave()
+	require.ErrorIs(t, err, automerge.ErrClosed)
+	_, err = ss.SharedHeads()
+	require.ErrorIs(t, err, automerge.ErrClosed)
+
+	msg, valid := automerge.NewSyncState(automerge.New()).GenerateMessage()
+	require.True(t, valid)
+	_, err = ss.ReceiveMessage(msg.Bytes())
+	require.ErrorIs(t, err, automerge.ErrClosed)
+	require.False(t, ss.Equal(automerge.NewSyncState(doc)))
+
+	_, err = doc.TrySave()
+	require.NoError(t, err)
+}
+
+func TestDoc_MemoryStats(t *testing.T) {
+	doc := automerge.New()
+	defer doc.Close()
+	require.Equal(t, automerge.MemoryStats{}, doc.MemoryStats())
+
+	require.NoError(t, doc.Path("a").Set("hello"))
+	_, err := doc.Commit("a")
+	require.NoError(t, err)
+	stats := doc.MemoryStats()
+	require.Equal(t, 1, stats.Changes)
+	require.Equal(t, 1, stats.Ops)
+
+	for i := 0; i < 100; i++ {
+		require.NoError(t, doc.Path(fmt.Sprint(i)).Set(i))
+	}
+	_, err = doc.Commit("b")
+	require.NoError(t, err)
+	more := doc.MemoryStats()
+	require.Equal(t, 2, more.Changes)
+	require.Equal(t, 101, more.Ops)
+	require.Greater(t, more.Bytes, stats.Bytes)
+}
+
+type leakTB struct {
+	errors []string
+}
+
+func (l *leakTB) Helper() {}
+
+func (l *leakTB) Errorf(format string, args ...any) {
+	l.errors = append(l.errors, fmt.Sprintf(format, args...))
+}
+
+var leaked *automerge.Doc
+
+func TestCheckLeaks(t *testing.T) {
+	tb := &leakTB{}
+	check := automerge.CheckLeaks(tb)
+	doc := automerge.New()
+	require.NoError(t, doc.Path("a").Set(1))
+	require.NoError(t, doc.Close())
+	check()
+	require.Empty(t, tb.errors)
+
+	check = automerge.CheckLeaks(tb)
+	leaked = automerge.New()
+	check()
+	require.Len(t, tb.errors, 1)
+	require.NoError(t, leaked.Close())
+	leaked = nil
+}
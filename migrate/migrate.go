@@ -0,0 +1,230 @@
+// Package migrate upgrades automerge documents between versions of your data model.
+//
+// Each migration is a numbered step that modifies the document using the normal
+// [automerge.Map], [automerge.List] and [automerge.Path] APIs. The version of a document
+// is stored in a reserved key at its root ([VersionKey] by default), and [Run] applies
+// any steps that have not yet been applied, committing each one as a separate change.
+//
+//	func init() {
+//		migrate.Register(1, "add tags to every task", func(d *automerge.Doc) error {
+//			tasks, err := d.Path("tasks").List().Values()
+//			...
+//		})
+//	}
+//
+//	err := migrate.Run(doc)
+//
+// # Concurrent migrations
+//
+// If two peers migrate copies of a document with the same heads, they must produce
+// exactly the same change so that the migration is not applied twice when they sync.
+// To make this possible each step is run with an actor ID derived from the version
+// and the heads of the document, and without a timestamp. Migration functions must be
+// deterministic: they must only depend on the contents of the document (not on the time,
+// random numbers, or the iteration order of go maps).
+//
+// If peers migrate documents with different heads their changes will be merged as normal
+// concurrent edits, so migrations should be written to be tolerant of this where possible
+// (for example setting keys rather than appending to lists).
+package migrate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/automerge/automerge-go"
+)
+
+// VersionKey is the key at the root of the document in which
+// the default registry stores the schema version.
+const VersionKey = "_schemaVersion"
+
+// Migration is a single step in upgrading a document.
+// Up is called to upgrade a document from Version - 1 to Version,
+// and the Description is used as the commit message.
+type Migration struct {
+	Version     int
+	Description string
+	Up          func(d *automerge.Doc) error
+}
+
+// Registry is an ordered set of migrations.
+// The zero value stores the version in [VersionKey].
+type Registry struct {
+	// Key is the root key in which the version is stored
+	Key string
+
+	m     sync.Mutex
+	steps []Migration
+}
+
+// Default is the registry used by the package-level [Register] and [Run]
+var Default = &Registry{}
+
+// Register adds a migration to the default registry, see [Registry.Register]
+func Register(version int, description string, up func(d *automerge.Doc) error) {
+	Default.Register(Migration{Version: version, Description: description, Up: up})
+}
+
+// Run applies pending migrations from the default registry, see [Registry.Run]
+func Run(d *automerge.Doc) error {
+	return Default.Run(d)
+}
+
+// Version returns the version of the document stored in the default registry's key.
+// A document that has never been migrated has version 0.
+func Version(d *automerge.Doc) (int, error) {
+	return Default.Version(d)
+}
+
+func (r *Registry) key() string {
+	if r.Key == "" {
+		return VersionKey
+	}
+	return r.Key
+}
+
+// Register adds a migration to the registry. Versions start at 1, and must be unique.
+// Register panics if the migration is invalid, as it is expected to be called during
+// program initialization.
+func (r *Registry) Register(m Migration) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if m.Version < 1 {
+		panic(fmt.Errorf("migrate: invalid version %d, versions start at 1", m.Version))
+	}
+	if m.Up == nil {
+		panic(fmt.Errorf("migrate: migration %d has no Up function", m.Version))
+	}
+	for _, o := range r.steps {
+		if o.Version == m.Version {
+			panic(fmt.Errorf("migrate: migration %d registered twice", m.Version))
+		}
+	}
+	r.steps = append(r.steps, m)
+	sort.Slice(r.steps, func(i, j int) bool { return r.steps[i].Version < r.steps[j].Version })
+}
+
+// Latest returns the highest registered version
+func (r *Registry) Latest() int {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if len(r.steps) == 0 {
+		return 0
+	}
+	return r.steps[len(r.steps)-1].Version
+}
+
+// Version returns the version of the document.
+// A document that has never been migrated has version 0.
+func (r *Registry) Version(d *automerge.Doc) (int, error) {
+	v, err := d.Path(r.key()).Get()
+	if err != nil {
+		return 0, err
+	}
+	if v.IsVoid() {
+		return 0, nil
+	}
+	version, err := automerge.As[int](v)
+	if err != nil {
+		return 0, fmt.Errorf("migrate: invalid version in %q: %w", r.key(), err)
+	}
+	return version, nil
+}
+
+// Pending returns the migrations that have not yet been applied to the document.
+// It returns an error if the document's version is newer than any registered migration,
+// which typically means the document was written by a newer version of your program.
+func (r *Registry) Pending(d *automerge.Doc) ([]Migration, error) {
+	version, err := r.Version(d)
+	if err != nil {
+		return nil, err
+	}
+	if latest := r.Latest(); version > latest {
+		return nil, fmt.Errorf("migrate: document is at version %d, but the latest known version is %d", version, latest)
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+	pending := []Migration{}
+	for _, m := range r.steps {
+		if m.Version > version {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// Run applies each pending migration to the document in order.
+//
+// Any outstanding operations on the document are committed first. Each migration
+// is then run on a fork of the document, committed with its Description as the message,
+// and merged back in. If a migration returns an error, its changes are discarded and Run
+// returns the error, leaving the document at the previous version.
+func (r *Registry) Run(d *automerge.Doc) error {
+	pending, err := r.Pending(d)
+	if err != nil {
+		return err
+	}
+	for _, m := range pending {
+		if err := r.apply(d, m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Registry) apply(d *automerge.Doc, m Migration) error {
+	heads, err := d.TryHeads()
+	if err != nil {
+		return err
+	}
+	fork, err := d.Fork()
+	if err != nil {
+		return err
+	}
+	if err := fork.SetActorID(migrationActor(r.key(), m.Version, heads)); err != nil {
+		return err
+	}
+
+	if err := m.Up(fork); err != nil {
+		return fmt.Errorf("migrate: migration %d failed: %w", m.Version, err)
+	}
+	if err := fork.Path(r.key()).Set(int64(m.Version)); err != nil {
+		return err
+	}
+
+	msg := m.Description
+	if msg == "" {
+		msg = fmt.Sprintf("Migrate to version %d", m.Version)
+	}
+	if _, err := fork.Commit(msg, automerge.CommitOptions{Time: &time.Time{}}); err != nil {
+		return fmt.Errorf("migrate: migration %d failed: %w", m.Version, err)
+	}
+
+	_, err = d.Merge(fork)
+	return err
+}
+
+// migrationActor returns an actor ID that is the same for every peer
+// that runs migration version on a document with the given heads.
+func migrationActor(key string, version int, heads []automerge.ChangeHash) string {
+	hs := make([]string, 0, len(heads))
+	for _, h := range heads {
+		hs = append(hs, h.String())
+	}
+	sort.Strings(hs)
+
+	h := sha256.New()
+	fmt.Fprintf(h, "automerge-go/migrate\x00%s\x00%d", key, version)
+	for _, s := range hs {
+		fmt.Fprintf(h, "\x00%s", s)
+	}
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
@@ -0,0 +1,115 @@
+package migrate_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/automerge/automerge-go"
+	"github.com/automerge/automerge-go/migrate"
+	"github.com/stretchr/testify/require"
+)
+
+func newRegistry() *migrate.Registry {
+	r := &migrate.Registry{}
+	r.Register(migrate.Migration{Version: 2, Description: "Add default tags", Up: func(d *automerge.Doc) error {
+		tasks, err := d.Path("tasks").List().Values()
+		if err != nil {
+			return err
+		}
+		for i := range tasks {
+			if err := d.Path("tasks", i, "tags").Set([]string{"untriaged"}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}})
+	r.Register(migrate.Migration{Version: 1, Description: "Create task list", Up: func(d *automerge.Doc) error {
+		return d.Path("tasks").List().Append(map[string]any{"title": "welcome"})
+	}})
+	return r
+}
+
+func TestRegistry_Run(t *testing.T) {
+	r := newRegistry()
+	require.Equal(t, 2, r.Latest())
+
+	doc := automerge.New()
+	require.NoError(t, r.Run(doc))
+
+	v, err := r.Version(doc)
+	require.NoError(t, err)
+	require.Equal(t, 2, v)
+
+	tasks, err := automerge.As[[]map[string]any](doc.Path("tasks").Get())
+	require.NoError(t, err)
+	require.Equal(t, []map[string]any{{"title": "welcome", "tags": []any{"untriaged"}}}, tasks)
+
+	changes, err := doc.Changes()
+	require.NoError(t, err)
+	require.Len(t, changes, 2)
+	require.Equal(t, "Create task list", changes[0].Message())
+	require.Equal(t, "Add default tags", changes[1].Message())
+	require.True(t, changes[1].Timestamp().Equal(changes[0].Timestamp()))
+
+	heads := doc.Heads()
+	require.NoError(t, r.Run(doc))
+	require.Equal(t, heads, doc.Heads())
+
+	require.NoError(t, doc.Path(migrate.VersionKey).Set(int64(3)))
+	require.EqualError(t, r.Run(doc), "migrate: document is at version 3, but the latest known version is 2")
+}
+
+func TestRegistry_Concurrent(t *testing.T) {
+	r := newRegistry()
+
+	base := automerge.New()
+	require.NoError(t, base.Path("name").Set("project"))
+	_, err := base.Commit("initial")
+	require.NoError(t, err)
+
+	a, err := base.Fork()
+	require.NoError(t, err)
+	b, err := base.Fork()
+	require.NoError(t, err)
+
+	require.NoError(t, r.Run(a))
+	require.NoError(t, r.Run(b))
+	require.Equal(t, a.Heads(), b.Heads())
+
+	_, err = a.Merge(b)
+	require.NoError(t, err)
+	require.Equal(t, 1, a.Path("tasks").List().Len())
+}
+
+func TestRegistry_Failure(t *testing.T) {
+	r := &migrate.Registry{Key: "version"}
+	r.Register(migrate.Migration{Version: 1, Up: func(d *automerge.Doc) error {
+		return d.Path("ok").Set(true)
+	}})
+	r.Register(migrate.Migration{Version: 2, Up: func(d *automerge.Doc) error {
+		if err := d.Path("partial").Set(true); err != nil {
+			return err
+		}
+		return fmt.Errorf("oops")
+	}})
+
+	d := automerge.New()
+	require.EqualError(t, r.Run(d), "migrate: migration 2 failed: oops")
+
+	v, err := r.Version(d)
+	require.NoError(t, err)
+	require.Equal(t, 1, v)
+
+	changes, err := d.Changes()
+	require.NoError(t, err)
+	require.Len(t, changes, 1)
+	require.Equal(t, "Migrate to version 1", changes[0].Message())
+
+	partial, err := d.Path("partial").Get()
+	require.NoError(t, err)
+	require.True(t, partial.IsVoid())
+
+	require.Panics(t, func() {
+		r.Register(migrate.Migration{Version: 1, Up: func(d *automerge.Doc) error { return nil }})
+	})
+}
@@ -212,7 +212,11 @@ func unmarshal(rv reflect.Value, v *Value) error {
 		}
 
 	case reflect.Interface:
-		val := reflect.ValueOf(v.Interface())
+		i, err := v.TryInterface()
+		if err != nil {
+			return err
+		}
+		val := reflect.ValueOf(i)
 		if !val.IsValid() {
 			// reflect.ValueOf(nil) returns the zero Value which cannot be used.
 			// reflect.Zero() returns the Value representing the nil value of the current type,
@@ -0,0 +1,386 @@
+package automerge
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// OpID identifies an operation (and the object or list element that it
+// creates) by the counter of the operation and the actor that made it.
+type OpID struct {
+	Counter uint64
+	Actor   string
+}
+
+// IsZero reports whether the OpID is the zero value, which is used for the
+// root object in [Op.Obj] and for the start of a list in [Op.Elem].
+func (id OpID) IsZero() bool {
+	return id.Counter == 0 && id.Actor == ""
+}
+
+// String returns the OpID in the form "counter@actor"
+func (id OpID) String() string {
+	return strconv.FormatUint(id.Counter, 10) + "@" + id.Actor
+}
+
+// Action is the type of an [Op]
+type Action uint64
+
+// Actions used by automerge
+const (
+	ActionMakeMap   Action = 0
+	ActionSet       Action = 1
+	ActionMakeList  Action = 2
+	ActionDelete    Action = 3
+	ActionMakeText  Action = 4
+	ActionIncrement Action = 5
+	ActionMakeTable Action = 6
+	ActionMark      Action = 7
+)
+
+var actionNames = map[Action]string{
+	ActionMakeMap:   "makeMap",
+	ActionSet:       "set",
+	ActionMakeList:  "makeList",
+	ActionDelete:    "del",
+	ActionMakeText:  "makeText",
+	ActionIncrement: "inc",
+	ActionMakeTable: "makeTable",
+	ActionMark:      "mark",
+}
+
+// String returns the name of the action
+func (a Action) String() string {
+	if s, ok := actionNames[a]; ok {
+		return s
+	}
+	return fmt.Sprintf("Action(%d)", uint64(a))
+}
+
+// Op is a single operation decoded from a [Change] or a document.
+//
+// Operations on maps have a Key, operations on lists and text have an Elem
+// (the element they modify, or that they insert after if Insert is true).
+// For set operations Kind and Value describe the value that was written
+// (using the same Go types as [Value.Interface], except that counters are
+// int64); for make operations Kind is the kind of object created.
+type Op struct {
+	ID     OpID
+	Obj    OpID
+	Key    string
+	Elem   OpID
+	Insert bool
+	Action Action
+	Kind   Kind
+	Value  any
+
+	// Pred are the operations that this operation overwrites or deletes,
+	// and Succ (only known for operations decoded from a document) are the
+	// operations that overwrite or delete this one.
+	Pred []OpID
+	Succ []OpID
+
+	// MarkName and Expand are set for mark operations
+	MarkName string
+	Expand   bool
+}
+
+// Ops decodes the operations in the change. Ops are numbered consecutively
+// from the change's start op.
+func (c *Change) Ops() ([]Op, error) {
+	if c.cChange == nil {
+		return nil, errChangeClosed
+	}
+	raw := c.Save()
+	bud := newBudget(len(raw))
+	typ, chunk, _, err := readChunk(bud, raw)
+	if err != nil {
+		return nil, fmt.Errorf("automerge.Change.Ops: %w", markError(ErrCorruptDocument, err))
+	}
+	if typ != 1 {
+		return nil, newError(ErrCorruptDocument, "automerge.Change.Ops: not a change chunk")
+	}
+	ops, err := decodeChange(bud, chunk)
+	if err != nil {
+		return nil, fmt.Errorf("automerge.Change.Ops: %w", markError(ErrCorruptDocument, err))
+	}
+	return ops, nil
+}
+
+// DecodeOps decodes the operations in the output of [Doc.Save]. Unlike
+// [Change.Ops] the operations include their successors, so you can tell
+// which operations are still visible in the document.
+func DecodeOps(raw []byte) ([]Op, error) {
+	bud := newBudget(len(raw))
+	typ, chunk, _, err := readChunk(bud, raw)
+	if err != nil {
+		return nil, fmt.Errorf("automerge.DecodeOps: %w", markError(ErrCorruptDocument, err))
+	}
+	if typ != 0 {
+		return nil, newError(ErrCorruptDocument, "automerge.DecodeOps: not a document chunk")
+	}
+	ops, err := decodeDocument(bud, chunk)
+	if err != nil {
+		return nil, fmt.Errorf("automerge.DecodeOps: %w", markError(ErrCorruptDocument, err))
+	}
+	return ops, nil
+}
+
+func decodeChange(bud *budget, b []byte) ([]Op, error) {
+	_, b, err := readHashes(b)
+	if err != nil {
+		return nil, err
+	}
+	actor, b, err := readActor(b)
+	if err != nil {
+		return nil, err
+	}
+	if _, b, err = readULEB(b); err != nil { // seq
+		return nil, err
+	}
+	startOp, b, err := readULEB(b)
+	if err != nil {
+		return nil, err
+	}
+	if _, b, err = readSLEB(b); err != nil { // time
+		return nil, err
+	}
+	if _, b, err = readPrefixed(b); err != nil { // message
+		return nil, err
+	}
+	actors, b, err := readActors(b, []string{actor})
+	if err != nil {
+		return nil, err
+	}
+	metas, b, err := readColumnMeta(b)
+	if err != nil {
+		return nil, err
+	}
+	cols, _, err := readColumns(bud, metas, b)
+	if err != nil {
+		return nil, err
+	}
+
+	ops, err := decodeOps(bud, cols, actors)
+	if err != nil {
+		return nil, err
+	}
+	for i := range ops {
+		ops[i].ID = OpID{Counter: startOp + uint64(i), Actor: actor}
+	}
+	return ops, nil
+}
+
+func decodeDocument(bud *budget, b []byte) ([]Op, error) {
+	actors, b, err := readActors(b, nil)
+	if err != nil {
+		return nil, err
+	}
+	if _, b, err = readHashes(b); err != nil {
+		return nil, err
+	}
+	changeMetas, b, err := readColumnMeta(b)
+	if err != nil {
+		return nil, err
+	}
+	opMetas, b, err := readColumnMeta(b)
+	if err != nil {
+		return nil, err
+	}
+	if _, b, err = readColumns(bud, changeMetas, b); err != nil {
+		return nil, err
+	}
+	cols, _, err := readColumns(bud, opMetas, b)
+	if err != nil {
+		return nil, err
+	}
+
+	ops, err := decodeOps(bud, cols, actors)
+	if err != nil {
+		return nil, err
+	}
+	idActors, err := decodeULEBColumn(bud, cols[colIDActor])
+	if err != nil {
+		return nil, err
+	}
+	idCounters, err := decodeDeltaColumn(bud, cols[colIDCounter])
+	if err != nil {
+		return nil, err
+	}
+	for i := range ops {
+		if ops[i].ID, err = opID(actors, at(idActors, i), at(idCounters, i)); err != nil {
+			return nil, err
+		}
+	}
+	return ops, nil
+}
+
+// decodeOps decodes the operation columns that are shared by changes and
+// documents. The number of operations is determined by the action column.
+func decodeOps(bud *budget, cols map[uint64][]byte, actors []string) ([]Op, error) {
+	actions, err := decodeULEBColumn(bud, cols[colAction])
+	if err != nil {
+		return nil, err
+	}
+	objActors, err := decodeULEBColumn(bud, cols[colObjActor])
+	if err != nil {
+		return nil, err
+	}
+	objCounters, err := decodeULEBColumn(bud, cols[colObjCounter])
+	if err != nil {
+		return nil, err
+	}
+	keyActors, err := decodeULEBColumn(bud, cols[colKeyActor])
+	if err != nil {
+		return nil, err
+	}
+	keyCounters, err := decodeDeltaColumn(bud, cols[colKeyCounter])
+	if err != nil {
+		return nil, err
+	}
+	keyStrings, err := decodeStringColumn(bud, cols[colKeyString])
+	if err != nil {
+		return nil, err
+	}
+	inserts, err := decodeBoolColumn(bud, cols[colInsert])
+	if err != nil {
+		return nil, err
+	}
+	valueMetas, err := decodeULEBColumn(bud, cols[colValMeta])
+	if err != nil {
+		return nil, err
+	}
+	markNames, err := decodeStringColumn(bud, cols[colMarkName])
+	if err != nil {
+		return nil, err
+	}
+	expands, err := decodeBoolColumn(bud, cols[colExpand])
+	if err != nil {
+		return nil, err
+	}
+	preds, err := newOpIDGroups(bud, cols, colPredGroup, colPredActor, colPredCtr, actors)
+	if err != nil {
+		return nil, err
+	}
+	succs, err := newOpIDGroups(bud, cols, colSuccGroup, colSuccActor, colSuccCtr, actors)
+	if err != nil {
+		return nil, err
+	}
+	values := cols[colVal]
+
+	ops := make([]Op, len(actions))
+	for i := range ops {
+		op := &ops[i]
+		if !actions[i].ok {
+			return nil, fmt.Errorf("missing action for op %d", i)
+		}
+		op.Action = Action(actions[i].v)
+		if op.Obj, err = opID(actors, at(objActors, i), signed(at(objCounters, i))); err != nil {
+			return nil, err
+		}
+		if k := at(keyStrings, i); k.ok {
+			op.Key = k.v
+		} else if c := at(keyCounters, i); c.v != 0 {
+			if op.Elem, err = opID(actors, at(keyActors, i), c); err != nil {
+				return nil, err
+			}
+		}
+		op.Insert = i < len(inserts) && inserts[i]
+		op.Expand = i < len(expands) && expands[i]
+		op.MarkName = at(markNames, i).v
+
+		meta := at(valueMetas, i)
+		if op.Kind, op.Value, values, err = readValue(meta.v, values); err != nil {
+			return nil, err
+		}
+		switch op.Action {
+		case ActionMakeMap, ActionMakeTable:
+			op.Kind = KindMap
+		case ActionMakeList:
+			op.Kind = KindList
+		case ActionMakeText:
+			op.Kind = KindText
+		case ActionDelete:
+			op.Kind = KindVoid
+		}
+
+		if op.Pred, err = preds.next(at(preds.groups, i)); err != nil {
+			return nil, err
+		}
+		if op.Succ, err = succs.next(at(succs.groups, i)); err != nil {
+			return nil, err
+		}
+	}
+	return ops, nil
+}
+
+// at returns the i'th value of a column, which is null if the column is
+// shorter than the number of operations.
+func at[T any](col []nullable[T], i int) nullable[T] {
+	if i < len(col) {
+		return col[i]
+	}
+	return nullable[T]{}
+}
+
+func signed(u nullable[uint64]) nullable[int64] {
+	return nullable[int64]{v: int64(u.v), ok: u.ok}
+}
+
+// opID returns the OpID with the given actor index and counter, or the
+// zero OpID if either is null.
+func opID(actors []string, actor nullable[uint64], counter nullable[int64]) (OpID, error) {
+	if !actor.ok || !counter.ok {
+		return OpID{}, nil
+	}
+	if actor.v >= uint64(len(actors)) {
+		return OpID{}, fmt.Errorf("invalid actor index %d", actor.v)
+	}
+	if counter.v < 0 {
+		return OpID{}, fmt.Errorf("invalid op counter %d", counter.v)
+	}
+	return OpID{Counter: uint64(counter.v), Actor: actors[actor.v]}, nil
+}
+
+// opIDGroups decodes a list of OpIDs per operation (such as predecessors)
+type opIDGroups struct {
+	groups   []nullable[uint64]
+	actors   []nullable[uint64]
+	counters []nullable[int64]
+	names    []string
+	pos      int
+}
+
+func newOpIDGroups(bud *budget, cols map[uint64][]byte, group, actor, counter uint64, names []string) (*opIDGroups, error) {
+	var err error
+	g := &opIDGroups{names: names}
+	if g.groups, err = decodeULEBColumn(bud, cols[group]); err != nil {
+		return nil, err
+	}
+	if g.actors, err = decodeULEBColumn(bud, cols[actor]); err != nil {
+		return nil, err
+	}
+	if g.counters, err = decodeDeltaColumn(bud, cols[counter]); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func (g *opIDGroups) next(n nullable[uint64]) ([]OpID, error) {
+	if n.v == 0 {
+		return nil, nil
+	}
+	if n.v > uint64(len(g.actors)-g.pos) || n.v > uint64(len(g.counters)-g.pos) {
+		return nil, fmt.Errorf("not enough op IDs in group")
+	}
+	ids := make([]OpID, 0, n.v)
+	for i := uint64(0); i < n.v; i++ {
+		id, err := opID(g.names, g.actors[g.pos], g.counters[g.pos])
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+		g.pos++
+	}
+	return ids, nil
+}
@@ -0,0 +1,157 @@
+package automerge_test
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/automerge/automerge-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChange_Ops(t *testing.T) {
+	doc := automerge.New()
+	actor := doc.ActorID()
+	require.NoError(t, doc.Path("s").Set("hello"))
+	require.NoError(t, doc.Path("c").Set(automerge.NewCounter(3)))
+	require.NoError(t, doc.Path("t").Set(time.UnixMilli(1000)))
+	require.NoError(t, doc.Path("l").Set([]any{"a", "b"}))
+	_, err := doc.Commit("create")
+	require.NoError(t, err)
+
+	require.NoError(t, doc.Path("c").Counter().Inc(2))
+	require.NoError(t, doc.Path("l", 0).Delete())
+	require.NoError(t, doc.Path("s").Set([]byte("bye")))
+	_, err = doc.Commit("update")
+	require.NoError(t, err)
+
+	id := func(c uint64) automerge.OpID { return automerge.OpID{Counter: c, Actor: actor} }
+
+	chs, err := doc.Changes()
+	require.NoError(t, err)
+	require.Len(t, chs, 2)
+
+	ops, err := chs[0].Ops()
+	require.NoError(t, err)
+	require.Equal(t, []automerge.Op{
+		{ID: id(1), Key: "s", Action: automerge.ActionSet, Kind: automerge.KindStr, Value: "hello"},
+		{ID: id(2), Key: "c", Action: automerge.ActionSet, Kind: automerge.KindCounter, Value: int64(3)},
+		{ID: id(3), Key: "t", Action: automerge.ActionSet, Kind: automerge.KindTime, Value: time.UnixMilli(1000)},
+		{ID: id(4), Key: "l", Action: automerge.ActionMakeList, Kind: automerge.KindList},
+		{ID: id(5), Obj: id(4), Insert: true, Action: automerge.ActionSet, Kind: automerge.KindStr, Value: "a"},
+		{ID: id(6), Obj: id(4), Elem: id(5), Insert: true, Action: automerge.ActionSet, Kind: automerge.KindStr, Value: "b"},
+	}, ops)
+	require.True(t, ops[0].Obj.IsZero())
+
+	ops, err = chs[1].Ops()
+	require.NoError(t, err)
+	require.Equal(t, []automerge.Op{
+		{ID: id(7), Key: "c", Action: automerge.ActionIncrement, Kind: automerge.KindInt64, Value: int64(2), Pred: []automerge.OpID{id(2)}},
+		{ID: id(8), Obj: id(4), Elem: id(5), Action: automerge.ActionDelete, Kind: automerge.KindVoid, Pred: []automerge.OpID{id(5)}},
+		{ID: id(9), Key: "s", Action: automerge.ActionSet, Kind: automerge.KindBytes, Value: []byte("bye"), Pred: []automerge.OpID{id(1)}},
+	}, ops)
+	require.Equal(t, "del", ops[1].Action.String())
+	require.Equal(t, "5@"+actor, ops[1].Elem.String())
+
+	// documents do not store deletions, only the successors they create
+	ops, err = automerge.DecodeOps(doc.Save())
+	require.NoError(t, err)
+	require.Len(t, ops, 8)
+	succs := map[automerge.OpID][]automerge.OpID{}
+	for _, op := range ops {
+		succs[op.ID] = op.Succ
+	}
+	require.Equal(t, []automerge.OpID{id(9)}, succs[id(1)])
+	require.Equal(t, []automerge.OpID{id(7)}, succs[id(2)])
+	require.Equal(t, []automerge.OpID{id(8)}, succs[id(5)])
+	require.Empty(t, succs[id(6)])
+
+	_, err = automerge.DecodeOps(chs[0].Save())
+	require.Error(t, err)
+	_, err = automerge.DecodeOps([]byte("not a document"))
+	require.Error(t, err)
+}
+
+func TestChange_OpsPolicy(t *testing.T) {
+	errAdmin := errors.New("admin is read-only")
+	writer := automerge.New()
+	require.NoError(t, writer.Path("public").Set("ok"))
+	_, err := writer.Commit("public")
+	require.NoError(t, err)
+	require.NoError(t, writer.Path("admin").Set(true))
+	_, err = writer.Commit("admin")
+	require.NoError(t, err)
+
+	doc := automerge.New()
+	doc.SetPolicy(func(d *automerge.Doc, ch *automerge.Change) error {
+		ops, err := ch.Ops()
+		if err != nil {
+			return err
+		}
+		for _, op := range ops {
+			if op.Obj.IsZero() && op.Key == "admin" {
+				return errAdmin
+			}
+		}
+		return nil
+	})
+
+	chs, err := writer.Changes()
+	require.NoError(t, err)
+	require.NoError(t, doc.Apply(chs[0]))
+	require.ErrorIs(t, doc.Apply(chs[1]), errAdmin)
+}
+
+// docChunk returns a document chunk with no actors or changes, and
+// a single action column
+func docChunk(spec uint64, column []byte) []byte {
+	body := []byte{0, 0, 0, 1}
+	body = binary.AppendUvarint(body, spec)
+	body = binary.AppendUvarint(body, uint64(len(column)))
+	body = append(body, column...)
+	chunk := []byte{0x85, 0x6f, 0x4a, 0x83, 0, 0, 0, 0, 0}
+	return append(binary.AppendUvarint(chunk, uint64(len(body))), body...)
+}
+
+func TestDecodeOps_Limits(t *testing.T) {
+	// a run of n "set" actions
+	run := func(n int64) []byte {
+		return append(sleb(n), 1)
+	}
+
+	ops, err := automerge.DecodeOps(docChunk(66, run(1000)))
+	require.NoError(t, err)
+	require.Len(t, ops, 1000)
+	require.Equal(t, automerge.ActionSet, ops[999].Action)
+
+	// the number of values is limited relative to the size of the input
+	_, err = automerge.DecodeOps(docChunk(66, run(1<<30)))
+	require.ErrorIs(t, err, automerge.ErrCorruptDocument)
+
+	// as is the size of compressed columns
+	buf := &bytes.Buffer{}
+	w, err := flate.NewWriter(buf, flate.BestCompression)
+	require.NoError(t, err)
+	_, err = w.Write(make([]byte, 10<<20))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	_, err = automerge.DecodeOps(docChunk(66|8, buf.Bytes()))
+	require.ErrorIs(t, err, automerge.ErrCorruptDocument)
+	require.ErrorContains(t, err, "too large")
+}
+
+// sleb returns the signed LEB128 encoding of n
+func sleb(n int64) []byte {
+	b := []byte{}
+	for {
+		c := byte(n & 0x7f)
+		n >>= 7
+		if n == 0 && c&0x40 == 0 || n == -1 && c&0x40 != 0 {
+			return append(b, c)
+		}
+		b = append(b, c|0x80)
+	}
+}
@@ -1,11 +1,16 @@
 package automerge
 
 import (
+	"errors"
 	"fmt"
 	"reflect"
+	"strconv"
+	"strings"
 )
 
-// Path is a cursor that lets you reach into the document
+// Path is a cursor that lets you reach into the document.
+// Paths created with [NewPath] or [ParsePath] are detached; you must attach
+// them to a document by passing them to [Doc.Path] before reading or writing.
 type Path struct {
 	d    *Doc
 	path []any
@@ -15,37 +20,74 @@ type deleteIndicator int
 
 var toDelete = deleteIndicator(0)
 
-// Path extends the cursor with more path segments.
-// It panics if any path segment is not a string or an int.
-// It does not check that the path segment is traversable until you call
-// a method that accesses the document.
-func (p *Path) Path(path ...any) *Path {
-	for i, v := range path {
+// NewPath returns a detached path made of the given segments.
+// Each segment must be a string (a map key), an int (a list index),
+// or a [*Path] whose segments are spliced in.
+// Unlike [Doc.Path] it returns an error instead of panicking on an
+// invalid segment, which makes it suitable for untrusted input.
+func NewPath(path ...any) (*Path, error) {
+	return (&Path{}).Join(path...)
+}
+
+// Join is like [Path.Path], but returns an error instead of panicking
+// if any path segment is invalid. A nil p, or a nil [*Path] segment, is
+// treated as an empty detached path.
+func (p *Path) Join(path ...any) (*Path, error) {
+	if p == nil {
+		p = &Path{}
+	}
+	ret := &Path{d: p.d, path: make([]any, 0, len(p.path)+len(path))}
+	ret.path = append(ret.path, p.path...)
+	for _, v := range path {
+		if sub, ok := v.(*Path); ok {
+			if sub != nil {
+				ret.path = append(ret.path, sub.path...)
+			}
+			continue
+		}
 		rv := reflect.ValueOf(v)
 		if rv.CanInt() {
-			path[i] = int(rv.Int())
-		} else if rv.CanConvert(reflect.TypeOf("")) {
-			path[i] = rv.String()
+			ret.path = append(ret.path, int(rv.Int()))
+		} else if rv.Kind() == reflect.String {
+			ret.path = append(ret.path, rv.String())
 		} else {
-			panic(fmt.Errorf("automerge: invalid path segment, expected string or int, got: %T(%#v)", v, v))
+			return nil, fmt.Errorf("automerge: invalid path segment, expected string or int, got: %T(%#v)", v, v)
 		}
 	}
-	return &Path{d: p.d, path: append(p.path, path...)}
+	return ret, nil
+}
+
+// Path extends the cursor with more path segments.
+// It panics if any path segment is not a string, an int or a [*Path];
+// use [Path.Join] if you need an error instead.
+// It does not check that the path segment is traversable until you call
+// a method that accesses the document.
+func (p *Path) Path(path ...any) *Path {
+	return must(p.Join(path...))
+}
+
+// Segments returns a copy of the path segments, each
+// segment is either a string (a map key) or an int (a list index).
+func (p *Path) Segments() []any {
+	return append([]any{}, p.path...)
 }
 
 // Get returns the value at a given path
 func (p *Path) Get() (*Value, error) {
+	if p.d == nil {
+		return nil, &PathError{Path: p, Err: newError(ErrDetached, "tried to read detached path")}
+	}
 	obj := p.d.Root()
 	var err error
 
-	for _, i := range p.path {
+	for n, i := range p.path {
 		switch idx := i.(type) {
 		case string:
 			if obj.Kind() == KindVoid {
 				return obj, nil
 			}
 			if obj.Kind() != KindMap {
-				return nil, fmt.Errorf("%#v: tried to read property %#v of non-map %#v", p, idx, obj.val)
+				return nil, &PathError{Path: p, Err: kindMismatch(&Path{d: p.d, path: p.path[:n]}, KindMap, obj.Kind(), "tried to read property %#v of non-map %#v", idx, obj.val)}
 			}
 			obj, err = obj.Map().Get(idx)
 			if err != nil {
@@ -57,7 +99,7 @@ func (p *Path) Get() (*Value, error) {
 				return obj, nil
 			}
 			if obj.Kind() != KindList {
-				return nil, fmt.Errorf("%#v: tried to read index %#v of non-list %#v", p, idx, obj.val)
+				return nil, &PathError{Path: p, Err: kindMismatch(&Path{d: p.d, path: p.path[:n]}, KindList, obj.Kind(), "tried to read index %#v of non-list %#v", idx, obj.val)}
 			}
 			obj, err = obj.List().Get(idx)
 
@@ -75,6 +117,9 @@ func (p *Path) Get() (*Value, error) {
 // Set sets the value at the given path, and creates any missing parent
 // Maps or Lists needed.
 func (p *Path) Set(v any) error {
+	if p.d == nil {
+		return &PathError{Path: p, Err: newError(ErrDetached, "tried to write detached path")}
+	}
 	_, set, err := p.ensure()
 	if err != nil {
 		return err
@@ -112,7 +157,7 @@ func (p *Path) ensureMap(debugKey string) (*Map, error) {
 		return v.Map(), nil
 	}
 
-	return nil, fmt.Errorf("%#v: tried to write property %#v of non-map %#v", p, debugKey, v.val)
+	return nil, &PathError{Path: p, Err: kindMismatch(p, KindMap, v.Kind(), "tried to write property %#v of non-map %#v", debugKey, v.val)}
 }
 
 func (p *Path) ensureList(debugKey int) (*List, error) {
@@ -133,7 +178,7 @@ func (p *Path) ensureList(debugKey int) (*List, error) {
 		return v.List(), nil
 	}
 
-	return nil, fmt.Errorf("%#v: tried to write index %v of non-list %#v", p, debugKey, v.val)
+	return nil, &PathError{Path: p, Err: kindMismatch(p, KindList, v.Kind(), "tried to write index %v of non-list %#v", debugKey, v.val)}
 }
 
 func (p *Path) ensureText() (*Text, error) {
@@ -154,7 +199,7 @@ func (p *Path) ensureText() (*Text, error) {
 		return v.Text(), nil
 	}
 
-	return nil, fmt.Errorf("%#v: tried to edit non-text %#v", p, v.val)
+	return nil, &PathError{Path: p, Err: kindMismatch(p, KindText, v.Kind(), "tried to edit non-text %#v", v.val)}
 }
 
 func (p *Path) ensureCounter() (*Counter, error) {
@@ -175,14 +220,14 @@ func (p *Path) ensureCounter() (*Counter, error) {
 		return v.Counter(), nil
 	}
 
-	return nil, fmt.Errorf("%#v: tried to increment non-counter %#v", p, v.val)
+	return nil, &PathError{Path: p, Err: kindMismatch(p, KindCounter, v.Kind(), "tried to increment non-counter %#v", v.val)}
 
 }
 
 func (p *Path) ensure() (*Value, func(v any) error, error) {
 	if len(p.path) == 0 {
 		return p.d.Root(), func(v any) error {
-			return fmt.Errorf("%#v: tried to overwrite root of document", p)
+			return &PathError{Path: p, Err: errors.New("tried to overwrite root of document")}
 		}, nil
 	}
 	last := p.path[len(p.path)-1]
@@ -213,7 +258,7 @@ func (p *Path) ensure() (*Value, func(v any) error, error) {
 		}
 		return v, func(v any) error {
 			if key > l.Len() || key == l.Len() && v == toDelete {
-				return fmt.Errorf("%#v: tried to write index %v beyond end of list length %v", p, key, l.Len())
+				return &PathError{Path: p, Err: newError(ErrIndexOutOfRange, "tried to write index %v beyond end of list length %v", key, l.Len())}
 			}
 			if v == toDelete {
 				return l.Delete(key)
@@ -261,9 +306,48 @@ func (p *Path) Text() *Text {
 	return &Text{doc: p.d, path: p}
 }
 
-// String returns a representation suitable for debugging.
+// String returns the path in the syntax accepted by [ParsePath],
+// for example `tasks[3].title` or `["key with spaces"][0]`.
+// The root path is represented by the empty string.
 func (p *Path) String() string {
-	return p.GoString()
+	var sb strings.Builder
+	for _, seg := range p.path {
+		switch s := seg.(type) {
+		case int:
+			sb.WriteString("[" + strconv.Itoa(s) + "]")
+		case string:
+			if isIdentifier(s) {
+				if sb.Len() > 0 {
+					sb.WriteByte('.')
+				}
+				sb.WriteString(s)
+			} else {
+				sb.WriteString("[" + strconv.Quote(s) + "]")
+			}
+		}
+	}
+	return sb.String()
+}
+
+// JSONPointer returns the path as an [RFC 6901] JSON Pointer,
+// for example "/tasks/3/title".
+// Note that JSON Pointers do not distinguish between map keys and
+// list indexes, so a map key that looks like a number will be parsed
+// back by [ParsePath] as a list index.
+//
+// [RFC 6901]: https://www.rfc-editor.org/rfc/rfc6901
+func (p *Path) JSONPointer() string {
+	var sb strings.Builder
+	for _, seg := range p.path {
+		sb.WriteByte('/')
+		switch s := seg.(type) {
+		case int:
+			sb.WriteString(strconv.Itoa(s))
+		case string:
+			sb.WriteString(pointerEscaper.Replace(s))
+		}
+	}
+	return sb.String()
 }
 
 // GoString returns a representation suitable for debugging
@@ -277,3 +361,132 @@ func (p *Path) GoString() string {
 	}
 	return str + "}"
 }
+
+var pointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+var pointerUnescaper = strings.NewReplacer("~1", "/", "~0", "~")
+
+// ParsePath parses a detached path from a string.
+// Two syntaxes are supported:
+//
+// If s starts with a "/" it is parsed as an [RFC 6901] JSON Pointer
+// (e.g. "/tasks/3/title"), in which case reference tokens that are
+// non-negative integers without leading zeros are treated as list indexes
+// and all other reference tokens as map keys.
+//
+// Otherwise s is parsed using the same dotted syntax as [Path.String]
+// (e.g. `tasks[3].title`), where map keys that are not valid identifiers
+// can be written as quoted strings in brackets (e.g. `tasks["due date"]`).
+// The empty string is the root of the document.
+//
+// Attach the returned path to a document with [Doc.Path].
+//
+// [RFC 6901]: https://www.rfc-editor.org/rfc/rfc6901
+func ParsePath(s string) (*Path, error) {
+	if strings.HasPrefix(s, "/") {
+		return parsePointer(s)
+	}
+	return parseDotted(s)
+}
+
+func parsePointer(s string) (*Path, error) {
+	p := &Path{path: []any{}}
+	for _, tok := range strings.Split(s[1:], "/") {
+		for i := 0; i < len(tok); i++ {
+			if tok[i] == '~' && (i+1 == len(tok) || tok[i+1] != '0' && tok[i+1] != '1') {
+				return nil, fmt.Errorf("automerge.ParsePath: invalid escape sequence in %q", tok)
+			}
+		}
+		if idx, ok := parseIndex(tok); ok {
+			p.path = append(p.path, idx)
+		} else {
+			p.path = append(p.path, pointerUnescaper.Replace(tok))
+		}
+	}
+	return p, nil
+}
+
+func parseDotted(s string) (*Path, error) {
+	p := &Path{path: []any{}}
+	errorf := func(i int, msg string) error {
+		return fmt.Errorf("automerge.ParsePath: %s at offset %d in %q", msg, i, s)
+	}
+
+	i := 0
+	for i < len(s) {
+		switch {
+		case s[i] == '[':
+			end := strings.IndexByte(s[i:], ']')
+			if i+1 < len(s) && s[i+1] == '"' {
+				quoted, err := strconv.QuotedPrefix(s[i+1:])
+				if err != nil {
+					return nil, errorf(i+1, "invalid quoted key")
+				}
+				key, _ := strconv.Unquote(quoted)
+				end = i + 1 + len(quoted)
+				if end >= len(s) || s[end] != ']' {
+					return nil, errorf(end, "expected ]")
+				}
+				p.path = append(p.path, key)
+				i = end + 1
+				continue
+			}
+			if end < 0 {
+				return nil, errorf(i, "unterminated [")
+			}
+			idx, ok := parseIndex(s[i+1 : i+end])
+			if !ok {
+				return nil, errorf(i+1, "expected list index or quoted key")
+			}
+			p.path = append(p.path, idx)
+			i += end + 1
+
+		case i == 0 || s[i] == '.':
+			if i > 0 {
+				i++
+			}
+			start := i
+			for i < len(s) && isIdentifierByte(s[i], i == start) {
+				i++
+			}
+			if i == start {
+				return nil, errorf(start, "expected identifier")
+			}
+			p.path = append(p.path, s[start:i])
+
+		default:
+			return nil, errorf(i, fmt.Sprintf("unexpected %q", s[i]))
+		}
+	}
+	return p, nil
+}
+
+// parseIndex parses a list index in the canonical form
+// used by RFC 6901 (no sign, no leading zeros).
+func parseIndex(s string) (int, bool) {
+	if s == "" || len(s) > 1 && s[0] == '0' {
+		return 0, false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return 0, false
+		}
+	}
+	idx, err := strconv.Atoi(s)
+	return idx, err == nil
+}
+
+func isIdentifierByte(c byte, first bool) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || !first && c >= '0' && c <= '9'
+}
+
+func isIdentifier(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if !isIdentifierByte(s[i], i == 0) {
+			return false
+		}
+	}
+	return true
+}
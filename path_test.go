@@ -34,3 +34,75 @@ func TestPath_Errors(t *testing.T) {
 	err = doc.Path().Set(1)
 	require.ErrorContains(t, err, `&automerge.Path{}: tried to overwrite root of document`)
 }
+
+func TestParsePath(t *testing.T) {
+	tcs := []struct {
+		in   string
+		want []any
+		str  string
+	}{
+		{"", []any{}, ""},
+		{"tasks[3].title", []any{"tasks", 3, "title"}, "tasks[3].title"},
+		{`tasks["due date"][0]`, []any{"tasks", "due date", 0}, `tasks["due date"][0]`},
+		{`["a.b"].c`, []any{"a.b", "c"}, `["a.b"].c`},
+		{"[0][1]", []any{0, 1}, "[0][1]"},
+		{"/tasks/3/title", []any{"tasks", 3, "title"}, "tasks[3].title"},
+		{"/a~1b/m~0n/03", []any{"a/b", "m~n", "03"}, `["a/b"]["m~n"]["03"]`},
+		{"/", []any{""}, `[""]`},
+	}
+
+	for _, tc := range tcs {
+		p, err := automerge.ParsePath(tc.in)
+		require.NoError(t, err, tc.in)
+		require.Equal(t, tc.want, p.Segments(), tc.in)
+		require.Equal(t, tc.str, p.String(), tc.in)
+
+		p2, err := automerge.ParsePath(p.String())
+		require.NoError(t, err, tc.in)
+		require.Equal(t, tc.want, p2.Segments(), tc.in)
+	}
+
+	p, err := automerge.ParsePath("tasks[3].title")
+	require.NoError(t, err)
+	require.Equal(t, "/tasks/3/title", p.JSONPointer())
+
+	for _, in := range []string{".a", "a.", "a..b", "a[", "a[x]", "a[-1]", `a["b]`, "a b", "/a~2", "/a~"} {
+		_, err := automerge.ParsePath(in)
+		require.Error(t, err, in)
+	}
+}
+
+func TestNewPath(t *testing.T) {
+	_, err := automerge.NewPath("x", true)
+	require.EqualError(t, err, "automerge: invalid path segment, expected string or int, got: bool(true)")
+
+	p, err := automerge.NewPath("x", 0)
+	require.NoError(t, err)
+
+	_, err = p.Get()
+	require.ErrorContains(t, err, "tried to read detached path")
+	require.ErrorContains(t, p.Set(1), "tried to write detached path")
+
+	doc := automerge.New()
+	require.NoError(t, doc.Path(p).Set("hello"))
+
+	v, err := automerge.As[[]string](doc.Path("x").Get())
+	require.NoError(t, err)
+	require.Equal(t, []string{"hello"}, v)
+
+	parsed, err := automerge.ParsePath("/x/0")
+	require.NoError(t, err)
+	s, err := automerge.As[string](doc.Path(parsed).Get())
+	require.NoError(t, err)
+	require.Equal(t, "hello", s)
+
+	_, err = doc.Path("x").Join(struct{}{})
+	require.Error(t, err)
+
+	// a nil path is an empty detached path
+	var nilPath *automerge.Path
+	p, err = nilPath.Join("x", nilPath)
+	require.NoError(t, err)
+	require.Equal(t, []any{"x"}, p.Segments())
+	require.ErrorContains(t, p.Set(1), "tried to write detached path")
+}
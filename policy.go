@@ -0,0 +1,242 @@
+package automerge
+
+import (
+	"fmt"
+)
+
+// Policy decides whether a change received from another peer may be applied
+// to a document, see [Doc.SetPolicy]. It returns nil to accept the change, or
+// an error explaining why it was rejected. It is called without the document
+// locked, so it may read from the document (which does not yet contain the
+// change), but it should not write to it. Use [Change.Ops] to inspect which
+// objects a change modifies.
+type Policy func(d *Doc, ch *Change) error
+
+// RejectedError is returned when a change is rejected by the [Verifier] or
+// [Policy] of a document. When a change is rejected none of the changes
+// passed to the same method call are applied.
+type RejectedError struct {
+	Hash  ChangeHash
+	Actor string
+	Err   error
+}
+
+func (e *RejectedError) Error() string {
+	return fmt.Sprintf("automerge: change %s by %s rejected: %v", e.Hash, e.Actor, e.Err)
+}
+
+func (e *RejectedError) Unwrap() error {
+	return e.Err
+}
+
+// SetPolicy configures the document to check every change received by
+// [Doc.Apply], [Doc.LoadIncremental], [Doc.Merge] and [SyncState.ReceiveMessage].
+// The policy runs after the [Verifier] (if any), and is not run for changes
+// that are already in the document. If it rejects any change the method
+// returns a [*RejectedError] and none of the changes are applied.
+func (d *Doc) SetPolicy(p Policy) {
+	d.m.Lock()
+	defer d.m.Unlock()
+	d.policy = p
+}
+
+// AllowActors returns a policy that accepts only changes made by the
+// given (hex-encoded) actor IDs.
+func AllowActors(actors ...string) Policy {
+	allowed := map[string]bool{}
+	for _, a := range actors {
+		allowed[a] = true
+	}
+	return func(d *Doc, ch *Change) error {
+		actor, err := ch.TryActorID()
+		if err != nil {
+			return err
+		}
+		if !allowed[actor] {
+			return fmt.Errorf("actor is not allowed")
+		}
+		return nil
+	}
+}
+
+// ActorPolicies returns a policy that checks changes with the policy for the
+// change's actor. A nil policy accepts all changes by the actor, and changes
+// by actors that are not in the map are rejected.
+func ActorPolicies(policies map[string]Policy) Policy {
+	return func(d *Doc, ch *Change) error {
+		actor, err := ch.TryActorID()
+		if err != nil {
+			return err
+		}
+		p, ok := policies[actor]
+		if !ok {
+			return fmt.Errorf("actor is not allowed")
+		}
+		if p == nil {
+			return nil
+		}
+		return p(d, ch)
+	}
+}
+
+// AllowPaths returns a policy that accepts only changes whose operations all
+// modify the document at or below one of the given paths (use [NewPath] or
+// [ParsePath] to create them). For example, allowing the path "comments" lets
+// a peer add and edit comments, but not change anything else.
+//
+// A change may create the value at a path that ends in a map key (if the map
+// that contains it exists), and anything below a path whose object (a [Map],
+// [List] or [Text]) exists, or was created below an allowed path by an
+// earlier change passed to the same method call. The policy walks the objects below each path for
+// every change it checks, so it is best suited to paths below which the
+// document stays small.
+func AllowPaths(paths ...*Path) Policy {
+	key := new(byte)
+	return func(d *Doc, ch *Change) error {
+		ops, err := ch.Ops()
+		if err != nil {
+			return err
+		}
+
+		objs := map[OpID]bool{}
+		keys := map[OpID]map[string]bool{}
+		for _, p := range paths {
+			segs := p.Segments()
+			v, err := d.Path(segs...).Get()
+			if err != nil {
+				return err
+			}
+			if err := v.Walk(func(_ *Path, v *Value) error {
+				if id, ok := valueOpID(v); ok {
+					objs[id] = true
+				}
+				return nil
+			}); err != nil {
+				return err
+			}
+
+			if len(segs) == 0 {
+				continue
+			}
+			key, ok := segs[len(segs)-1].(string)
+			if !ok {
+				continue
+			}
+			parent, err := d.Path(segs[:len(segs)-1]...).Get()
+			if err != nil {
+				return err
+			}
+			if id, ok := valueOpID(parent); ok {
+				if keys[id] == nil {
+					keys[id] = map[string]bool{}
+				}
+				keys[id][key] = true
+			}
+		}
+
+		// objects created by earlier changes in the batch, which this
+		// change may modify before the document contains them
+		created := d.batchState(key, func() any { return map[OpID]bool{} }).(map[OpID]bool)
+		for _, op := range ops {
+			if !objs[op.Obj] && !created[op.Obj] && !keys[op.Obj][op.Key] {
+				return fmt.Errorf("%s of %s is not in an allowed path", op.Action, op.ID)
+			}
+			switch op.Action {
+			case ActionMakeMap, ActionMakeList, ActionMakeText, ActionMakeTable:
+				created[op.ID] = true
+			}
+		}
+		return nil
+	}
+}
+
+// batchState returns the state stored under key for the batch of changes
+// being checked, creating it with init the first time. Outside of a check
+// it returns a new value each time, so nothing outlives the batch.
+func (d *Doc) batchState(key any, init func() any) any {
+	d.m.Lock()
+	defer d.m.Unlock()
+	if d.checking == nil {
+		return init()
+	}
+	v, ok := d.checking[key]
+	if !ok {
+		v = init()
+		d.checking[key] = v
+	}
+	return v
+}
+
+// valueOpID returns the ID of the object v, if it is a map, list or text
+func valueOpID(v *Value) (OpID, bool) {
+	switch val := v.val.(type) {
+	case *Map:
+		return val.objID.opID(), true
+	case *List:
+		return val.objID.opID(), true
+	case *Text:
+		return val.objID.opID(), true
+	}
+	return OpID{}, false
+}
+
+// checksIncoming reports whether incoming changes need to be decoded so
+// that they can be checked, or so that the signatures received for them
+// (sigs, and those passed to [Doc.AddSignatures]) can be stored.
+func (d *Doc) checksIncoming(sigs map[ChangeHash][]byte) bool {
+	d.m.Lock()
+	defer d.m.Unlock()
+	return d.verifier != nil || d.policy != nil || len(sigs) > 0 || len(d.pendingSignatures) > 0
+}
+
+// checkIncoming must be called with the document unlocked, before changes
+// from another peer are applied. sigs are signatures received with the
+// changes. It returns the signature to store for each change (once the
+// changes have been applied, see storeSignatures).
+func (d *Doc) checkIncoming(chs []*Change, sigs map[ChangeHash][]byte) (map[ChangeHash][]byte, error) {
+	if !d.checksIncoming(sigs) {
+		return nil, nil
+	}
+	d.checkM.Lock()
+	defer d.checkM.Unlock()
+	d.m.Lock()
+	v, p := d.verifier, d.policy
+	d.checking = map[any]any{}
+	d.m.Unlock()
+	defer func() {
+		d.m.Lock()
+		d.checking = nil
+		d.m.Unlock()
+	}()
+
+	accepted := map[ChangeHash][]byte{}
+	for _, ch := range chs {
+		h := ch.Hash()
+		if _, err := d.Change(h); err == nil {
+			continue
+		}
+		candidates := d.signatureCandidates(h, sigs[h])
+		var err error
+		switch {
+		case v != nil && len(candidates) == 0:
+			err = v.Verify(ch, nil)
+		case v != nil:
+			for _, sig := range candidates {
+				if err = v.Verify(ch, sig); err == nil {
+					accepted[h] = sig
+					break
+				}
+			}
+		case len(candidates) > 0:
+			accepted[h] = candidates[0]
+		}
+		if err == nil && p != nil {
+			err = p(d, ch)
+		}
+		if err != nil {
+			actor, _ := ch.TryActorID()
+			return nil, &RejectedError{Hash: h, Actor: actor, Err: err}
+		}
+	}
+	return accepted, nil
+}
@@ -0,0 +1,226 @@
+package automerge_test
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/automerge/automerge-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPolicy(t *testing.T) {
+	trusted := automerge.New()
+	require.NoError(t, trusted.Path("x").Set(1))
+	_, err := trusted.Commit("x")
+	require.NoError(t, err)
+
+	limited := automerge.New()
+	require.NoError(t, limited.Path("y").Set(2))
+	_, err = limited.Commit("y")
+	require.NoError(t, err)
+	require.NoError(t, limited.Path("y").Set(3))
+	_, err = limited.Commit("big")
+	require.NoError(t, err)
+
+	stranger := automerge.New()
+	require.NoError(t, stranger.Path("z").Set(4))
+	_, err = stranger.Commit("z")
+	require.NoError(t, err)
+
+	newDoc := func() *automerge.Doc {
+		doc := automerge.New()
+		doc.SetPolicy(automerge.ActorPolicies(map[string]automerge.Policy{
+			trusted.ActorID(): nil,
+			limited.ActorID(): func(d *automerge.Doc, ch *automerge.Change) error {
+				if ch.Message() == "big" {
+					return fmt.Errorf("too big")
+				}
+				return nil
+			},
+		}))
+		return doc
+	}
+
+	t.Run("Apply", func(t *testing.T) {
+		doc := newDoc()
+		chs, err := trusted.Changes()
+		require.NoError(t, err)
+		require.NoError(t, doc.Apply(chs...))
+
+		chs, err = stranger.Changes()
+		require.NoError(t, err)
+		err = doc.Apply(chs...)
+		rejected := &automerge.RejectedError{}
+		require.True(t, errors.As(err, &rejected))
+		require.Equal(t, stranger.ActorID(), rejected.Actor)
+		require.Equal(t, chs[0].Hash(), rejected.Hash)
+
+		chs, err = limited.Changes()
+		require.NoError(t, err)
+		require.NoError(t, doc.Apply(chs[0]))
+		err = doc.Apply(chs[1])
+		require.ErrorContains(t, err, "too big")
+		require.Len(t, doc.Heads(), 2)
+	})
+
+	t.Run("LoadIncremental", func(t *testing.T) {
+		doc := newDoc()
+		require.ErrorContains(t, doc.LoadIncremental(limited.Save()), "too big")
+		require.Empty(t, doc.Heads())
+		require.NoError(t, doc.LoadIncremental(trusted.Save()))
+		require.Equal(t, trusted.Heads(), doc.Heads())
+	})
+
+	t.Run("Merge", func(t *testing.T) {
+		doc := newDoc()
+		_, err := doc.Merge(stranger)
+		require.ErrorContains(t, err, "not allowed")
+		_, err = doc.Merge(trusted)
+		require.NoError(t, err)
+
+		// changes made to the other document while it is being checked
+		// are not merged without being checked
+		other, err := trusted.Fork()
+		require.NoError(t, err)
+		require.NoError(t, other.Path("x").Set(2))
+		_, err = other.Commit("x")
+		require.NoError(t, err)
+		allowed := automerge.AllowActors(trusted.ActorID(), other.ActorID())
+		doc = automerge.New()
+		doc.SetPolicy(func(d *automerge.Doc, ch *automerge.Change) error {
+			if other.ActorID() != stranger.ActorID() {
+				require.NoError(t, other.SetActorID(stranger.ActorID()))
+				require.NoError(t, other.Path("x").Set(3))
+				_, err := other.Commit("sneaky")
+				require.NoError(t, err)
+			}
+			return allowed(d, ch)
+		})
+		heads, err := doc.Merge(other)
+		require.NoError(t, err)
+		require.Equal(t, doc.Heads(), heads)
+		require.NotEqual(t, other.Heads(), heads)
+	})
+
+	t.Run("ReceiveMessage", func(t *testing.T) {
+		doc := newDoc()
+		doc.SetPolicy(automerge.AllowActors(trusted.ActorID()))
+		ss1 := automerge.NewSyncState(stranger)
+		ss2 := automerge.NewSyncState(doc)
+		var err error
+		for i := 0; i < 5 && err == nil; i++ {
+			if msg, valid := ss1.GenerateMessage(); valid {
+				_, err = ss2.ReceiveMessage(msg.Bytes())
+			}
+			if msg, valid := ss2.GenerateMessage(); valid {
+				_, err := ss1.ReceiveMessage(msg.Bytes())
+				require.NoError(t, err)
+			}
+		}
+		require.ErrorContains(t, err, "not allowed")
+		require.Empty(t, doc.Heads())
+	})
+}
+
+func TestAllowPaths(t *testing.T) {
+	base := automerge.New()
+	require.NoError(t, base.Path("title").Set("doc"))
+	require.NoError(t, base.Path("comments").Set([]any{}))
+	require.NoError(t, base.Path("settings").Set(map[string]any{}))
+	_, err := base.Commit("base")
+	require.NoError(t, err)
+
+	comments, err := automerge.NewPath("comments")
+	require.NoError(t, err)
+	theme, err := automerge.ParsePath("settings.theme")
+	require.NoError(t, err)
+
+	newDoc := func() *automerge.Doc {
+		doc, err := base.Fork()
+		require.NoError(t, err)
+		doc.SetPolicy(automerge.AllowPaths(comments, theme))
+		return doc
+	}
+	change := func(f func(peer *automerge.Doc)) []*automerge.Change {
+		peer, err := base.Fork()
+		require.NoError(t, err)
+		heads := peer.Heads()
+		f(peer)
+		_, err = peer.Commit("change")
+		require.NoError(t, err)
+		chs, err := peer.Changes(heads...)
+		require.NoError(t, err)
+		return chs
+	}
+
+	t.Run("allowed", func(t *testing.T) {
+		doc := newDoc()
+		// objects created below an allowed path can be modified by later
+		// changes received at the same time
+		chs := change(func(peer *automerge.Doc) {
+			require.NoError(t, peer.Path("comments").List().Append(map[string]any{"text": "hi"}))
+			_, err := peer.Commit("add")
+			require.NoError(t, err)
+			require.NoError(t, peer.Path("comments", 0, "text").Set("hello"))
+			require.NoError(t, peer.Path("settings", "theme").Set(map[string]any{"dark": true}))
+		})
+		require.Len(t, chs, 2)
+		require.NoError(t, doc.Apply(chs...))
+
+		v, err := automerge.As[string](doc.Path("comments", 0, "text").Get())
+		require.NoError(t, err)
+		require.Equal(t, "hello", v)
+
+		// and once they are in the document
+		require.NoError(t, doc.Apply(change(func(peer *automerge.Doc) {
+			_, err := peer.Merge(doc)
+			require.NoError(t, err)
+			require.NoError(t, peer.Path("settings", "theme", "dark").Set(false))
+		})...))
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		for name, f := range map[string]func(peer *automerge.Doc){
+			"root key":    func(peer *automerge.Doc) { require.NoError(t, peer.Path("title").Set("hacked")) },
+			"sibling key": func(peer *automerge.Doc) { require.NoError(t, peer.Path("settings", "lang").Set("fr")) },
+			"parent":      func(peer *automerge.Doc) { require.NoError(t, peer.Path("comments").Set([]any{"new"})) },
+			"mixed": func(peer *automerge.Doc) {
+				require.NoError(t, peer.Path("comments").List().Append("ok"))
+				require.NoError(t, peer.Path("title").Set("hacked"))
+			},
+		} {
+			t.Run(name, func(t *testing.T) {
+				doc := newDoc()
+				err := doc.Apply(change(f)...)
+				rejected := &automerge.RejectedError{}
+				require.ErrorAs(t, err, &rejected)
+				require.ErrorContains(t, err, "not in an allowed path")
+				require.Equal(t, base.Heads(), doc.Heads())
+			})
+		}
+	})
+
+	t.Run("rejected batch", func(t *testing.T) {
+		doc := newDoc()
+		peer, err := base.Fork()
+		require.NoError(t, err)
+		require.NoError(t, peer.Path("comments").List().Append(map[string]any{}))
+		_, err = peer.Commit("add")
+		require.NoError(t, err)
+		require.NoError(t, peer.Path("title").Set("hacked"))
+		_, err = peer.Commit("hack")
+		require.NoError(t, err)
+		require.NoError(t, peer.Path("comments", 0, "text").Set("hi"))
+		_, err = peer.Commit("edit")
+		require.NoError(t, err)
+		chs, err := peer.Changes(base.Heads()...)
+		require.NoError(t, err)
+		require.Len(t, chs, 3)
+		require.ErrorContains(t, doc.Apply(chs...), "not in an allowed path")
+
+		// objects created by a rejected batch are not remembered
+		require.ErrorContains(t, doc.Apply(chs[2]), "not in an allowed path")
+		require.Equal(t, base.Heads(), doc.Heads())
+	})
+}
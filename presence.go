@@ -0,0 +1,386 @@
+package automerge
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// DefaultPresenceHeartbeat is how often a [Presence] re-broadcasts its state
+const DefaultPresenceHeartbeat = 15 * time.Second
+
+// PresenceOptions configure a [Presence]
+type PresenceOptions struct {
+	// Heartbeat is how often the local state is re-sent so that peers know
+	// this peer is still online. Defaults to [DefaultPresenceHeartbeat].
+	Heartbeat time.Duration
+	// Timeout is how long a peer is kept after its last message.
+	// Defaults to three times Heartbeat.
+	Timeout time.Duration
+	// Relay forwards messages received on one link to all other links,
+	// for example on a server that connects many clients.
+	Relay bool
+	// OnChange is called whenever a peer joins, leaves or updates its state.
+	OnChange func()
+	// OnError is called if sending to a link fails. The link is closed
+	// before OnError is called.
+	OnError func(err error)
+}
+
+// PresencePeer is the ephemeral state of another peer
+type PresencePeer struct {
+	ID       string
+	State    json.RawMessage
+	LastSeen time.Time
+}
+
+// Decode unmarshals the peer's state into v
+func (pp PresencePeer) Decode(v any) error {
+	return json.Unmarshal(pp.State, v)
+}
+
+// Presence shares ephemeral state, such as cursor positions or who is online,
+// between peers. Unlike changes to a [Doc], presence state is not stored: each
+// peer periodically broadcasts its current state, and peers that have not been
+// heard from within the timeout are forgotten.
+//
+// Presence is transport agnostic: connect it to peers with [Presence.AddLink],
+// or set [SyncMuxOptions].Presence to send it alongside sync messages.
+type Presence struct {
+	self    string
+	session string
+	opts    PresenceOptions
+
+	m     sync.Mutex
+	seq   uint64
+	state json.RawMessage
+	peers map[string]*presencePeer
+	links map[*PresenceLink]bool
+	done  chan struct{}
+}
+
+// maxEndedSessions is how many previous sessions of a peer are remembered
+const maxEndedSessions = 8
+
+type presencePeer struct {
+	session  string
+	seq      uint64
+	state    json.RawMessage
+	lastSeen time.Time
+	// left is set once the peer has left. The peer is kept until it times
+	// out, so that delayed messages from the session are ignored.
+	left bool
+	// ended are the peer's previous sessions, whose messages are ignored
+	ended []string
+}
+
+// stale reports whether msg was sent before the last message from the peer
+func (pp *presencePeer) stale(msg *presenceMessage) bool {
+	if msg.Session == pp.session {
+		return msg.Seq <= pp.seq
+	}
+	for _, s := range pp.ended {
+		if s == msg.Session {
+			return true
+		}
+	}
+	return false
+}
+
+// presenceMessage is the JSON encoding of a presence update. Session is
+// chosen randomly by each Presence, so that a peer that restarts (and
+// so starts counting from 1 again) is not mistaken for an old message.
+type presenceMessage struct {
+	Peer    string          `json:"peer"`
+	Session string          `json:"session,omitempty"`
+	Seq     uint64          `json:"seq"`
+	State   json.RawMessage `json:"state,omitempty"`
+	Leave   bool            `json:"leave,omitempty"`
+}
+
+// NewPresence returns a presence channel for the local peer self.
+// Call [Presence.Close] to announce that the peer is leaving.
+func NewPresence(self string, opts ...PresenceOptions) *Presence {
+	p := &Presence{
+		self:    self,
+		session: newPresenceSession(),
+		peers:   map[string]*presencePeer{},
+		links:   map[*PresenceLink]bool{},
+		done:    make(chan struct{}),
+	}
+	for _, o := range opts {
+		if o.Heartbeat != 0 {
+			p.opts.Heartbeat = o.Heartbeat
+		}
+		if o.Timeout != 0 {
+			p.opts.Timeout = o.Timeout
+		}
+		if o.Relay {
+			p.opts.Relay = true
+		}
+		if o.OnChange != nil {
+			p.opts.OnChange = o.OnChange
+		}
+		if o.OnError != nil {
+			p.opts.OnError = o.OnError
+		}
+	}
+	if p.opts.Heartbeat <= 0 {
+		p.opts.Heartbeat = DefaultPresenceHeartbeat
+	}
+	if p.opts.Timeout <= 0 {
+		p.opts.Timeout = 3 * p.opts.Heartbeat
+	}
+	go p.run()
+	return p
+}
+
+func newPresenceSession() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// ID returns the local peer's ID
+func (p *Presence) ID() string {
+	return p.self
+}
+
+// Set updates the local state (encoded as JSON) and broadcasts it to all peers.
+func (p *Presence) Set(state any) error {
+	b, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("automerge.Presence: %w", err)
+	}
+	p.m.Lock()
+	p.state = b
+	msg, links := p.update()
+	p.m.Unlock()
+
+	broadcast(links, msg)
+	return nil
+}
+
+// Get returns the local state, or nil if Set has not been called
+func (p *Presence) Get() json.RawMessage {
+	p.m.Lock()
+	defer p.m.Unlock()
+	return p.state
+}
+
+// Peers returns the other peers that are currently online, sorted by ID
+func (p *Presence) Peers() []PresencePeer {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	ret := []PresencePeer{}
+	for id, pp := range p.peers {
+		if !pp.left {
+			ret = append(ret, PresencePeer{ID: id, State: pp.state, LastSeen: pp.lastSeen})
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].ID < ret[j].ID })
+	return ret
+}
+
+// update returns the encoded local state, and must be called with p.m held
+func (p *Presence) update() ([]byte, []*PresenceLink) {
+	if p.state == nil {
+		return nil, nil
+	}
+	p.seq++
+	msg, _ := json.Marshal(presenceMessage{Peer: p.self, Session: p.session, Seq: p.seq, State: p.state})
+	return msg, p.linkList(nil)
+}
+
+// linkList must be called with p.m held
+func (p *Presence) linkList(except *PresenceLink) []*PresenceLink {
+	ret := []*PresenceLink{}
+	for l := range p.links {
+		if l != except {
+			ret = append(ret, l)
+		}
+	}
+	return ret
+}
+
+func broadcast(links []*PresenceLink, msg []byte) {
+	if msg == nil {
+		return
+	}
+	for _, l := range links {
+		l.sendAll(msg)
+	}
+}
+
+func (p *Presence) changed() {
+	if p.opts.OnChange != nil {
+		p.opts.OnChange()
+	}
+}
+
+func (p *Presence) run() {
+	t := time.NewTicker(p.opts.Heartbeat)
+	defer t.Stop()
+	for {
+		select {
+		case <-p.done:
+			return
+		case <-t.C:
+		}
+
+		p.m.Lock()
+		msg, links := p.update()
+		expired := false
+		for id, pp := range p.peers {
+			if time.Since(pp.lastSeen) > p.opts.Timeout {
+				delete(p.peers, id)
+				expired = expired || !pp.left
+			}
+		}
+		p.m.Unlock()
+
+		broadcast(links, msg)
+		if expired {
+			p.changed()
+		}
+	}
+}
+
+// Close broadcasts that the local peer is leaving and stops sending heartbeats.
+// Links are not closed.
+func (p *Presence) Close() error {
+	p.m.Lock()
+	select {
+	case <-p.done:
+		p.m.Unlock()
+		return nil
+	default:
+	}
+	close(p.done)
+	p.seq++
+	msg, _ := json.Marshal(presenceMessage{Peer: p.self, Session: p.session, Seq: p.seq, Leave: true})
+	links := p.linkList(nil)
+	p.m.Unlock()
+
+	broadcast(links, msg)
+	return nil
+}
+
+// PresenceLink connects a [Presence] to one peer connection
+type PresenceLink struct {
+	p    *Presence
+	send func(msg []byte) error
+}
+
+// AddLink connects the presence to a peer. send is called with each message
+// that should be delivered to the peer, and the peer's messages should be
+// passed to [PresenceLink.Receive]. The current state is sent immediately.
+//
+// If send returns an error the link is closed and [PresenceOptions].OnError
+// is called.
+func (p *Presence) AddLink(send func(msg []byte) error) *PresenceLink {
+	l := &PresenceLink{p: p, send: send}
+
+	p.m.Lock()
+	p.links[l] = true
+	msgs := [][]byte{}
+	if p.state != nil {
+		msg, _ := json.Marshal(presenceMessage{Peer: p.self, Session: p.session, Seq: p.seq, State: p.state})
+		msgs = append(msgs, msg)
+	}
+	if p.opts.Relay {
+		for id, pp := range p.peers {
+			if !pp.left {
+				msg, _ := json.Marshal(presenceMessage{Peer: id, Session: pp.session, Seq: pp.seq, State: pp.state})
+				msgs = append(msgs, msg)
+			}
+		}
+	}
+	p.m.Unlock()
+
+	l.sendAll(msgs...)
+	return l
+}
+
+// sendAll sends msgs to the peer, closing the link if that fails
+func (l *PresenceLink) sendAll(msgs ...[]byte) {
+	for _, msg := range msgs {
+		if err := l.send(msg); err != nil {
+			p := l.p
+			p.m.Lock()
+			open := p.links[l]
+			delete(p.links, l)
+			p.m.Unlock()
+			if open && p.opts.OnError != nil {
+				p.opts.OnError(fmt.Errorf("automerge.Presence: %w", err))
+			}
+			return
+		}
+	}
+}
+
+// Receive handles a presence message from the peer
+func (l *PresenceLink) Receive(b []byte) error {
+	msg := presenceMessage{}
+	if err := json.Unmarshal(b, &msg); err != nil {
+		return fmt.Errorf("automerge.Presence: invalid message: %w", err)
+	}
+	p := l.p
+	if msg.Peer == "" || msg.Peer == p.self {
+		return nil
+	}
+
+	p.m.Lock()
+	pp := p.peers[msg.Peer]
+	if pp != nil && pp.stale(&msg) {
+		// an old or duplicate message (e.g. relayed by more than one peer)
+		p.m.Unlock()
+		return nil
+	}
+	if pp == nil {
+		pp = &presencePeer{session: msg.Session, left: true}
+		p.peers[msg.Peer] = pp
+	} else if pp.session != msg.Session {
+		// the peer has restarted
+		pp.ended = append(pp.ended, pp.session)
+		if len(pp.ended) > maxEndedSessions {
+			pp.ended = pp.ended[1:]
+		}
+		pp.session = msg.Session
+	}
+	changed := false
+	if msg.Leave {
+		changed = !pp.left
+		pp.left, pp.state = true, nil
+	} else {
+		changed = pp.left || string(pp.state) != string(msg.State)
+		pp.left, pp.state = false, msg.State
+	}
+	pp.seq, pp.lastSeen = msg.Seq, time.Now()
+	var links []*PresenceLink
+	if p.opts.Relay {
+		links = p.linkList(l)
+	}
+	p.m.Unlock()
+
+	broadcast(links, b)
+	if changed {
+		p.changed()
+	}
+	return nil
+}
+
+// Close disconnects the link. Peers that were only reachable over this link
+// are forgotten once they time out.
+func (l *PresenceLink) Close() {
+	l.p.m.Lock()
+	defer l.p.m.Unlock()
+	delete(l.p.links, l)
+}
@@ -0,0 +1,172 @@
+package automerge_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/automerge/automerge-go"
+	"github.com/stretchr/testify/require"
+)
+
+type cursor struct {
+	Name string `json:"name"`
+	Pos  int    `json:"pos"`
+}
+
+func peerIDs(p *automerge.Presence) []string {
+	ret := []string{}
+	for _, pp := range p.Peers() {
+		ret = append(ret, pp.ID)
+	}
+	return ret
+}
+
+func TestPresence(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// a server relays presence between two clients, each over a SyncMux
+	server := automerge.NewPresence("server", automerge.PresenceOptions{Relay: true})
+	defer server.Close()
+	var changes int32
+	alice := automerge.NewPresence("alice", automerge.PresenceOptions{
+		OnChange: func() { atomic.AddInt32(&changes, 1) },
+	})
+	bob := automerge.NewPresence("bob")
+	defer bob.Close()
+
+	require.NoError(t, alice.Set(cursor{Name: "Alice", Pos: 1}))
+	for _, p := range []*automerge.Presence{alice, bob} {
+		c1, c2 := automerge.NewMessagePipe()
+		go automerge.NewSyncMux(c1, automerge.SyncMuxOptions{Presence: p}).Run(ctx)
+		go automerge.NewSyncMux(c2, automerge.SyncMuxOptions{Presence: server}).Run(ctx)
+	}
+
+	require.Eventually(t, func() bool { return len(bob.Peers()) == 1 }, 5*time.Second, time.Millisecond)
+	pp := bob.Peers()[0]
+	require.Equal(t, "alice", pp.ID)
+	c := cursor{}
+	require.NoError(t, pp.Decode(&c))
+	require.Equal(t, cursor{Name: "Alice", Pos: 1}, c)
+
+	require.NoError(t, bob.Set(cursor{Name: "Bob"}))
+	require.Eventually(t, func() bool { return len(alice.Peers()) == 1 }, 5*time.Second, time.Millisecond)
+	require.Equal(t, []string{"alice", "bob"}, peerIDs(server))
+	require.Greater(t, atomic.LoadInt32(&changes), int32(0))
+
+	require.NoError(t, alice.Set(cursor{Name: "Alice", Pos: 2}))
+	require.Eventually(t, func() bool {
+		c := cursor{}
+		return bob.Peers()[0].Decode(&c) == nil && c.Pos == 2
+	}, 5*time.Second, time.Millisecond)
+
+	// leaving is broadcast
+	require.NoError(t, alice.Close())
+	require.Eventually(t, func() bool { return len(bob.Peers()) == 0 }, 5*time.Second, time.Millisecond)
+	require.Equal(t, []string{"bob"}, peerIDs(server))
+}
+
+func TestPresence_Timeout(t *testing.T) {
+	a := automerge.NewPresence("a", automerge.PresenceOptions{Heartbeat: 10 * time.Millisecond})
+	defer a.Close()
+	b := automerge.NewPresence("b", automerge.PresenceOptions{Heartbeat: 10 * time.Millisecond})
+	defer b.Close()
+
+	var ab, ba *automerge.PresenceLink
+	var connected int32 = 1
+	ab = a.AddLink(func(msg []byte) error {
+		if atomic.LoadInt32(&connected) == 1 {
+			return ba.Receive(msg)
+		}
+		return nil
+	})
+	ba = b.AddLink(func(msg []byte) error { return ab.Receive(msg) })
+	require.NoError(t, b.Set("here"))
+	require.NoError(t, a.Set("here"))
+
+	// heartbeats keep peers alive
+	require.Eventually(t, func() bool { return len(b.Peers()) == 1 }, 5*time.Second, time.Millisecond)
+	time.Sleep(100 * time.Millisecond)
+	require.Equal(t, []string{"a"}, peerIDs(b))
+
+	// once messages stop the peer times out
+	atomic.StoreInt32(&connected, 0)
+	require.Eventually(t, func() bool { return len(b.Peers()) == 0 }, 5*time.Second, time.Millisecond)
+	require.Equal(t, []string{"b"}, peerIDs(a))
+
+	require.Error(t, ab.Receive([]byte("not json")))
+}
+
+func TestPresence_Restart(t *testing.T) {
+	b := automerge.NewPresence("b")
+	defer b.Close()
+
+	// connect returns a presence for peer "a" linked to b, and the messages it sent
+	connect := func() (*automerge.Presence, *[][]byte) {
+		a := automerge.NewPresence("a")
+		sent := &[][]byte{}
+		ba := b.AddLink(func([]byte) error { return nil })
+		a.AddLink(func(msg []byte) error {
+			*sent = append(*sent, msg)
+			return ba.Receive(msg)
+		})
+		return a, sent
+	}
+	state := func() string {
+		peers := b.Peers()
+		if len(peers) != 1 {
+			return ""
+		}
+		return string(peers[0].State)
+	}
+
+	a, sent := connect()
+	require.NoError(t, a.Set("one"))
+	require.NoError(t, a.Set("two"))
+	require.Equal(t, `"two"`, state())
+
+	// a restarted peer starts counting again, but is not ignored
+	a2, _ := connect()
+	defer a2.Close()
+	require.NoError(t, a2.Set("three"))
+	require.Equal(t, `"three"`, state())
+
+	// while delayed messages from before the restart are
+	l := b.AddLink(func([]byte) error { return nil })
+	require.NoError(t, l.Receive((*sent)[1]))
+	require.Equal(t, `"three"`, state())
+
+	// and a peer that has left can rejoin
+	require.NoError(t, a2.Close())
+	require.Empty(t, b.Peers())
+	a3, _ := connect()
+	defer a3.Close()
+	require.NoError(t, a3.Set("four"))
+	require.Equal(t, `"four"`, state())
+	require.NoError(t, l.Receive((*sent)[0]))
+	require.Equal(t, `"four"`, state())
+	a.Close()
+}
+
+func TestPresence_SendError(t *testing.T) {
+	errs := make(chan error, 10)
+	p := automerge.NewPresence("p", automerge.PresenceOptions{OnError: func(err error) { errs <- err }})
+	defer p.Close()
+	require.NoError(t, p.Set("here"))
+
+	errBroken := errors.New("broken")
+	sends := 0
+	p.AddLink(func([]byte) error {
+		sends++
+		return errBroken
+	})
+	require.ErrorIs(t, <-errs, errBroken)
+
+	// the link is closed
+	require.NoError(t, p.Set("still here"))
+	require.Equal(t, 1, sends)
+	require.Empty(t, errs)
+}
@@ -0,0 +1,504 @@
+package automerge
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Query is a compiled path expression that can match many values in a document.
+//
+// The syntax extends that of [ParsePath] with wildcards and filters:
+//
+//	tasks[*].assignee          // the assignee of every task
+//	settings.*                 // every value in the settings map
+//	tasks[?done==false].title  // the title of every task that is not done
+//	tasks[?owner.name=="ada" && priority>=2]
+//	tags[?@!="draft"]          // every tag that is not "draft"
+//	tasks[?assignee]           // every task with an assignee
+//	tasks[?!assignee]          // every task without an assignee
+//
+// Filters apply to each child of a map or list. Within a filter, operands on the left
+// are paths relative to the child (@ refers to the child itself), and operands on the
+// right are literals: numbers, double-quoted strings, true, false or null. Conditions
+// can be combined with && and ||, where && binds more tightly.
+// Numbers of any kind (including counters) compare numerically, and [Text] compares
+// equal to the corresponding string.
+type Query struct {
+	src   string
+	steps []queryStep
+}
+
+// QueryMatch is a value matched by a [Query], and the concrete path at which it was found.
+type QueryMatch struct {
+	Path  *Path
+	Value *Value
+}
+
+type queryStepKind int
+
+const (
+	queryKey queryStepKind = iota
+	queryIndex
+	queryWildcard
+	queryFilter
+)
+
+type queryStep struct {
+	kind   queryStepKind
+	key    string
+	index  int
+	filter [][]queryCond
+}
+
+type queryCond struct {
+	path   []any
+	negate bool
+	op     string
+	lit    any
+}
+
+// ParseQuery compiles a query, see [Query] for the syntax.
+func ParseQuery(s string) (*Query, error) {
+	q := &Query{src: s}
+	errorf := func(i int, msg string) error {
+		return fmt.Errorf("automerge.ParseQuery: %s at offset %d in %q", msg, i, s)
+	}
+
+	i := 0
+	for i < len(s) {
+		switch {
+		case strings.HasPrefix(s[i:], "[*]"):
+			q.steps = append(q.steps, queryStep{kind: queryWildcard})
+			i += 3
+
+		case strings.HasPrefix(s[i:], "[?"):
+			end := filterEnd(s, i+2)
+			if end < 0 {
+				return nil, errorf(i, "unterminated [?")
+			}
+			filter, err := parseFilter(s[i+2 : end])
+			if err != nil {
+				return nil, errorf(i+2, err.Error())
+			}
+			q.steps = append(q.steps, queryStep{kind: queryFilter, filter: filter})
+			i = end + 1
+
+		case s[i] == '[':
+			if i+1 < len(s) && s[i+1] == '"' {
+				quoted, err := strconv.QuotedPrefix(s[i+1:])
+				if err != nil {
+					return nil, errorf(i+1, "invalid quoted key")
+				}
+				key, _ := strconv.Unquote(quoted)
+				end := i + 1 + len(quoted)
+				if end >= len(s) || s[end] != ']' {
+					return nil, errorf(end, "expected ]")
+				}
+				q.steps = append(q.steps, queryStep{kind: queryKey, key: key})
+				i = end + 1
+				continue
+			}
+			end := strings.IndexByte(s[i:], ']')
+			if end < 0 {
+				return nil, errorf(i, "unterminated [")
+			}
+			idx, ok := parseIndex(s[i+1 : i+end])
+			if !ok {
+				return nil, errorf(i+1, "expected list index, quoted key, * or ?filter")
+			}
+			q.steps = append(q.steps, queryStep{kind: queryIndex, index: idx})
+			i += end + 1
+
+		case i == 0 || s[i] == '.':
+			if i > 0 {
+				i++
+			}
+			if i < len(s) && s[i] == '*' {
+				q.steps = append(q.steps, queryStep{kind: queryWildcard})
+				i++
+				continue
+			}
+			start := i
+			for i < len(s) && isIdentifierByte(s[i], i == start) {
+				i++
+			}
+			if i == start {
+				return nil, errorf(start, "expected identifier or *")
+			}
+			q.steps = append(q.steps, queryStep{kind: queryKey, key: s[start:i]})
+
+		default:
+			return nil, errorf(i, fmt.Sprintf("unexpected %q", s[i]))
+		}
+	}
+	return q, nil
+}
+
+// filterEnd returns the index of the ] that closes a filter starting at i,
+// skipping over quoted strings and nested brackets.
+func filterEnd(s string, i int) int {
+	depth := 0
+	for i < len(s) {
+		switch s[i] {
+		case '"':
+			quoted, err := strconv.QuotedPrefix(s[i:])
+			if err != nil {
+				return -1
+			}
+			i += len(quoted)
+			continue
+		case '[':
+			depth++
+		case ']':
+			if depth == 0 {
+				return i
+			}
+			depth--
+		}
+		i++
+	}
+	return -1
+}
+
+// splitOutsideQuotes splits s on sep, ignoring any occurrences within double-quoted strings.
+func splitOutsideQuotes(s string, sep string) []string {
+	parts := []string{}
+	start := 0
+	for i := 0; i < len(s); {
+		if s[i] == '"' {
+			quoted, err := strconv.QuotedPrefix(s[i:])
+			if err == nil {
+				i += len(quoted)
+				continue
+			}
+		}
+		if strings.HasPrefix(s[i:], sep) {
+			parts = append(parts, s[start:i])
+			i += len(sep)
+			start = i
+			continue
+		}
+		i++
+	}
+	return append(parts, s[start:])
+}
+
+var queryOps = []string{"==", "!=", "<=", ">=", "<", ">"}
+
+func parseFilter(s string) ([][]queryCond, error) {
+	ors := [][]queryCond{}
+	for _, or := range splitOutsideQuotes(s, "||") {
+		ands := []queryCond{}
+		for _, and := range splitOutsideQuotes(or, "&&") {
+			c, err := parseCond(strings.TrimSpace(and))
+			if err != nil {
+				return nil, err
+			}
+			ands = append(ands, c)
+		}
+		ors = append(ors, ands)
+	}
+	return ors, nil
+}
+
+func parseCond(s string) (queryCond, error) {
+	c := queryCond{}
+	lhs := s
+	for i := 0; i < len(s); i++ {
+		if s[i] == '"' {
+			quoted, err := strconv.QuotedPrefix(s[i:])
+			if err != nil {
+				return c, fmt.Errorf("invalid quoted key in filter %q", s)
+			}
+			i += len(quoted) - 1
+			continue
+		}
+		for _, op := range queryOps {
+			if strings.HasPrefix(s[i:], op) {
+				lit, err := parseLiteral(strings.TrimSpace(s[i+len(op):]))
+				if err != nil {
+					return c, err
+				}
+				c.op, c.lit, lhs = op, lit, s[:i]
+				break
+			}
+		}
+		if c.op != "" {
+			break
+		}
+	}
+
+	lhs = strings.TrimSpace(lhs)
+	if c.op == "" && strings.HasPrefix(lhs, "!") {
+		c.negate = true
+		lhs = strings.TrimSpace(lhs[1:])
+	}
+	if lhs == "" {
+		return c, fmt.Errorf("empty condition in filter")
+	}
+	if lhs == "@" {
+		c.path = []any{}
+		return c, nil
+	}
+	lhs = strings.TrimPrefix(strings.TrimPrefix(lhs, "@."), "@")
+	p, err := parseDotted(lhs)
+	if err != nil {
+		return c, fmt.Errorf("invalid path %q in filter", lhs)
+	}
+	c.path = p.path
+	return c, nil
+}
+
+func parseLiteral(s string) (any, error) {
+	switch s {
+	case "null":
+		return nil, nil
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	if strings.HasPrefix(s, `"`) {
+		str, err := strconv.Unquote(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid string literal %s in filter", s)
+		}
+		return str, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid literal %q in filter", s)
+	}
+	return f, nil
+}
+
+// String returns the query as it was parsed
+func (q *Query) String() string {
+	return q.src
+}
+
+// Query returns all values in the document that match the query.
+// See [Query] for the syntax. If heads are provided the query is run against
+// a fork of the document as of those heads, and the returned paths refer to
+// that fork (you can pass them to [Doc.Path] to resolve them in d).
+func (d *Doc) Query(query string, heads ...ChangeHash) ([]*QueryMatch, error) {
+	q, err := ParseQuery(query)
+	if err != nil {
+		return nil, err
+	}
+	return q.Run(d, heads...)
+}
+
+// Run returns all values in the document that match the query,
+// see [Doc.Query] for details.
+func (q *Query) Run(d *Doc, heads ...ChangeHash) ([]*QueryMatch, error) {
+	if len(heads) > 0 {
+		var err error
+		d, err = d.Fork(heads...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	matches := []*QueryMatch{{Path: d.Path(), Value: d.Root()}}
+	for _, step := range q.steps {
+		next := []*QueryMatch{}
+		for _, m := range matches {
+			children, err := step.apply(m)
+			if err != nil {
+				return nil, err
+			}
+			next = append(next, children...)
+		}
+		matches = next
+	}
+	return matches, nil
+}
+
+func (s queryStep) apply(m *QueryMatch) ([]*QueryMatch, error) {
+	switch s.kind {
+	case queryKey:
+		if m.Value.Kind() != KindMap {
+			return nil, nil
+		}
+		v, err := m.Value.Map().Get(s.key)
+		if err != nil || v.IsVoid() {
+			return nil, err
+		}
+		return []*QueryMatch{{Path: m.Path.Path(s.key), Value: v}}, nil
+
+	case queryIndex:
+		if m.Value.Kind() != KindList {
+			return nil, nil
+		}
+		v, err := m.Value.List().Get(s.index)
+		if err != nil || v.IsVoid() {
+			return nil, err
+		}
+		return []*QueryMatch{{Path: m.Path.Path(s.index), Value: v}}, nil
+	}
+
+	children, err := children(m.Path, m.Value)
+	if err != nil || s.kind == queryWildcard {
+		return children, err
+	}
+
+	ret := []*QueryMatch{}
+	for _, c := range children {
+		ok, err := evalFilter(s.filter, c.Value)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			ret = append(ret, c)
+		}
+	}
+	return ret, nil
+}
+
+// children returns the values in the map or list v (at p), with maps sorted
+// by key. Other values have no children.
+func children(p *Path, v *Value) ([]*QueryMatch, error) {
+	switch v.Kind() {
+	case KindMap:
+		values, err := v.Map().Values()
+		if err != nil {
+			return nil, err
+		}
+		keys := make([]string, 0, len(values))
+		for k := range values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		ret := make([]*QueryMatch, 0, len(keys))
+		for _, k := range keys {
+			ret = append(ret, &QueryMatch{Path: p.Path(k), Value: values[k]})
+		}
+		return ret, nil
+
+	case KindList:
+		values, err := v.List().Values()
+		if err != nil {
+			return nil, err
+		}
+		ret := make([]*QueryMatch, 0, len(values))
+		for i, item := range values {
+			ret = append(ret, &QueryMatch{Path: p.Path(i), Value: item})
+		}
+		return ret, nil
+	}
+	return nil, nil
+}
+
+func evalFilter(filter [][]queryCond, v *Value) (bool, error) {
+	for _, ands := range filter {
+		all := true
+		for _, c := range ands {
+			ok, err := c.eval(v)
+			if err != nil {
+				return false, err
+			}
+			if !ok {
+				all = false
+				break
+			}
+		}
+		if all {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (c queryCond) eval(v *Value) (bool, error) {
+	var err error
+	for _, seg := range c.path {
+		switch idx := seg.(type) {
+		case string:
+			if v.Kind() != KindMap {
+				return c.negate, nil
+			}
+			v, err = v.Map().Get(idx)
+		case int:
+			if v.Kind() != KindList {
+				return c.negate, nil
+			}
+			v, err = v.List().Get(idx)
+		}
+		if err != nil {
+			return false, err
+		}
+	}
+
+	if c.op == "" {
+		return v.IsVoid() == c.negate, nil
+	}
+	if v.IsVoid() {
+		return false, nil
+	}
+
+	x, err := filterOperand(v)
+	if err != nil {
+		return false, err
+	}
+
+	switch lit := c.lit.(type) {
+	case float64:
+		if f, ok := x.(float64); ok {
+			return compareOrdered(f, lit, c.op), nil
+		}
+	case string:
+		if s, ok := x.(string); ok {
+			return compareOrdered(s, lit, c.op), nil
+		}
+	case bool, nil:
+		switch c.op {
+		case "==":
+			return x == c.lit, nil
+		case "!=":
+			return x != c.lit, nil
+		}
+		return false, nil
+	}
+	return c.op == "!=", nil
+}
+
+// filterOperand converts v to a comparable go value: numbers become
+// float64, text becomes a string, and containers become a non-comparable marker.
+func filterOperand(v *Value) (any, error) {
+	switch v.Kind() {
+	case KindInt64:
+		return float64(v.Int64()), nil
+	case KindUint64:
+		return float64(v.Uint64()), nil
+	case KindFloat64:
+		return v.Float64(), nil
+	case KindCounter:
+		return float64(v.Counter().val), nil
+	case KindText:
+		return v.Text().Get()
+	case KindBool, KindStr, KindNull:
+		return v.val, nil
+	}
+	return struct{}{}, nil
+}
+
+func compareOrdered[T float64 | string](a, b T, op string) bool {
+	switch op {
+	case "==":
+		return a == b
+	case "!=":
+		return a != b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	}
+	return false
+}
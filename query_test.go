@@ -0,0 +1,62 @@
+package automerge_test
+
+import (
+	"testing"
+
+	"github.com/automerge/automerge-go"
+	"github.com/stretchr/testify/require"
+)
+
+func queryPaths(t *testing.T, doc *automerge.Doc, q string, heads ...automerge.ChangeHash) []string {
+	t.Helper()
+	matches, err := doc.Query(q, heads...)
+	require.NoError(t, err, q)
+	ret := []string{}
+	for _, m := range matches {
+		ret = append(ret, m.Path.String())
+	}
+	return ret
+}
+
+func TestDoc_Query(t *testing.T) {
+	doc := automerge.New()
+	require.NoError(t, doc.Path("tasks").Set([]map[string]any{
+		{"title": "a", "done": true, "priority": 1, "assignee": "ada"},
+		{"title": "b", "done": false, "priority": 2, "reviewer": nil},
+		{"title": "c", "done": false, "priority": 3, "assignee": map[string]any{"name": "bob"}},
+	}))
+	require.NoError(t, doc.Path("tags").Set([]string{"draft", "urgent"}))
+	require.NoError(t, doc.Path("notes").Set(automerge.NewText("hello")))
+	heads, err := doc.Commit("initial")
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"tasks[0].title", "tasks[1].title", "tasks[2].title"}, queryPaths(t, doc, "tasks[*].title"))
+	require.Equal(t, []string{"tasks[1]", "tasks[2]"}, queryPaths(t, doc, "tasks[?done==false]"))
+	require.Equal(t, []string{"tasks[2].title"}, queryPaths(t, doc, "tasks[?done==false && priority>=3].title"))
+	require.Equal(t, []string{"tasks[0]", "tasks[2]"}, queryPaths(t, doc, "tasks[?priority<2 || assignee.name==\"bob\"]"))
+	require.Equal(t, []string{"tasks[0]", "tasks[2]"}, queryPaths(t, doc, "tasks[?assignee]"))
+	require.Equal(t, []string{"tasks[1]"}, queryPaths(t, doc, "tasks[?!assignee]"))
+	require.Equal(t, []string{"tasks[1]"}, queryPaths(t, doc, "tasks[?reviewer==null]"))
+	require.Equal(t, []string{"tags[1]"}, queryPaths(t, doc, `tags[?@!="draft"]`))
+	require.Equal(t, []string{"notes"}, queryPaths(t, doc, `[?@=="hello"]`))
+	require.Equal(t, []string{"notes", "tags", "tasks"}, queryPaths(t, doc, "*"))
+	require.Equal(t, []string{}, queryPaths(t, doc, "tasks[5].title"))
+	require.Equal(t, []string{}, queryPaths(t, doc, "tags.x"))
+
+	matches, err := doc.Query("tasks[?title==\"b\"].priority")
+	require.NoError(t, err)
+	require.Len(t, matches, 1)
+	require.Equal(t, float64(2), matches[0].Value.Float64())
+
+	require.NoError(t, doc.Path("tasks", 1, "done").Set(true))
+	_, err = doc.Commit("done")
+	require.NoError(t, err)
+
+	require.Equal(t, []string{"tasks[2]"}, queryPaths(t, doc, "tasks[?done==false]"))
+	require.Equal(t, []string{"tasks[1]", "tasks[2]"}, queryPaths(t, doc, "tasks[?done==false]", heads))
+
+	for _, q := range []string{"tasks[", "tasks[?]", "tasks[?done==maybe]", "tasks[?done==\"x]", "tasks..x", "[x]"} {
+		_, err := automerge.ParseQuery(q)
+		require.Error(t, err, q)
+	}
+}
@@ -0,0 +1,373 @@
+package repo
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// This file contains the subset of CBOR (RFC 8949) needed to speak the
+// automerge-repo protocol. Maps are encoded with their keys in the order
+// given, which matches the order used by the JavaScript implementation.
+
+// maxCBORLength bounds the length of any string, array or map that is
+// decoded, so that a corrupt length prefix cannot exhaust memory.
+const maxCBORLength = 256 << 20
+
+// cborMap is a map with string keys that preserves the order of its keys
+type cborMap []cborPair
+
+type cborPair struct {
+	key string
+	val any
+}
+
+const (
+	cborUint   = 0
+	cborNegInt = 1
+	cborBytes  = 2
+	cborString = 3
+	cborArray  = 4
+	cborMapTyp = 5
+	cborTag    = 6
+	cborSimple = 7
+)
+
+func cborEncode(v any) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	if err := cborWrite(buf, v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func cborWriteHead(buf *bytes.Buffer, major byte, n uint64) {
+	switch {
+	case n < 24:
+		buf.WriteByte(major<<5 | byte(n))
+	case n <= math.MaxUint8:
+		buf.WriteByte(major<<5 | 24)
+		buf.WriteByte(byte(n))
+	case n <= math.MaxUint16:
+		buf.WriteByte(major<<5 | 25)
+		buf.Write(binary.BigEndian.AppendUint16(nil, uint16(n)))
+	case n <= math.MaxUint32:
+		buf.WriteByte(major<<5 | 26)
+		buf.Write(binary.BigEndian.AppendUint32(nil, uint32(n)))
+	default:
+		buf.WriteByte(major<<5 | 27)
+		buf.Write(binary.BigEndian.AppendUint64(nil, n))
+	}
+}
+
+func cborWrite(buf *bytes.Buffer, v any) error {
+	switch v := v.(type) {
+	case nil:
+		buf.WriteByte(0xf6)
+	case bool:
+		if v {
+			buf.WriteByte(0xf5)
+		} else {
+			buf.WriteByte(0xf4)
+		}
+	case int:
+		return cborWrite(buf, int64(v))
+	case int64:
+		if v < 0 {
+			cborWriteHead(buf, cborNegInt, uint64(-(v + 1)))
+		} else {
+			cborWriteHead(buf, cborUint, uint64(v))
+		}
+	case uint64:
+		cborWriteHead(buf, cborUint, v)
+	case float64:
+		buf.WriteByte(0xfb)
+		buf.Write(binary.BigEndian.AppendUint64(nil, math.Float64bits(v)))
+	case string:
+		cborWriteHead(buf, cborString, uint64(len(v)))
+		buf.WriteString(v)
+	case []byte:
+		cborWriteHead(buf, cborBytes, uint64(len(v)))
+		buf.Write(v)
+	case []string:
+		cborWriteHead(buf, cborArray, uint64(len(v)))
+		for _, s := range v {
+			cborWriteHead(buf, cborString, uint64(len(s)))
+			buf.WriteString(s)
+		}
+	case []any:
+		cborWriteHead(buf, cborArray, uint64(len(v)))
+		for _, e := range v {
+			if err := cborWrite(buf, e); err != nil {
+				return err
+			}
+		}
+	case cborMap:
+		cborWriteHead(buf, cborMapTyp, uint64(len(v)))
+		for _, p := range v {
+			cborWriteHead(buf, cborString, uint64(len(p.key)))
+			buf.WriteString(p.key)
+			if err := cborWrite(buf, p.val); err != nil {
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("cbor: cannot encode %T", v)
+	}
+	return nil
+}
+
+// cborDecode decodes a single CBOR item that must occupy all of b
+func cborDecode(b []byte) (any, error) {
+	r := bytes.NewReader(b)
+	v, err := cborRead(r, 0)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	if r.Len() > 0 {
+		return nil, fmt.Errorf("cbor: %d unexpected trailing bytes", r.Len())
+	}
+	return v, nil
+}
+
+var errCBORBreak = errors.New("cbor: unexpected break")
+
+// cborRead reads one CBOR item from r. It returns io.EOF only if r
+// was empty. Maps are decoded as map[string]any; tags are ignored.
+func cborRead(r io.ByteReader, depth int) (any, error) {
+	if depth > 64 {
+		return nil, fmt.Errorf("cbor: nested too deeply")
+	}
+	ib, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	v, err := cborReadItem(r, ib, depth)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return v, err
+}
+
+func cborReadItem(r io.ByteReader, ib byte, depth int) (any, error) {
+	major, info := ib>>5, ib&0x1f
+
+	if major == cborSimple {
+		switch info {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22, 23:
+			return nil, nil
+		case 25:
+			n, err := cborReadUint(r, 2)
+			if err != nil {
+				return nil, err
+			}
+			return float16(uint16(n)), nil
+		case 26:
+			n, err := cborReadUint(r, 4)
+			if err != nil {
+				return nil, err
+			}
+			return float64(math.Float32frombits(uint32(n))), nil
+		case 27:
+			n, err := cborReadUint(r, 8)
+			if err != nil {
+				return nil, err
+			}
+			return math.Float64frombits(n), nil
+		case 31:
+			return nil, errCBORBreak
+		}
+		return nil, fmt.Errorf("cbor: unsupported simple value %d", info)
+	}
+
+	indefinite := info == 31
+	var n uint64
+	switch {
+	case info < 24:
+		n = uint64(info)
+	case info <= 27:
+		var err error
+		if n, err = cborReadUint(r, 1<<(info-24)); err != nil {
+			return nil, err
+		}
+	case indefinite && (major == cborBytes || major == cborString || major == cborArray || major == cborMapTyp):
+	default:
+		return nil, fmt.Errorf("cbor: invalid additional information %d", info)
+	}
+	if !indefinite && major >= cborBytes && major <= cborMapTyp && n > maxCBORLength {
+		return nil, fmt.Errorf("cbor: length %d too large", n)
+	}
+
+	switch major {
+	case cborUint:
+		return n, nil
+	case cborNegInt:
+		if n > math.MaxInt64 {
+			return nil, fmt.Errorf("cbor: integer overflow")
+		}
+		return -int64(n) - 1, nil
+
+	case cborBytes, cborString:
+		var b []byte
+		if indefinite {
+			for {
+				chunk, err := cborRead(r, depth+1)
+				if err == errCBORBreak {
+					break
+				}
+				if err != nil {
+					return nil, err
+				}
+				switch c := chunk.(type) {
+				case []byte:
+					b = append(b, c...)
+				case string:
+					b = append(b, c...)
+				}
+				if len(b) > maxCBORLength {
+					return nil, fmt.Errorf("cbor: length too large")
+				}
+			}
+		} else {
+			// don't trust n for the initial allocation
+			c := n
+			if c > 4096 {
+				c = 4096
+			}
+			b = make([]byte, 0, c)
+			for i := uint64(0); i < n; i++ {
+				c, err := r.ReadByte()
+				if err != nil {
+					return nil, err
+				}
+				b = append(b, c)
+			}
+		}
+		if major == cborString {
+			return string(b), nil
+		}
+		return b, nil
+
+	case cborArray:
+		ret := []any{}
+		for i := uint64(0); indefinite || i < n; i++ {
+			v, err := cborRead(r, depth+1)
+			if indefinite && err == errCBORBreak {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, v)
+		}
+		return ret, nil
+
+	case cborMapTyp:
+		ret := map[string]any{}
+		for i := uint64(0); indefinite || i < n; i++ {
+			k, err := cborRead(r, depth+1)
+			if indefinite && err == errCBORBreak {
+				break
+			}
+			if err != nil {
+				return nil, err
+			}
+			v, err := cborRead(r, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			ks, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("cbor: unsupported map key %T", k)
+			}
+			ret[ks] = v
+		}
+		return ret, nil
+
+	case cborTag:
+		// cbor-x wraps typed arrays in tags (64 for Uint8Array); the
+		// tagged value is the byte string itself.
+		return cborRead(r, depth+1)
+	}
+	return nil, fmt.Errorf("cbor: invalid major type %d", major)
+}
+
+func cborReadUint(r io.ByteReader, size int) (uint64, error) {
+	var n uint64
+	for i := 0; i < size; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		n = n<<8 | uint64(b)
+	}
+	return n, nil
+}
+
+func float16(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		return -f
+	}
+	return f
+}
+
+// cborReader reads a stream of concatenated CBOR items. CBOR items are
+// self-delimiting, so no additional framing is required.
+type cborReader struct {
+	r *bufio.Reader
+}
+
+func newCBORReader(r io.Reader) *cborReader {
+	return &cborReader{r: bufio.NewReader(r)}
+}
+
+// next returns the raw bytes of the next item
+func (cr *cborReader) next() ([]byte, error) {
+	rec := &recordingReader{r: cr.r}
+	if _, err := cborRead(rec, 0); err != nil {
+		if err == errCBORBreak {
+			err = fmt.Errorf("cbor: unexpected break")
+		}
+		return nil, err
+	}
+	return rec.buf, nil
+}
+
+type recordingReader struct {
+	r   io.ByteReader
+	buf []byte
+}
+
+func (rr *recordingReader) ReadByte() (byte, error) {
+	b, err := rr.r.ReadByte()
+	if err == nil {
+		rr.buf = append(rr.buf, b)
+	}
+	return b, err
+}
@@ -0,0 +1,265 @@
+package repo
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Transport sends and receives encoded messages between two peers.
+// Message oriented transports (like WebSockets) can implement it directly,
+// byte streams can be adapted with [NewStreamTransport].
+//
+// ReadMessage is only called from one goroutine at a time, but WriteMessage
+// may be called concurrently with ReadMessage.
+type Transport interface {
+	ReadMessage() ([]byte, error)
+	WriteMessage(b []byte) error
+	Close() error
+}
+
+// NewStreamTransport returns a [Transport] that sends messages over a byte stream
+// (for example a TCP connection). As CBOR is self-delimiting, each message is
+// written as-is with no further framing.
+func NewStreamTransport(rw io.ReadWriteCloser) Transport {
+	return &streamTransport{rw: rw, r: newCBORReader(rw)}
+}
+
+type streamTransport struct {
+	rw io.ReadWriteCloser
+	r  *cborReader
+	m  sync.Mutex
+}
+
+func (st *streamTransport) ReadMessage() ([]byte, error) {
+	return st.r.next()
+}
+
+func (st *streamTransport) WriteMessage(b []byte) error {
+	st.m.Lock()
+	defer st.m.Unlock()
+	_, err := st.rw.Write(b)
+	return err
+}
+
+func (st *streamTransport) Close() error {
+	return st.rw.Close()
+}
+
+// ConnOptions configure a [ConnAdapter]
+type ConnOptions struct {
+	// Server is true if this side of the connection waits for the other
+	// peer to send a join message (as automerge-repo's WebSocket server does).
+	// Otherwise this side sends the join message.
+	Server bool
+	// PeerMetadata is sent to the other peer during the handshake
+	PeerMetadata PeerMetadata
+}
+
+// ConnAdapter is a [NetworkAdapter] that speaks the automerge-repo protocol
+// to a single remote peer over a [Transport].
+type ConnAdapter struct {
+	t    Transport
+	opts ConnOptions
+
+	m       sync.Mutex
+	self    PeerID
+	remote  PeerID
+	meta    *PeerMetadata
+	started bool
+	closed  bool
+	err     error
+	done    chan struct{}
+}
+
+// NewConnAdapter returns an adapter that will perform the automerge-repo
+// handshake over t once it is connected to a [Repo].
+func NewConnAdapter(t Transport, opts ConnOptions) *ConnAdapter {
+	return &ConnAdapter{t: t, opts: opts, done: make(chan struct{})}
+}
+
+// Connect implements [NetworkAdapter]. The handshake happens in the background,
+// events.PeerCandidate is called once it completes.
+func (c *ConnAdapter) Connect(self PeerID, events NetworkEvents) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	if c.started {
+		return fmt.Errorf("repo.ConnAdapter: already connected")
+	}
+	if c.closed {
+		return ErrClosed
+	}
+	c.started = true
+	c.self = self
+	go c.run(events)
+	return nil
+}
+
+// Send implements [NetworkAdapter]
+func (c *ConnAdapter) Send(msg *Message) error {
+	c.m.Lock()
+	remote, closed := c.remote, c.closed
+	c.m.Unlock()
+
+	if closed {
+		return ErrClosed
+	}
+	if remote == "" {
+		return fmt.Errorf("repo.ConnAdapter: handshake not complete")
+	}
+	if msg.TargetID != remote {
+		return fmt.Errorf("repo.ConnAdapter: unknown peer %s", msg.TargetID)
+	}
+	return c.write(msg)
+}
+
+// Close implements [NetworkAdapter]
+func (c *ConnAdapter) Close() error {
+	c.m.Lock()
+	if c.closed {
+		c.m.Unlock()
+		return nil
+	}
+	c.closed = true
+	started := c.started
+	c.m.Unlock()
+
+	err := c.t.Close()
+	if !started {
+		close(c.done)
+	}
+	return err
+}
+
+// Done is closed once the connection has ended
+func (c *ConnAdapter) Done() <-chan struct{} {
+	return c.done
+}
+
+// Err returns the reason the connection ended. It returns nil if the
+// connection is still open, or if it was ended by calling Close.
+func (c *ConnAdapter) Err() error {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.err
+}
+
+// RemotePeer returns the ID and metadata of the other peer,
+// or "" if the handshake has not completed.
+func (c *ConnAdapter) RemotePeer() (PeerID, *PeerMetadata) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.remote, c.meta
+}
+
+func (c *ConnAdapter) write(msg *Message) error {
+	b, err := EncodeMessage(msg)
+	if err != nil {
+		return err
+	}
+	return c.t.WriteMessage(b)
+}
+
+func (c *ConnAdapter) read() (*Message, error) {
+	b, err := c.t.ReadMessage()
+	if err != nil {
+		return nil, err
+	}
+	msg, err := DecodeMessage(b)
+	if err != nil {
+		return nil, err
+	}
+	if msg.Type == MessageError {
+		return nil, fmt.Errorf("repo: remote error: %s", msg.ErrorMessage)
+	}
+	return msg, nil
+}
+
+func (c *ConnAdapter) fail(err error) {
+	c.m.Lock()
+	closed := c.closed
+	c.closed = true
+	if !closed && err != nil && !errors.Is(err, io.EOF) {
+		c.err = err
+	}
+	c.m.Unlock()
+	c.t.Close()
+}
+
+func (c *ConnAdapter) handshake() (*Message, error) {
+	meta := c.opts.PeerMetadata
+	if !c.opts.Server {
+		if err := c.write(&Message{Type: MessageJoin, SenderID: c.self, PeerMetadata: &meta}); err != nil {
+			return nil, err
+		}
+	}
+
+	msg, err := c.read()
+	if err != nil {
+		return nil, err
+	}
+
+	if !c.opts.Server {
+		if msg.Type != MessagePeer {
+			return nil, fmt.Errorf("repo: expected peer message, got %q", msg.Type)
+		}
+		if msg.SelectedProtocolVersion != ProtocolVersion {
+			return nil, fmt.Errorf("repo: unsupported protocol version %q", msg.SelectedProtocolVersion)
+		}
+		return msg, nil
+	}
+
+	if msg.Type != MessageJoin {
+		err = fmt.Errorf("repo: expected join message, got %q", msg.Type)
+	} else if !contains(msg.SupportedProtocolVersions, ProtocolVersion) {
+		err = fmt.Errorf("repo: unsupported protocol versions %q", msg.SupportedProtocolVersions)
+	}
+	if err != nil {
+		c.write(&Message{Type: MessageError, ErrorMessage: err.Error()})
+		return nil, err
+	}
+	err = c.write(&Message{Type: MessagePeer, SenderID: c.self, TargetID: msg.SenderID, PeerMetadata: &meta})
+	return msg, err
+}
+
+func (c *ConnAdapter) run(events NetworkEvents) {
+	defer close(c.done)
+
+	hello, err := c.handshake()
+	if err != nil {
+		c.fail(err)
+		return
+	}
+	c.m.Lock()
+	c.remote = hello.SenderID
+	c.meta = hello.PeerMetadata
+	c.m.Unlock()
+
+	events.PeerCandidate(hello.SenderID)
+	defer events.PeerDisconnected(hello.SenderID)
+
+	for {
+		msg, err := c.read()
+		if err != nil {
+			c.fail(err)
+			return
+		}
+		switch msg.Type {
+		case MessageJoin, MessagePeer:
+			// ignore repeated handshakes
+		default:
+			msg.SenderID = hello.SenderID
+			events.Receive(msg)
+		}
+	}
+}
+
+func contains(ss []string, s string) bool {
+	for _, o := range ss {
+		if o == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,119 @@
+package repo
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// URLPrefix is the scheme used by automerge URLs
+const URLPrefix = "automerge:"
+
+// DocumentID identifies a document. It is the base58check encoding of
+// 16 random bytes, compatible with the document IDs used by automerge-repo.
+type DocumentID string
+
+// PeerID identifies a peer on the network
+type PeerID string
+
+// NewDocumentID generates a new random document ID
+func NewDocumentID() DocumentID {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	// mark as a version 4 uuid, as automerge-repo does
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return DocumentID(base58CheckEncode(b))
+}
+
+// ParseDocumentID validates a document ID or an automerge URL
+// (e.g. "automerge:4NMNnkMhL8jXrdJ9jamS58PAVdXu") and returns the document ID.
+func ParseDocumentID(s string) (DocumentID, error) {
+	s = strings.TrimPrefix(s, URLPrefix)
+	if i := strings.IndexAny(s, "#?"); i >= 0 {
+		s = s[:i]
+	}
+	if _, err := base58CheckDecode(s); err != nil {
+		return "", fmt.Errorf("repo: invalid document id %q: %w", s, err)
+	}
+	return DocumentID(s), nil
+}
+
+// URL returns the automerge URL for the document
+func (id DocumentID) URL() string {
+	return URLPrefix + string(id)
+}
+
+// Bytes returns the binary form of the document ID, or nil if it is invalid
+func (id DocumentID) Bytes() []byte {
+	b, err := base58CheckDecode(string(id))
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+func base58CheckEncode(payload []byte) string {
+	sum := checksum(payload)
+	b := append(append([]byte{}, payload...), sum[:]...)
+
+	n := new(big.Int).SetBytes(b)
+	radix := big.NewInt(58)
+	mod := new(big.Int)
+	out := []byte{}
+	for n.Sign() > 0 {
+		n.DivMod(n, radix, mod)
+		out = append(out, base58Alphabet[mod.Int64()])
+	}
+	for _, c := range b {
+		if c != 0 {
+			break
+		}
+		out = append(out, base58Alphabet[0])
+	}
+	for i, j := 0, len(out)-1; i < j; i, j = i+1, j-1 {
+		out[i], out[j] = out[j], out[i]
+	}
+	return string(out)
+}
+
+func base58CheckDecode(s string) ([]byte, error) {
+	if s == "" {
+		return nil, fmt.Errorf("empty")
+	}
+	n := new(big.Int)
+	radix := big.NewInt(58)
+	for i := 0; i < len(s); i++ {
+		d := strings.IndexByte(base58Alphabet, s[i])
+		if d < 0 {
+			return nil, fmt.Errorf("invalid base58 character %q", s[i])
+		}
+		n.Mul(n, radix)
+		n.Add(n, big.NewInt(int64(d)))
+	}
+	b := n.Bytes()
+	for i := 0; i < len(s) && s[i] == base58Alphabet[0]; i++ {
+		b = append([]byte{0}, b...)
+	}
+	if len(b) < 4 {
+		return nil, fmt.Errorf("too short")
+	}
+	payload, sum := b[:len(b)-4], b[len(b)-4:]
+	want := checksum(payload)
+	if string(sum) != string(want[:]) {
+		return nil, fmt.Errorf("invalid checksum")
+	}
+	return payload, nil
+}
+
+func checksum(b []byte) [4]byte {
+	h := sha256.Sum256(b)
+	h = sha256.Sum256(h[:])
+	return [4]byte{h[0], h[1], h[2], h[3]}
+}
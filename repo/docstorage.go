@@ -0,0 +1,267 @@
+package repo
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/automerge/automerge-go"
+)
+
+// Storage persists documents as a snapshot followed by incremental chunks of
+// changes, without needing a [Repo].
+type Storage interface {
+	// LoadChunks returns the chunks stored for a document, snapshots first.
+	// Their data concatenated together can be passed to [automerge.Load].
+	LoadChunks(ctx context.Context, id DocumentID) ([]Chunk, error)
+	// AppendIncremental stores a chunk of changes, for example as returned
+	// by [automerge.Doc.SaveIncremental].
+	AppendIncremental(ctx context.Context, id DocumentID, data []byte) error
+	// Compact replaces the chunks stored for a document with one snapshot.
+	Compact(ctx context.Context, id DocumentID) error
+	// ListDocuments returns the IDs of all stored documents in sorted order.
+	ListDocuments(ctx context.Context) ([]DocumentID, error)
+}
+
+// DefaultMaxIncrementalChunks is the default for [ChunkStorageOptions].MaxIncrementalChunks
+const DefaultMaxIncrementalChunks = 64
+
+// ChunkStorageOptions configure a [ChunkStorage]
+type ChunkStorageOptions struct {
+	// MaxIncrementalChunks is the number of incremental chunks after which a
+	// document is compacted. Defaults to [DefaultMaxIncrementalChunks].
+	// Documents are also compacted when their incremental chunks are larger
+	// in total than their snapshot.
+	MaxIncrementalChunks int
+}
+
+// ChunkStorage implements [Storage] on top of a [StorageAdapter] using the same
+// keys as automerge-repo. Use it with [NewMemoryStorage] to keep documents in
+// memory, or with [NewFileStorage] to write them to disk.
+type ChunkStorage struct {
+	adapter StorageAdapter
+	opts    ChunkStorageOptions
+
+	m    sync.Mutex
+	docs map[DocumentID]*chunkStats
+}
+
+// chunkStats tracks the size of a stored document to decide when to compact it
+type chunkStats struct {
+	m sync.Mutex
+	chunkSizes
+}
+
+type chunkSizes struct {
+	loaded            bool
+	snapshotSize      int
+	incrementalSize   int
+	incrementalChunks int
+}
+
+// NewChunkStorage returns a [*ChunkStorage] that stores chunks in adapter
+func NewChunkStorage(adapter StorageAdapter, opts ...ChunkStorageOptions) *ChunkStorage {
+	cs := &ChunkStorage{adapter: adapter, docs: map[DocumentID]*chunkStats{}}
+	for _, o := range opts {
+		if o.MaxIncrementalChunks != 0 {
+			cs.opts.MaxIncrementalChunks = o.MaxIncrementalChunks
+		}
+	}
+	if cs.opts.MaxIncrementalChunks <= 0 {
+		cs.opts.MaxIncrementalChunks = DefaultMaxIncrementalChunks
+	}
+	return cs
+}
+
+// Adapter returns the underlying [StorageAdapter]
+func (cs *ChunkStorage) Adapter() StorageAdapter {
+	return cs.adapter
+}
+
+func (cs *ChunkStorage) stats(id DocumentID) *chunkStats {
+	cs.m.Lock()
+	defer cs.m.Unlock()
+	st := cs.docs[id]
+	if st == nil {
+		st = &chunkStats{}
+		cs.docs[id] = st
+	}
+	return st
+}
+
+func isSnapshot(k StorageKey) bool {
+	return len(k) > 1 && k[1] == "snapshot"
+}
+
+// sortChunks orders snapshots before incremental changes
+func sortChunks(chunks []Chunk) {
+	sort.SliceStable(chunks, func(i, j int) bool {
+		return isSnapshot(chunks[i].Key) && !isSnapshot(chunks[j].Key)
+	})
+}
+
+func (st *chunkStats) reset(chunks []Chunk) {
+	st.chunkSizes = chunkSizes{loaded: true}
+	for _, c := range chunks {
+		if isSnapshot(c.Key) {
+			st.snapshotSize += len(c.Data)
+		} else {
+			st.incrementalSize += len(c.Data)
+			st.incrementalChunks++
+		}
+	}
+}
+
+// LoadChunks implements [Storage]
+func (cs *ChunkStorage) LoadChunks(ctx context.Context, id DocumentID) ([]Chunk, error) {
+	chunks, err := cs.adapter.LoadRange(ctx, StorageKey{string(id)})
+	if err != nil {
+		return nil, err
+	}
+	sortChunks(chunks)
+	return chunks, nil
+}
+
+// AppendIncremental implements [Storage]. The document is compacted if it
+// has grown past the thresholds in [ChunkStorageOptions].
+func (cs *ChunkStorage) AppendIncremental(ctx context.Context, id DocumentID, data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+	st := cs.stats(id)
+	st.m.Lock()
+	defer st.m.Unlock()
+
+	if !st.loaded {
+		chunks, err := cs.LoadChunks(ctx, id)
+		if err != nil {
+			return err
+		}
+		st.reset(chunks)
+	}
+
+	sum := sha256.Sum256(data)
+	key := StorageKey{string(id), "incremental", hex.EncodeToString(sum[:])}
+	if err := cs.adapter.Save(ctx, key, data); err != nil {
+		return err
+	}
+	st.incrementalSize += len(data)
+	st.incrementalChunks++
+
+	if st.incrementalChunks > cs.opts.MaxIncrementalChunks || st.incrementalSize > st.snapshotSize {
+		return cs.compact(ctx, id, st)
+	}
+	return nil
+}
+
+// Compact implements [Storage]. The chunks are loaded into a document, which
+// is saved as a new snapshot before the chunks it replaces are removed.
+func (cs *ChunkStorage) Compact(ctx context.Context, id DocumentID) error {
+	st := cs.stats(id)
+	st.m.Lock()
+	defer st.m.Unlock()
+	return cs.compact(ctx, id, st)
+}
+
+// compact must be called with st.m held
+func (cs *ChunkStorage) compact(ctx context.Context, id DocumentID, st *chunkStats) error {
+	chunks, err := cs.LoadChunks(ctx, id)
+	if err != nil {
+		return err
+	}
+	if len(chunks) == 0 || (len(chunks) == 1 && isSnapshot(chunks[0].Key)) {
+		st.reset(chunks)
+		return nil
+	}
+
+	doc, err := loadChunks(chunks)
+	if err != nil {
+		return fmt.Errorf("repo: could not compact %s: %w", id, err)
+	}
+	data, err := doc.TrySave()
+	if err != nil {
+		return err
+	}
+	heads, err := doc.TryHeads()
+	if err != nil {
+		return err
+	}
+	key := StorageKey{string(id), "snapshot", headsHash(heads)}
+	if err := cs.adapter.Save(ctx, key, data); err != nil {
+		return err
+	}
+	for _, c := range chunks {
+		if c.Key.index() == key.index() {
+			continue
+		}
+		if err := cs.adapter.Remove(ctx, c.Key); err != nil {
+			return err
+		}
+	}
+	st.reset([]Chunk{{Key: key, Data: data}})
+	return nil
+}
+
+// keyLister is implemented by adapters that can list keys without loading data
+type keyLister interface {
+	Keys(ctx context.Context, prefix StorageKey) ([]StorageKey, error)
+}
+
+// ListDocuments implements [Storage]
+func (cs *ChunkStorage) ListDocuments(ctx context.Context) ([]DocumentID, error) {
+	var keys []StorageKey
+	if kl, ok := cs.adapter.(keyLister); ok {
+		var err error
+		if keys, err = kl.Keys(ctx, StorageKey{}); err != nil {
+			return nil, err
+		}
+	} else {
+		chunks, err := cs.adapter.LoadRange(ctx, StorageKey{})
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range chunks {
+			keys = append(keys, c.Key)
+		}
+	}
+
+	seen := map[string]bool{}
+	ret := []DocumentID{}
+	for _, k := range keys {
+		// other data (such as httpsync sessions) may share the adapter
+		if len(k) != 3 || (k[1] != "snapshot" && k[1] != "incremental") {
+			continue
+		}
+		if !seen[k[0]] {
+			seen[k[0]] = true
+			ret = append(ret, DocumentID(k[0]))
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i] < ret[j] })
+	return ret, nil
+}
+
+func loadChunks(chunks []Chunk) (*automerge.Doc, error) {
+	var buf bytes.Buffer
+	for _, c := range chunks {
+		buf.Write(c.Data)
+	}
+	return automerge.Load(buf.Bytes())
+}
+
+// LoadDocument loads a document from s. It returns [ErrUnavailable]
+// if the document is not stored.
+func LoadDocument(ctx context.Context, s Storage, id DocumentID) (*automerge.Doc, error) {
+	chunks, err := s.LoadChunks(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if len(chunks) == 0 {
+		return nil, ErrUnavailable
+	}
+	return loadChunks(chunks)
+}
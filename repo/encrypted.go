@@ -0,0 +1,262 @@
+package repo
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"sync"
+)
+
+// KeyProvider supplies the keys used by [EncryptedStorage].
+// Keys must be 16, 24 or 32 bytes long (for AES-128, AES-192 or AES-256).
+type KeyProvider interface {
+	// CurrentKey returns the key that new data should be encrypted with,
+	// and an ID (at most 255 bytes) that is stored alongside the data.
+	CurrentKey(ctx context.Context) (id string, key []byte, err error)
+	// Key returns the key with the given ID, so that data encrypted with
+	// previous keys can still be read.
+	Key(ctx context.Context, id string) ([]byte, error)
+}
+
+// StaticKeys is a [KeyProvider] that holds keys in memory.
+// To rotate keys, [StaticKeys.Add] a new key as the current key.
+type StaticKeys struct {
+	m       sync.Mutex
+	current string
+	keys    map[string][]byte
+}
+
+// NewStaticKeys returns a [*StaticKeys] which encrypts with key,
+// identified by id.
+func NewStaticKeys(id string, key []byte) *StaticKeys {
+	return &StaticKeys{current: id, keys: map[string][]byte{id: key}}
+}
+
+// Add adds a key, and if current is true uses it for new data
+func (sk *StaticKeys) Add(id string, key []byte, current bool) {
+	sk.m.Lock()
+	defer sk.m.Unlock()
+	sk.keys[id] = key
+	if current {
+		sk.current = id
+	}
+}
+
+// Remove forgets a key. Data encrypted with it can no longer be read.
+func (sk *StaticKeys) Remove(id string) {
+	sk.m.Lock()
+	defer sk.m.Unlock()
+	delete(sk.keys, id)
+}
+
+// CurrentKey implements [KeyProvider]
+func (sk *StaticKeys) CurrentKey(ctx context.Context) (string, []byte, error) {
+	sk.m.Lock()
+	defer sk.m.Unlock()
+	key, ok := sk.keys[sk.current]
+	if !ok {
+		return "", nil, fmt.Errorf("repo: unknown key %q", sk.current)
+	}
+	return sk.current, key, nil
+}
+
+// Key implements [KeyProvider]
+func (sk *StaticKeys) Key(ctx context.Context, id string) ([]byte, error) {
+	sk.m.Lock()
+	defer sk.m.Unlock()
+	key, ok := sk.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("repo: unknown key %q", id)
+	}
+	return key, nil
+}
+
+// EncryptedStorage is a [StorageAdapter] that encrypts chunks with AES-GCM
+// before passing them to another adapter.
+//
+// Each chunk is stored as a version byte, the length-prefixed ID of the key
+// it was encrypted with, a random nonce and the sealed data. The storage key
+// is used as additional authenticated data, so a chunk that has been moved to
+// another key (or another document) fails to decrypt.
+//
+// Only the contents of chunks are protected. Storage keys are passed to the
+// inner adapter unchanged, so whoever can read the storage can see the
+// document IDs, and the hashes that name each chunk (which can be compared
+// with changes seen elsewhere), as well as the size of each chunk and the ID
+// of the key it was encrypted with.
+//
+// Each chunk is authenticated on its own, so storage that returns an older
+// set of chunks (dropping recent chunks, or restoring removed ones) is not
+// detected: the document just loads as it was at that point. Applications
+// that need to detect this should keep the heads of each document somewhere
+// they trust, and check them after loading.
+//
+// Keys are rotated by changing the current key of the [KeyProvider]. New
+// chunks, including the snapshots written when a document is compacted, are
+// encrypted with the current key; [EncryptedStorage.Reencrypt] re-encrypts
+// any remaining chunks so that old keys can be retired.
+type EncryptedStorage struct {
+	inner StorageAdapter
+	keys  KeyProvider
+}
+
+const encryptedVersion = 1
+
+// NewEncryptedStorage returns a [*EncryptedStorage] that stores encrypted
+// chunks in inner.
+func NewEncryptedStorage(inner StorageAdapter, keys KeyProvider) *EncryptedStorage {
+	return &EncryptedStorage{inner: inner, keys: keys}
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (es *EncryptedStorage) encrypt(ctx context.Context, key StorageKey, data []byte) ([]byte, error) {
+	id, k, err := es.keys.CurrentKey(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(id) > 255 {
+		return nil, fmt.Errorf("repo: key ID %q is too long", id)
+	}
+	gcm, err := newGCM(k)
+	if err != nil {
+		return nil, fmt.Errorf("repo: invalid key %q: %w", id, err)
+	}
+
+	out := make([]byte, 0, 2+len(id)+gcm.NonceSize()+len(data)+gcm.Overhead())
+	out = append(out, encryptedVersion, byte(len(id)))
+	out = append(out, id...)
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out = append(out, nonce...)
+	return gcm.Seal(out, nonce, data, []byte(key.index())), nil
+}
+
+// encryptedHeader returns the ID of the key that data was encrypted with
+// and the offset of the nonce.
+func encryptedHeader(data []byte) (string, int, error) {
+	if len(data) < 2 || data[0] != encryptedVersion || len(data) < 2+int(data[1]) {
+		return "", 0, fmt.Errorf("not an encrypted chunk")
+	}
+	return string(data[2 : 2+data[1]]), 2 + int(data[1]), nil
+}
+
+func (es *EncryptedStorage) decrypt(ctx context.Context, key StorageKey, data []byte) ([]byte, error) {
+	id, offset, err := encryptedHeader(data)
+	if err == nil {
+		var k []byte
+		if k, err = es.keys.Key(ctx, id); err == nil {
+			var gcm cipher.AEAD
+			if gcm, err = newGCM(k); err == nil {
+				data = data[offset:]
+				if len(data) < gcm.NonceSize() {
+					err = fmt.Errorf("chunk too short")
+				} else {
+					n := gcm.NonceSize()
+					data, err = gcm.Open(nil, data[:n], data[n:], []byte(key.index()))
+				}
+			}
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("repo: could not decrypt %s: %w", key, err)
+	}
+	return data, nil
+}
+
+// Load implements [StorageAdapter]
+func (es *EncryptedStorage) Load(ctx context.Context, key StorageKey) ([]byte, error) {
+	data, err := es.inner.Load(ctx, key)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	return es.decrypt(ctx, key, data)
+}
+
+// Save implements [StorageAdapter]
+func (es *EncryptedStorage) Save(ctx context.Context, key StorageKey, data []byte) error {
+	enc, err := es.encrypt(ctx, key, data)
+	if err != nil {
+		return err
+	}
+	return es.inner.Save(ctx, key, enc)
+}
+
+// Remove implements [StorageAdapter]
+func (es *EncryptedStorage) Remove(ctx context.Context, key StorageKey) error {
+	return es.inner.Remove(ctx, key)
+}
+
+// LoadRange implements [StorageAdapter]
+func (es *EncryptedStorage) LoadRange(ctx context.Context, prefix StorageKey) ([]Chunk, error) {
+	chunks, err := es.inner.LoadRange(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	for i, c := range chunks {
+		if chunks[i].Data, err = es.decrypt(ctx, c.Key, c.Data); err != nil {
+			return nil, err
+		}
+	}
+	return chunks, nil
+}
+
+// RemoveRange implements [StorageAdapter]
+func (es *EncryptedStorage) RemoveRange(ctx context.Context, prefix StorageKey) error {
+	return es.inner.RemoveRange(ctx, prefix)
+}
+
+// Keys returns the keys of all chunks whose key starts with prefix, if the
+// underlying adapter supports listing keys.
+func (es *EncryptedStorage) Keys(ctx context.Context, prefix StorageKey) ([]StorageKey, error) {
+	if kl, ok := es.inner.(keyLister); ok {
+		return kl.Keys(ctx, prefix)
+	}
+	chunks, err := es.inner.LoadRange(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	ret := []StorageKey{}
+	for _, c := range chunks {
+		ret = append(ret, c.Key)
+	}
+	return ret, nil
+}
+
+// Reencrypt re-encrypts every chunk under prefix that was not encrypted with
+// the current key, and returns the number of chunks that were rewritten.
+func (es *EncryptedStorage) Reencrypt(ctx context.Context, prefix StorageKey) (int, error) {
+	current, _, err := es.keys.CurrentKey(ctx)
+	if err != nil {
+		return 0, err
+	}
+	chunks, err := es.inner.LoadRange(ctx, prefix)
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	for _, c := range chunks {
+		if id, _, err := encryptedHeader(c.Data); err == nil && id == current {
+			continue
+		}
+		data, err := es.decrypt(ctx, c.Key, c.Data)
+		if err != nil {
+			return n, err
+		}
+		if err := es.Save(ctx, c.Key, data); err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
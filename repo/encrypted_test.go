@@ -0,0 +1,72 @@
+package repo_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/automerge/automerge-go"
+	"github.com/automerge/automerge-go/repo"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncryptedStorage(t *testing.T) {
+	ctx := context.Background()
+	inner := repo.NewMemoryStorage()
+	keys := repo.NewStaticKeys("k1", bytes.Repeat([]byte{1}, 32))
+	es := repo.NewEncryptedStorage(inner, keys)
+	s := repo.NewChunkStorage(es, repo.ChunkStorageOptions{MaxIncrementalChunks: 100})
+
+	id := repo.NewDocumentID()
+	doc := automerge.New()
+	require.NoError(t, doc.Path("secret").Set("customer data"))
+	_, err := doc.Commit("secret")
+	require.NoError(t, err)
+	require.NoError(t, s.AppendIncremental(ctx, id, doc.SaveIncremental()))
+	require.NoError(t, doc.Path("n").Set(1))
+	_, err = doc.Commit("n")
+	require.NoError(t, err)
+	require.NoError(t, s.AppendIncremental(ctx, id, doc.SaveIncremental()))
+
+	raw, err := inner.LoadRange(ctx, repo.StorageKey{string(id)})
+	require.NoError(t, err)
+	require.Len(t, raw, 2)
+	for _, c := range raw {
+		require.NotContains(t, string(c.Data), "customer data")
+	}
+
+	loaded, err := repo.LoadDocument(ctx, s, id)
+	require.NoError(t, err)
+	require.Equal(t, doc.Heads(), loaded.Heads())
+	ids, err := s.ListDocuments(ctx)
+	require.NoError(t, err)
+	require.Equal(t, []repo.DocumentID{id}, ids)
+
+	// chunks cannot be moved to another key
+	require.NoError(t, inner.Save(ctx, raw[0].Key, raw[1].Data))
+	_, err = repo.LoadDocument(ctx, s, id)
+	require.ErrorContains(t, err, "could not decrypt")
+	require.NoError(t, inner.Save(ctx, raw[0].Key, raw[0].Data))
+
+	// rotating the key re-encrypts snapshots on compaction
+	keys.Add("k2", bytes.Repeat([]byte{2}, 32), true)
+	require.NoError(t, s.Compact(ctx, id))
+	keys.Remove("k1")
+	loaded, err = repo.LoadDocument(ctx, s, id)
+	require.NoError(t, err)
+	require.Equal(t, doc.Heads(), loaded.Heads())
+
+	// remaining chunks can be re-encrypted explicitly
+	other := repo.StorageKey{"other", "incremental", "x"}
+	require.NoError(t, es.Save(ctx, other, []byte("hello")))
+	keys.Add("k3", bytes.Repeat([]byte{3}, 16), true)
+	n, err := es.Reencrypt(ctx, repo.StorageKey{})
+	require.NoError(t, err)
+	require.Equal(t, 2, n)
+	keys.Remove("k2")
+	b, err := es.Load(ctx, other)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(b))
+	_, err = repo.LoadDocument(ctx, s, id)
+	require.NoError(t, err)
+}
@@ -0,0 +1,242 @@
+package repo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// FileStorage is a [StorageAdapter] that stores each chunk in a file.
+//
+// Files are laid out in the same way as automerge-repo's NodeFSStorageAdapter,
+// so a directory can be shared with JavaScript peers: the key
+// ["3nFb…", "snapshot", "ab12…"] is stored at dir/3n/Fb…/snapshot/ab12….
+//
+// Key segments are escaped with [url.PathEscape] (and a leading "." as "%2E"),
+// so any key other than one with an empty segment can be stored. Document IDs
+// and the other keys used by automerge-repo contain no characters that need
+// escaping. A first segment of one or two characters (which automerge-repo
+// does not use) has its first character escaped too, so that it can still be
+// split.
+//
+// Each chunk is written to a temporary file which is synced to disk and then
+// renamed into place, so readers never see a partially written chunk.
+type FileStorage struct {
+	dir string
+}
+
+const fileStorageTemp = ".tmp-"
+
+// NewFileStorage returns a [*FileStorage] that stores data in dir,
+// creating it if it does not exist.
+func NewFileStorage(dir string) (*FileStorage, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("repo: %w", err)
+	}
+	return &FileStorage{dir: dir}, nil
+}
+
+// Dir returns the directory that data is stored in
+func (fs *FileStorage) Dir() string {
+	return fs.dir
+}
+
+// path returns the file path for a key (or key prefix)
+func (fs *FileStorage) path(key StorageKey) (string, error) {
+	parts := []string{fs.dir}
+	for i, k := range key {
+		if k == "" {
+			return "", fmt.Errorf("repo: invalid storage key %q", key.String())
+		}
+		k = escapeSegment(k)
+		if i == 0 {
+			// the first segment is always split, so key can tell where it
+			// ends; short segments are padded by escaping their first byte,
+			// which url.PathEscape leaves unescaped
+			if len(k) <= 2 {
+				k = fmt.Sprintf("%%%02X", k[0]) + k[1:]
+			}
+			parts = append(parts, k[:2], k[2:])
+		} else {
+			parts = append(parts, k)
+		}
+	}
+	return filepath.Join(parts...), nil
+}
+
+// key is the inverse of path, and returns nil for files that are not chunks
+func (fs *FileStorage) key(path string) StorageKey {
+	rel, err := filepath.Rel(fs.dir, path)
+	if err != nil {
+		return nil
+	}
+	parts := strings.Split(filepath.ToSlash(rel), "/")
+	if len(parts) < 2 || strings.HasPrefix(parts[len(parts)-1], fileStorageTemp) {
+		return nil
+	}
+	parts = append([]string{parts[0] + parts[1]}, parts[2:]...)
+	key := make(StorageKey, 0, len(parts))
+	for _, p := range parts {
+		k, err := url.PathUnescape(p)
+		if err != nil {
+			return nil
+		}
+		key = append(key, k)
+	}
+	return key
+}
+
+// escapeSegment makes a key segment safe to use as a file name. Segments
+// never start with ".", so they cannot be "." or ".." or clash with
+// temporary files.
+func escapeSegment(k string) string {
+	k = url.PathEscape(k)
+	if strings.HasPrefix(k, ".") {
+		k = "%2E" + k[1:]
+	}
+	return k
+}
+
+// Load implements [StorageAdapter]
+func (fs *FileStorage) Load(ctx context.Context, key StorageKey) ([]byte, error) {
+	p, err := fs.path(key)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(p)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return b, err
+}
+
+// Save implements [StorageAdapter]
+func (fs *FileStorage) Save(ctx context.Context, key StorageKey, data []byte) error {
+	p, err := fs.path(key)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Dir(p)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, fileStorageTemp)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if e := f.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), p)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return syncDir(dir)
+}
+
+// syncDir makes a rename durable. Not all platforms support syncing a
+// directory, so errors are ignored.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return nil
+	}
+	d.Sync()
+	return d.Close()
+}
+
+// Remove implements [StorageAdapter]
+func (fs *FileStorage) Remove(ctx context.Context, key StorageKey) error {
+	p, err := fs.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// Keys returns the keys of all chunks whose key starts with prefix, in sorted order
+func (fs *FileStorage) Keys(ctx context.Context, prefix StorageKey) ([]StorageKey, error) {
+	root, err := fs.path(prefix)
+	if err != nil {
+		return nil, err
+	}
+	ret := []StorageKey{}
+	err = filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			if k := fs.key(path); k != nil && k.hasPrefix(prefix) {
+				ret = append(ret, k)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].index() < ret[j].index() })
+	return ret, nil
+}
+
+// LoadRange implements [StorageAdapter]
+func (fs *FileStorage) LoadRange(ctx context.Context, prefix StorageKey) ([]Chunk, error) {
+	keys, err := fs.Keys(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	ret := []Chunk{}
+	for _, k := range keys {
+		data, err := fs.Load(ctx, k)
+		if err != nil {
+			return nil, err
+		}
+		// the chunk may have been removed since it was listed
+		if data != nil {
+			ret = append(ret, Chunk{Key: k, Data: data})
+		}
+	}
+	return ret, nil
+}
+
+// RemoveRange implements [StorageAdapter]
+func (fs *FileStorage) RemoveRange(ctx context.Context, prefix StorageKey) error {
+	if len(prefix) == 0 {
+		entries, err := os.ReadDir(fs.dir)
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if err := os.RemoveAll(filepath.Join(fs.dir, e.Name())); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	p, err := fs.path(prefix)
+	if err != nil {
+		return err
+	}
+	return os.RemoveAll(p)
+}
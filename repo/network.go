@@ -0,0 +1,167 @@
+package repo
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Message types used by the repo, they match those used by automerge-repo.
+const (
+	// MessageSync carries an [automerge.SyncMessage] for a document
+	MessageSync = "sync"
+	// MessageRequest is like MessageSync, but is sent when the sender
+	// does not have a copy of the document.
+	MessageRequest = "request"
+	// MessageDocUnavailable is the reply to a MessageRequest if the
+	// recipient does not have a copy of the document either.
+	MessageDocUnavailable = "doc-unavailable"
+	// MessageEphemeral carries data that is not stored in the document.
+	MessageEphemeral = "ephemeral"
+
+	// MessageJoin is sent by a client to start the handshake
+	MessageJoin = "join"
+	// MessagePeer is the server's reply to MessageJoin
+	MessagePeer = "peer"
+	// MessageError is sent before closing a connection because of a protocol error
+	MessageError = "error"
+)
+
+// Message is sent between repos over a [NetworkAdapter].
+// For sync and request messages Data contains the bytes of an [automerge.SyncMessage].
+type Message struct {
+	Type       string
+	SenderID   PeerID
+	TargetID   PeerID
+	DocumentID DocumentID
+	Data       []byte
+
+	// SessionID and Count identify ephemeral messages, so that
+	// peers can drop duplicates.
+	SessionID string
+	Count     int64
+
+	// PeerMetadata, SupportedProtocolVersions and SelectedProtocolVersion
+	// are exchanged in the join/peer handshake.
+	PeerMetadata              *PeerMetadata
+	SupportedProtocolVersions []string
+	SelectedProtocolVersion   string
+
+	// ErrorMessage is the text of an error message
+	ErrorMessage string
+}
+
+// PeerMetadata describes a peer during the handshake
+type PeerMetadata struct {
+	// StorageID identifies the peer's storage, if it has any
+	StorageID string
+	// IsEphemeral is true if the peer does not persist documents
+	IsEphemeral bool
+}
+
+// NetworkEvents is implemented by the repo to receive
+// events from a [NetworkAdapter].
+type NetworkEvents interface {
+	// PeerCandidate is called when a new peer is reachable
+	PeerCandidate(peer PeerID)
+	// PeerDisconnected is called when a peer is no longer reachable
+	PeerDisconnected(peer PeerID)
+	// Receive is called with each message sent to this peer
+	Receive(msg *Message)
+}
+
+// NetworkAdapter connects a repo to other peers. It is modelled on the
+// NetworkAdapter interface in automerge-repo.
+//
+// Implementations must deliver events for each adapter in order, and must not
+// block in Send waiting for the remote peer to process the message.
+type NetworkAdapter interface {
+	// Connect starts delivering events for the peer self.
+	Connect(self PeerID, events NetworkEvents) error
+	// Send delivers a message to msg.TargetID
+	Send(msg *Message) error
+	// Close disconnects from the network
+	Close() error
+}
+
+// MemoryNetwork connects repos within the same process.
+// It is primarily useful for testing.
+type MemoryNetwork struct {
+	m     sync.Mutex
+	peers map[PeerID]*memoryAdapter
+}
+
+// NewMemoryNetwork returns a network with no peers
+func NewMemoryNetwork() *MemoryNetwork {
+	return &MemoryNetwork{peers: map[PeerID]*memoryAdapter{}}
+}
+
+// Adapter returns a new [NetworkAdapter] that connects to every
+// other adapter on the network.
+func (n *MemoryNetwork) Adapter() NetworkAdapter {
+	return &memoryAdapter{network: n}
+}
+
+type memoryAdapter struct {
+	network *MemoryNetwork
+	self    PeerID
+	events  NetworkEvents
+	q       *queue
+}
+
+func (a *memoryAdapter) Connect(self PeerID, events NetworkEvents) error {
+	n := a.network
+	n.m.Lock()
+	defer n.m.Unlock()
+
+	if _, ok := n.peers[self]; ok {
+		return fmt.Errorf("repo.MemoryNetwork: peer %s is already connected", self)
+	}
+	a.self = self
+	a.events = events
+	a.q = newQueue()
+
+	for id, other := range n.peers {
+		id, other := id, other
+		other.q.push(func() { other.events.PeerCandidate(self) })
+		a.q.push(func() { events.PeerCandidate(id) })
+	}
+	n.peers[self] = a
+	return nil
+}
+
+func (a *memoryAdapter) Send(msg *Message) error {
+	n := a.network
+	n.m.Lock()
+	defer n.m.Unlock()
+
+	if n.peers[a.self] != a {
+		return fmt.Errorf("repo.MemoryNetwork: not connected")
+	}
+	target, ok := n.peers[msg.TargetID]
+	if !ok {
+		return fmt.Errorf("repo.MemoryNetwork: unknown peer %s", msg.TargetID)
+	}
+	cp := *msg
+	cp.SenderID = a.self
+	cp.Data = append([]byte{}, msg.Data...)
+	target.q.push(func() { target.events.Receive(&cp) })
+	return nil
+}
+
+func (a *memoryAdapter) Close() error {
+	n := a.network
+	n.m.Lock()
+	if n.peers[a.self] != a {
+		n.m.Unlock()
+		return nil
+	}
+	delete(n.peers, a.self)
+	for _, other := range n.peers {
+		other := other
+		other.q.push(func() { other.events.PeerDisconnected(a.self) })
+	}
+	n.m.Unlock()
+
+	a.q.close()
+	return nil
+}
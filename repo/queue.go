@@ -0,0 +1,84 @@
+package repo
+
+import "sync"
+
+// queue is an unbounded FIFO of functions that are run one at a time on
+// a dedicated goroutine. Push never blocks, so it is safe to call from
+// within a function that is itself running on the queue.
+type queue struct {
+	m      sync.Mutex
+	items  []func()
+	wake   chan struct{}
+	done   chan struct{}
+	closed bool
+}
+
+func newQueue() *queue {
+	q := &queue{wake: make(chan struct{}, 1), done: make(chan struct{})}
+	go q.run()
+	return q
+}
+
+func (q *queue) push(f func()) bool {
+	q.m.Lock()
+	defer q.m.Unlock()
+	if q.closed {
+		return false
+	}
+	q.items = append(q.items, f)
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// call runs f on the queue and waits for it to complete.
+// It must not be called from a function running on the queue.
+func (q *queue) call(f func()) bool {
+	done := make(chan struct{})
+	if !q.push(func() { defer close(done); f() }) {
+		return false
+	}
+	<-done
+	return true
+}
+
+// close stops accepting new items, and waits for queued items to run.
+func (q *queue) close() {
+	q.m.Lock()
+	if q.closed {
+		q.m.Unlock()
+		<-q.done
+		return
+	}
+	q.closed = true
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+	q.m.Unlock()
+	<-q.done
+}
+
+func (q *queue) run() {
+	defer close(q.done)
+	for {
+		q.m.Lock()
+		items := q.items
+		q.items = nil
+		closed := q.closed
+		q.m.Unlock()
+
+		for _, f := range items {
+			f()
+		}
+		if len(items) > 0 {
+			continue
+		}
+		if closed {
+			return
+		}
+		<-q.wake
+	}
+}
@@ -0,0 +1,609 @@
+// Package repo manages many automerge documents, identified by [DocumentID], keeping
+// them persisted and in sync with other peers. It is modelled on [automerge-repo].
+//
+// A [Repo] is configured with an optional [StorageAdapter] to persist documents, and any
+// number of [NetworkAdapter]s to connect to other peers:
+//
+//	r, err := repo.New(repo.Options{
+//		Storage: storage,
+//		Network: []repo.NetworkAdapter{adapter},
+//	})
+//
+//	h, err := r.Create()
+//	h.Doc().Path("title").Set("hello")
+//	h.Doc().Commit("set title")
+//
+//	// ... on another peer
+//	h, err := r.Find(ctx, id)
+//
+// Whenever a document is committed (or receives changes from a peer) the repo saves it
+// to storage, and sends sync messages to every connected peer that the document is
+// shared with.
+//
+// Documents can also be persisted without a repo using a [Storage], such as
+// [ChunkStorage] over a [MemoryStorage] or [FileStorage].
+//
+// Messages are encoded with [EncodeMessage] in the same CBOR format as automerge-repo,
+// so a [ConnAdapter] can connect a repo to JavaScript peers over any [Transport].
+//
+// [automerge-repo]: https://github.com/automerge/automerge-repo
+package repo
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/automerge/automerge-go"
+)
+
+// ErrUnavailable is returned by [Repo.Find] if the document is not in storage
+// and no connected peer has a copy.
+var ErrUnavailable = errors.New("repo: document unavailable")
+
+// ErrClosed is returned when using a [Repo] after calling [Repo.Close]
+var ErrClosed = errors.New("repo: closed")
+
+// Options configure a [Repo]
+type Options struct {
+	// PeerID identifies this repo on the network. If empty a random ID is used.
+	PeerID PeerID
+	// Storage persists documents. If nil, documents are only kept in memory.
+	Storage StorageAdapter
+	// Network connects the repo to other peers.
+	Network []NetworkAdapter
+	// SharePolicy decides whether a document should be announced to a peer
+	// before the peer asks for it. If nil, all documents are shared with all peers.
+	// Peers that request a document by ID are always sent it.
+	SharePolicy func(peer PeerID, id DocumentID) bool
+	// OnError is called with errors that occur in the background, for example
+	// when saving to storage or sending a message fails. If nil, errors are ignored.
+	OnError func(err error)
+}
+
+// Repo manages a collection of documents.
+type Repo struct {
+	opts Options
+	q    *queue
+
+	m       sync.Mutex
+	network []NetworkAdapter
+	closed  bool
+
+	// the following fields may only be accessed on q
+	handles map[DocumentID]*Handle
+	peers   map[PeerID]NetworkAdapter
+}
+
+// New creates a repo and connects it to the network
+func New(opts Options) (*Repo, error) {
+	if opts.PeerID == "" {
+		b := make([]byte, 8)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		opts.PeerID = PeerID("peer-" + hex.EncodeToString(b))
+	}
+
+	r := &Repo{
+		opts:    opts,
+		q:       newQueue(),
+		handles: map[DocumentID]*Handle{},
+		peers:   map[PeerID]NetworkAdapter{},
+	}
+	for _, n := range opts.Network {
+		if err := r.Connect(n); err != nil {
+			r.Close()
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// doneNotifier is implemented by adapters that can end on their own,
+// such as [ConnAdapter] when its connection is closed.
+type doneNotifier interface {
+	Done() <-chan struct{}
+}
+
+// Connect adds a network adapter to a running repo, for example
+// a [ConnAdapter] for a newly accepted connection. The adapter
+// is closed when the repo is closed. If the adapter has a
+// Done() <-chan struct{} method, it is removed once that is closed.
+func (r *Repo) Connect(n NetworkAdapter) error {
+	r.m.Lock()
+	defer r.m.Unlock()
+	if r.closed {
+		return ErrClosed
+	}
+	if err := n.Connect(r.opts.PeerID, &repoEvents{r: r, adapter: n}); err != nil {
+		return err
+	}
+	r.network = append(r.network, n)
+	if dn, ok := n.(doneNotifier); ok {
+		go func() {
+			<-dn.Done()
+			r.remove(n)
+		}()
+	}
+	return nil
+}
+
+// Disconnect closes a network adapter that was added with [Repo.Connect]
+// or [Options].Network, and removes it from the repo.
+func (r *Repo) Disconnect(n NetworkAdapter) error {
+	r.remove(n)
+	return n.Close()
+}
+
+func (r *Repo) remove(n NetworkAdapter) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	for i, o := range r.network {
+		if o == n {
+			// a new slice, so that the adapter is not kept alive by the old one
+			network := make([]NetworkAdapter, 0, len(r.network)-1)
+			r.network = append(append(network, r.network[:i]...), r.network[i+1:]...)
+			return
+		}
+	}
+}
+
+// PeerID returns the ID of this repo on the network
+func (r *Repo) PeerID() PeerID {
+	return r.opts.PeerID
+}
+
+// Peers returns the IDs of the currently connected peers
+func (r *Repo) Peers() []PeerID {
+	ret := []PeerID{}
+	r.q.call(func() {
+		for p := range r.peers {
+			ret = append(ret, p)
+		}
+	})
+	return ret
+}
+
+// Create adds a new, empty, document to the repo.
+func (r *Repo) Create() (*Handle, error) {
+	doc, err := automerge.TryNew()
+	if err != nil {
+		return nil, err
+	}
+	return r.Import(doc)
+}
+
+// Import adds an existing document to the repo with a new [DocumentID].
+func (r *Repo) Import(doc *automerge.Doc) (*Handle, error) {
+	h := newHandle(r, NewDocumentID(), doc)
+	h.markReady(nil)
+
+	ok := r.q.call(func() {
+		r.handles[h.id] = h
+		h.stored = &storedDoc{}
+		r.docChanged(h)
+	})
+	if !ok {
+		return nil, ErrClosed
+	}
+	return h, nil
+}
+
+// Find returns the document with the given ID.
+// If the document is not already loaded, it is loaded from storage, or
+// requested from connected peers. Find returns [ErrUnavailable] if it cannot be found
+// and waits until ctx is done if a peer has the document but has not yet sent it.
+func (r *Repo) Find(ctx context.Context, id DocumentID) (*Handle, error) {
+	if _, err := ParseDocumentID(string(id)); err != nil {
+		return nil, err
+	}
+
+	var h *Handle
+	ok := r.q.call(func() {
+		if h = r.handles[id]; h != nil {
+			return
+		}
+		h = newHandle(r, id, nil)
+		r.handles[id] = h
+		r.load(ctx, h)
+	})
+	if !ok {
+		return nil, ErrClosed
+	}
+
+	select {
+	case <-h.ready:
+		if h.err != nil {
+			return nil, h.err
+		}
+		return h, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// Delete removes the document from the repo and from storage.
+func (r *Repo) Delete(ctx context.Context, id DocumentID) error {
+	var err error
+	ok := r.q.call(func() {
+		if h := r.handles[id]; h != nil {
+			h.close()
+			delete(r.handles, id)
+		}
+		if r.opts.Storage != nil {
+			err = r.opts.Storage.RemoveRange(ctx, StorageKey{string(id)})
+		}
+	})
+	if !ok {
+		return ErrClosed
+	}
+	return err
+}
+
+// Close disconnects the repo from the network, and stops saving documents.
+func (r *Repo) Close() error {
+	r.q.call(func() {
+		for _, h := range r.handles {
+			h.close()
+		}
+		r.peers = map[PeerID]NetworkAdapter{}
+	})
+	r.q.close()
+
+	r.m.Lock()
+	network := r.network
+	r.closed = true
+	r.network = nil
+	r.m.Unlock()
+
+	var errs []error
+	for _, n := range network {
+		if err := n.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return errs[0]
+	}
+	return nil
+}
+
+func (r *Repo) reportError(err error) {
+	if err != nil && r.opts.OnError != nil {
+		r.opts.OnError(err)
+	}
+}
+
+// load runs on the queue, and tries to find the document in storage,
+// and then from connected peers. If storage fails the handle fails too,
+// rather than starting again from an empty document.
+func (r *Repo) load(ctx context.Context, h *Handle) {
+	if r.opts.Storage != nil {
+		doc, stored, err := loadFromStorage(ctx, r.opts.Storage, h.id)
+		if err != nil {
+			h.markReady(fmt.Errorf("repo: failed to load %s: %w", h.id, err))
+			h.close()
+			delete(r.handles, h.id)
+			return
+		}
+		if doc != nil {
+			h.stored = stored
+			h.setDoc(doc)
+			h.markReady(nil)
+			r.docChanged(h)
+			return
+		}
+	}
+
+	doc, err := automerge.TryNew()
+	if err != nil {
+		h.markReady(err)
+		h.close()
+		delete(r.handles, h.id)
+		return
+	}
+	h.stored = &storedDoc{}
+	h.setDoc(doc)
+	if len(r.peers) == 0 {
+		h.markReady(ErrUnavailable)
+		h.close()
+		delete(r.handles, h.id)
+		return
+	}
+	for peer := range r.peers {
+		r.sync(h, peer)
+	}
+}
+
+// docChanged runs on the queue after the document has changed
+func (r *Repo) docChanged(h *Handle) {
+	if h.closed {
+		return
+	}
+	if !h.isReady() {
+		heads, err := h.doc.TryHeads()
+		if err != nil {
+			r.reportError(fmt.Errorf("repo: failed to read %s: %w", h.id, err))
+			return
+		}
+		if len(heads) == 0 {
+			return
+		}
+		h.markReady(nil)
+	}
+	if r.opts.Storage != nil {
+		err := h.stored.save(context.Background(), r.opts.Storage, h.id, h.doc)
+		if err != nil {
+			r.reportError(fmt.Errorf("repo: failed to save %s: %w", h.id, err))
+		}
+	}
+	for peer := range r.peers {
+		if h.syncStates[peer] != nil || r.shares(peer, h.id) {
+			r.sync(h, peer)
+		}
+	}
+}
+
+func (r *Repo) shares(peer PeerID, id DocumentID) bool {
+	return r.opts.SharePolicy == nil || r.opts.SharePolicy(peer, id)
+}
+
+// sync runs on the queue, and sends the next sync message to peer (if any)
+func (r *Repo) sync(h *Handle, peer PeerID) {
+	adapter := r.peers[peer]
+	if adapter == nil {
+		return
+	}
+	ss, err := h.syncState(peer)
+	if err != nil {
+		r.reportError(fmt.Errorf("repo: failed to sync %s: %w", h.id, err))
+		return
+	}
+	msg, valid, err := ss.TryGenerateMessage()
+	if err != nil {
+		r.reportError(fmt.Errorf("repo: failed to sync %s: %w", h.id, err))
+		return
+	}
+	if !valid {
+		return
+	}
+	data, err := msg.TryBytes()
+	if err != nil {
+		r.reportError(fmt.Errorf("repo: failed to sync %s: %w", h.id, err))
+		return
+	}
+	typ := MessageSync
+	if !h.isReady() {
+		typ = MessageRequest
+	}
+	r.send(adapter, &Message{Type: typ, SenderID: r.opts.PeerID, TargetID: peer, DocumentID: h.id, Data: data})
+}
+
+func (r *Repo) send(adapter NetworkAdapter, msg *Message) {
+	if adapter == nil {
+		return
+	}
+	if err := adapter.Send(msg); err != nil {
+		r.reportError(fmt.Errorf("repo: failed to send %s to %s: %w", msg.Type, msg.TargetID, err))
+	}
+}
+
+func (r *Repo) peerCandidate(peer PeerID, adapter NetworkAdapter) {
+	r.peers[peer] = adapter
+	for _, h := range r.handles {
+		if !h.isReady() || r.shares(peer, h.id) {
+			r.sync(h, peer)
+		}
+	}
+}
+
+func (r *Repo) peerDisconnected(peer PeerID, adapter NetworkAdapter) {
+	// the peer may already have reconnected over a different adapter
+	if r.peers[peer] != adapter {
+		return
+	}
+	delete(r.peers, peer)
+	for _, h := range r.handles {
+		delete(h.syncStates, peer)
+		r.checkUnavailable(h)
+	}
+}
+
+func (r *Repo) receive(msg *Message) {
+	switch msg.Type {
+	case MessageSync, MessageRequest:
+		h := r.handles[msg.DocumentID]
+		if h == nil && r.opts.Storage != nil {
+			doc, stored, err := loadFromStorage(context.Background(), r.opts.Storage, msg.DocumentID)
+			if err != nil {
+				// the message is dropped, so that the stored document is
+				// not replaced by a new one
+				r.reportError(fmt.Errorf("repo: failed to load %s: %w", msg.DocumentID, err))
+				if msg.Type == MessageRequest {
+					r.send(r.peers[msg.SenderID], &Message{Type: MessageDocUnavailable, SenderID: r.opts.PeerID, TargetID: msg.SenderID, DocumentID: msg.DocumentID})
+				}
+				return
+			}
+			if doc != nil {
+				h = newHandle(r, msg.DocumentID, doc)
+				h.stored = stored
+				h.markReady(nil)
+				r.handles[h.id] = h
+			}
+		}
+		if h == nil {
+			if msg.Type == MessageRequest {
+				r.send(r.peers[msg.SenderID], &Message{Type: MessageDocUnavailable, SenderID: r.opts.PeerID, TargetID: msg.SenderID, DocumentID: msg.DocumentID})
+				return
+			}
+			if _, err := ParseDocumentID(string(msg.DocumentID)); err != nil {
+				r.reportError(err)
+				return
+			}
+			doc, err := automerge.TryNew()
+			if err != nil {
+				r.reportError(err)
+				return
+			}
+			h = newHandle(r, msg.DocumentID, doc)
+			h.stored = &storedDoc{}
+			r.handles[h.id] = h
+		}
+		if !h.isReady() && msg.Type == MessageRequest {
+			r.send(r.peers[msg.SenderID], &Message{Type: MessageDocUnavailable, SenderID: r.opts.PeerID, TargetID: msg.SenderID, DocumentID: msg.DocumentID})
+			return
+		}
+
+		delete(h.unavailable, msg.SenderID)
+		ss, err := h.syncState(msg.SenderID)
+		if err == nil {
+			_, err = ss.ReceiveMessage(msg.Data)
+		}
+		if err != nil {
+			r.reportError(fmt.Errorf("repo: invalid sync message for %s from %s: %w", msg.DocumentID, msg.SenderID, err))
+			return
+		}
+		r.sync(h, msg.SenderID)
+
+	case MessageDocUnavailable:
+		h := r.handles[msg.DocumentID]
+		if h == nil || h.isReady() {
+			return
+		}
+		h.unavailable[msg.SenderID] = true
+		r.checkUnavailable(h)
+	}
+}
+
+// checkUnavailable marks a requested document as unavailable
+// once every connected peer has said they do not have it.
+func (r *Repo) checkUnavailable(h *Handle) {
+	if h.isReady() {
+		return
+	}
+	for peer := range r.peers {
+		if !h.unavailable[peer] {
+			return
+		}
+	}
+	h.markReady(ErrUnavailable)
+	h.close()
+	delete(r.handles, h.id)
+}
+
+// repoEvents delivers events from a NetworkAdapter onto the repo's queue
+type repoEvents struct {
+	r       *Repo
+	adapter NetworkAdapter
+}
+
+func (e *repoEvents) PeerCandidate(peer PeerID) {
+	e.r.q.push(func() { e.r.peerCandidate(peer, e.adapter) })
+}
+
+func (e *repoEvents) PeerDisconnected(peer PeerID) {
+	e.r.q.push(func() { e.r.peerDisconnected(peer, e.adapter) })
+}
+
+func (e *repoEvents) Receive(msg *Message) {
+	e.r.q.push(func() { e.r.receive(msg) })
+}
+
+// Handle gives access to a document managed by a [Repo].
+type Handle struct {
+	repo *Repo
+	id   DocumentID
+
+	readyOnce sync.Once
+	ready     chan struct{}
+	err       error
+
+	// the following fields may only be accessed on the repo's queue
+	doc         *automerge.Doc
+	removeHooks func()
+	stored      *storedDoc
+	syncStates  map[PeerID]*automerge.SyncState
+	unavailable map[PeerID]bool
+	closed      bool
+}
+
+func newHandle(r *Repo, id DocumentID, doc *automerge.Doc) *Handle {
+	h := &Handle{
+		repo:        r,
+		id:          id,
+		ready:       make(chan struct{}),
+		syncStates:  map[PeerID]*automerge.SyncState{},
+		unavailable: map[PeerID]bool{},
+	}
+	if doc != nil {
+		h.setDoc(doc)
+	}
+	return h
+}
+
+func (h *Handle) setDoc(doc *automerge.Doc) {
+	h.doc = doc
+	changed := func() { h.repo.q.push(func() { h.repo.docChanged(h) }) }
+	h.removeHooks = doc.AddHooks(automerge.Hooks{
+		AfterCommit: func(*automerge.Doc, automerge.ChangeHash) { changed() },
+		AfterApply:  func(*automerge.Doc, []automerge.ChangeHash) { changed() },
+	})
+}
+
+func (h *Handle) markReady(err error) {
+	h.readyOnce.Do(func() {
+		h.err = err
+		close(h.ready)
+	})
+}
+
+func (h *Handle) isReady() bool {
+	select {
+	case <-h.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+func (h *Handle) syncState(peer PeerID) (*automerge.SyncState, error) {
+	ss := h.syncStates[peer]
+	if ss == nil {
+		var err error
+		if ss, err = automerge.TryNewSyncState(h.doc); err != nil {
+			return nil, err
+		}
+		h.syncStates[peer] = ss
+	}
+	return ss, nil
+}
+
+func (h *Handle) close() {
+	h.closed = true
+	if h.removeHooks != nil {
+		h.removeHooks()
+	}
+}
+
+// ID returns the document's ID
+func (h *Handle) ID() DocumentID {
+	return h.id
+}
+
+// URL returns the document's automerge URL
+func (h *Handle) URL() string {
+	return h.id.URL()
+}
+
+// Doc returns the document. Handles are only returned once the document has
+// been loaded, so this is never a placeholder for a document that could not
+// be loaded. After modifying it you must call [automerge.Doc.Commit]
+// for the repo to save the changes and send them to other peers.
+func (h *Handle) Doc() *automerge.Doc {
+	<-h.ready
+	return h.doc
+}
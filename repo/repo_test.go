@@ -0,0 +1,196 @@
+package repo_test
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/automerge/automerge-go"
+	"github.com/automerge/automerge-go/repo"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDocumentID(t *testing.T) {
+	id := repo.NewDocumentID()
+	require.Len(t, id.Bytes(), 16)
+
+	parsed, err := repo.ParseDocumentID(id.URL())
+	require.NoError(t, err)
+	require.Equal(t, id, parsed)
+
+	parsed, err = repo.ParseDocumentID(id.URL() + "#heads")
+	require.NoError(t, err)
+	require.Equal(t, id, parsed)
+
+	// changing a character breaks the checksum
+	last := "x"
+	if id[len(id)-1] == 'x' {
+		last = "y"
+	}
+	_, err = repo.ParseDocumentID("automerge:" + string(id[:len(id)-1]) + last)
+	require.Error(t, err)
+	_, err = repo.ParseDocumentID("automerge:0OIl")
+	require.Error(t, err)
+}
+
+func newRepo(t *testing.T, opts repo.Options) *repo.Repo {
+	t.Helper()
+	// peers may disconnect while messages are in flight, so errors are not fatal
+	opts.OnError = func(err error) { t.Log(err) }
+	r, err := repo.New(opts)
+	require.NoError(t, err)
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+func eventually(t *testing.T, doc *automerge.Doc, path string, want any) {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		v, err := doc.Path(path).Get()
+		return err == nil && !v.IsVoid() && v.Interface() == want
+	}, 5*time.Second, time.Millisecond)
+}
+
+func TestRepo_Sync(t *testing.T) {
+	ctx := context.Background()
+	n := repo.NewMemoryNetwork()
+	a := newRepo(t, repo.Options{PeerID: "a", Network: []repo.NetworkAdapter{n.Adapter()}})
+	b := newRepo(t, repo.Options{PeerID: "b", Network: []repo.NetworkAdapter{n.Adapter()}})
+
+	require.Eventually(t, func() bool { return len(a.Peers()) == 1 && len(b.Peers()) == 1 }, time.Second, time.Millisecond)
+
+	ha, err := a.Create()
+	require.NoError(t, err)
+	require.NoError(t, ha.Doc().Path("title").Set("hello"))
+	_, err = ha.Doc().Commit("set title")
+	require.NoError(t, err)
+
+	hb, err := b.Find(ctx, ha.ID())
+	require.NoError(t, err)
+	require.Equal(t, ha.URL(), hb.URL())
+	eventually(t, hb.Doc(), "title", "hello")
+
+	require.NoError(t, hb.Doc().Path("count").Set(int64(2)))
+	_, err = hb.Doc().Commit("set count")
+	require.NoError(t, err)
+	eventually(t, ha.Doc(), "count", int64(2))
+
+	// a third peer joining later gets the documents shared with it
+	c := newRepo(t, repo.Options{PeerID: "c", Network: []repo.NetworkAdapter{n.Adapter()}})
+	require.Eventually(t, func() bool { return len(c.Peers()) == 2 }, time.Second, time.Millisecond)
+	ctx2, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	hc, err := c.Find(ctx2, ha.ID())
+	require.NoError(t, err)
+	eventually(t, hc.Doc(), "count", int64(2))
+}
+
+func TestRepo_Unavailable(t *testing.T) {
+	ctx := context.Background()
+	a := newRepo(t, repo.Options{})
+	_, err := a.Find(ctx, repo.NewDocumentID())
+	require.ErrorIs(t, err, repo.ErrUnavailable)
+
+	n := repo.NewMemoryNetwork()
+	b := newRepo(t, repo.Options{PeerID: "b", Network: []repo.NetworkAdapter{n.Adapter()}})
+	c := newRepo(t, repo.Options{PeerID: "c", Network: []repo.NetworkAdapter{n.Adapter()}})
+	require.Eventually(t, func() bool { return len(b.Peers()) == 1 && len(c.Peers()) == 1 }, time.Second, time.Millisecond)
+
+	_, err = b.Find(ctx, repo.NewDocumentID())
+	require.ErrorIs(t, err, repo.ErrUnavailable)
+
+	_, err = b.Find(ctx, "not-an-id")
+	require.Error(t, err)
+}
+
+func TestRepo_Storage(t *testing.T) {
+	ctx := context.Background()
+	storage := repo.NewMemoryStorage()
+
+	a := newRepo(t, repo.Options{Storage: storage})
+	h, err := a.Create()
+	require.NoError(t, err)
+	for i := 0; i < 10; i++ {
+		require.NoError(t, h.Doc().Path("n").Set(int64(i)))
+		_, err = h.Doc().Commit("set n")
+		require.NoError(t, err)
+	}
+	require.NoError(t, a.Close())
+
+	chunks, err := storage.LoadRange(ctx, repo.StorageKey{string(h.ID())})
+	require.NoError(t, err)
+	require.NotEmpty(t, chunks)
+	for _, c := range chunks {
+		require.Contains(t, []string{"snapshot", "incremental"}, c.Key[1])
+	}
+
+	b := newRepo(t, repo.Options{Storage: storage})
+	h2, err := b.Find(ctx, h.ID())
+	require.NoError(t, err)
+	n, err := automerge.As[int](h2.Doc().Path("n").Get())
+	require.NoError(t, err)
+	require.Equal(t, 9, n)
+
+	require.NoError(t, b.Delete(ctx, h.ID()))
+	chunks, err = storage.LoadRange(ctx, repo.StorageKey{string(h.ID())})
+	require.NoError(t, err)
+	require.Empty(t, chunks)
+}
+
+// failingStorage fails to load any document
+type failingStorage struct {
+	repo.StorageAdapter
+}
+
+func (failingStorage) LoadRange(ctx context.Context, prefix repo.StorageKey) ([]repo.Chunk, error) {
+	return nil, errors.New("disk on fire")
+}
+
+func TestRepo_StorageError(t *testing.T) {
+	ctx := context.Background()
+	n := repo.NewMemoryNetwork()
+	a := newRepo(t, repo.Options{PeerID: "a", Network: []repo.NetworkAdapter{n.Adapter()}})
+	b := newRepo(t, repo.Options{PeerID: "b", Network: []repo.NetworkAdapter{n.Adapter()},
+		Storage: failingStorage{repo.NewMemoryStorage()}})
+	require.Eventually(t, func() bool { return len(a.Peers()) == 1 && len(b.Peers()) == 1 }, time.Second, time.Millisecond)
+
+	ha, err := a.Create()
+	require.NoError(t, err)
+	require.NoError(t, ha.Doc().Path("title").Set("hello"))
+	_, err = ha.Doc().Commit("set title")
+	require.NoError(t, err)
+
+	// the document is not replaced by an empty one, or by a copy from a peer
+	_, err = b.Find(ctx, ha.ID())
+	require.ErrorContains(t, err, "disk on fire")
+}
+
+// doneAdapter is a network adapter that ends on its own
+type doneAdapter struct {
+	done chan struct{}
+}
+
+func (da *doneAdapter) Connect(self repo.PeerID, events repo.NetworkEvents) error { return nil }
+func (da *doneAdapter) Send(msg *repo.Message) error                              { return nil }
+func (da *doneAdapter) Close() error                                              { return nil }
+func (da *doneAdapter) Done() <-chan struct{}                                     { return da.done }
+
+func TestRepo_AdapterDone(t *testing.T) {
+	r := newRepo(t, repo.Options{})
+
+	// adapters are forgotten once they end
+	var collected int32
+	func() {
+		da := &doneAdapter{done: make(chan struct{})}
+		runtime.SetFinalizer(da, func(*doneAdapter) { atomic.StoreInt32(&collected, 1) })
+		require.NoError(t, r.Connect(da))
+		close(da.done)
+	}()
+	require.Eventually(t, func() bool {
+		runtime.GC()
+		return atomic.LoadInt32(&collected) == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
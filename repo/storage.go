@@ -0,0 +1,249 @@
+package repo
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/automerge/automerge-go"
+)
+
+// StorageKey is a hierarchical key identifying a chunk of stored data.
+// The repo stores documents using the same layout as automerge-repo:
+// ["<document id>", "snapshot", "<hash of heads>"] for full snapshots, and
+// ["<document id>", "incremental", "<hash of data>"] for incremental changes.
+type StorageKey []string
+
+// Chunk is a piece of stored data and its key
+type Chunk struct {
+	Key  StorageKey
+	Data []byte
+}
+
+// StorageAdapter persists chunks of data. It is modelled on the StorageAdapter
+// interface in automerge-repo. Implementations must be safe to call
+// from multiple goroutines.
+type StorageAdapter interface {
+	// Load returns the data stored at key, or nil if there is none.
+	Load(ctx context.Context, key StorageKey) ([]byte, error)
+	// Save stores data at key, replacing any existing data.
+	Save(ctx context.Context, key StorageKey, data []byte) error
+	// Remove deletes the data stored at key (if any).
+	Remove(ctx context.Context, key StorageKey) error
+	// LoadRange returns all chunks whose key starts with prefix.
+	LoadRange(ctx context.Context, prefix StorageKey) ([]Chunk, error)
+	// RemoveRange deletes all chunks whose key starts with prefix.
+	RemoveRange(ctx context.Context, prefix StorageKey) error
+}
+
+// MemoryStorage is a [StorageAdapter] that keeps data in memory.
+// It is primarily useful for testing.
+type MemoryStorage struct {
+	m      sync.Mutex
+	chunks map[string]Chunk
+}
+
+// NewMemoryStorage returns an empty [*MemoryStorage]
+func NewMemoryStorage() *MemoryStorage {
+	return &MemoryStorage{chunks: map[string]Chunk{}}
+}
+
+func (k StorageKey) String() string {
+	return strings.Join(k, "/")
+}
+
+func (k StorageKey) hasPrefix(prefix StorageKey) bool {
+	if len(prefix) > len(k) {
+		return false
+	}
+	for i := range prefix {
+		if k[i] != prefix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (k StorageKey) index() string {
+	return strings.Join(k, "\x00")
+}
+
+// Load implements [StorageAdapter]
+func (ms *MemoryStorage) Load(ctx context.Context, key StorageKey) ([]byte, error) {
+	ms.m.Lock()
+	defer ms.m.Unlock()
+	if c, ok := ms.chunks[key.index()]; ok {
+		return append([]byte{}, c.Data...), nil
+	}
+	return nil, nil
+}
+
+// Save implements [StorageAdapter]
+func (ms *MemoryStorage) Save(ctx context.Context, key StorageKey, data []byte) error {
+	ms.m.Lock()
+	defer ms.m.Unlock()
+	ms.chunks[key.index()] = Chunk{Key: append(StorageKey{}, key...), Data: append([]byte{}, data...)}
+	return nil
+}
+
+// Remove implements [StorageAdapter]
+func (ms *MemoryStorage) Remove(ctx context.Context, key StorageKey) error {
+	ms.m.Lock()
+	defer ms.m.Unlock()
+	delete(ms.chunks, key.index())
+	return nil
+}
+
+// LoadRange implements [StorageAdapter]
+func (ms *MemoryStorage) LoadRange(ctx context.Context, prefix StorageKey) ([]Chunk, error) {
+	ms.m.Lock()
+	defer ms.m.Unlock()
+	ret := []Chunk{}
+	for _, c := range ms.chunks {
+		if c.Key.hasPrefix(prefix) {
+			ret = append(ret, Chunk{Key: append(StorageKey{}, c.Key...), Data: append([]byte{}, c.Data...)})
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Key.index() < ret[j].Key.index() })
+	return ret, nil
+}
+
+// Keys returns the keys of all chunks whose key starts with prefix, in sorted order
+func (ms *MemoryStorage) Keys(ctx context.Context, prefix StorageKey) ([]StorageKey, error) {
+	ms.m.Lock()
+	defer ms.m.Unlock()
+	ret := []StorageKey{}
+	for _, c := range ms.chunks {
+		if c.Key.hasPrefix(prefix) {
+			ret = append(ret, append(StorageKey{}, c.Key...))
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].index() < ret[j].index() })
+	return ret, nil
+}
+
+// RemoveRange implements [StorageAdapter]
+func (ms *MemoryStorage) RemoveRange(ctx context.Context, prefix StorageKey) error {
+	ms.m.Lock()
+	defer ms.m.Unlock()
+	for k, c := range ms.chunks {
+		if c.Key.hasPrefix(prefix) {
+			delete(ms.chunks, k)
+		}
+	}
+	return nil
+}
+
+// storedDoc tracks what has been written to storage for a document
+// so that new changes can be saved incrementally and compacted.
+type storedDoc struct {
+	heads           []automerge.ChangeHash
+	keys            []StorageKey
+	snapshotSize    int
+	incrementalSize int
+}
+
+func loadFromStorage(ctx context.Context, s StorageAdapter, id DocumentID) (*automerge.Doc, *storedDoc, error) {
+	chunks, err := s.LoadRange(ctx, StorageKey{string(id)})
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(chunks) == 0 {
+		return nil, nil, nil
+	}
+
+	sortChunks(chunks)
+
+	sd := &storedDoc{}
+	for _, c := range chunks {
+		sd.keys = append(sd.keys, c.Key)
+		if isSnapshot(c.Key) {
+			sd.snapshotSize += len(c.Data)
+		} else {
+			sd.incrementalSize += len(c.Data)
+		}
+	}
+	doc, err := loadChunks(chunks)
+	if err != nil {
+		return nil, nil, err
+	}
+	if sd.heads, err = doc.TryHeads(); err != nil {
+		return nil, nil, err
+	}
+	return doc, sd, nil
+}
+
+// save writes any changes to the document since it was last saved. If the
+// incremental changes have grown larger than the last snapshot, the document
+// is compacted into a new snapshot and the old chunks are removed.
+func (sd *storedDoc) save(ctx context.Context, s StorageAdapter, id DocumentID, doc *automerge.Doc) error {
+	heads, err := doc.TryHeads()
+	if err != nil || len(heads) == 0 || sameHeads(heads, sd.heads) {
+		return err
+	}
+
+	if sd.snapshotSize == 0 || sd.incrementalSize > sd.snapshotSize {
+		data, err := doc.TrySave()
+		if err != nil {
+			return err
+		}
+		key := StorageKey{string(id), "snapshot", headsHash(heads)}
+		if err := s.Save(ctx, key, data); err != nil {
+			return err
+		}
+		for _, k := range sd.keys {
+			if k.index() == key.index() {
+				continue
+			}
+			if err := s.Remove(ctx, k); err != nil {
+				return err
+			}
+		}
+		*sd = storedDoc{heads: heads, keys: []StorageKey{key}, snapshotSize: len(data)}
+		return nil
+	}
+
+	changes, err := doc.Changes(sd.heads...)
+	if err != nil {
+		return err
+	}
+	data := automerge.SaveChanges(changes)
+	sum := sha256.Sum256(data)
+	key := StorageKey{string(id), "incremental", hex.EncodeToString(sum[:])}
+	if err := s.Save(ctx, key, data); err != nil {
+		return err
+	}
+	sd.heads = heads
+	sd.keys = append(sd.keys, key)
+	sd.incrementalSize += len(data)
+	return nil
+}
+
+func headsHash(heads []automerge.ChangeHash) string {
+	hs := []string{}
+	for _, h := range heads {
+		hs = append(hs, h.String())
+	}
+	sort.Strings(hs)
+	sum := sha256.Sum256([]byte(strings.Join(hs, "")))
+	return hex.EncodeToString(sum[:])
+}
+
+func sameHeads(a, b []automerge.ChangeHash) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	seen := map[automerge.ChangeHash]bool{}
+	for _, h := range a {
+		seen[h] = true
+	}
+	for _, h := range b {
+		if !seen[h] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,158 @@
+package repo_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/automerge/automerge-go"
+	"github.com/automerge/automerge-go/repo"
+	"github.com/stretchr/testify/require"
+)
+
+func TestChunkStorage(t *testing.T) {
+	fs, err := repo.NewFileStorage(t.TempDir())
+	require.NoError(t, err)
+
+	for name, adapter := range map[string]repo.StorageAdapter{
+		"memory": repo.NewMemoryStorage(),
+		"file":   fs,
+	} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			s := repo.NewChunkStorage(adapter, repo.ChunkStorageOptions{MaxIncrementalChunks: 5})
+
+			id := repo.NewDocumentID()
+			_, err := repo.LoadDocument(ctx, s, id)
+			require.ErrorIs(t, err, repo.ErrUnavailable)
+
+			doc := automerge.New()
+			for i := 0; i < 20; i++ {
+				require.NoError(t, doc.Path("n").Set(int64(i)))
+				_, err := doc.Commit("set n")
+				require.NoError(t, err)
+				require.NoError(t, s.AppendIncremental(ctx, id, doc.SaveIncremental()))
+
+				// compaction keeps the number of chunks bounded
+				chunks, err := s.LoadChunks(ctx, id)
+				require.NoError(t, err)
+				require.LessOrEqual(t, len(chunks), 6)
+			}
+
+			loaded, err := repo.LoadDocument(ctx, s, id)
+			require.NoError(t, err)
+			require.Equal(t, doc.Heads(), loaded.Heads())
+
+			require.NoError(t, s.Compact(ctx, id))
+			chunks, err := s.LoadChunks(ctx, id)
+			require.NoError(t, err)
+			require.Len(t, chunks, 1)
+			require.Equal(t, "snapshot", chunks[0].Key[1])
+
+			other := repo.NewDocumentID()
+			require.NoError(t, s.AppendIncremental(ctx, other, automerge.New().SaveIncremental()))
+			d := automerge.New()
+			require.NoError(t, d.Path("x").Set(true))
+			_, err = d.Commit("x")
+			require.NoError(t, err)
+			require.NoError(t, s.AppendIncremental(ctx, other, d.SaveIncremental()))
+
+			// data that is not a document is not listed
+			require.NoError(t, adapter.Save(ctx, repo.StorageKey{"httpsync", "/doc", "session"}, []byte("x")))
+
+			ids, err := s.ListDocuments(ctx)
+			require.NoError(t, err)
+			require.ElementsMatch(t, []repo.DocumentID{id, other}, ids)
+		})
+	}
+}
+
+func TestFileStorage(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	fs, err := repo.NewFileStorage(dir)
+	require.NoError(t, err)
+
+	// keys are laid out like automerge-repo's NodeFSStorageAdapter
+	key := repo.StorageKey{"3nFbWpmF", "incremental", "abcd"}
+	require.NoError(t, fs.Save(ctx, key, []byte("hello")))
+	b, err := os.ReadFile(filepath.Join(dir, "3n", "FbWpmF", "incremental", "abcd"))
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(b))
+
+	b, err = fs.Load(ctx, key)
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(b))
+	b, err = fs.Load(ctx, repo.StorageKey{"3nFbWpmF", "incremental", "missing"})
+	require.NoError(t, err)
+	require.Nil(t, b)
+
+	// temporary files from an interrupted write are ignored
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "3n", "FbWpmF", "incremental", ".tmp-123"), []byte("x"), 0o644))
+	chunks, err := fs.LoadRange(ctx, repo.StorageKey{"3nFbWpmF"})
+	require.NoError(t, err)
+	require.Equal(t, []repo.Chunk{{Key: key, Data: []byte("hello")}}, chunks)
+
+	// other keys are escaped so they stay inside the directory
+	for _, k := range []repo.StorageKey{
+		{"../escape", "x", "y"},
+		{"httpsync", "/docs/1", "abc"},
+		{"..", ".", `a\b`},
+		{".tmp-1", "x", "y"},
+		{"ab", "x"},
+		{"a", "b", "c"},
+	} {
+		require.NoError(t, fs.Save(ctx, k, []byte("data")))
+		b, err := fs.Load(ctx, k)
+		require.NoError(t, err)
+		require.Equal(t, "data", string(b))
+		keys, err := fs.Keys(ctx, k)
+		require.NoError(t, err)
+		require.Equal(t, []repo.StorageKey{k}, keys)
+		require.NoError(t, fs.Remove(ctx, k))
+	}
+	require.Error(t, fs.Save(ctx, repo.StorageKey{"", "x"}, nil))
+
+	// a short first segment does not clash with a longer one
+	require.NoError(t, fs.Save(ctx, repo.StorageKey{"ab", "x"}, []byte("short")))
+	require.NoError(t, fs.Save(ctx, repo.StorageKey{"abx"}, []byte("long")))
+	keys, err := fs.Keys(ctx, repo.StorageKey{"ab"})
+	require.NoError(t, err)
+	require.Equal(t, []repo.StorageKey{{"ab", "x"}}, keys)
+	keys, err = fs.Keys(ctx, repo.StorageKey{"abx"})
+	require.NoError(t, err)
+	require.Equal(t, []repo.StorageKey{{"abx"}}, keys)
+	b, err = fs.Load(ctx, repo.StorageKey{"ab", "x"})
+	require.NoError(t, err)
+	require.Equal(t, "short", string(b))
+	require.NoError(t, fs.RemoveRange(ctx, repo.StorageKey{"ab"}))
+	keys, err = fs.Keys(ctx, repo.StorageKey{"ab"})
+	require.NoError(t, err)
+	require.Empty(t, keys)
+	b, err = fs.Load(ctx, repo.StorageKey{"abx"})
+	require.NoError(t, err)
+	require.Equal(t, "long", string(b))
+	require.NoError(t, fs.Remove(ctx, repo.StorageKey{"abx"}))
+	_, err = os.Stat(filepath.Join(filepath.Dir(dir), "escape"))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	// a repo can use file storage directly
+	r := newRepo(t, repo.Options{Storage: fs})
+	h, err := r.Create()
+	require.NoError(t, err)
+	require.NoError(t, h.Doc().Path("title").Set("hello"))
+	_, err = h.Doc().Commit("title")
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+
+	doc, err := repo.LoadDocument(ctx, repo.NewChunkStorage(fs), h.ID())
+	require.NoError(t, err)
+	require.Equal(t, h.Doc().Heads(), doc.Heads())
+
+	require.NoError(t, fs.RemoveRange(ctx, repo.StorageKey{"3nFbWpmF"}))
+	require.NoError(t, fs.RemoveRange(ctx, repo.StorageKey{}))
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	require.Empty(t, entries)
+}
@@ -0,0 +1,72 @@
+// Prints the messages in wireVectors (repo/wire_test.go) as encoded by
+// automerge-repo, one "name hex" line per message.
+//
+// Messages are encoded with automerge-repo's own cbor helper, the one its
+// network adapters use on the wire. Keys are encoded in insertion order, so
+// each object lists its fields in the order automerge-repo constructs them.
+// package.json pins the automerge-repo and cbor-x versions to check the
+// vectors against; package-lock.json pins the rest of the tree.
+//
+// To run it:
+//
+//	cd repo/testdata && npm ci && npm run vectors
+//
+// (If package-lock.json is missing, create it with
+// "npm install --package-lock-only" and commit it.)
+import { cbor } from "@automerge/automerge-repo"
+
+const docId = "4NMNnkMhL8jXrdJ9jamS58PAVdXu"
+const syncData = new Uint8Array([0x42, 0, 0, 1, 0, 0, 0])
+
+const vectors = {
+  join: {
+    type: "join",
+    senderId: "client-1",
+    peerMetadata: { isEphemeral: true },
+    supportedProtocolVersions: ["1"],
+  },
+  peer: {
+    type: "peer",
+    senderId: "server",
+    peerMetadata: { storageId: "3760df37-a4c6-4f66-9ecd-732039a9385d", isEphemeral: false },
+    selectedProtocolVersion: "1",
+    targetId: "client-1",
+  },
+  sync: {
+    type: "sync",
+    senderId: "server",
+    targetId: "client-1",
+    documentId: docId,
+    data: syncData,
+  },
+  request: {
+    type: "request",
+    senderId: "client-1",
+    targetId: "server",
+    documentId: docId,
+    data: syncData,
+  },
+  "doc-unavailable": {
+    type: "doc-unavailable",
+    senderId: "server",
+    targetId: "client-1",
+    documentId: docId,
+  },
+  ephemeral: {
+    type: "ephemeral",
+    senderId: "client-1",
+    targetId: "server",
+    count: 3,
+    sessionId: "session-1",
+    documentId: docId,
+    data: cbor.encode({ cursor: 5 }),
+  },
+  error: {
+    type: "error",
+    message: "unsupported protocol version",
+  },
+}
+
+for (const [name, msg] of Object.entries(vectors)) {
+  console.log(name, Buffer.from(cbor.encode(msg)).toString("hex"))
+}
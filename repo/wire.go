@@ -0,0 +1,226 @@
+package repo
+
+import (
+	"fmt"
+)
+
+// ProtocolVersion is the version of the automerge-repo protocol implemented by this package
+const ProtocolVersion = "1"
+
+// EncodeMessage encodes msg as CBOR in the format used by automerge-repo.
+func EncodeMessage(msg *Message) ([]byte, error) {
+	m := cborMap{{"type", msg.Type}}
+	add := func(key string, val any) { m = append(m, cborPair{key, val}) }
+
+	switch msg.Type {
+	case MessageJoin:
+		add("senderId", string(msg.SenderID))
+		add("peerMetadata", encodePeerMetadata(msg.PeerMetadata))
+		versions := msg.SupportedProtocolVersions
+		if versions == nil {
+			versions = []string{ProtocolVersion}
+		}
+		add("supportedProtocolVersions", versions)
+
+	case MessagePeer:
+		add("senderId", string(msg.SenderID))
+		add("peerMetadata", encodePeerMetadata(msg.PeerMetadata))
+		version := msg.SelectedProtocolVersion
+		if version == "" {
+			version = ProtocolVersion
+		}
+		add("selectedProtocolVersion", version)
+		add("targetId", string(msg.TargetID))
+
+	case MessageError:
+		add("message", msg.ErrorMessage)
+
+	case MessageSync, MessageRequest:
+		add("senderId", string(msg.SenderID))
+		add("targetId", string(msg.TargetID))
+		add("documentId", string(msg.DocumentID))
+		add("data", nonNil(msg.Data))
+
+	case MessageDocUnavailable:
+		add("senderId", string(msg.SenderID))
+		add("targetId", string(msg.TargetID))
+		add("documentId", string(msg.DocumentID))
+
+	case MessageEphemeral:
+		add("senderId", string(msg.SenderID))
+		add("targetId", string(msg.TargetID))
+		add("count", msg.Count)
+		add("sessionId", msg.SessionID)
+		add("documentId", string(msg.DocumentID))
+		add("data", nonNil(msg.Data))
+
+	default:
+		return nil, fmt.Errorf("repo: cannot encode message of type %q", msg.Type)
+	}
+	return cborEncode(m)
+}
+
+func nonNil(b []byte) []byte {
+	if b == nil {
+		return []byte{}
+	}
+	return b
+}
+
+func encodePeerMetadata(pm *PeerMetadata) cborMap {
+	m := cborMap{}
+	if pm == nil {
+		return m
+	}
+	if pm.StorageID != "" {
+		m = append(m, cborPair{"storageId", pm.StorageID})
+	}
+	return append(m, cborPair{"isEphemeral", pm.IsEphemeral})
+}
+
+// DecodeMessage decodes a CBOR message in the format used by automerge-repo.
+// Messages with an unknown type are returned with only their Type, SenderID
+// and TargetID set, so that newer peers can be ignored gracefully.
+func DecodeMessage(b []byte) (*Message, error) {
+	v, err := cborDecode(b)
+	if err != nil {
+		return nil, fmt.Errorf("repo: invalid message: %w", err)
+	}
+	m, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("repo: invalid message: expected map, got %T", v)
+	}
+
+	d := &messageDecoder{m: m}
+	msg := &Message{Type: d.string("type", true)}
+	if d.err != nil {
+		return nil, d.err
+	}
+
+	switch msg.Type {
+	case MessageJoin:
+		msg.SenderID = PeerID(d.string("senderId", true))
+		msg.PeerMetadata = d.peerMetadata("peerMetadata")
+		msg.SupportedProtocolVersions = d.strings("supportedProtocolVersions")
+
+	case MessagePeer:
+		msg.SenderID = PeerID(d.string("senderId", true))
+		msg.TargetID = PeerID(d.string("targetId", true))
+		msg.PeerMetadata = d.peerMetadata("peerMetadata")
+		msg.SelectedProtocolVersion = d.string("selectedProtocolVersion", true)
+
+	case MessageError:
+		msg.ErrorMessage = d.string("message", false)
+
+	case MessageSync, MessageRequest, MessageDocUnavailable, MessageEphemeral:
+		msg.SenderID = PeerID(d.string("senderId", true))
+		msg.TargetID = PeerID(d.string("targetId", true))
+		msg.DocumentID = DocumentID(d.string("documentId", true))
+		if msg.Type != MessageDocUnavailable {
+			msg.Data = d.bytes("data")
+		}
+		if msg.Type == MessageEphemeral {
+			msg.SessionID = d.string("sessionId", true)
+			msg.Count = d.int("count")
+		}
+
+	default:
+		msg.SenderID = PeerID(d.string("senderId", false))
+		msg.TargetID = PeerID(d.string("targetId", false))
+	}
+
+	if d.err != nil {
+		return nil, d.err
+	}
+	return msg, nil
+}
+
+// messageDecoder reads fields from a decoded CBOR map, recording the first error
+type messageDecoder struct {
+	m   map[string]any
+	err error
+}
+
+func (d *messageDecoder) fail(key string, want string, got any) {
+	if d.err != nil {
+		return
+	}
+	if got == nil {
+		d.err = fmt.Errorf("repo: invalid message: missing %s", key)
+	} else {
+		d.err = fmt.Errorf("repo: invalid message: %s: expected %s, got %T", key, want, got)
+	}
+}
+
+func (d *messageDecoder) string(key string, required bool) string {
+	v, ok := d.m[key]
+	if !ok && !required {
+		return ""
+	}
+	s, ok := v.(string)
+	if !ok {
+		d.fail(key, "string", v)
+	}
+	return s
+}
+
+func (d *messageDecoder) bytes(key string) []byte {
+	v := d.m[key]
+	b, ok := v.([]byte)
+	if !ok {
+		d.fail(key, "bytes", v)
+	}
+	return b
+}
+
+func (d *messageDecoder) int(key string) int64 {
+	switch v := d.m[key].(type) {
+	case uint64:
+		if v <= 1<<53 {
+			return int64(v)
+		}
+	case int64:
+		return v
+	case float64:
+		if v == float64(int64(v)) {
+			return int64(v)
+		}
+	}
+	d.fail(key, "integer", d.m[key])
+	return 0
+}
+
+func (d *messageDecoder) strings(key string) []string {
+	v := d.m[key]
+	arr, ok := v.([]any)
+	if !ok {
+		d.fail(key, "array", v)
+		return nil
+	}
+	ret := []string{}
+	for _, e := range arr {
+		s, ok := e.(string)
+		if !ok {
+			d.fail(key, "array of strings", v)
+			return nil
+		}
+		ret = append(ret, s)
+	}
+	return ret
+}
+
+func (d *messageDecoder) peerMetadata(key string) *PeerMetadata {
+	v, ok := d.m[key]
+	if !ok {
+		return nil
+	}
+	m, ok := v.(map[string]any)
+	if !ok {
+		d.fail(key, "map", v)
+		return nil
+	}
+	pm := &PeerMetadata{}
+	pm.StorageID, _ = m["storageId"].(string)
+	pm.IsEphemeral, _ = m["isEphemeral"].(bool)
+	return pm
+}
@@ -0,0 +1,233 @@
+package repo_test
+
+import (
+	"context"
+	"encoding/hex"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/automerge/automerge-go/repo"
+	"github.com/stretchr/testify/require"
+)
+
+const testDocID = repo.DocumentID("4NMNnkMhL8jXrdJ9jamS58PAVdXu")
+
+// wireVectors are messages as encoded by automerge-repo, which uses cbor-x
+// with useRecords and tagUint8Array disabled: definite length maps with keys
+// in insertion order, and untagged byte strings.
+//
+// testdata/wire_vectors.mjs encodes the same messages with automerge-repo's
+// own encoder, at the versions pinned in testdata/package.json, and prints
+// one "name hex" line for each; its output must match the hex below. Run it
+// (see the script for the command) after changing a vector.
+var wireVectors = []struct {
+	name string
+	hex  string
+	msg  *repo.Message
+}{
+	{
+		name: "join",
+		hex:  "a46474797065646a6f696e6873656e646572496468636c69656e742d316c706565724d65746164617461a16b6973457068656d6572616cf57819737570706f7274656450726f746f636f6c56657273696f6e73816131",
+		msg: &repo.Message{
+			Type:                      repo.MessageJoin,
+			SenderID:                  "client-1",
+			PeerMetadata:              &repo.PeerMetadata{IsEphemeral: true},
+			SupportedProtocolVersions: []string{"1"},
+		},
+	},
+	{
+		name: "peer",
+		hex:  "a5647479706564706565726873656e6465724964667365727665726c706565724d65746164617461a26973746f726167654964782433373630646633372d613463362d346636362d396563642d3733323033396139333835646b6973457068656d6572616cf47773656c656374656450726f746f636f6c56657273696f6e613168746172676574496468636c69656e742d31",
+		msg: &repo.Message{
+			Type:                    repo.MessagePeer,
+			SenderID:                "server",
+			TargetID:                "client-1",
+			PeerMetadata:            &repo.PeerMetadata{StorageID: "3760df37-a4c6-4f66-9ecd-732039a9385d"},
+			SelectedProtocolVersion: "1",
+		},
+	},
+	{
+		name: "sync",
+		hex:  "a564747970656473796e636873656e64657249646673657276657268746172676574496468636c69656e742d316a646f63756d656e744964781c344e4d4e6e6b4d684c386a5872644a396a616d53353850415664587564646174614742000001000000",
+		msg: &repo.Message{
+			Type:       repo.MessageSync,
+			SenderID:   "server",
+			TargetID:   "client-1",
+			DocumentID: testDocID,
+			Data:       []byte{0x42, 0, 0, 1, 0, 0, 0},
+		},
+	},
+	{
+		name: "request",
+		hex:  "a5647479706567726571756573746873656e646572496468636c69656e742d31687461726765744964667365727665726a646f63756d656e744964781c344e4d4e6e6b4d684c386a5872644a396a616d53353850415664587564646174614742000001000000",
+		msg: &repo.Message{
+			Type:       repo.MessageRequest,
+			SenderID:   "client-1",
+			TargetID:   "server",
+			DocumentID: testDocID,
+			Data:       []byte{0x42, 0, 0, 1, 0, 0, 0},
+		},
+	},
+	{
+		name: "doc-unavailable",
+		hex:  "a464747970656f646f632d756e617661696c61626c656873656e64657249646673657276657268746172676574496468636c69656e742d316a646f63756d656e744964781c344e4d4e6e6b4d684c386a5872644a396a616d533538504156645875",
+		msg: &repo.Message{
+			Type:       repo.MessageDocUnavailable,
+			SenderID:   "server",
+			TargetID:   "client-1",
+			DocumentID: testDocID,
+		},
+	},
+	{
+		name: "ephemeral",
+		hex:  "a7647479706569657068656d6572616c6873656e646572496468636c69656e742d316874617267657449646673657276657265636f756e74036973657373696f6e49646973657373696f6e2d316a646f63756d656e744964781c344e4d4e6e6b4d684c386a5872644a396a616d533538504156645875646461746149a166637572736f7205",
+		msg: &repo.Message{
+			Type:       repo.MessageEphemeral,
+			SenderID:   "client-1",
+			TargetID:   "server",
+			DocumentID: testDocID,
+			SessionID:  "session-1",
+			Count:      3,
+			Data:       []byte{0xa1, 0x66, 'c', 'u', 'r', 's', 'o', 'r', 0x05},
+		},
+	},
+	{
+		name: "error",
+		hex:  "a26474797065656572726f72676d657373616765781c756e737570706f727465642070726f746f636f6c2076657273696f6e",
+		msg: &repo.Message{
+			Type:         repo.MessageError,
+			ErrorMessage: "unsupported protocol version",
+		},
+	},
+}
+
+func TestWire_Vectors(t *testing.T) {
+	for _, v := range wireVectors {
+		t.Run(v.name, func(t *testing.T) {
+			b, err := hex.DecodeString(v.hex)
+			require.NoError(t, err)
+
+			msg, err := repo.DecodeMessage(b)
+			require.NoError(t, err)
+			require.Equal(t, v.msg, msg)
+
+			enc, err := repo.EncodeMessage(v.msg)
+			require.NoError(t, err)
+			require.Equal(t, v.hex, hex.EncodeToString(enc))
+		})
+	}
+}
+
+func TestWire_Decode(t *testing.T) {
+	// keys in a different order, indefinite length map, data tagged as a
+	// Uint8Array (tag 64), and count encoded as a float.
+	b, err := hex.DecodeString("bf" +
+		"6464617461" + "d840" + "43010203" +
+		"65636f756e74" + "fb4000000000000000" +
+		"6474797065" + "69657068656d6572616c" +
+		"6973657373696f6e4964" + "6173" +
+		"6873656e6465724964" + "6161" +
+		"687461726765744964" + "6162" +
+		"6a646f63756d656e744964" + "6164" +
+		"ff")
+	require.NoError(t, err)
+	msg, err := repo.DecodeMessage(b)
+	require.NoError(t, err)
+	require.Equal(t, &repo.Message{
+		Type:       repo.MessageEphemeral,
+		SenderID:   "a",
+		TargetID:   "b",
+		DocumentID: "d",
+		SessionID:  "s",
+		Count:      2,
+		Data:       []byte{1, 2, 3},
+	}, msg)
+
+	// unknown message types are passed through
+	b, err = hex.DecodeString("a2" + "6474797065" + "63667574" + "6873656e6465724964" + "6161")
+	require.NoError(t, err)
+	msg, err = repo.DecodeMessage(b)
+	require.NoError(t, err)
+	require.Equal(t, &repo.Message{Type: "fut", SenderID: "a"}, msg)
+
+	for _, bad := range []string{
+		"",
+		"a0",                            // no type
+		"a1647479706501",                // type is not a string
+		"a16474797065647379",            // truncated
+		"a264747970656473796e63ff",      // break in a definite map
+		"a16474797065647379" + "6e6300", // trailing bytes
+		"7b7fffffffffffffff",            // huge length
+	} {
+		b, err := hex.DecodeString(bad)
+		require.NoError(t, err)
+		_, err = repo.DecodeMessage(b)
+		require.Error(t, err, bad)
+	}
+
+	_, err = repo.DecodeMessage([]byte{0xa2, 0x64, 't', 'y', 'p', 'e', 0x64, 's', 'y', 'n', 'c', 0x61, 'x', 0x01})
+	require.EqualError(t, err, "repo: invalid message: missing senderId")
+}
+
+func TestConnAdapter(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	server := newRepo(t, repo.Options{PeerID: "server"})
+	client := newRepo(t, repo.Options{PeerID: "client"})
+
+	a, b := net.Pipe()
+	sc := repo.NewConnAdapter(repo.NewStreamTransport(a), repo.ConnOptions{Server: true, PeerMetadata: repo.PeerMetadata{StorageID: "s"}})
+	cc := repo.NewConnAdapter(repo.NewStreamTransport(b), repo.ConnOptions{PeerMetadata: repo.PeerMetadata{IsEphemeral: true}})
+	require.NoError(t, server.Connect(sc))
+	require.NoError(t, client.Connect(cc))
+
+	require.Eventually(t, func() bool { return len(server.Peers()) == 1 && len(client.Peers()) == 1 }, time.Second, time.Millisecond)
+	peer, meta := cc.RemotePeer()
+	require.Equal(t, repo.PeerID("server"), peer)
+	require.Equal(t, &repo.PeerMetadata{StorageID: "s"}, meta)
+	peer, meta = sc.RemotePeer()
+	require.Equal(t, repo.PeerID("client"), peer)
+	require.Equal(t, &repo.PeerMetadata{IsEphemeral: true}, meta)
+
+	h, err := server.Create()
+	require.NoError(t, err)
+	require.NoError(t, h.Doc().Path("title").Set("hello"))
+	_, err = h.Doc().Commit("set title")
+	require.NoError(t, err)
+
+	h2, err := client.Find(ctx, h.ID())
+	require.NoError(t, err)
+	eventually(t, h2.Doc(), "title", "hello")
+
+	_, err = client.Find(ctx, repo.NewDocumentID())
+	require.ErrorIs(t, err, repo.ErrUnavailable)
+
+	require.NoError(t, cc.Close())
+	<-sc.Done()
+	<-cc.Done()
+	require.NoError(t, cc.Err())
+	require.Eventually(t, func() bool { return len(server.Peers()) == 0 }, time.Second, time.Millisecond)
+}
+
+func TestConnAdapter_Handshake(t *testing.T) {
+	a, b := net.Pipe()
+	sc := repo.NewConnAdapter(repo.NewStreamTransport(a), repo.ConnOptions{Server: true})
+	server := newRepo(t, repo.Options{PeerID: "server"})
+	require.NoError(t, server.Connect(sc))
+
+	tr := repo.NewStreamTransport(b)
+	enc, err := repo.EncodeMessage(&repo.Message{Type: repo.MessageJoin, SenderID: "client", SupportedProtocolVersions: []string{"2"}})
+	require.NoError(t, err)
+	require.NoError(t, tr.WriteMessage(enc))
+
+	reply, err := tr.ReadMessage()
+	require.NoError(t, err)
+	msg, err := repo.DecodeMessage(reply)
+	require.NoError(t, err)
+	require.Equal(t, repo.MessageError, msg.Type)
+
+	<-sc.Done()
+	require.EqualError(t, sc.Err(), `repo: unsupported protocol versions ["2"]`)
+}
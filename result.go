@@ -13,22 +13,49 @@ import "C"
 import (
 	"fmt"
 	"runtime"
+	"sync/atomic"
 
 	_ "github.com/automerge/automerge-go/deps"
 )
 
+// liveResults counts the results that have not yet been freed
+var liveResults atomic.Int64
+
 // result wraps an AMresult, and arranges for it to be AMfree'd after
-// the result is garbage collected.
+// the result is garbage collected, or when the last [Doc], [Change] or
+// [SyncState] that owns it is closed.
 type result struct {
 	cResult *C.AMresult
+	owners  atomic.Int32
 }
 
 func wrap(r *C.AMresult) *result {
-	ret := &result{r}
-	runtime.SetFinalizer(ret, func(*result) { C.AMresultFree(r) })
+	ret := &result{cResult: r}
+	liveResults.Add(1)
+	runtime.SetFinalizer(ret, (*result).free)
 	return ret
 }
 
+// retain records that a Doc, Change or SyncState owns the result
+func (r *result) retain() {
+	r.owners.Add(1)
+}
+
+// release frees the result once all of its owners have been closed.
+// Nothing may use the result (or its items) after it is freed.
+func (r *result) release() {
+	if r.owners.Add(-1) == 0 {
+		r.free()
+	}
+}
+
+func (r *result) free() {
+	runtime.SetFinalizer(r, nil)
+	C.AMresultFree(r.cResult)
+	r.cResult = nil
+	liveResults.Add(-1)
+}
+
 func (r *result) void() error {
 	item, err := r.item()
 	if err != nil {
@@ -69,7 +96,7 @@ func (r *result) items() ([]*item, error) {
 		return ret, nil
 	case C.AM_STATUS_ERROR:
 		msg := fromByteSpanStr(C.AMresultError(r.cResult))
-		return nil, fmt.Errorf(msg)
+		return nil, coreError(msg)
 	case C.AM_STATUS_INVALID_RESULT:
 		return nil, fmt.Errorf("automerge: invalid result")
 	default:
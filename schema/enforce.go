@@ -0,0 +1,31 @@
+package schema
+
+import "github.com/automerge/automerge-go"
+
+// Enforce validates d against s whenever it changes.
+//
+// Before each local change is committed (either by [automerge.Doc.Commit] or
+// implicitly, for example by [automerge.Doc.Save]) the document is validated, and
+// if it does not match the schema the pending local operations are rolled back and
+// the method returns the [*ValidationError].
+//
+// Changes from other peers cannot be rejected (they have already been made), so after
+// remote changes are applied the document is validated and, if it does not match,
+// onRemote is called with the error. onRemote may be nil.
+//
+// The returned function stops enforcing the schema.
+func Enforce(d *automerge.Doc, s *Schema, onRemote func(err error)) (remove func()) {
+	return d.AddHooks(automerge.Hooks{
+		BeforeCommit: func(d *automerge.Doc) error {
+			return s.Validate(d)
+		},
+		AfterApply: func(d *automerge.Doc, before []automerge.ChangeHash) {
+			if onRemote == nil {
+				return
+			}
+			if err := s.Validate(d); err != nil {
+				onRemote(err)
+			}
+		},
+	})
+}
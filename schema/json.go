@@ -0,0 +1,145 @@
+package schema
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+type jsonSchema struct {
+	Type                 json.RawMessage        `json:"type"`
+	Properties           map[string]*jsonSchema `json:"properties"`
+	Required             []string               `json:"required"`
+	AdditionalProperties json.RawMessage        `json:"additionalProperties"`
+	Items                *jsonSchema            `json:"items"`
+	MinItems             *int                   `json:"minItems"`
+	MaxItems             *int                   `json:"maxItems"`
+	MinLength            *int                   `json:"minLength"`
+	MaxLength            *int                   `json:"maxLength"`
+	Pattern              *string                `json:"pattern"`
+	Minimum              *float64               `json:"minimum"`
+	Maximum              *float64               `json:"maximum"`
+	Enum                 []any                  `json:"enum"`
+	Const                json.RawMessage        `json:"const"`
+	AnyOf                []*jsonSchema          `json:"anyOf"`
+}
+
+// FromJSON loads a schema from its JSON Schema representation.
+//
+// The supported keywords are type, properties, required, additionalProperties,
+// items, minItems, maxItems, minLength, maxLength, pattern, minimum, maximum,
+// enum, const and anyOf. Other keywords (such as $schema, title or description)
+// are ignored. In addition to the JSON Schema types, type may be one of the
+// automerge specific types "text", "counter", "bytes" or "timestamp".
+func FromJSON(b []byte) (*Schema, error) {
+	js := &jsonSchema{}
+	if err := json.Unmarshal(b, js); err != nil {
+		return nil, fmt.Errorf("schema.FromJSON: %w", err)
+	}
+	s, err := js.convert("#")
+	if err != nil {
+		return nil, fmt.Errorf("schema.FromJSON: %w", err)
+	}
+	return s, nil
+}
+
+func (js *jsonSchema) convert(at string) (*Schema, error) {
+	s := &Schema{
+		Required:  js.Required,
+		MinItems:  js.MinItems,
+		MaxItems:  js.MaxItems,
+		MinLength: js.MinLength,
+		MaxLength: js.MaxLength,
+		Minimum:   js.Minimum,
+		Maximum:   js.Maximum,
+		Enum:      js.Enum,
+	}
+
+	if len(js.Type) > 0 {
+		var one Type
+		if err := json.Unmarshal(js.Type, &one); err == nil {
+			s.Types = []Type{one}
+		} else if err := json.Unmarshal(js.Type, &s.Types); err != nil {
+			return nil, fmt.Errorf("%s/type: expected a string or an array of strings", at)
+		}
+		for _, t := range s.Types {
+			if !knownTypes[t] {
+				return nil, fmt.Errorf("%s/type: unknown type %q", at, t)
+			}
+		}
+	}
+
+	if len(js.Const) > 0 {
+		var c any
+		if err := json.Unmarshal(js.Const, &c); err != nil {
+			return nil, fmt.Errorf("%s/const: %w", at, err)
+		}
+		s.Enum = []any{c}
+	}
+
+	if js.Pattern != nil {
+		re, err := regexp.Compile(*js.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("%s/pattern: %w", at, err)
+		}
+		s.Pattern = re
+	}
+
+	if js.Properties != nil {
+		s.Properties = map[string]*Schema{}
+		for k, v := range js.Properties {
+			sub, err := v.convert(at + "/properties/" + k)
+			if err != nil {
+				return nil, err
+			}
+			s.Properties[k] = sub
+		}
+	}
+
+	if len(js.AdditionalProperties) > 0 {
+		var allowed bool
+		if err := json.Unmarshal(js.AdditionalProperties, &allowed); err == nil {
+			s.Strict = !allowed
+		} else {
+			sub := &jsonSchema{}
+			if err := json.Unmarshal(js.AdditionalProperties, sub); err != nil {
+				return nil, fmt.Errorf("%s/additionalProperties: expected a bool or a schema", at)
+			}
+			if s.AdditionalProperties, err = sub.convert(at + "/additionalProperties"); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if js.Items != nil {
+		sub, err := js.Items.convert(at + "/items")
+		if err != nil {
+			return nil, err
+		}
+		s.Items = sub
+	}
+
+	for i, alt := range js.AnyOf {
+		sub, err := alt.convert(fmt.Sprintf("%s/anyOf/%d", at, i))
+		if err != nil {
+			return nil, err
+		}
+		s.AnyOf = append(s.AnyOf, sub)
+	}
+
+	return s, nil
+}
+
+var knownTypes = map[Type]bool{
+	TypeObject:    true,
+	TypeArray:     true,
+	TypeString:    true,
+	TypeNumber:    true,
+	TypeInteger:   true,
+	TypeBoolean:   true,
+	TypeNull:      true,
+	TypeText:      true,
+	TypeCounter:   true,
+	TypeBytes:     true,
+	TypeTimestamp: true,
+}
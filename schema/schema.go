@@ -0,0 +1,491 @@
+// Package schema validates the shape of automerge documents.
+//
+// A [Schema] can be defined in go, or loaded from a subset of [JSON Schema]
+// with [FromJSON]. You can validate a document (or part of it) on demand:
+//
+//	err := s.Validate(doc)
+//
+// or use [Enforce] to validate it automatically, rejecting local commits that
+// would leave the document in an invalid state and reporting remote changes that do so.
+//
+// [JSON Schema]: https://json-schema.org
+package schema
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/automerge/automerge-go"
+)
+
+// Type is the type of a value in the document.
+// The JSON Schema types are supported, in addition to some automerge specific types.
+type Type string
+
+const (
+	// TypeObject matches an [automerge.Map]
+	TypeObject Type = "object"
+	// TypeArray matches an [automerge.List]
+	TypeArray Type = "array"
+	// TypeString matches a string or an [automerge.Text]
+	TypeString Type = "string"
+	// TypeNumber matches any float64, int64, uint64 or [automerge.Counter]
+	TypeNumber Type = "number"
+	// TypeInteger matches any int64, uint64 or [automerge.Counter],
+	// or a float64 with no fractional part
+	TypeInteger Type = "integer"
+	// TypeBoolean matches a bool
+	TypeBoolean Type = "boolean"
+	// TypeNull matches an explicit null
+	TypeNull Type = "null"
+	// TypeText matches an [automerge.Text]
+	TypeText Type = "text"
+	// TypeCounter matches an [automerge.Counter]
+	TypeCounter Type = "counter"
+	// TypeBytes matches a []byte
+	TypeBytes Type = "bytes"
+	// TypeTimestamp matches a time.Time
+	TypeTimestamp Type = "timestamp"
+)
+
+// Schema describes the allowed values at a position in the document.
+// The zero Schema allows any value. Each constraint only applies to values
+// for which it makes sense (for example MinLength applies to strings and text,
+// and is ignored for numbers).
+type Schema struct {
+	// Types lists the allowed types. If empty any type is allowed.
+	Types []Type
+
+	// Properties gives the schema for keys of a map.
+	Properties map[string]*Schema
+	// Required lists the keys that must be present in a map.
+	Required []string
+	// AdditionalProperties is the schema for keys of a map
+	// that are not listed in Properties. If nil, any value is allowed.
+	AdditionalProperties *Schema
+	// Strict disallows keys of a map that are not listed in Properties.
+	Strict bool
+
+	// Items is the schema for each item in a list.
+	Items *Schema
+	// MinItems and MaxItems constrain the length of a list.
+	MinItems, MaxItems *int
+
+	// MinLength and MaxLength constrain the length of a string in
+	// unicode codepoints.
+	MinLength, MaxLength *int
+	// Pattern is a regular expression that strings must match.
+	Pattern *regexp.Regexp
+
+	// Minimum and Maximum constrain numbers (inclusively).
+	Minimum, Maximum *float64
+
+	// Enum lists the allowed values. Numbers compare numerically,
+	// and text compares equal to the corresponding string.
+	Enum []any
+
+	// AnyOf requires that the value matches at least one of the schemas.
+	AnyOf []*Schema
+}
+
+// Any returns a schema that allows any value
+func Any() *Schema { return &Schema{} }
+
+// String returns a schema that allows strings and [automerge.Text]
+func String() *Schema { return &Schema{Types: []Type{TypeString}} }
+
+// Text returns a schema that allows only [automerge.Text]
+func Text() *Schema { return &Schema{Types: []Type{TypeText}} }
+
+// Number returns a schema that allows any number
+func Number() *Schema { return &Schema{Types: []Type{TypeNumber}} }
+
+// Integer returns a schema that allows integers
+func Integer() *Schema { return &Schema{Types: []Type{TypeInteger}} }
+
+// Counter returns a schema that allows only [automerge.Counter]
+func Counter() *Schema { return &Schema{Types: []Type{TypeCounter}} }
+
+// Boolean returns a schema that allows bools
+func Boolean() *Schema { return &Schema{Types: []Type{TypeBoolean}} }
+
+// Bytes returns a schema that allows []byte
+func Bytes() *Schema { return &Schema{Types: []Type{TypeBytes}} }
+
+// Timestamp returns a schema that allows time.Time
+func Timestamp() *Schema { return &Schema{Types: []Type{TypeTimestamp}} }
+
+// Object returns a schema for a map with the given properties,
+// of which the listed ones are required.
+func Object(properties map[string]*Schema, required ...string) *Schema {
+	return &Schema{Types: []Type{TypeObject}, Properties: properties, Required: required}
+}
+
+// MapOf returns a schema for a map where every value matches values
+func MapOf(values *Schema) *Schema {
+	return &Schema{Types: []Type{TypeObject}, AdditionalProperties: values}
+}
+
+// Array returns a schema for a list where every item matches items
+func Array(items *Schema) *Schema {
+	return &Schema{Types: []Type{TypeArray}, Items: items}
+}
+
+// Nullable returns a copy of the schema that also allows null
+func (s *Schema) Nullable() *Schema {
+	ret := *s
+	if len(ret.Types) > 0 {
+		ret.Types = append(append([]Type{}, s.Types...), TypeNull)
+	}
+	return &ret
+}
+
+// FieldError describes a single value that did not match the schema
+type FieldError struct {
+	Path    *automerge.Path
+	Message string
+}
+
+// Error returns the path and the problem
+func (e *FieldError) Error() string {
+	p := e.Path.String()
+	if p == "" {
+		p = "<root>"
+	}
+	return p + ": " + e.Message
+}
+
+// ValidationError is returned when a document does not match a schema.
+// It lists every problem found.
+type ValidationError struct {
+	Fields []*FieldError
+}
+
+// Error summarizes the validation errors
+func (e *ValidationError) Error() string {
+	msgs := []string{}
+	for _, f := range e.Fields {
+		msgs = append(msgs, f.Error())
+	}
+	return "schema: " + strings.Join(msgs, "; ")
+}
+
+// Validate checks the entire document against the schema.
+// It returns a [*ValidationError] if the document does not match.
+func (s *Schema) Validate(d *automerge.Doc) error {
+	return s.ValidatePath(d.Path())
+}
+
+// ValidatePath checks the value at p against the schema.
+// It returns a [*ValidationError] if the value does not match.
+func (s *Schema) ValidatePath(p *automerge.Path) error {
+	v, err := p.Get()
+	if err != nil {
+		return err
+	}
+	return s.validateAt(p, v)
+}
+
+// ValidateValue checks v against the schema.
+// It returns a [*ValidationError] if the value does not match,
+// the paths in the error are relative to v.
+func (s *Schema) ValidateValue(v *automerge.Value) error {
+	return s.validateAt(must(automerge.NewPath()), v)
+}
+
+func (s *Schema) validateAt(p *automerge.Path, v *automerge.Value) error {
+	ve := &ValidationError{}
+	if err := s.validate(p, v, ve); err != nil {
+		return err
+	}
+	if len(ve.Fields) > 0 {
+		return ve
+	}
+	return nil
+}
+
+func (ve *ValidationError) add(p *automerge.Path, msg string, args ...any) {
+	ve.Fields = append(ve.Fields, &FieldError{Path: p, Message: fmt.Sprintf(msg, args...)})
+}
+
+func (s *Schema) validate(p *automerge.Path, v *automerge.Value, ve *ValidationError) error {
+	if v.IsVoid() {
+		ve.add(p, "value is missing")
+		return nil
+	}
+
+	if len(s.Types) > 0 && !s.allowsType(v) {
+		ve.add(p, "expected %s, got %s", typeList(s.Types), typeOf(v))
+		return nil
+	}
+
+	if len(s.AnyOf) > 0 {
+		matched := false
+		for _, alt := range s.AnyOf {
+			sub := &ValidationError{}
+			if err := alt.validate(p, v, sub); err != nil {
+				return err
+			}
+			if len(sub.Fields) == 0 {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			ve.add(p, "does not match any of the allowed schemas")
+		}
+	}
+
+	if len(s.Enum) > 0 {
+		x, err := enumValue(v)
+		if err != nil {
+			return err
+		}
+		found := false
+		for _, e := range s.Enum {
+			if equal(x, normalizeEnum(e)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			ve.add(p, "value %#v is not one of %v", x, s.Enum)
+		}
+	}
+
+	switch v.Kind() {
+	case automerge.KindMap:
+		return s.validateMap(p, v.Map(), ve)
+	case automerge.KindList:
+		return s.validateList(p, v.List(), ve)
+	case automerge.KindStr:
+		s.validateString(p, v.Str(), ve)
+	case automerge.KindText:
+		str, err := v.Text().Get()
+		if err != nil {
+			return err
+		}
+		s.validateString(p, str, ve)
+	case automerge.KindFloat64, automerge.KindInt64, automerge.KindUint64, automerge.KindCounter:
+		x, err := enumValue(v)
+		if err != nil {
+			return err
+		}
+		f := x.(float64)
+		if s.Minimum != nil && f < *s.Minimum {
+			ve.add(p, "%v is less than the minimum %v", f, *s.Minimum)
+		}
+		if s.Maximum != nil && f > *s.Maximum {
+			ve.add(p, "%v is greater than the maximum %v", f, *s.Maximum)
+		}
+	}
+	return nil
+}
+
+func (s *Schema) validateMap(p *automerge.Path, m *automerge.Map, ve *ValidationError) error {
+	values, err := m.Values()
+	if err != nil {
+		return err
+	}
+	for _, k := range s.Required {
+		if _, ok := values[k]; !ok {
+			ve.add(p.Path(k), "required value is missing")
+		}
+	}
+	for _, k := range sortedKeys(values) {
+		sub, ok := s.Properties[k]
+		if !ok {
+			if s.Strict {
+				ve.add(p.Path(k), "unexpected key")
+				continue
+			}
+			sub = s.AdditionalProperties
+		}
+		if sub == nil {
+			continue
+		}
+		if err := sub.validate(p.Path(k), values[k], ve); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Schema) validateList(p *automerge.Path, l *automerge.List, ve *ValidationError) error {
+	values, err := l.Values()
+	if err != nil {
+		return err
+	}
+	if s.MinItems != nil && len(values) < *s.MinItems {
+		ve.add(p, "list has %d items, expected at least %d", len(values), *s.MinItems)
+	}
+	if s.MaxItems != nil && len(values) > *s.MaxItems {
+		ve.add(p, "list has %d items, expected at most %d", len(values), *s.MaxItems)
+	}
+	if s.Items == nil {
+		return nil
+	}
+	for i, v := range values {
+		if err := s.Items.validate(p.Path(i), v, ve); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Schema) validateString(p *automerge.Path, str string, ve *ValidationError) {
+	n := utf8.RuneCountInString(str)
+	if s.MinLength != nil && n < *s.MinLength {
+		ve.add(p, "string has length %d, expected at least %d", n, *s.MinLength)
+	}
+	if s.MaxLength != nil && n > *s.MaxLength {
+		ve.add(p, "string has length %d, expected at most %d", n, *s.MaxLength)
+	}
+	if s.Pattern != nil && !s.Pattern.MatchString(str) {
+		ve.add(p, "string %q does not match pattern %s", str, s.Pattern)
+	}
+}
+
+func (s *Schema) allowsType(v *automerge.Value) bool {
+	for _, t := range s.Types {
+		if typeMatches(t, v) {
+			return true
+		}
+	}
+	return false
+}
+
+func typeMatches(t Type, v *automerge.Value) bool {
+	switch t {
+	case TypeObject:
+		return v.Kind() == automerge.KindMap
+	case TypeArray:
+		return v.Kind() == automerge.KindList
+	case TypeString:
+		return v.Kind() == automerge.KindStr || v.Kind() == automerge.KindText
+	case TypeText:
+		return v.Kind() == automerge.KindText
+	case TypeNumber:
+		switch v.Kind() {
+		case automerge.KindFloat64, automerge.KindInt64, automerge.KindUint64, automerge.KindCounter:
+			return true
+		}
+	case TypeInteger:
+		switch v.Kind() {
+		case automerge.KindInt64, automerge.KindUint64, automerge.KindCounter:
+			return true
+		case automerge.KindFloat64:
+			f := v.Float64()
+			return f == math.Trunc(f) && !math.IsInf(f, 0)
+		}
+	case TypeCounter:
+		return v.Kind() == automerge.KindCounter
+	case TypeBoolean:
+		return v.Kind() == automerge.KindBool
+	case TypeNull:
+		return v.Kind() == automerge.KindNull
+	case TypeBytes:
+		return v.Kind() == automerge.KindBytes
+	case TypeTimestamp:
+		return v.Kind() == automerge.KindTime
+	}
+	return false
+}
+
+func typeOf(v *automerge.Value) Type {
+	switch v.Kind() {
+	case automerge.KindMap:
+		return TypeObject
+	case automerge.KindList:
+		return TypeArray
+	case automerge.KindStr:
+		return TypeString
+	case automerge.KindText:
+		return TypeText
+	case automerge.KindFloat64, automerge.KindInt64, automerge.KindUint64:
+		return TypeNumber
+	case automerge.KindCounter:
+		return TypeCounter
+	case automerge.KindBool:
+		return TypeBoolean
+	case automerge.KindNull:
+		return TypeNull
+	case automerge.KindBytes:
+		return TypeBytes
+	case automerge.KindTime:
+		return TypeTimestamp
+	}
+	return Type(v.Kind().String())
+}
+
+func typeList(ts []Type) string {
+	strs := []string{}
+	for _, t := range ts {
+		strs = append(strs, string(t))
+	}
+	return strings.Join(strs, " or ")
+}
+
+// enumValue converts v into a go value that can be compared with an
+// enum value: numbers become float64 and text becomes a string.
+// Maps and lists never compare equal to an enum value.
+func enumValue(v *automerge.Value) (any, error) {
+	switch v.Kind() {
+	case automerge.KindFloat64:
+		return v.Float64(), nil
+	case automerge.KindInt64:
+		return float64(v.Int64()), nil
+	case automerge.KindUint64:
+		return float64(v.Uint64()), nil
+	case automerge.KindCounter:
+		return automerge.As[float64](v)
+	case automerge.KindText:
+		return v.Text().Get()
+	case automerge.KindMap, automerge.KindList:
+		return struct{}{}, nil
+	}
+	return v.TryInterface()
+}
+
+func normalizeEnum(e any) any {
+	rv := reflect.ValueOf(e)
+	switch {
+	case rv.CanInt():
+		return float64(rv.Int())
+	case rv.CanUint():
+		return float64(rv.Uint())
+	case rv.CanFloat():
+		return rv.Float()
+	}
+	return e
+}
+
+func equal(a, b any) bool {
+	if ab, ok := a.([]byte); ok {
+		bb, ok := b.([]byte)
+		return ok && bytes.Equal(ab, bb)
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func sortedKeys[T any](m map[string]T) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func must[T any](r T, e error) T {
+	if e != nil {
+		panic(e)
+	}
+	return r
+}
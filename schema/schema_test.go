@@ -0,0 +1,131 @@
+package schema_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/automerge/automerge-go"
+	"github.com/automerge/automerge-go/schema"
+	"github.com/stretchr/testify/require"
+)
+
+var taskSchema = schema.Object(map[string]*schema.Schema{
+	"tasks": schema.Array(schema.Object(map[string]*schema.Schema{
+		"title":    schema.String(),
+		"done":     schema.Boolean(),
+		"priority": {Types: []schema.Type{schema.TypeInteger}, Enum: []any{1, 2, 3}},
+	}, "title", "done")),
+}, "tasks")
+
+func TestSchema_Validate(t *testing.T) {
+	doc := automerge.New()
+	require.NoError(t, doc.Path("tasks").Set([]map[string]any{
+		{"title": "a", "done": true, "priority": 1},
+		{"title": automerge.NewText("b"), "done": false},
+	}))
+	require.NoError(t, taskSchema.Validate(doc))
+
+	require.NoError(t, doc.Path("tasks", 1, "done").Set("no"))
+	require.NoError(t, doc.Path("tasks", 0, "priority").Set(5))
+	require.NoError(t, doc.Path("tasks", 0, "title").Delete())
+
+	err := taskSchema.Validate(doc)
+	ve := &schema.ValidationError{}
+	require.True(t, errors.As(err, &ve))
+	require.Len(t, ve.Fields, 3)
+	require.Equal(t, []any{"tasks", 0, "title"}, ve.Fields[0].Path.Segments())
+	require.EqualError(t, err, "schema: tasks[0].title: required value is missing; "+
+		"tasks[0].priority: value 5 is not one of [1 2 3]; "+
+		"tasks[1].done: expected boolean, got string")
+
+	require.NoError(t, schema.Boolean().ValidatePath(doc.Path("tasks", 0, "done")))
+
+	v, err := doc.Path("tasks").Get()
+	require.NoError(t, err)
+	err = schema.Array(schema.Object(nil, "done")).ValidateValue(v)
+	require.NoError(t, err)
+	err = schema.Array(schema.Object(nil, "owner")).ValidateValue(v)
+	require.EqualError(t, err, "schema: [0].owner: required value is missing; [1].owner: required value is missing")
+}
+
+func TestFromJSON(t *testing.T) {
+	s, err := schema.FromJSON([]byte(`{
+		"$schema": "https://json-schema.org/draft/2020-12/schema",
+		"type": "object",
+		"properties": {
+			"name": {"type": "string", "minLength": 1, "pattern": "^[a-z]+$"},
+			"age": {"type": ["integer", "null"], "minimum": 0},
+			"tags": {"type": "array", "items": {"type": "string"}, "maxItems": 2},
+			"kind": {"const": "person"},
+			"visits": {"type": "counter"}
+		},
+		"required": ["name"],
+		"additionalProperties": false
+	}`))
+	require.NoError(t, err)
+
+	doc := automerge.New()
+	require.NoError(t, doc.Path("name").Set("ada"))
+	require.NoError(t, doc.Path("age").Set(nil))
+	require.NoError(t, doc.Path("tags").Set([]string{"x"}))
+	require.NoError(t, doc.Path("kind").Set("person"))
+	require.NoError(t, doc.Path("visits").Set(automerge.NewCounter(1)))
+	require.NoError(t, s.Validate(doc))
+
+	require.NoError(t, doc.Path("name").Set("Ada"))
+	require.NoError(t, doc.Path("age").Set(-1.5))
+	require.NoError(t, doc.Path("tags").Set([]string{"x", "y", "z"}))
+	require.NoError(t, doc.Path("kind").Set("robot"))
+	require.NoError(t, doc.Path("extra").Set(true))
+	require.EqualError(t, s.Validate(doc), "schema: "+
+		"age: expected integer or null, got number; "+
+		"extra: unexpected key; "+
+		`kind: value "robot" is not one of [person]; `+
+		`name: string "Ada" does not match pattern ^[a-z]+$; `+
+		"tags: list has 3 items, expected at most 2")
+
+	_, err = schema.FromJSON([]byte(`{"properties": {"x": {"type": "date"}}}`))
+	require.EqualError(t, err, `schema.FromJSON: #/properties/x/type: unknown type "date"`)
+}
+
+func TestEnforce(t *testing.T) {
+	doc := automerge.New()
+	remote := []error{}
+	remove := schema.Enforce(doc, taskSchema, func(err error) { remote = append(remote, err) })
+
+	require.NoError(t, doc.Path("tasks").Set([]map[string]any{{"title": "a", "done": false}}))
+	_, err := doc.Commit("valid")
+	require.NoError(t, err)
+
+	require.NoError(t, doc.Path("tasks", 0, "done").Set("maybe"))
+	_, err = doc.Commit("invalid")
+	require.EqualError(t, err, "schema: tasks[0].done: expected boolean, got string")
+
+	done, err := automerge.As[bool](doc.Path("tasks", 0, "done").Get())
+	require.NoError(t, err)
+	require.False(t, done)
+
+	// implicit commits are validated too
+	require.NoError(t, doc.Path("tasks", 0, "title").Set(1))
+	_, err = doc.TrySave()
+	require.EqualError(t, err, "schema: tasks[0].title: expected string, got number")
+	title, err := automerge.As[string](doc.Path("tasks", 0, "title").Get())
+	require.NoError(t, err)
+	require.Equal(t, "a", title)
+
+	other, err := doc.Fork()
+	require.NoError(t, err)
+	require.NoError(t, other.Path("tasks", 0, "done").Set(7))
+	_, err = other.Commit("remote invalid")
+	require.NoError(t, err)
+
+	_, err = doc.Merge(other)
+	require.NoError(t, err)
+	require.Len(t, remote, 1)
+	require.EqualError(t, remote[0], "schema: tasks[0].done: expected boolean, got number")
+
+	remove()
+	require.NoError(t, doc.Path("tasks").Set("whatever"))
+	_, err = doc.Commit("unchecked")
+	require.NoError(t, err)
+}
@@ -0,0 +1,392 @@
+package automerge
+
+// #include "automerge.h"
+import "C"
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Signer signs changes as they are committed, see [Doc.SetSigner]
+type Signer interface {
+	// Sign returns a signature for the change with the given hash
+	Sign(ch ChangeHash) ([]byte, error)
+}
+
+// Verifier checks changes received from other peers before they are applied,
+// see [Doc.SetVerifier].
+type Verifier interface {
+	// Verify returns an error if the change should be rejected. sig is the
+	// signature received for the change, or nil if there is none.
+	Verify(ch *Change, sig []byte) error
+}
+
+// SetSigner configures the document to sign each local change, whether it is
+// created by [Doc.Commit] or implicitly (for example by calling [Doc.Save]
+// with uncommitted operations). The signer is called with the document
+// locked, and if it fails the change is discarded. Signatures are stored
+// alongside the document (not in the changes themselves), see [Doc.Signatures].
+// They are sent to peers by [SyncConn], [SyncMux], [SyncHub] and httpsync
+// (see [SyncMessage.SignedBytes]) and saved by [Journal], but [Doc.Save]
+// does not include them: use [Doc.SaveSignatures] to save them.
+func (d *Doc) SetSigner(s Signer) {
+	d.m.Lock()
+	defer d.m.Unlock()
+	d.signer = s
+}
+
+// SetVerifier configures the document to verify every change received by
+// [Doc.Apply], [Doc.LoadIncremental], [Doc.Merge] and [SyncState.ReceiveMessage].
+// If any change is rejected then none of the changes are applied, and the
+// method returns a [*RejectedError]. Changes that are already in the document are
+// not verified again.
+func (d *Doc) SetVerifier(v Verifier) {
+	d.m.Lock()
+	defer d.m.Unlock()
+	d.verifier = v
+}
+
+// Limits on the signatures that a document keeps for changes that it does not
+// yet have, so that a peer cannot make it use unbounded memory.
+const (
+	maxSignatureSize         = 1024
+	maxSignatureCandidates   = 4
+	maxPendingSignatureBytes = 1 << 20
+)
+
+// Signatures returns the stored signatures for the given changes, or for all
+// changes if none are given. Signatures are sent to peers automatically with
+// the changes in each [SyncMessage], and can be saved with [Doc.SaveSignatures].
+func (d *Doc) Signatures(hashes ...ChangeHash) map[ChangeHash][]byte {
+	d.m.Lock()
+	defer d.m.Unlock()
+	ret := map[ChangeHash][]byte{}
+	if len(hashes) == 0 {
+		for h, sig := range d.signatures {
+			ret[h] = sig
+		}
+		return ret
+	}
+	for _, h := range hashes {
+		if sig, ok := d.signatures[h]; ok {
+			ret[h] = sig
+		}
+	}
+	return ret
+}
+
+// AddSignatures adds signatures received from another peer.
+//
+// A signature for a change that is already in the document is stored if the
+// [Verifier] (if any) accepts it, and if no signature is stored for the change
+// yet; a stored signature is never replaced. Signatures for changes that are
+// not yet in the document are kept until the change is received, and then
+// checked in the same way. To limit memory use only a few signatures are kept
+// per change, and signatures for changes that are never received are
+// eventually dropped.
+func (d *Doc) AddSignatures(sigs map[ChangeHash][]byte) {
+	for h, sig := range sigs {
+		if len(sig) == 0 || len(sig) > maxSignatureSize {
+			continue
+		}
+		d.m.Lock()
+		_, have := d.signatures[h]
+		v := d.verifier
+		d.m.Unlock()
+		if have {
+			continue
+		}
+
+		ch, err := d.Change(h)
+		if errors.Is(err, ErrUnknownChange) {
+			d.addPendingSignature(h, sig)
+			continue
+		}
+		if err != nil || (v != nil && v.Verify(ch, sig) != nil) {
+			continue
+		}
+		d.m.Lock()
+		d.storeSignatures(map[ChangeHash][]byte{h: sig})
+		d.m.Unlock()
+	}
+}
+
+// addPendingSignature keeps sig until the change h is received
+func (d *Doc) addPendingSignature(h ChangeHash, sig []byte) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	candidates := d.pendingSignatures[h]
+	if len(candidates) >= maxSignatureCandidates {
+		return
+	}
+	for _, c := range candidates {
+		if bytes.Equal(c, sig) {
+			return
+		}
+	}
+	if d.pendingSignatures == nil {
+		d.pendingSignatures = map[ChangeHash][][]byte{}
+	}
+	if len(candidates) == 0 {
+		d.pendingOrder = append(d.pendingOrder, h)
+	}
+	d.pendingSignatures[h] = append(candidates, append([]byte{}, sig...))
+	d.pendingBytes += len(sig)
+
+	for d.pendingBytes > maxPendingSignatureBytes && len(d.pendingOrder) > 0 {
+		d.dropPendingSignatures(d.pendingOrder[0])
+	}
+}
+
+// dropPendingSignatures must be called with the document locked
+func (d *Doc) dropPendingSignatures(h ChangeHash) {
+	candidates, ok := d.pendingSignatures[h]
+	if !ok {
+		return
+	}
+	for _, c := range candidates {
+		d.pendingBytes -= len(c)
+	}
+	delete(d.pendingSignatures, h)
+	for i, o := range d.pendingOrder {
+		if o == h {
+			d.pendingOrder = append(d.pendingOrder[:i:i], d.pendingOrder[i+1:]...)
+			break
+		}
+	}
+}
+
+// signatureCandidates returns the signatures that might be valid for the
+// change h: sig (received with the change) and any pending signatures.
+func (d *Doc) signatureCandidates(h ChangeHash, sig []byte) [][]byte {
+	d.m.Lock()
+	defer d.m.Unlock()
+	ret := [][]byte{}
+	if len(sig) > 0 && len(sig) <= maxSignatureSize {
+		ret = append(ret, sig)
+	}
+	return append(ret, d.pendingSignatures[h]...)
+}
+
+// storeSignatures must be called with the document locked, once the changes
+// that the signatures are for have been applied.
+func (d *Doc) storeSignatures(sigs map[ChangeHash][]byte) {
+	for h, sig := range sigs {
+		d.dropPendingSignatures(h)
+		if _, ok := d.signatures[h]; ok {
+			continue
+		}
+		if d.signatures == nil {
+			d.signatures = map[ChangeHash][]byte{}
+		}
+		d.signatures[h] = append([]byte{}, sig...)
+	}
+}
+
+// SaveSignatures returns the stored signatures encoded with
+// [EncodeSignatures], so that they can be saved alongside the document.
+func (d *Doc) SaveSignatures() []byte {
+	return EncodeSignatures(d.Signatures())
+}
+
+// LoadSignatures adds the signatures returned by [Doc.SaveSignatures],
+// see [Doc.AddSignatures].
+func (d *Doc) LoadSignatures(b []byte) error {
+	sigs, err := DecodeSignatures(b)
+	if err != nil {
+		return err
+	}
+	d.AddSignatures(sigs)
+	return nil
+}
+
+// sign must be called with the document locked, immediately after the
+// change ch is committed. If the change cannot be signed it is discarded.
+func (d *Doc) sign(cDoc *C.AMdoc, ch ChangeHash) error {
+	if d.signer == nil {
+		return nil
+	}
+	sig, err := d.signer.Sign(ch)
+	if err != nil {
+		err = fmt.Errorf("automerge.Commit: could not sign change %s: %w", ch, err)
+		if derr := d.discard(cDoc, ch); derr != nil {
+			return fmt.Errorf("%w (and the change could not be discarded: %v)", err, derr)
+		}
+		return err
+	}
+	if d.signatures == nil {
+		d.signatures = map[ChangeHash][]byte{}
+	}
+	d.signatures[ch] = sig
+	return nil
+}
+
+// chunkMagic starts every chunk in the automerge binary format
+var chunkMagic = []byte{0x85, 0x6f, 0x4a, 0x83}
+
+// splitChanges decodes the changes in the output of [Doc.SaveIncremental],
+// which may contain change chunks, compressed change chunks and document
+// chunks.
+func splitChanges(raw []byte) ([]*Change, error) {
+	ret := []*Change{}
+	for len(raw) > 0 {
+		if len(raw) < 9 || !bytes.Equal(raw[:4], chunkMagic) {
+			return nil, fmt.Errorf("automerge: invalid chunk")
+		}
+		n, l := binary.Uvarint(raw[9:])
+		if l <= 0 || n > uint64(len(raw)-9-l) {
+			return nil, fmt.Errorf("automerge: invalid chunk length")
+		}
+		end := 9 + l + int(n)
+		if raw[8] == 0 {
+			chs, err := LoadChanges(raw[:end])
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, chs...)
+		} else {
+			ch, err := LoadChange(raw[:end])
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, ch)
+		}
+		raw = raw[end:]
+	}
+	return ret, nil
+}
+
+// EncodeSignatures encodes signatures for sending to another peer. Each
+// signature is written as the change hash, its uvarint length and its bytes.
+func EncodeSignatures(sigs map[ChangeHash][]byte) []byte {
+	hashes := make([]ChangeHash, 0, len(sigs))
+	for h := range sigs {
+		hashes = append(hashes, h)
+	}
+	sort.Slice(hashes, func(i, j int) bool { return bytes.Compare(hashes[i][:], hashes[j][:]) < 0 })
+
+	out := []byte{}
+	for _, h := range hashes {
+		out = append(out, h[:]...)
+		out = binary.AppendUvarint(out, uint64(len(sigs[h])))
+		out = append(out, sigs[h]...)
+	}
+	return out
+}
+
+// DecodeSignatures decodes the output of [EncodeSignatures]
+func DecodeSignatures(b []byte) (map[ChangeHash][]byte, error) {
+	ret := map[ChangeHash][]byte{}
+	for len(b) > 0 {
+		if len(b) < 32 {
+			return nil, newError(ErrCorruptDocument, "automerge.DecodeSignatures: invalid encoding")
+		}
+		h := *(*ChangeHash)(b[:32])
+		n, l := binary.Uvarint(b[32:])
+		if l <= 0 || n > uint64(len(b)-32-l) {
+			return nil, newError(ErrCorruptDocument, "automerge.DecodeSignatures: invalid encoding")
+		}
+		start := 32 + l
+		ret[h] = append([]byte{}, b[start:start+int(n)]...)
+		b = b[start+int(n):]
+	}
+	return ret, nil
+}
+
+// signedMessageType starts a sync message that carries signatures. It cannot
+// be confused with a message from the automerge core, which starts with 0x42.
+const signedMessageType = 0x53
+
+// appendSignedMessage returns msg prefixed by the encoded signatures
+func appendSignedMessage(sigs map[ChangeHash][]byte, msg []byte) []byte {
+	enc := EncodeSignatures(sigs)
+	out := []byte{signedMessageType}
+	out = binary.AppendUvarint(out, uint64(len(enc)))
+	out = append(out, enc...)
+	return append(out, msg...)
+}
+
+// splitSignedMessage undoes appendSignedMessage. Messages without signatures
+// are returned unchanged.
+func splitSignedMessage(b []byte) (map[ChangeHash][]byte, []byte, error) {
+	if len(b) == 0 || b[0] != signedMessageType {
+		return nil, b, nil
+	}
+	n, l := binary.Uvarint(b[1:])
+	if l <= 0 || n > uint64(len(b)-1-l) {
+		return nil, nil, newError(ErrCorruptDocument, "automerge.LoadSyncMessage: invalid signatures")
+	}
+	start := 1 + l
+	sigs, err := DecodeSignatures(b[start : start+int(n)])
+	if err != nil {
+		return nil, nil, err
+	}
+	return sigs, b[start+int(n):], nil
+}
+
+// Ed25519Signer signs changes with an ed25519 private key
+type Ed25519Signer struct {
+	Key ed25519.PrivateKey
+}
+
+// Sign implements [Signer]
+func (s Ed25519Signer) Sign(ch ChangeHash) ([]byte, error) {
+	if len(s.Key) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("automerge.Ed25519Signer: invalid key")
+	}
+	return ed25519.Sign(s.Key, ch[:]), nil
+}
+
+// Ed25519Verifier accepts changes signed by the key trusted for the
+// change's actor, and rejects all other changes.
+type Ed25519Verifier struct {
+	m    sync.Mutex
+	keys map[string]ed25519.PublicKey
+}
+
+// NewEd25519Verifier returns a verifier that trusts no keys
+func NewEd25519Verifier() *Ed25519Verifier {
+	return &Ed25519Verifier{keys: map[string]ed25519.PublicKey{}}
+}
+
+// Trust accepts changes by actorID (hex-encoded) that are signed by key
+func (v *Ed25519Verifier) Trust(actorID string, key ed25519.PublicKey) {
+	v.m.Lock()
+	defer v.m.Unlock()
+	v.keys[actorID] = key
+}
+
+// Revoke rejects any further changes by actorID
+func (v *Ed25519Verifier) Revoke(actorID string) {
+	v.m.Lock()
+	defer v.m.Unlock()
+	delete(v.keys, actorID)
+}
+
+// Verify implements [Verifier]
+func (v *Ed25519Verifier) Verify(ch *Change, sig []byte) error {
+	actor, err := ch.TryActorID()
+	if err != nil {
+		return err
+	}
+	v.m.Lock()
+	key, ok := v.keys[actor]
+	v.m.Unlock()
+	if !ok {
+		return fmt.Errorf("automerge.Ed25519Verifier: unknown actor %s", actor)
+	}
+	if sig == nil {
+		return fmt.Errorf("automerge.Ed25519Verifier: change is not signed")
+	}
+	h := ch.Hash()
+	if !ed25519.Verify(key, h[:], sig) {
+		return fmt.Errorf("automerge.Ed25519Verifier: invalid signature")
+	}
+	return nil
+}
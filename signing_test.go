@@ -0,0 +1,293 @@
+package automerge_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/binary"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/automerge/automerge-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSigning(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+
+	signed := automerge.New()
+	signed.SetSigner(automerge.Ed25519Signer{Key: priv})
+	require.NoError(t, signed.Path("x").Set(1))
+	h, err := signed.Commit("x")
+	require.NoError(t, err)
+	require.True(t, ed25519.Verify(pub, h[:], signed.Signatures(h)[h]))
+
+	unsigned := automerge.New()
+	require.NoError(t, unsigned.Path("y").Set(2))
+	_, err = unsigned.Commit("y")
+	require.NoError(t, err)
+
+	newVerified := func() *automerge.Doc {
+		v := automerge.NewEd25519Verifier()
+		v.Trust(signed.ActorID(), pub)
+		v.Trust(unsigned.ActorID(), pub)
+		doc := automerge.New()
+		doc.SetVerifier(v)
+		return doc
+	}
+
+	t.Run("Apply", func(t *testing.T) {
+		doc := newVerified()
+		chs, err := signed.Changes()
+		require.NoError(t, err)
+		require.ErrorContains(t, doc.Apply(chs...), "not signed")
+
+		doc.AddSignatures(signed.Signatures())
+		require.NoError(t, doc.Apply(chs...))
+		require.Equal(t, signed.Heads(), doc.Heads())
+
+		chs, err = unsigned.Changes()
+		require.NoError(t, err)
+		require.Error(t, doc.Apply(chs...))
+		require.Equal(t, signed.Heads(), doc.Heads())
+	})
+
+	t.Run("LoadIncremental", func(t *testing.T) {
+		doc := newVerified()
+		require.Error(t, doc.LoadIncremental(unsigned.Save()))
+		require.Empty(t, doc.Heads())
+
+		doc.AddSignatures(signed.Signatures())
+		require.NoError(t, doc.LoadIncremental(signed.Save()))
+		require.Equal(t, signed.Heads(), doc.Heads())
+	})
+
+	t.Run("Merge", func(t *testing.T) {
+		doc := newVerified()
+		_, err := doc.Merge(unsigned)
+		require.Error(t, err)
+		_, err = doc.Merge(signed)
+		require.NoError(t, err)
+		require.Equal(t, signed.Heads(), doc.Heads())
+	})
+
+	t.Run("ReceiveMessage", func(t *testing.T) {
+		doc := newVerified()
+		ss1 := automerge.NewSyncState(unsigned)
+		ss2 := automerge.NewSyncState(doc)
+		for i := 0; i < 5; i++ {
+			if msg, valid := ss1.GenerateMessage(); valid {
+				if _, err = ss2.ReceiveMessage(msg.Bytes()); err != nil {
+					break
+				}
+			}
+			if msg, valid := ss2.GenerateMessage(); valid {
+				_, err := ss1.ReceiveMessage(msg.Bytes())
+				require.NoError(t, err)
+			}
+		}
+		require.ErrorContains(t, err, "rejected")
+		require.Empty(t, doc.Heads())
+	})
+
+	t.Run("SyncState", func(t *testing.T) {
+		doc := newVerified()
+		ss1 := automerge.NewSyncState(signed)
+		ss2 := automerge.NewSyncState(doc)
+		for i := 0; i < 5; i++ {
+			if msg, valid := ss1.GenerateMessage(); valid {
+				_, err := ss2.ReceiveMessage(msg.SignedBytes())
+				require.NoError(t, err)
+			}
+			if msg, valid := ss2.GenerateMessage(); valid {
+				_, err := ss1.ReceiveMessage(msg.SignedBytes())
+				require.NoError(t, err)
+			}
+		}
+		require.Equal(t, signed.Heads(), doc.Heads())
+		require.Equal(t, signed.Signatures(), doc.Signatures())
+	})
+
+	t.Run("Bytes", func(t *testing.T) {
+		// Bytes is the standard encoding, which other implementations can
+		// read, and only SignedBytes carries the signatures
+		ss1 := automerge.NewSyncState(signed)
+		ss2 := automerge.NewSyncState(automerge.New())
+		sent := false
+		for i := 0; i < 5; i++ {
+			if msg, valid := ss1.GenerateMessage(); valid {
+				require.Equal(t, byte(0x42), msg.Bytes()[0])
+				if len(msg.Signatures()) > 0 {
+					sent = true
+					require.Equal(t, byte(0x53), msg.SignedBytes()[0])
+				}
+				_, err := ss2.ReceiveMessage(msg.Bytes())
+				require.NoError(t, err)
+			}
+			if msg, valid := ss2.GenerateMessage(); valid {
+				_, err := ss1.ReceiveMessage(msg.Bytes())
+				require.NoError(t, err)
+			}
+		}
+		require.True(t, sent)
+		require.Equal(t, signed.Heads(), ss2.Doc.Heads())
+		require.Empty(t, ss2.Doc.Signatures())
+	})
+
+	t.Run("invalid signatures", func(t *testing.T) {
+		doc := newVerified()
+		chs, err := signed.Changes()
+		require.NoError(t, err)
+
+		// a bad signature received first does not prevent a good one
+		doc.AddSignatures(map[automerge.ChangeHash][]byte{h: []byte("forged")})
+		doc.AddSignatures(signed.Signatures())
+		require.NoError(t, doc.Apply(chs...))
+		require.Equal(t, signed.Signatures(), doc.Signatures())
+
+		// and cannot replace it later
+		doc.AddSignatures(map[automerge.ChangeHash][]byte{h: []byte("forged")})
+		require.Equal(t, signed.Signatures(), doc.Signatures())
+
+		// without a verifier, the first signature is kept
+		doc = automerge.New()
+		require.NoError(t, doc.Apply(chs...))
+		doc.AddSignatures(signed.Signatures())
+		doc.AddSignatures(map[automerge.ChangeHash][]byte{h: []byte("forged")})
+		require.Equal(t, signed.Signatures(), doc.Signatures())
+	})
+
+	t.Run("pending limit", func(t *testing.T) {
+		doc := newVerified()
+		chs, err := signed.Changes()
+		require.NoError(t, err)
+
+		// signatures for changes that never arrive are eventually dropped
+		doc.AddSignatures(signed.Signatures())
+		for i := 0; i < 2048; i++ {
+			var unknown automerge.ChangeHash
+			binary.BigEndian.PutUint32(unknown[:], uint32(i))
+			doc.AddSignatures(map[automerge.ChangeHash][]byte{unknown: make([]byte, 1000)})
+		}
+		require.ErrorContains(t, doc.Apply(chs...), "not signed")
+	})
+
+	t.Run("Journal", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "doc")
+		j, err := automerge.OpenJournaled(path)
+		require.NoError(t, err)
+		_, err = j.Doc().Merge(signed)
+		require.NoError(t, err)
+		require.NoError(t, j.Close())
+
+		j, err = automerge.OpenJournaled(path)
+		require.NoError(t, err)
+		require.Equal(t, signed.Signatures(), j.Doc().Signatures())
+		require.NoError(t, j.Checkpoint())
+		require.NoError(t, j.Close())
+
+		j, err = automerge.OpenJournaled(path)
+		require.NoError(t, err)
+		defer j.Close()
+		require.Equal(t, signed.Signatures(), j.Doc().Signatures())
+	})
+
+	t.Run("SyncMux", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		doc := newVerified()
+		c1, c2 := automerge.NewMessagePipe()
+		mux1 := automerge.NewSyncMux(c1)
+		mux2 := automerge.NewSyncMux(c2)
+		require.NoError(t, mux1.Subscribe("doc", signed))
+		require.NoError(t, mux2.Subscribe("doc", doc))
+		go mux1.Run(ctx)
+		go mux2.Run(ctx)
+
+		require.Eventually(t, func() bool {
+			return len(doc.Heads()) == 1 && doc.Heads()[0] == h
+		}, time.Second, 5*time.Millisecond)
+		require.Equal(t, signed.Signatures(), doc.Signatures())
+	})
+}
+
+func TestSignatures_Encoding(t *testing.T) {
+	doc := automerge.New()
+	require.NoError(t, doc.Path("x").Set(1))
+	h, err := doc.Commit("x")
+	require.NoError(t, err)
+
+	sigs := map[automerge.ChangeHash][]byte{h: []byte("sig")}
+	decoded, err := automerge.DecodeSignatures(automerge.EncodeSignatures(sigs))
+	require.NoError(t, err)
+	require.Equal(t, sigs, decoded)
+
+	_, err = automerge.DecodeSignatures([]byte("short"))
+	require.Error(t, err)
+}
+
+type failingSigner struct {
+	fail bool
+	next automerge.Signer
+}
+
+func (s *failingSigner) Sign(ch automerge.ChangeHash) ([]byte, error) {
+	if s.fail {
+		return nil, errors.New("no key")
+	}
+	return s.next.Sign(ch)
+}
+
+func TestSigning_Commit(t *testing.T) {
+	_, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	signer := &failingSigner{next: automerge.Ed25519Signer{Key: priv}}
+
+	doc := automerge.New()
+	actor := doc.ActorID()
+	doc.SetSigner(signer)
+	require.NoError(t, doc.Path("x").Set(1))
+	first, err := doc.Commit("first")
+	require.NoError(t, err)
+
+	// a change that cannot be signed is discarded
+	signer.fail = true
+	require.NoError(t, doc.Path("x").Set(2))
+	_, err = doc.Commit("second")
+	require.ErrorContains(t, err, "no key")
+	require.Equal(t, []automerge.ChangeHash{first}, doc.Heads())
+	require.Equal(t, actor, doc.ActorID())
+	x, err := automerge.As[int](doc.Path("x").Get())
+	require.NoError(t, err)
+	require.Equal(t, 1, x)
+
+	// implicit commits are signed too
+	signer.fail = false
+	require.NoError(t, doc.Path("x").Set(3))
+	saved, err := doc.TrySave()
+	require.NoError(t, err)
+	heads := doc.Heads()
+	require.Len(t, heads, 1)
+	require.NotEqual(t, first, heads[0])
+	require.Len(t, doc.Signatures(), 2)
+	require.Contains(t, doc.Signatures(), heads[0])
+
+	loaded, err := automerge.Load(saved)
+	require.NoError(t, err)
+	require.Equal(t, heads, loaded.Heads())
+
+	// the first change by an actor can be discarded too
+	doc = automerge.New()
+	doc.SetSigner(signer)
+	signer.fail = true
+	require.NoError(t, doc.Path("x").Set(1))
+	_, err = doc.TrySave()
+	require.Error(t, err)
+	require.Empty(t, doc.Heads())
+	v, err := doc.Path("x").Get()
+	require.NoError(t, err)
+	require.Equal(t, automerge.KindVoid, v.Kind())
+}
@@ -0,0 +1,199 @@
+package automerge
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// DefaultMaxSyncMessageSize is the largest message accepted by [SyncConn]
+// unless [SyncConnOptions.MaxMessageSize] is set.
+const DefaultMaxSyncMessageSize = 64 << 20
+
+// SyncConnOptions configure [SyncConn]
+type SyncConnOptions struct {
+	// SyncState to resume syncing from, see [LoadSyncState].
+	// If nil a new SyncState is created.
+	SyncState *SyncState
+	// MaxMessageSize is the largest message that will be read from the peer.
+	// Defaults to [DefaultMaxSyncMessageSize].
+	MaxMessageSize int
+}
+
+// MessageConn sends and receives whole messages, for example
+// a WebSocket connection.
+type MessageConn interface {
+	// ReadMessage returns the next message from the peer, or [io.EOF]
+	// when the peer has disconnected.
+	ReadMessage() ([]byte, error)
+	// WriteMessage sends a message to the peer. It must be safe to call
+	// concurrently with ReadMessage.
+	WriteMessage(msg []byte) error
+}
+
+// SyncConn keeps doc in sync with a peer on the other end of rw until ctx is
+// cancelled or the peer disconnects. The peer should also be running SyncConn
+// (or an equivalent implementation of the framing below).
+//
+// Each sync message is written as a 4-byte big-endian length followed by the
+// bytes of the message. Messages are read and written concurrently so neither
+// side can block the other, and a new message is sent whenever the document
+// changes locally (for example after [Doc.Commit] or [Doc.Merge]).
+//
+// SyncConn returns nil if the peer disconnects cleanly (rw returns [io.EOF]),
+// ctx.Err() if ctx is cancelled, or the first error encountered. If ctx is
+// cancelled while a read is in progress the read is abandoned, so the caller
+// should close rw once SyncConn has returned.
+func SyncConn(ctx context.Context, doc *Doc, rw io.ReadWriter, opts ...SyncConnOptions) error {
+	o := syncConnOptions(opts)
+	return SyncMessageConn(ctx, doc, &framedConn{rw: rw, max: o.MaxMessageSize}, o)
+}
+
+// SyncMessageConn is like [SyncConn], but sends each sync message as a single
+// message on conn with no additional framing. MaxMessageSize is not enforced,
+// conn should limit the size of messages it reads.
+func SyncMessageConn(ctx context.Context, doc *Doc, conn MessageConn, opts ...SyncConnOptions) error {
+	o := syncConnOptions(opts)
+	if o.SyncState == nil {
+		ss, err := TryNewSyncState(doc)
+		if err != nil {
+			return err
+		}
+		o.SyncState = ss
+	}
+	if o.SyncState.Doc != doc {
+		return fmt.Errorf("automerge.SyncConn: SyncState is for a different document")
+	}
+
+	changed := make(chan struct{}, 1)
+	notify := func() {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+	remove := doc.AddHooks(Hooks{
+		AfterCommit: func(*Doc, ChangeHash) { notify() },
+		AfterApply:  func(*Doc, []ChangeHash) { notify() },
+	})
+	defer remove()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type received struct {
+		msg []byte
+		err error
+	}
+	recv := make(chan received)
+	go func() {
+		for {
+			msg, err := conn.ReadMessage()
+			select {
+			case recv <- received{msg, err}:
+			case <-ctx.Done():
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+
+	for {
+		msg, valid, err := o.SyncState.TryGenerateMessage()
+		if err != nil {
+			return err
+		}
+		if valid {
+			b, err := msg.TrySignedBytes()
+			if err != nil {
+				return err
+			}
+			if err := conn.WriteMessage(b); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-changed:
+		case r := <-recv:
+			if r.err == io.EOF {
+				return nil
+			}
+			if r.err != nil {
+				return r.err
+			}
+			if _, err := o.SyncState.ReceiveMessage(r.msg); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func syncConnOptions(opts []SyncConnOptions) SyncConnOptions {
+	o := SyncConnOptions{}
+	for _, opt := range opts {
+		if opt.SyncState != nil {
+			o.SyncState = opt.SyncState
+		}
+		if opt.MaxMessageSize != 0 {
+			o.MaxMessageSize = opt.MaxMessageSize
+		}
+	}
+	if o.MaxMessageSize == 0 {
+		o.MaxMessageSize = DefaultMaxSyncMessageSize
+	}
+	return o
+}
+
+// NewFramedConn returns a [MessageConn] that uses the same framing as
+// [SyncConn] to send messages over rw, for example to run a [SyncMux] over a
+// TCP connection. Only MaxMessageSize is used from opts.
+func NewFramedConn(rw io.ReadWriter, opts ...SyncConnOptions) MessageConn {
+	return &framedConn{rw: rw, max: syncConnOptions(opts).MaxMessageSize}
+}
+
+// framedConn sends length-prefixed messages over a stream
+type framedConn struct {
+	rw  io.ReadWriter
+	max int
+}
+
+func (fc *framedConn) ReadMessage() ([]byte, error) {
+	return readSyncFrame(fc.rw, fc.max)
+}
+
+func (fc *framedConn) WriteMessage(msg []byte) error {
+	return writeSyncFrame(fc.rw, msg)
+}
+
+func writeSyncFrame(w io.Writer, msg []byte) error {
+	buf := make([]byte, 4, 4+len(msg))
+	binary.BigEndian.PutUint32(buf, uint32(len(msg)))
+	_, err := w.Write(append(buf, msg...))
+	return err
+}
+
+func readSyncFrame(r io.Reader, max int) ([]byte, error) {
+	var l [4]byte
+	if _, err := io.ReadFull(r, l[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(l[:])
+	if uint64(n) > uint64(max) {
+		return nil, fmt.Errorf("automerge.SyncConn: message of %d bytes exceeds limit of %d", n, max)
+	}
+	msg := make([]byte, n)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return msg, nil
+}
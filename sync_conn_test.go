@@ -0,0 +1,135 @@
+package automerge_test
+
+import (
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/automerge/automerge-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncConn(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	doc1 := automerge.New()
+	doc2 := automerge.New()
+	require.NoError(t, doc1.Path("a").Set("one"))
+	_, err := doc1.Commit("a")
+	require.NoError(t, err)
+	require.NoError(t, doc2.Path("b").Set("two"))
+	_, err = doc2.Commit("b")
+	require.NoError(t, err)
+
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	errs := make(chan error, 2)
+	go func() { errs <- automerge.SyncConn(ctx, doc1, c1) }()
+	go func() { errs <- automerge.SyncConn(ctx, doc2, c2) }()
+
+	synced := func() bool {
+		h1, h2 := doc1.Heads(), doc2.Heads()
+		return len(h1) == 2 && len(h2) == 2 && h1[0] == h2[0] && h1[1] == h2[1]
+	}
+	require.Eventually(t, synced, 5*time.Second, time.Millisecond)
+
+	// changes made after the initial sync are sent too
+	require.NoError(t, doc1.Path("c").Set("three"))
+	_, err = doc1.Commit("c")
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		v, err := automerge.As[string](doc2.Path("c").Get())
+		return err == nil && v == "three"
+	}, 5*time.Second, time.Millisecond)
+
+	other := automerge.New()
+	require.NoError(t, other.Path("d").Set("four"))
+	_, err = other.Commit("d")
+	require.NoError(t, err)
+	_, err = doc2.Merge(other)
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		v, err := automerge.As[string](doc1.Path("d").Get())
+		return err == nil && v == "four"
+	}, 5*time.Second, time.Millisecond)
+
+	cancel()
+	require.ErrorIs(t, <-errs, context.Canceled)
+	require.ErrorIs(t, <-errs, context.Canceled)
+}
+
+func TestSyncConn_Disconnect(t *testing.T) {
+	doc := automerge.New()
+	require.NoError(t, doc.Path("a").Set("one"))
+	_, err := doc.Commit("a")
+	require.NoError(t, err)
+
+	c1, c2 := net.Pipe()
+	errs := make(chan error, 1)
+	go func() { errs <- automerge.SyncConn(context.Background(), doc, c1) }()
+
+	// read the first message then hang up
+	var l [4]byte
+	_, err = io.ReadFull(c2, l[:])
+	require.NoError(t, err)
+	msg := make([]byte, binary.BigEndian.Uint32(l[:]))
+	_, err = io.ReadFull(c2, msg)
+	require.NoError(t, err)
+	_, err = automerge.LoadSyncMessage(msg)
+	require.NoError(t, err)
+	require.NoError(t, c2.Close())
+
+	require.NoError(t, <-errs)
+
+	// oversized messages are rejected
+	c1, c2 = net.Pipe()
+	defer c2.Close()
+	go func() {
+		errs <- automerge.SyncConn(context.Background(), doc, c1, automerge.SyncConnOptions{MaxMessageSize: 10})
+	}()
+	go func() {
+		c2.Write([]byte{0, 0, 1, 0})
+	}()
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			if _, err := c2.Read(buf); err != nil {
+				return
+			}
+		}
+	}()
+	require.EqualError(t, <-errs, "automerge.SyncConn: message of 256 bytes exceeds limit of 10")
+}
+
+func TestNewFramedConn(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c1, c2 := net.Pipe()
+	defer c1.Close()
+	defer c2.Close()
+
+	doc1 := automerge.New()
+	require.NoError(t, doc1.Path("x").Set("framed"))
+	_, err := doc1.Commit("x")
+	require.NoError(t, err)
+	doc2 := automerge.New()
+
+	mux1 := automerge.NewSyncMux(automerge.NewFramedConn(c1))
+	require.NoError(t, mux1.Subscribe("doc", doc1))
+	mux2 := automerge.NewSyncMux(automerge.NewFramedConn(c2))
+	require.NoError(t, mux2.Subscribe("doc", doc2))
+	go mux1.Run(ctx)
+	go mux2.Run(ctx)
+
+	require.Eventually(t, func() bool {
+		v, err := automerge.As[string](doc2.Path("x").Get())
+		return err == nil && v == "framed"
+	}, 5*time.Second, time.Millisecond)
+}
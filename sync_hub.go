@@ -0,0 +1,284 @@
+package automerge
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// SyncHubOptions configure a [SyncHub]
+type SyncHubOptions struct {
+	// LoadSyncState returns the saved sync state for a peer (as returned by
+	// [SyncState.Save]), or nil to start from scratch.
+	LoadSyncState func(peer string) ([]byte, error)
+	// SaveSyncState is called with the sync state of a peer when it is
+	// removed from the hub, so that it can be resumed when the peer reconnects.
+	SaveSyncState func(peer string, state []byte) error
+	// OnError is called if sending to a peer fails, and again if saving the
+	// peer's sync state then fails. The peer is removed from the hub, and its
+	// sync state saved, before OnError is called.
+	OnError func(peer string, err error)
+}
+
+// SyncHub keeps a document in sync with many peers.
+//
+// Each peer has its own [SyncState] and a goroutine that sends it messages.
+// Whenever the document changes (because of a local commit, or changes
+// received from any peer) every peer is marked as needing a message. The
+// messages are generated lazily, when the previous send to that peer has
+// completed, so a burst of changes results in one message per peer and a slow
+// peer never has more than one message outstanding.
+type SyncHub struct {
+	doc  *Doc
+	opts SyncHubOptions
+
+	m           sync.Mutex
+	peers       map[string]*hubPeer
+	removeHooks func()
+}
+
+type hubPeer struct {
+	id   string
+	send func(msg []byte) error
+
+	m  sync.Mutex
+	ss *SyncState
+
+	dirty chan struct{}
+	done  chan struct{}
+}
+
+// NewSyncHub returns a hub that syncs doc. Call [SyncHub.Close] when finished
+// with it.
+func NewSyncHub(doc *Doc, opts ...SyncHubOptions) *SyncHub {
+	h := &SyncHub{doc: doc, peers: map[string]*hubPeer{}}
+	for _, o := range opts {
+		if o.LoadSyncState != nil {
+			h.opts.LoadSyncState = o.LoadSyncState
+		}
+		if o.SaveSyncState != nil {
+			h.opts.SaveSyncState = o.SaveSyncState
+		}
+		if o.OnError != nil {
+			h.opts.OnError = o.OnError
+		}
+	}
+	h.removeHooks = doc.AddHooks(Hooks{
+		AfterCommit: func(*Doc, ChangeHash) { h.changed() },
+		AfterApply:  func(*Doc, []ChangeHash) { h.changed() },
+	})
+	return h
+}
+
+// Doc returns the document being synced
+func (h *SyncHub) Doc() *Doc {
+	return h.doc
+}
+
+func (h *SyncHub) changed() {
+	h.m.Lock()
+	defer h.m.Unlock()
+	for _, p := range h.peers {
+		p.markDirty()
+	}
+}
+
+func (p *hubPeer) markDirty() {
+	select {
+	case p.dirty <- struct{}{}:
+	default:
+	}
+}
+
+// AddPeer starts syncing with a new peer. send is called with each message
+// for the peer, one at a time, on a goroutine owned by the hub. While send
+// is blocked no further messages are generated for the peer.
+func (h *SyncHub) AddPeer(id string, send func(msg []byte) error) error {
+	var ss *SyncState
+	if h.opts.LoadSyncState != nil {
+		b, err := h.opts.LoadSyncState(id)
+		if err != nil {
+			return err
+		}
+		if b != nil {
+			if ss, err = LoadSyncState(h.doc, b); err != nil {
+				return err
+			}
+		}
+	}
+	if ss == nil {
+		var err error
+		if ss, err = TryNewSyncState(h.doc); err != nil {
+			return err
+		}
+	}
+
+	p := &hubPeer{id: id, send: send, ss: ss, dirty: make(chan struct{}, 1), done: make(chan struct{})}
+
+	h.m.Lock()
+	if h.removeHooks == nil {
+		h.m.Unlock()
+		return fmt.Errorf("automerge.SyncHub: closed")
+	}
+	if _, ok := h.peers[id]; ok {
+		h.m.Unlock()
+		return fmt.Errorf("automerge.SyncHub: peer %q already added", id)
+	}
+	h.peers[id] = p
+	h.m.Unlock()
+
+	p.markDirty()
+	go h.run(p)
+	return nil
+}
+
+func (h *SyncHub) run(p *hubPeer) {
+	for {
+		select {
+		case <-p.dirty:
+		case <-p.done:
+			return
+		}
+
+		for {
+			p.m.Lock()
+			msg, valid, err := p.ss.TryGenerateMessage()
+			p.m.Unlock()
+			if err == nil && !valid {
+				break
+			}
+			var b []byte
+			if err == nil {
+				b, err = msg.TrySignedBytes()
+			}
+			if err == nil {
+				err = p.send(b)
+			}
+			if err != nil {
+				if h.remove(p) {
+					saveErr := h.save(p)
+					h.reportError(p.id, err)
+					h.reportError(p.id, saveErr)
+				}
+				return
+			}
+
+			select {
+			case <-p.done:
+				return
+			default:
+			}
+		}
+	}
+}
+
+func (h *SyncHub) reportError(id string, err error) {
+	if err != nil && h.opts.OnError != nil {
+		h.opts.OnError(id, err)
+	}
+}
+
+// Receive handles a message from a peer
+func (h *SyncHub) Receive(id string, msg []byte) error {
+	h.m.Lock()
+	p := h.peers[id]
+	h.m.Unlock()
+	if p == nil {
+		return fmt.Errorf("automerge.SyncHub: unknown peer %q", id)
+	}
+
+	p.m.Lock()
+	_, err := p.ss.ReceiveMessage(msg)
+	p.m.Unlock()
+	if err != nil {
+		return err
+	}
+	// the peer may be waiting for a reply, even if the document did not change
+	p.markDirty()
+	return nil
+}
+
+// RemovePeer stops syncing with a peer, and saves its sync state
+func (h *SyncHub) RemovePeer(id string) error {
+	h.m.Lock()
+	p := h.peers[id]
+	h.m.Unlock()
+	if p == nil {
+		return fmt.Errorf("automerge.SyncHub: unknown peer %q", id)
+	}
+	h.remove(p)
+	return h.save(p)
+}
+
+// remove returns false if the peer was already removed
+func (h *SyncHub) remove(p *hubPeer) bool {
+	h.m.Lock()
+	defer h.m.Unlock()
+	if h.peers[p.id] != p {
+		return false
+	}
+	delete(h.peers, p.id)
+	close(p.done)
+	return true
+}
+
+func (h *SyncHub) save(p *hubPeer) error {
+	if h.opts.SaveSyncState == nil {
+		return nil
+	}
+	p.m.Lock()
+	b, err := p.ss.TrySave()
+	p.m.Unlock()
+	if err != nil {
+		return err
+	}
+	return h.opts.SaveSyncState(p.id, b)
+}
+
+// Peers returns the IDs of the connected peers in sorted order
+func (h *SyncHub) Peers() []string {
+	h.m.Lock()
+	defer h.m.Unlock()
+	ret := []string{}
+	for id := range h.peers {
+		ret = append(ret, id)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// SharedHeads returns the heads that the hub knows a peer has, see [SyncState.SharedHeads].
+func (h *SyncHub) SharedHeads(id string) ([]ChangeHash, error) {
+	h.m.Lock()
+	p := h.peers[id]
+	h.m.Unlock()
+	if p == nil {
+		return nil, fmt.Errorf("automerge.SyncHub: unknown peer %q", id)
+	}
+	p.m.Lock()
+	defer p.m.Unlock()
+	return p.ss.SharedHeads()
+}
+
+// Close removes all peers (saving their sync state) and stops watching the document.
+func (h *SyncHub) Close() error {
+	h.m.Lock()
+	peers := h.peers
+	h.peers = map[string]*hubPeer{}
+	if h.removeHooks != nil {
+		h.removeHooks()
+		h.removeHooks = nil
+	}
+	for _, p := range peers {
+		close(p.done)
+	}
+	h.m.Unlock()
+
+	var err error
+	for _, p := range peers {
+		if e := h.save(p); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
@@ -0,0 +1,160 @@
+package automerge_test
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/automerge/automerge-go"
+	"github.com/stretchr/testify/require"
+)
+
+// hubClient is a peer of a SyncHub that processes messages on its own goroutine
+type hubClient struct {
+	id    string
+	doc   *automerge.Doc
+	ss    *automerge.SyncState
+	inbox chan []byte
+}
+
+func newHubClient(t *testing.T, hub *automerge.SyncHub, id string) *hubClient {
+	c := &hubClient{id: id, doc: automerge.New(), inbox: make(chan []byte, 100)}
+	c.ss = automerge.NewSyncState(c.doc)
+	go func() {
+		for msg := range c.inbox {
+			if msg != nil {
+				if _, err := c.ss.ReceiveMessage(msg); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+			if m, valid := c.ss.GenerateMessage(); valid {
+				if err := hub.Receive(c.id, m.Bytes()); err != nil {
+					return
+				}
+			}
+		}
+	}()
+	return c
+}
+
+func (c *hubClient) send(msg []byte) error {
+	c.inbox <- msg
+	return nil
+}
+
+func (c *hubClient) set(t *testing.T, key string, value int) {
+	require.NoError(t, c.doc.Path(key).Set(value))
+	_, err := c.doc.Commit("set " + key)
+	require.NoError(t, err)
+	c.inbox <- nil
+}
+
+func hasValue(d *automerge.Doc, key string, value int) bool {
+	v, err := automerge.As[int](d.Path(key).Get())
+	return err == nil && v == value
+}
+
+func TestSyncHub(t *testing.T) {
+	doc := automerge.New()
+	saved := map[string][]byte{}
+	var m sync.Mutex
+	hub := automerge.NewSyncHub(doc, automerge.SyncHubOptions{
+		SaveSyncState: func(peer string, state []byte) error {
+			m.Lock()
+			defer m.Unlock()
+			saved[peer] = state
+			return nil
+		},
+		LoadSyncState: func(peer string) ([]byte, error) {
+			m.Lock()
+			defer m.Unlock()
+			return saved[peer], nil
+		},
+	})
+
+	clients := []*hubClient{}
+	for i := 0; i < 10; i++ {
+		c := newHubClient(t, hub, fmt.Sprintf("peer-%d", i))
+		require.NoError(t, hub.AddPeer(c.id, c.send))
+		clients = append(clients, c)
+	}
+	require.Len(t, hub.Peers(), 10)
+	require.Error(t, hub.AddPeer("peer-0", clients[0].send))
+
+	// local commits are sent to everyone
+	require.NoError(t, doc.Path("hub").Set(1))
+	_, err := doc.Commit("hub")
+	require.NoError(t, err)
+	for _, c := range clients {
+		require.Eventually(t, func() bool { return hasValue(c.doc, "hub", 1) }, 5*time.Second, time.Millisecond)
+	}
+
+	// changes from one peer are fanned out to the others
+	clients[3].set(t, "peer", 3)
+	for _, c := range clients {
+		require.Eventually(t, func() bool { return hasValue(c.doc, "peer", 3) }, 5*time.Second, time.Millisecond)
+	}
+	require.True(t, hasValue(doc, "peer", 3))
+	require.Eventually(t, func() bool {
+		heads, err := hub.SharedHeads("peer-0")
+		return err == nil && len(heads) == 1 && heads[0] == doc.Heads()[0]
+	}, 5*time.Second, time.Millisecond)
+
+	// removing a peer saves its state, re-adding it resumes
+	require.NoError(t, hub.RemovePeer("peer-0"))
+	require.NotNil(t, saved["peer-0"])
+	require.Len(t, hub.Peers(), 9)
+	require.NoError(t, hub.AddPeer("peer-0", clients[0].send))
+
+	require.NoError(t, hub.Close())
+	require.Len(t, saved, 10)
+	require.Empty(t, hub.Peers())
+}
+
+func TestSyncHub_Backpressure(t *testing.T) {
+	doc := automerge.New()
+	errs := make(chan error, 1)
+	saved := make(chan string, 10)
+	hub := automerge.NewSyncHub(doc, automerge.SyncHubOptions{
+		OnError: func(peer string, err error) { errs <- err },
+		SaveSyncState: func(peer string, state []byte) error {
+			saved <- peer
+			return nil
+		},
+	})
+	defer hub.Close()
+
+	fast := newHubClient(t, hub, "fast")
+	require.NoError(t, hub.AddPeer(fast.id, fast.send))
+
+	// the slow peer blocks on its first message
+	slow := newHubClient(t, hub, "slow")
+	unblock := make(chan struct{})
+	var sends int32
+	require.NoError(t, hub.AddPeer(slow.id, func(msg []byte) error {
+		atomic.AddInt32(&sends, 1)
+		<-unblock
+		return slow.send(msg)
+	}))
+
+	for i := 0; i < 20; i++ {
+		require.NoError(t, doc.Path("n").Set(i))
+		_, err := doc.Commit("n")
+		require.NoError(t, err)
+	}
+	require.Eventually(t, func() bool { return hasValue(fast.doc, "n", 19) }, 5*time.Second, time.Millisecond)
+
+	// once unblocked the slow peer catches up without a message per change
+	close(unblock)
+	require.Eventually(t, func() bool { return hasValue(slow.doc, "n", 19) }, 5*time.Second, time.Millisecond)
+	require.Less(t, atomic.LoadInt32(&sends), int32(10))
+
+	// peers whose send fails are removed, and their sync state saved
+	require.NoError(t, hub.AddPeer("broken", func([]byte) error { return fmt.Errorf("broken pipe") }))
+	require.EqualError(t, <-errs, "broken pipe")
+	require.Equal(t, "broken", <-saved)
+	require.Equal(t, []string{"fast", "slow"}, hub.Peers())
+}
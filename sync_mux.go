@@ -0,0 +1,553 @@
+package automerge
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Frame types used by [SyncMux]
+const (
+	muxSync        = 0
+	muxUnsubscribe = 1
+	muxUnavailable = 2
+	muxPresence    = 3
+)
+
+// SyncMuxOptions configure a [SyncMux]
+type SyncMuxOptions struct {
+	// OnSubscribe is called when the peer sends a message for a document that
+	// has not been subscribed to locally. It should return the document to
+	// sync (for example by loading it from disk), or nil if it is not available.
+	// If OnSubscribe is nil, messages for unknown documents are rejected.
+	OnSubscribe func(id string) (*Doc, error)
+	// OnUnavailable is called when the peer rejects a document.
+	OnUnavailable func(id string)
+	// Presence, if set, is sent alongside the sync messages while
+	// [SyncMux.Run] is running.
+	Presence *Presence
+}
+
+// SyncMux syncs many documents with a single peer over one [MessageConn].
+//
+// Each message on the connection is a frame containing a type byte, the
+// document ID (prefixed by its uvarint length) and, for sync frames, the bytes
+// of a [SyncMessage]. Each document has its own [SyncState].
+//
+// Documents that need a message are sent one message each in turn, so a
+// document with a large history cannot starve the others. [Presence] updates
+// are sent in frames with an empty document ID.
+//
+// A SyncMux can only be run once. When [SyncMux.Run] returns all documents
+// are unsubscribed (without telling the peer), and no further messages from
+// the peer are applied.
+type SyncMux struct {
+	conn MessageConn
+	opts SyncMuxOptions
+
+	m       sync.Mutex
+	subs    map[string]*muxSub
+	queue   []*muxSub
+	control [][]byte
+	wake    chan struct{}
+	// closed is set when Run returns, and err if the mux has failed
+	closed bool
+	err    error
+
+	presence *PresenceLink
+}
+
+type muxSub struct {
+	id          string
+	doc         *Doc
+	lazy        bool
+	removeHooks func()
+
+	// the following are protected by SyncMux.m
+	queued  bool
+	removed bool
+	// remoteClosed is true if the peer has unsubscribed or rejected the
+	// document; nothing is sent until the peer sends a sync message for it.
+	remoteClosed bool
+
+	// ssm protects ss
+	ssm sync.Mutex
+	ss  *SyncState
+}
+
+// maxMuxControl bounds the number of frames (unsubscribes, rejections and
+// presence updates) waiting to be sent, so that a peer that does not read
+// cannot make them grow without limit.
+const maxMuxControl = 1024
+
+var errMuxClosed = errors.New("automerge.SyncMux: closed")
+
+// NewSyncMux returns a multiplexer that sends messages over conn.
+// Call [SyncMux.Run] to start syncing.
+func NewSyncMux(conn MessageConn, opts ...SyncMuxOptions) *SyncMux {
+	m := &SyncMux{conn: conn, subs: map[string]*muxSub{}, wake: make(chan struct{}, 1)}
+	for _, o := range opts {
+		if o.OnSubscribe != nil {
+			m.opts.OnSubscribe = o.OnSubscribe
+		}
+		if o.OnUnavailable != nil {
+			m.opts.OnUnavailable = o.OnUnavailable
+		}
+		if o.Presence != nil {
+			m.opts.Presence = o.Presence
+		}
+	}
+	return m
+}
+
+// Subscribe starts syncing doc with the peer under the given id.
+func (m *SyncMux) Subscribe(id string, doc *Doc) error {
+	m.m.Lock()
+	defer m.m.Unlock()
+	if m.closed {
+		return errMuxClosed
+	}
+	if _, ok := m.subs[id]; ok {
+		return fmt.Errorf("automerge.SyncMux: %q is already subscribed", id)
+	}
+	_, err := m.subscribe(id, doc, false)
+	return err
+}
+
+// subscribe must be called with m.m held
+func (m *SyncMux) subscribe(id string, doc *Doc, lazy bool) (*muxSub, error) {
+	ss, err := TryNewSyncState(doc)
+	if err != nil {
+		return nil, fmt.Errorf("automerge.SyncMux: %q: %w", id, err)
+	}
+	s := &muxSub{id: id, doc: doc, lazy: lazy, ss: ss}
+	s.removeHooks = doc.AddHooks(Hooks{
+		AfterCommit: func(*Doc, ChangeHash) { m.markDirty(s) },
+		AfterApply:  func(*Doc, []ChangeHash) { m.markDirty(s) },
+	})
+	m.subs[id] = s
+	m.enqueue(s)
+	return s, nil
+}
+
+// Unsubscribe stops syncing the document, and tells the peer to forget its sync state.
+func (m *SyncMux) Unsubscribe(id string) error {
+	m.m.Lock()
+	defer m.m.Unlock()
+	s := m.subs[id]
+	if s == nil {
+		return fmt.Errorf("automerge.SyncMux: %q is not subscribed", id)
+	}
+	m.remove(s)
+	m.sendControl(muxUnsubscribe, id, nil)
+	return nil
+}
+
+// remove must be called with m.m held
+func (m *SyncMux) remove(s *muxSub) {
+	s.removeHooks()
+	s.removed = true
+	delete(m.subs, s.id)
+}
+
+// Subscribed returns the IDs of the subscribed documents in sorted order
+func (m *SyncMux) Subscribed() []string {
+	m.m.Lock()
+	defer m.m.Unlock()
+	ret := []string{}
+	for id := range m.subs {
+		ret = append(ret, id)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// SyncState returns a copy of the sync state for a subscribed document, or
+// nil if the document is not subscribed. Like a state loaded with
+// [LoadSyncState], the copy only contains the shared heads.
+func (m *SyncMux) SyncState(id string) (*SyncState, error) {
+	m.m.Lock()
+	s := m.subs[id]
+	m.m.Unlock()
+	if s == nil {
+		return nil, nil
+	}
+	s.ssm.Lock()
+	b, err := s.ss.TrySave()
+	s.ssm.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("automerge.SyncMux: %q: %w", id, err)
+	}
+	return LoadSyncState(s.doc, b)
+}
+
+func (m *SyncMux) markDirty(s *muxSub) {
+	m.m.Lock()
+	defer m.m.Unlock()
+	m.enqueue(s)
+}
+
+// enqueue must be called with m.m held
+func (m *SyncMux) enqueue(s *muxSub) {
+	if s.queued || s.removed || s.remoteClosed {
+		return
+	}
+	s.queued = true
+	m.queue = append(m.queue, s)
+	m.notify()
+}
+
+func (m *SyncMux) notify() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// sendControl must be called with m.m held. If too many frames are waiting
+// to be sent the mux fails, and Run returns an error.
+func (m *SyncMux) sendControl(typ byte, id string, payload []byte) {
+	if len(m.control) >= maxMuxControl {
+		if m.err == nil {
+			m.err = fmt.Errorf("automerge.SyncMux: too many messages waiting to be sent")
+		}
+	} else {
+		m.control = append(m.control, encodeMuxFrame(typ, id, payload))
+	}
+	m.notify()
+}
+
+func encodeMuxFrame(typ byte, id string, payload []byte) []byte {
+	b := make([]byte, 0, 1+binary.MaxVarintLen64+len(id)+len(payload))
+	b = append(b, typ)
+	b = binary.AppendUvarint(b, uint64(len(id)))
+	b = append(b, id...)
+	return append(b, payload...)
+}
+
+func decodeMuxFrame(b []byte) (typ byte, id string, payload []byte, err error) {
+	if len(b) < 1 {
+		return 0, "", nil, fmt.Errorf("automerge.SyncMux: empty frame")
+	}
+	n, l := binary.Uvarint(b[1:])
+	if l <= 0 || uint64(len(b)-1-l) < n {
+		return 0, "", nil, fmt.Errorf("automerge.SyncMux: invalid frame")
+	}
+	start := 1 + l
+	return b[0], string(b[start : start+int(n)]), b[start+int(n):], nil
+}
+
+// next returns the next frame to send, or nil if there is nothing to send
+func (m *SyncMux) next() ([]byte, error) {
+	for {
+		m.m.Lock()
+		if m.err != nil {
+			err := m.err
+			m.m.Unlock()
+			return nil, err
+		}
+		if len(m.control) > 0 {
+			f := m.control[0]
+			m.control = m.control[1:]
+			m.m.Unlock()
+			return f, nil
+		}
+		if len(m.queue) == 0 {
+			m.m.Unlock()
+			return nil, nil
+		}
+		s := m.queue[0]
+		m.queue = m.queue[1:]
+		s.queued = false
+		m.m.Unlock()
+
+		s.ssm.Lock()
+		msg, valid, err := s.ss.TryGenerateMessage()
+		s.ssm.Unlock()
+		if err != nil {
+			return nil, fmt.Errorf("automerge.SyncMux: %q: %w", s.id, err)
+		}
+		if !valid {
+			continue
+		}
+		b, err := msg.TrySignedBytes()
+		if err != nil {
+			return nil, fmt.Errorf("automerge.SyncMux: %q: %w", s.id, err)
+		}
+		return encodeMuxFrame(muxSync, s.id, b), nil
+	}
+}
+
+// Run syncs the subscribed documents until ctx is cancelled or the peer
+// disconnects. It returns nil if the peer disconnects cleanly, ctx.Err()
+// if ctx is cancelled, or the first error encountered.
+//
+// Before returning Run unsubscribes all documents, and if conn is an
+// [io.Closer] closes it and waits for the pending read to finish. Otherwise
+// the pending read is abandoned, and its message is ignored.
+func (m *SyncMux) Run(ctx context.Context) error {
+	m.m.Lock()
+	if m.closed {
+		m.m.Unlock()
+		return errMuxClosed
+	}
+	m.m.Unlock()
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	if m.opts.Presence != nil {
+		l := m.opts.Presence.AddLink(func(msg []byte) error {
+			m.m.Lock()
+			defer m.m.Unlock()
+			m.sendControl(muxPresence, "", msg)
+			return nil
+		})
+		m.m.Lock()
+		m.presence = l
+		m.m.Unlock()
+		defer func() {
+			l.Close()
+			m.m.Lock()
+			m.presence = nil
+			m.m.Unlock()
+		}()
+	}
+
+	readErr := make(chan error, 1)
+	readDone := make(chan struct{})
+	go func() {
+		defer close(readDone)
+		for {
+			b, err := m.conn.ReadMessage()
+			if err == nil {
+				err = m.receive(b)
+			}
+			if err != nil {
+				readErr <- err
+				return
+			}
+			if ctx.Err() != nil {
+				return
+			}
+		}
+	}()
+	defer m.close(readDone)
+
+	for {
+		f, err := m.next()
+		if err != nil {
+			return err
+		}
+		if f != nil {
+			if err := m.conn.WriteMessage(f); err != nil {
+				return err
+			}
+			continue
+		}
+
+		select {
+		case <-m.wake:
+		case <-ctx.Done():
+			return ctx.Err()
+		case err := <-readErr:
+			if errors.Is(err, io.EOF) {
+				return nil
+			}
+			return err
+		}
+	}
+}
+
+// close stops the reader and unsubscribes all documents
+func (m *SyncMux) close(readDone <-chan struct{}) {
+	m.m.Lock()
+	m.closed = true
+	subs := []*muxSub{}
+	for _, s := range m.subs {
+		subs = append(subs, s)
+	}
+	m.m.Unlock()
+	if c, ok := m.conn.(io.Closer); ok {
+		c.Close()
+		<-readDone
+	} else {
+		// wait for any message that is being applied
+		for _, s := range subs {
+			s.ssm.Lock()
+			s.ssm.Unlock()
+		}
+	}
+
+	m.m.Lock()
+	defer m.m.Unlock()
+	for _, s := range m.subs {
+		m.remove(s)
+	}
+	m.queue = nil
+	m.control = nil
+}
+
+func (m *SyncMux) receive(b []byte) error {
+	typ, id, payload, err := decodeMuxFrame(b)
+	if err != nil {
+		return err
+	}
+	if typ == muxPresence {
+		m.m.Lock()
+		l := m.presence
+		m.m.Unlock()
+		if l == nil {
+			return nil
+		}
+		return l.Receive(payload)
+	}
+
+	m.m.Lock()
+	if m.closed {
+		m.m.Unlock()
+		return errMuxClosed
+	}
+	s := m.subs[id]
+	m.m.Unlock()
+
+	switch typ {
+	case muxSync:
+		if s == nil {
+			if s = m.lazySubscribe(id); s == nil {
+				return nil
+			}
+		}
+		s.ssm.Lock()
+		m.m.Lock()
+		closed := m.closed
+		m.m.Unlock()
+		if closed {
+			s.ssm.Unlock()
+			return errMuxClosed
+		}
+		_, err := s.ss.ReceiveMessage(payload)
+		s.ssm.Unlock()
+		if err != nil {
+			return fmt.Errorf("automerge.SyncMux: %q: %w", id, err)
+		}
+		m.m.Lock()
+		s.remoteClosed = false
+		m.enqueue(s)
+		m.m.Unlock()
+
+	case muxUnsubscribe, muxUnavailable:
+		if s == nil {
+			return nil
+		}
+		m.m.Lock()
+		if s.lazy && typ == muxUnsubscribe {
+			m.remove(s)
+		} else {
+			s.remoteClosed = true
+		}
+		m.m.Unlock()
+
+		ss, err := TryNewSyncState(s.doc)
+		if err != nil {
+			return fmt.Errorf("automerge.SyncMux: %q: %w", id, err)
+		}
+		s.ssm.Lock()
+		s.ss = ss
+		s.ssm.Unlock()
+		if typ == muxUnavailable && m.opts.OnUnavailable != nil {
+			m.opts.OnUnavailable(id)
+		}
+
+	default:
+		// ignore frame types from newer versions
+	}
+	return nil
+}
+
+func (m *SyncMux) lazySubscribe(id string) *muxSub {
+	var doc *Doc
+	var err error
+	if m.opts.OnSubscribe != nil {
+		doc, err = m.opts.OnSubscribe(id)
+	}
+
+	m.m.Lock()
+	defer m.m.Unlock()
+	if m.closed {
+		return nil
+	}
+	if s := m.subs[id]; s != nil {
+		return s
+	}
+	var s *muxSub
+	if err == nil && doc != nil {
+		s, err = m.subscribe(id, doc, true)
+	}
+	if err != nil || doc == nil {
+		m.sendControl(muxUnavailable, id, nil)
+		return nil
+	}
+	return s
+}
+
+// NewMessagePipe returns two connected in-memory [MessageConn]s, which are
+// useful for testing. Closing either end causes reads on both ends to return
+// [io.EOF] once any buffered messages have been read.
+func NewMessagePipe() (a, b *MessagePipe) {
+	ab := make(chan []byte, 64)
+	ba := make(chan []byte, 64)
+	done := make(chan struct{})
+	once := &sync.Once{}
+	return &MessagePipe{r: ba, w: ab, done: done, once: once},
+		&MessagePipe{r: ab, w: ba, done: done, once: once}
+}
+
+// MessagePipe is one end of an in-memory connection created by [NewMessagePipe]
+type MessagePipe struct {
+	r    <-chan []byte
+	w    chan<- []byte
+	done chan struct{}
+	once *sync.Once
+}
+
+// ReadMessage implements [MessageConn]
+func (p *MessagePipe) ReadMessage() ([]byte, error) {
+	select {
+	case msg := <-p.r:
+		return msg, nil
+	case <-p.done:
+		select {
+		case msg := <-p.r:
+			return msg, nil
+		default:
+			return nil, io.EOF
+		}
+	}
+}
+
+// WriteMessage implements [MessageConn]. It blocks if the other end
+// has too many unread messages.
+func (p *MessagePipe) WriteMessage(msg []byte) error {
+	select {
+	case <-p.done:
+		return io.ErrClosedPipe
+	default:
+	}
+	select {
+	case p.w <- append([]byte{}, msg...):
+		return nil
+	case <-p.done:
+		return io.ErrClosedPipe
+	}
+}
+
+// Close closes both ends of the pipe
+func (p *MessagePipe) Close() error {
+	p.once.Do(func() { close(p.done) })
+	return nil
+}
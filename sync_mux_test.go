@@ -0,0 +1,275 @@
+package automerge_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/automerge/automerge-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSyncMux(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	local := map[string]*automerge.Doc{}
+	for _, id := range []string{"a", "b", "secret"} {
+		d := automerge.New()
+		require.NoError(t, d.Path("id").Set(id))
+		_, err := d.Commit("id")
+		require.NoError(t, err)
+		local[id] = d
+	}
+
+	var m sync.Mutex
+	remote := map[string]*automerge.Doc{}
+	unavailable := make(chan string, 1)
+
+	c1, c2 := automerge.NewMessagePipe()
+	mux1 := automerge.NewSyncMux(c1, automerge.SyncMuxOptions{
+		OnUnavailable: func(id string) { unavailable <- id },
+	})
+	mux2 := automerge.NewSyncMux(c2, automerge.SyncMuxOptions{
+		OnSubscribe: func(id string) (*automerge.Doc, error) {
+			if id == "secret" {
+				return nil, fmt.Errorf("not allowed")
+			}
+			m.Lock()
+			defer m.Unlock()
+			remote[id] = automerge.New()
+			return remote[id], nil
+		},
+	})
+
+	for id, d := range local {
+		require.NoError(t, mux1.Subscribe(id, d))
+	}
+	require.Error(t, mux1.Subscribe("a", local["a"]))
+
+	errs := make(chan error, 2)
+	go func() { errs <- mux1.Run(ctx) }()
+	go func() { errs <- mux2.Run(ctx) }()
+
+	remoteDoc := func(id string) *automerge.Doc {
+		m.Lock()
+		defer m.Unlock()
+		return remote[id]
+	}
+	for _, id := range []string{"a", "b"} {
+		id := id
+		require.Eventually(t, func() bool {
+			d := remoteDoc(id)
+			if d == nil {
+				return false
+			}
+			v, err := automerge.As[string](d.Path("id").Get())
+			return err == nil && v == id
+		}, 5*time.Second, time.Millisecond)
+	}
+	require.Equal(t, "secret", <-unavailable)
+	require.Equal(t, []string{"a", "b"}, mux2.Subscribed())
+
+	// changes on the lazily subscribed side are sent back
+	require.NoError(t, remoteDoc("b").Path("reply").Set("hi"))
+	_, err := remoteDoc("b").Commit("reply")
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		v, err := automerge.As[string](local["b"].Path("reply").Get())
+		return err == nil && v == "hi"
+	}, 5*time.Second, time.Millisecond)
+	require.Eventually(t, func() bool {
+		ss, err := mux1.SyncState("b")
+		if err != nil {
+			return false
+		}
+		heads, err := ss.SharedHeads()
+		return err == nil && len(heads) == 1
+	}, 5*time.Second, time.Millisecond)
+
+	// the sync state is a copy, so closing it does not affect the mux
+	ss, err := mux1.SyncState("b")
+	require.NoError(t, err)
+	ss.Close()
+	ss, err = mux1.SyncState("b")
+	require.NoError(t, err)
+	heads, err := ss.SharedHeads()
+	require.NoError(t, err)
+	require.Equal(t, local["b"].Heads(), heads)
+
+	// unsubscribing removes the lazy subscription on the other side
+	require.NoError(t, mux1.Unsubscribe("a"))
+	require.Error(t, mux1.Unsubscribe("a"))
+	require.Eventually(t, func() bool { return len(mux2.Subscribed()) == 1 }, 5*time.Second, time.Millisecond)
+	ss, err = mux1.SyncState("a")
+	require.NoError(t, err)
+	require.Nil(t, ss)
+
+	require.NoError(t, c1.Close())
+	require.NoError(t, <-errs)
+	require.NoError(t, <-errs)
+}
+
+// recordingConn records the document ID of each frame written
+type recordingConn struct {
+	*automerge.MessagePipe
+	m   sync.Mutex
+	ids []string
+}
+
+func (rc *recordingConn) WriteMessage(msg []byte) error {
+	rc.m.Lock()
+	rc.ids = append(rc.ids, string(msg[2:2+msg[1]]))
+	rc.m.Unlock()
+	return rc.MessagePipe.WriteMessage(msg)
+}
+
+func TestSyncMux_Fairness(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c1, c2 := automerge.NewMessagePipe()
+	rc := &recordingConn{MessagePipe: c1}
+	mux1 := automerge.NewSyncMux(rc)
+	mux2 := automerge.NewSyncMux(c2, automerge.SyncMuxOptions{
+		OnSubscribe: func(id string) (*automerge.Doc, error) { return automerge.New(), nil },
+	})
+
+	big := automerge.New()
+	for i := 0; i < 100; i++ {
+		require.NoError(t, big.Path(fmt.Sprint(i)).Set(strings.Repeat("x", 1000)))
+		_, err := big.Commit("big")
+		require.NoError(t, err)
+	}
+	require.NoError(t, mux1.Subscribe("big", big))
+	for i := 0; i < 5; i++ {
+		small := automerge.New()
+		require.NoError(t, small.Path("n").Set(i))
+		_, err := small.Commit("small")
+		require.NoError(t, err)
+		require.NoError(t, mux1.Subscribe(fmt.Sprint("small-", i), small))
+	}
+
+	go mux1.Run(ctx)
+	go mux2.Run(ctx)
+
+	require.Eventually(t, func() bool {
+		rc.m.Lock()
+		defer rc.m.Unlock()
+		return len(rc.ids) >= 12
+	}, 5*time.Second, time.Millisecond)
+
+	// each document gets one message per round
+	rc.m.Lock()
+	defer rc.m.Unlock()
+	require.ElementsMatch(t, []string{"big", "small-0", "small-1", "small-2", "small-3", "small-4"}, rc.ids[:6])
+	require.ElementsMatch(t, []string{"big", "small-0", "small-1", "small-2", "small-3", "small-4"}, rc.ids[6:12])
+}
+
+// noCloseConn hides the Close method of a MessageConn
+type noCloseConn struct{ automerge.MessageConn }
+
+func TestSyncMux_Run(t *testing.T) {
+	doc := automerge.New()
+	require.NoError(t, doc.Path("x").Set("local"))
+	_, err := doc.Commit("x")
+	require.NoError(t, err)
+
+	var m sync.Mutex
+	var remote *automerge.Doc
+	c1, c2 := automerge.NewMessagePipe()
+	defer c2.Close()
+	mux1 := automerge.NewSyncMux(noCloseConn{c1})
+	mux2 := automerge.NewSyncMux(c2, automerge.SyncMuxOptions{
+		OnSubscribe: func(id string) (*automerge.Doc, error) {
+			m.Lock()
+			defer m.Unlock()
+			remote = automerge.New()
+			return remote, nil
+		},
+	})
+	require.NoError(t, mux1.Subscribe("doc", doc))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errs := make(chan error, 1)
+	go func() { errs <- mux1.Run(ctx) }()
+	go mux2.Run(context.Background())
+	remoteDoc := func() *automerge.Doc {
+		m.Lock()
+		defer m.Unlock()
+		return remote
+	}
+	require.Eventually(t, func() bool {
+		d := remoteDoc()
+		return d != nil && len(d.Heads()) == 1 && d.Heads()[0] == doc.Heads()[0]
+	}, 5*time.Second, time.Millisecond)
+
+	// once Run returns the documents are unsubscribed, and messages that
+	// are still read are not applied
+	cancel()
+	require.ErrorIs(t, <-errs, context.Canceled)
+	require.Empty(t, mux1.Subscribed())
+	require.Error(t, mux1.Subscribe("doc", doc))
+	require.Error(t, mux1.Run(context.Background()))
+
+	require.NoError(t, remoteDoc().Path("x").Set("remote"))
+	_, err = remoteDoc().Commit("x")
+	require.NoError(t, err)
+	require.Never(t, func() bool {
+		v, err := automerge.As[string](doc.Path("x").Get())
+		return err != nil || v != "local"
+	}, 50*time.Millisecond, time.Millisecond)
+}
+
+// blockedConn does not write until release is closed
+type blockedConn struct {
+	*automerge.MessagePipe
+	release chan struct{}
+}
+
+func (bc *blockedConn) WriteMessage(msg []byte) error {
+	<-bc.release
+	return bc.MessagePipe.WriteMessage(msg)
+}
+
+func TestSyncMux_ControlLimit(t *testing.T) {
+	c1, c2 := automerge.NewMessagePipe()
+	defer c2.Close()
+	bc := &blockedConn{MessagePipe: c1, release: make(chan struct{})}
+	var m sync.Mutex
+	requested := 0
+	mux := automerge.NewSyncMux(bc, automerge.SyncMuxOptions{
+		OnSubscribe: func(id string) (*automerge.Doc, error) {
+			m.Lock()
+			defer m.Unlock()
+			requested++
+			return nil, nil
+		},
+	})
+	errs := make(chan error, 1)
+	go func() { errs <- mux.Run(context.Background()) }()
+	go func() {
+		for {
+			if _, err := c2.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	// a peer that asks for many documents without reading the replies
+	// makes Run fail, instead of queueing replies without limit
+	for i := 0; i < 2000; i++ {
+		id := fmt.Sprint(i)
+		require.NoError(t, c2.WriteMessage(append([]byte{0, byte(len(id))}, id...)))
+	}
+	require.Eventually(t, func() bool {
+		m.Lock()
+		defer m.Unlock()
+		return requested == 2000
+	}, 5*time.Second, time.Millisecond)
+	close(bc.release)
+	require.ErrorContains(t, <-errs, "too many messages")
+}
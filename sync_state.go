@@ -5,6 +5,7 @@ import "C"
 
 import (
 	"runtime"
+	"unsafe"
 )
 
 // SyncState represents the state of syncing between a local copy of
@@ -18,10 +19,53 @@ type SyncState struct {
 }
 
 // NewSyncState returns a new sync state to sync with a peer
+// It panics if the sync state cannot be created, see [TryNewSyncState].
 func NewSyncState(d *Doc) *SyncState {
-	ss := must(wrap(C.AMsyncStateInit()).item()).syncState()
+	return must(TryNewSyncState(d))
+}
+
+// TryNewSyncState is like [NewSyncState] but returns an error instead of
+// panicking
+func TryNewSyncState(d *Doc) (*SyncState, error) {
+	item, err := wrap(C.AMsyncStateInit()).item()
+	if err != nil {
+		return nil, err
+	}
+	ss := item.syncState()
 	ss.Doc = d
-	return ss
+	return ss, nil
+}
+
+// Close frees the native memory used by the sync state immediately, instead
+// of waiting for the garbage collector. It does not close the document.
+// After Close, methods on the sync state return an error matching [ErrClosed]
+// ([SyncState.Equal] returns false). Like other failures, this makes
+// [SyncState.Save] and [SyncState.GenerateMessage] panic, so code that may use
+// a closed sync state should call [SyncState.TrySave] and
+// [SyncState.TryGenerateMessage] instead.
+// Closing a sync state more than once has no effect.
+func (ss *SyncState) Close() error {
+	_, unlock := ss.Doc.lock()
+	defer unlock()
+
+	if ss.cSyncState == nil {
+		return nil
+	}
+	ss.cSyncState = nil
+	ss.item.result.release()
+	return nil
+}
+
+var errSyncStateClosed = newError(ErrClosed, "automerge: sync state is closed")
+
+// lock locks the document, and returns an error if the sync state is closed
+func (ss *SyncState) lock() (func(), error) {
+	_, unlock := ss.Doc.lock()
+	if ss.cSyncState == nil {
+		unlock()
+		return nil, errSyncStateClosed
+	}
+	return unlock, nil
 }
 
 // LoadSyncState lets you resume syncing with a peer from where you left off.
@@ -31,7 +75,7 @@ func LoadSyncState(d *Doc, raw []byte) (*SyncState, error) {
 
 	item, err := wrap(C.AMsyncStateDecode(cBytes.src, cBytes.count)).item()
 	if err != nil {
-		return nil, err
+		return nil, markCorrupt(err)
 	}
 	ss := item.syncState()
 	ss.Doc = d
@@ -45,82 +89,387 @@ func (ss *SyncState) ReceiveMessage(msg []byte) (*SyncMessage, error) {
 	if err != nil {
 		return nil, err
 	}
+	var accepted map[ChangeHash][]byte
+	if ss.Doc.checksIncoming(sm.signatures) {
+		chs, err := sm.TryChanges()
+		if err != nil {
+			return nil, markError(ErrCorruptDocument, err)
+		}
+		if accepted, err = ss.Doc.checkIncoming(chs, sm.signatures); err != nil {
+			return nil, err
+		}
+	}
 
 	defer runtime.KeepAlive(ss)
 	defer runtime.KeepAlive(sm)
-	cDoc, unlock := ss.Doc.lock()
+	cDoc, unlock, err := ss.Doc.lockCommitted()
+	if err != nil {
+		return nil, err
+	}
 	defer unlock()
+	if ss.cSyncState == nil {
+		return nil, errSyncStateClosed
+	}
 
-	return sm, wrap(C.AMreceiveSyncMessage(cDoc, ss.cSyncState, sm.cSyncMessage)).void()
+	after := ss.Doc.prepareAfterApply(cDoc)
+	err = wrap(C.AMreceiveSyncMessage(cDoc, ss.cSyncState, sm.cSyncMessage)).void()
+	if err == nil {
+		ss.Doc.storeSignatures(accepted)
+	}
+	unlock()
+	after.run(ss.Doc)
+	return sm, markCorrupt(err)
 }
 
 // GenerateMessage generates the next message to send to the client.
 // If `valid` is false the clients are currently in sync and there are
 // no more messages to send (until you either modify the underlying document)
+// It panics if the message cannot be generated, see [SyncState.TryGenerateMessage].
 func (ss *SyncState) GenerateMessage() (sm *SyncMessage, valid bool) {
+	sm, valid, err := ss.TryGenerateMessage()
+	if err != nil {
+		panic(err)
+	}
+	return sm, valid
+}
+
+// TryGenerateMessage is like [SyncState.GenerateMessage] but returns an
+// error instead of panicking
+func (ss *SyncState) TryGenerateMessage() (sm *SyncMessage, valid bool, err error) {
 	defer runtime.KeepAlive(ss)
-	cDoc, unlock := ss.Doc.lock()
+	cDoc, unlock, err := ss.Doc.lockCommitted()
+	if err != nil {
+		return nil, false, err
+	}
 	defer unlock()
+	if ss.cSyncState == nil {
+		return nil, false, errSyncStateClosed
+	}
 
-	sm = must(wrap(C.AMgenerateSyncMessage(cDoc, ss.cSyncState)).item()).syncMessage()
-
+	item, err := wrap(C.AMgenerateSyncMessage(cDoc, ss.cSyncState)).item()
+	if err != nil {
+		return nil, false, err
+	}
+	sm = item.syncMessage()
 	if sm == nil {
-		return nil, false
+		return nil, false, nil
+	}
+	if len(ss.Doc.signatures) > 0 {
+		chs, err := sm.TryChanges()
+		if err != nil {
+			return nil, false, err
+		}
+		for _, ch := range chs {
+			if sig, ok := ss.Doc.signatures[ch.Hash()]; ok {
+				if sm.signatures == nil {
+					sm.signatures = map[ChangeHash][]byte{}
+				}
+				sm.signatures[ch.Hash()] = sig
+			}
+		}
 	}
 
-	return sm, true
+	return sm, true, nil
 }
 
 // Save serializes the sync state so that you can resume it later.
 // This is an optimization to reduce the number of round-trips required
 // to get two peers in sync at a later date.
+// It panics if the sync state cannot be saved, see [SyncState.TrySave].
 func (ss *SyncState) Save() []byte {
+	return must(ss.TrySave())
+}
+
+// TrySave is like [SyncState.Save] but returns an error instead of panicking
+func (ss *SyncState) TrySave() ([]byte, error) {
 	defer runtime.KeepAlive(ss)
+	unlock, err := ss.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 
-	return must(wrap(C.AMsyncStateEncode(ss.cSyncState)).item()).bytes()
+	item, err := wrap(C.AMsyncStateEncode(ss.cSyncState)).item()
+	if err != nil {
+		return nil, err
+	}
+	return item.bytes(), nil
+}
+
+// SharedHeads returns the heads that both peers are known to have
+func (ss *SyncState) SharedHeads() ([]ChangeHash, error) {
+	defer runtime.KeepAlive(ss)
+	unlock, err := ss.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	items, err := wrap(C.AMsyncStateSharedHeads(ss.cSyncState)).items()
+	if err != nil {
+		return nil, err
+	}
+	return mapItems(items, func(i *item) ChangeHash { return i.changeHash() }), nil
+}
+
+// LastSentHeads returns the heads of the local document that
+// were included in the last message sent to the peer
+func (ss *SyncState) LastSentHeads() ([]ChangeHash, error) {
+	defer runtime.KeepAlive(ss)
+	unlock, err := ss.lock()
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	items, err := wrap(C.AMsyncStateLastSentHeads(ss.cSyncState)).items()
+	if err != nil {
+		return nil, err
+	}
+	return mapItems(items, func(i *item) ChangeHash { return i.changeHash() }), nil
+}
+
+// TheirHeads returns the heads of the peer's document as of the last
+// message received. ok is false if no message has been received.
+func (ss *SyncState) TheirHeads() (heads []ChangeHash, ok bool, err error) {
+	defer runtime.KeepAlive(ss)
+	unlock, err := ss.lock()
+	if err != nil {
+		return nil, false, err
+	}
+	defer unlock()
+
+	var has C.bool
+	items, err := wrap(C.AMsyncStateTheirHeads(ss.cSyncState, &has)).items()
+	if err != nil || !has {
+		return nil, false, err
+	}
+	return mapItems(items, func(i *item) ChangeHash { return i.changeHash() }), true, nil
+}
+
+// TheirNeeds returns the hashes of changes that the peer has asked for
+// in the last message received. ok is false if no message has been received.
+func (ss *SyncState) TheirNeeds() (needs []ChangeHash, ok bool, err error) {
+	defer runtime.KeepAlive(ss)
+	unlock, err := ss.lock()
+	if err != nil {
+		return nil, false, err
+	}
+	defer unlock()
+
+	var has C.bool
+	items, err := wrap(C.AMsyncStateTheirNeeds(ss.cSyncState, &has)).items()
+	if err != nil || !has {
+		return nil, false, err
+	}
+	return mapItems(items, func(i *item) ChangeHash { return i.changeHash() }), true, nil
+}
+
+// TheirHaves returns the summaries of the peer's changes from the last
+// message received. ok is false if no message has been received.
+func (ss *SyncState) TheirHaves() (haves []SyncHave, ok bool, err error) {
+	defer runtime.KeepAlive(ss)
+	unlock, err := ss.lock()
+	if err != nil {
+		return nil, false, err
+	}
+	defer unlock()
+
+	var has C.bool
+	items, err := wrap(C.AMsyncStateTheirHaves(ss.cSyncState, &has)).items()
+	if err != nil || !has {
+		return nil, false, err
+	}
+	haves, err = syncHaves(items)
+	if err != nil {
+		return nil, false, err
+	}
+	return haves, true, nil
+}
+
+// Equal returns true if the two sync states are the same. The documents
+// they are associated with are not compared.
+func (ss *SyncState) Equal(other *SyncState) bool {
+	defer runtime.KeepAlive(ss)
+	defer runtime.KeepAlive(other)
+
+	// the documents are locked in a fixed order, so that concurrent calls to
+	// a.Equal(b) and b.Equal(a) cannot deadlock
+	d1, d2 := ss.Doc, other.Doc
+	if uintptr(unsafe.Pointer(d1)) > uintptr(unsafe.Pointer(d2)) {
+		d1, d2 = d2, d1
+	}
+	_, unlock := d1.lock()
+	defer unlock()
+	if d2 != d1 {
+		_, unlock2 := d2.lock()
+		defer unlock2()
+	}
+	if ss.cSyncState == nil || other.cSyncState == nil {
+		return false
+	}
+
+	return bool(C.AMsyncStateEqual(ss.cSyncState, other.cSyncState))
+}
+
+// SyncHave summarizes the changes a peer has. It is sent in a [SyncMessage]
+// so that the other peer can work out which changes to send.
+type SyncHave struct {
+	// LastSync is the set of heads that the sender had in common
+	// with the recipient at the end of their last sync.
+	LastSync []ChangeHash
 }
 
 // SyncMessage is sent between peers to keep copies of a document in sync.
+//
+// If the sending document has signatures for the changes in the message (see
+// [Doc.SetSigner]) they are kept with it. [SyncMessage.Bytes] returns the
+// standard encoding, which other automerge implementations can read, and
+// does not include them; [SyncMessage.SignedBytes] adds them for peers that
+// use this package.
 type SyncMessage struct {
 	item         *item
 	cSyncMessage *C.AMsyncMessage
+	signatures   map[ChangeHash][]byte
 }
 
 // LoadSyncMessage decodes a sync message from a byte slice for inspection.
 func LoadSyncMessage(msg []byte) (*SyncMessage, error) {
+	sigs, msg, err := splitSignedMessage(msg)
+	if err != nil {
+		return nil, err
+	}
 	cBytes, free := toByteSpan(msg)
 	defer free()
 
 	item, err := wrap(C.AMsyncMessageDecode(cBytes.src, cBytes.count)).item()
 	if err != nil {
-		return nil, err
+		return nil, markCorrupt(err)
 	}
-	return item.syncMessage(), nil
+	sm := item.syncMessage()
+	sm.signatures = sigs
+	return sm, nil
 }
 
-// Changes returns any changes included in this SyncMessage
+// Signatures returns the signatures sent with the changes in this message
+func (sm *SyncMessage) Signatures() map[ChangeHash][]byte {
+	ret := map[ChangeHash][]byte{}
+	for h, sig := range sm.signatures {
+		ret[h] = sig
+	}
+	return ret
+}
+
+// Changes returns any changes included in this SyncMessage.
+// It panics if the changes cannot be decoded, see [SyncMessage.TryChanges].
 func (sm *SyncMessage) Changes() []*Change {
-	defer runtime.KeepAlive(sm)
+	return must(sm.TryChanges())
+}
 
-	items := must(wrap(C.AMsyncMessageChanges(sm.cSyncMessage)).items())
+// TryChanges is like [SyncMessage.Changes] but returns an error instead of
+// panicking
+func (sm *SyncMessage) TryChanges() ([]*Change, error) {
+	defer runtime.KeepAlive(sm)
 
-	return mapItems(items, func(i *item) *Change { return i.change() })
+	items, err := wrap(C.AMsyncMessageChanges(sm.cSyncMessage)).items()
+	if err != nil {
+		return nil, err
+	}
+	return mapItems(items, func(i *item) *Change { return i.change() }), nil
 }
 
 // Heads gives the heads of the peer that generated the SyncMessage
+// It panics if the heads cannot be read, see [SyncMessage.TryHeads].
 func (sm *SyncMessage) Heads() []ChangeHash {
+	return must(sm.TryHeads())
+}
+
+// TryHeads is like [SyncMessage.Heads] but returns an error instead of
+// panicking
+func (sm *SyncMessage) TryHeads() ([]ChangeHash, error) {
 	defer runtime.KeepAlive(sm)
 
-	items := must(wrap(C.AMsyncMessageHeads(sm.cSyncMessage)).items())
+	items, err := wrap(C.AMsyncMessageHeads(sm.cSyncMessage)).items()
+	if err != nil {
+		return nil, err
+	}
+	return mapItems(items, func(i *item) ChangeHash { return i.changeHash() }), nil
+}
+
+// Haves gives summaries of the changes that the peer that
+// generated the SyncMessage has
+// It panics if the summaries cannot be read, see [SyncMessage.TryHaves].
+func (sm *SyncMessage) Haves() []SyncHave {
+	return must(sm.TryHaves())
+}
+
+// TryHaves is like [SyncMessage.Haves] but returns an error instead of
+// panicking
+func (sm *SyncMessage) TryHaves() ([]SyncHave, error) {
+	defer runtime.KeepAlive(sm)
+
+	items, err := wrap(C.AMsyncMessageHaves(sm.cSyncMessage)).items()
+	if err != nil {
+		return nil, err
+	}
+	return syncHaves(items)
+}
 
-	return mapItems(items, func(i *item) ChangeHash { return i.changeHash() })
+// Needs gives the hashes of changes that the peer that generated the
+// SyncMessage is missing and has asked for
+// It panics if the hashes cannot be read, see [SyncMessage.TryNeeds].
+func (sm *SyncMessage) Needs() []ChangeHash {
+	return must(sm.TryNeeds())
+}
+
+// TryNeeds is like [SyncMessage.Needs] but returns an error instead of
+// panicking
+func (sm *SyncMessage) TryNeeds() ([]ChangeHash, error) {
+	defer runtime.KeepAlive(sm)
+
+	items, err := wrap(C.AMsyncMessageNeeds(sm.cSyncMessage)).items()
+	if err != nil {
+		return nil, err
+	}
+	return mapItems(items, func(i *item) ChangeHash { return i.changeHash() }), nil
 }
 
 // Bytes returns a representation for sending over the network.
+// It panics if the message cannot be encoded, see [SyncMessage.TryBytes].
 func (sm *SyncMessage) Bytes() []byte {
+	return must(sm.TryBytes())
+}
+
+// TryBytes is like [SyncMessage.Bytes] but returns an error instead of
+// panicking
+func (sm *SyncMessage) TryBytes() ([]byte, error) {
 	if sm == nil {
-		return nil
+		return nil, nil
 	}
 	defer runtime.KeepAlive(sm)
-	return must(wrap(C.AMsyncMessageEncode(sm.cSyncMessage)).item()).bytes()
+	item, err := wrap(C.AMsyncMessageEncode(sm.cSyncMessage)).item()
+	if err != nil {
+		return nil, err
+	}
+	return item.bytes(), nil
+}
+
+// SignedBytes is like [SyncMessage.Bytes], but includes the signatures of the
+// changes in the message. Only [LoadSyncMessage] and [SyncState.ReceiveMessage]
+// in this package can read the result, so it should only be sent to peers that
+// use this package. If the message has no signatures it returns the same as
+// [SyncMessage.Bytes].
+// It panics if the message cannot be encoded, see [SyncMessage.TrySignedBytes].
+func (sm *SyncMessage) SignedBytes() []byte {
+	return must(sm.TrySignedBytes())
+}
+
+// TrySignedBytes is like [SyncMessage.SignedBytes] but returns an error
+// instead of panicking
+func (sm *SyncMessage) TrySignedBytes() ([]byte, error) {
+	b, err := sm.TryBytes()
+	if err != nil || len(sm.signatures) == 0 {
+		return b, err
+	}
+	return appendSignedMessage(sm.signatures, b), nil
 }
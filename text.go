@@ -70,7 +70,7 @@ func (t *Text) Len() int {
 // Get returns the current value as a string
 func (t *Text) Get() (string, error) {
 	if t.doc == nil {
-		return "", fmt.Errorf("automerge.Text: tried to read detached text")
+		return "", newError(ErrDetached, "automerge.Text: tried to read detached text")
 	}
 	if t.path != nil {
 		v, err := t.path.Get()
@@ -83,7 +83,7 @@ func (t *Text) Get() (string, error) {
 		case KindText:
 			return v.Text().Get()
 		default:
-			return "", fmt.Errorf("automerge.Text: tried to read non-text value %#v", v.val)
+			return "", kindMismatch(t.path, KindText, v.Kind(), "automerge.Text: tried to read non-text value %#v", v.val)
 		}
 	}
 
@@ -127,7 +127,7 @@ func (t *Text) Splice(pos int, del int, s string) error {
 
 func (t *Text) splice(pos C.size_t, del C.ptrdiff_t, s string) error {
 	if t.doc == nil {
-		return fmt.Errorf("automerge.Text: tried to write to detached text")
+		return newError(ErrDetached, "automerge.Text: tried to write to detached text")
 	}
 	if t.path != nil {
 		t2, err := t.path.ensureText()
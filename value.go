@@ -175,47 +175,47 @@ func (v *Value) assertKind(k Kind) {
 // It recursively converts automerge.Map to map[string]any,
 // automerge.List to []any, automerge.Text to string, and
 // automerge.Counter to int64.
+// It panics if the value cannot be read, see [Value.TryInterface].
 func (v *Value) Interface() any {
+	return must(v.TryInterface())
+}
+
+// TryInterface is like [Value.Interface] but returns an error instead of
+// panicking
+func (v *Value) TryInterface() (any, error) {
 	switch v.kind {
 	case KindMap:
 		values, err := v.Map().Values()
-		// this should not be able to happen because .load() is only
-		// called from Value.Interface() which checks that this is a map.
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 		out := map[string]any{}
 		for k, v := range values {
-			out[k] = v.Interface()
+			if out[k], err = v.TryInterface(); err != nil {
+				return nil, err
+			}
 		}
-		return out
+		return out, nil
 	case KindList:
 		values, err := v.List().Values()
-		// cannot happen as we know the value must have come from the doc
 		if err != nil {
-			panic(err)
+			return nil, err
 		}
 		out := []any{}
 		for _, v := range values {
-			out = append(out, v.Interface())
+			i, err := v.TryInterface()
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, i)
 		}
-		return out
+		return out, nil
 	case KindText:
-		s, err := v.Text().Get()
-		// cannot happen as we know the value must have come from the doc
-		if err != nil {
-			panic(err)
-		}
-		return s
+		return v.Text().Get()
 	case KindCounter:
-		c, err := v.Counter().Get()
-		// cannot happen as we know the value must have come from the doc
-		if err != nil {
-			panic(err)
-		}
-		return c
+		return v.Counter().Get()
 	default:
-		return v.val
+		return v.val, nil
 	}
 }
 
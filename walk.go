@@ -0,0 +1,100 @@
+package automerge
+
+import (
+	"errors"
+)
+
+// SkipChildren can be returned from a [WalkFunc] to skip
+// the children of the current [Map] or [List].
+// It is not returned as an error by [Doc.Walk] or [Value.Walk].
+var SkipChildren = errors.New("automerge: skip children")
+
+// WalkFunc is called for each value visited by [Doc.Walk] or [Value.Walk].
+// If it returns [SkipChildren] the children of the current value are not visited,
+// any other non-nil error stops the walk and is returned to the caller.
+type WalkFunc func(p *Path, v *Value) error
+
+// WalkOptions are (rarer) options passed to [Doc.Walk] or [Value.Walk].
+// If Post is set it is called after all children of a value have been visited
+// (including when the children were skipped).
+// If MaxDepth is greater than zero then values more than MaxDepth levels below the
+// starting value are not visited (the direct children of the root are at depth 1).
+// If Heads is set then [Doc.Walk] visits the document as of those heads,
+// it is ignored by [Value.Walk].
+type WalkOptions struct {
+	Post     WalkFunc
+	MaxDepth int
+	Heads    []ChangeHash
+}
+
+// Walk calls fn for every value in the document in depth-first order,
+// starting with the root. Map keys are visited in sorted order, and list items
+// in order. [Text] and [Counter] values are visited as leaves.
+// If Heads is set in the options, Walk visits a fork of the document as of those
+// heads and the paths passed to fn refer to that fork.
+func (d *Doc) Walk(fn WalkFunc, opts ...WalkOptions) error {
+	o := mergeWalkOptions(opts)
+	if len(o.Heads) > 0 {
+		var err error
+		d, err = d.Fork(o.Heads...)
+		if err != nil {
+			return err
+		}
+	}
+	return walk(d.Path(), d.Root(), 0, fn, o)
+}
+
+// Walk calls fn for v and every value nested within it in depth-first order.
+// See [Doc.Walk] for details. The paths passed to fn are detached and relative
+// to v, use [Doc.Path] to resolve them against a document.
+func (v *Value) Walk(fn WalkFunc, opts ...WalkOptions) error {
+	return walk(&Path{path: []any{}}, v, 0, fn, mergeWalkOptions(opts))
+}
+
+func mergeWalkOptions(opts []WalkOptions) WalkOptions {
+	ret := WalkOptions{}
+	for _, o := range opts {
+		if o.Post != nil {
+			ret.Post = o.Post
+		}
+		if o.MaxDepth != 0 {
+			ret.MaxDepth = o.MaxDepth
+		}
+		if o.Heads != nil {
+			ret.Heads = o.Heads
+		}
+	}
+	return ret
+}
+
+func walk(p *Path, v *Value, depth int, fn WalkFunc, o WalkOptions) error {
+	if err := fn(p, v); err != nil {
+		if err != SkipChildren {
+			return err
+		}
+	} else if o.MaxDepth <= 0 || depth < o.MaxDepth {
+		if err := walkChildren(p, v, depth, fn, o); err != nil {
+			return err
+		}
+	}
+
+	if o.Post != nil {
+		if err := o.Post(p, v); err != nil && err != SkipChildren {
+			return err
+		}
+	}
+	return nil
+}
+
+func walkChildren(p *Path, v *Value, depth int, fn WalkFunc, o WalkOptions) error {
+	cs, err := children(p, v)
+	if err != nil {
+		return err
+	}
+	for _, c := range cs {
+		if err := walk(c.Path, c.Value, depth+1, fn, o); err != nil {
+			return err
+		}
+	}
+	return nil
+}
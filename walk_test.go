@@ -0,0 +1,94 @@
+package automerge_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/automerge/automerge-go"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDoc_Walk(t *testing.T) {
+	doc := automerge.New()
+	require.NoError(t, doc.Path("b", "list").Set([]any{1, map[string]any{"x": true}}))
+	require.NoError(t, doc.Path("a").Set(automerge.NewText("hi")))
+	require.NoError(t, doc.Path("c").Set(automerge.NewCounter(3)))
+	heads, err := doc.Commit("initial")
+	require.NoError(t, err)
+
+	visited := []string{}
+	err = doc.Walk(func(p *automerge.Path, v *automerge.Value) error {
+		visited = append(visited, fmt.Sprintf("pre %s %s", p, v.Kind()))
+		return nil
+	}, automerge.WalkOptions{Post: func(p *automerge.Path, v *automerge.Value) error {
+		if v.Kind() == automerge.KindMap || v.Kind() == automerge.KindList {
+			visited = append(visited, fmt.Sprintf("post %s", p))
+		}
+		return nil
+	}})
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"pre  KindMap",
+		"pre a KindText",
+		"pre b KindMap",
+		"pre b.list KindList",
+		"pre b.list[0] KindFloat64",
+		"pre b.list[1] KindMap",
+		"pre b.list[1].x KindBool",
+		"post b.list[1]",
+		"post b.list",
+		"post b",
+		"pre c KindCounter",
+		"post ",
+	}, visited)
+
+	visited = []string{}
+	err = doc.Walk(func(p *automerge.Path, v *automerge.Value) error {
+		visited = append(visited, p.String())
+		if p.String() == "b.list" {
+			return automerge.SkipChildren
+		}
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"", "a", "b", "b.list", "c"}, visited)
+
+	visited = []string{}
+	err = doc.Walk(func(p *automerge.Path, v *automerge.Value) error {
+		visited = append(visited, p.String())
+		return nil
+	}, automerge.WalkOptions{MaxDepth: 1})
+	require.NoError(t, err)
+	require.Equal(t, []string{"", "a", "b", "c"}, visited)
+
+	stop := fmt.Errorf("stop")
+	err = doc.Walk(func(p *automerge.Path, v *automerge.Value) error {
+		if p.String() == "b.list[0]" {
+			return stop
+		}
+		return nil
+	})
+	require.Equal(t, stop, err)
+
+	require.NoError(t, doc.Path("d").Set("new"))
+	_, err = doc.Commit("more")
+	require.NoError(t, err)
+
+	visited = []string{}
+	err = doc.Walk(func(p *automerge.Path, v *automerge.Value) error {
+		visited = append(visited, p.String())
+		return nil
+	}, automerge.WalkOptions{Heads: []automerge.ChangeHash{heads}, MaxDepth: 1})
+	require.NoError(t, err)
+	require.Equal(t, []string{"", "a", "b", "c"}, visited)
+
+	visited = []string{}
+	v, err := doc.Path("b").Get()
+	require.NoError(t, err)
+	err = v.Walk(func(p *automerge.Path, v *automerge.Value) error {
+		visited = append(visited, p.String())
+		return nil
+	})
+	require.NoError(t, err)
+	require.Equal(t, []string{"", "list", "list[0]", "list[1]", "list[1].x"}, visited)
+}
@@ -0,0 +1,319 @@
+package ws
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// DefaultMaxMessageSize is the largest message a [Conn] will read,
+// unless configured otherwise.
+const DefaultMaxMessageSize = 64 << 20
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// Close status codes from RFC 6455 section 7.4.1
+const (
+	StatusNormalClosure   = 1000
+	StatusGoingAway       = 1001
+	StatusProtocolError   = 1002
+	StatusUnsupportedData = 1003
+	StatusNoStatus        = 1005
+	StatusInvalidPayload  = 1007
+	StatusMessageTooBig   = 1009
+	StatusInternalError   = 1011
+)
+
+// CloseError is returned by [Conn.ReadMessage] when the peer closes the
+// connection with a status other than [StatusNormalClosure] or [StatusGoingAway].
+type CloseError struct {
+	Code   int
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("ws: closed with status %d", e.Code)
+	}
+	return fmt.Sprintf("ws: closed with status %d: %s", e.Code, e.Reason)
+}
+
+// ErrClosed is returned when writing to a connection after it has been closed
+var ErrClosed = errors.New("ws: connection closed")
+
+// Conn is a WebSocket connection. Create one with [Upgrader.Upgrade] or [Dial].
+//
+// ReadMessage must only be called from one goroutine at a time, but
+// WriteMessage and Close may be called concurrently with it and with
+// each other.
+type Conn struct {
+	conn   net.Conn
+	br     *bufio.Reader
+	client bool
+
+	// MaxMessageSize is the largest message that ReadMessage will accept.
+	// Larger messages close the connection with [StatusMessageTooBig].
+	MaxMessageSize int
+
+	wm     sync.Mutex
+	closed bool
+}
+
+func newConn(conn net.Conn, br *bufio.Reader, client bool) *Conn {
+	if br == nil {
+		br = bufio.NewReader(conn)
+	}
+	return &Conn{conn: conn, br: br, client: client, MaxMessageSize: DefaultMaxMessageSize}
+}
+
+// NetConn returns the underlying network connection
+func (c *Conn) NetConn() net.Conn {
+	return c.conn
+}
+
+// ReadMessage returns the payload of the next text or binary message.
+// Pings are answered automatically. When the peer closes the connection
+// normally ReadMessage returns [io.EOF].
+func (c *Conn) ReadMessage() ([]byte, error) {
+	var msg []byte
+	var op byte
+	for {
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		switch opcode {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil && err != ErrClosed {
+				return nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			return nil, c.handleClose(payload)
+		case opContinuation:
+			if op == 0 {
+				return nil, c.fail(StatusProtocolError, "unexpected continuation frame")
+			}
+		case opText, opBinary:
+			if op != 0 {
+				return nil, c.fail(StatusProtocolError, "expected continuation frame")
+			}
+			op = opcode
+		default:
+			return nil, c.fail(StatusProtocolError, fmt.Sprintf("unknown opcode %d", opcode))
+		}
+
+		if len(msg)+len(payload) > c.MaxMessageSize {
+			return nil, c.fail(StatusMessageTooBig, "message too big")
+		}
+		msg = append(msg, payload...)
+		if fin {
+			break
+		}
+	}
+
+	if op == opText && !utf8.Valid(msg) {
+		return nil, c.fail(StatusInvalidPayload, "invalid utf-8")
+	}
+	if msg == nil {
+		msg = []byte{}
+	}
+	return msg, nil
+}
+
+// WriteMessage sends msg as a single binary message
+func (c *Conn) WriteMessage(msg []byte) error {
+	return c.writeFrame(opBinary, msg)
+}
+
+// WriteText sends msg as a single text message
+func (c *Conn) WriteText(msg string) error {
+	return c.writeFrame(opText, []byte(msg))
+}
+
+// Ping sends a ping to the peer, the pong is handled by ReadMessage.
+func (c *Conn) Ping() error {
+	return c.writeFrame(opPing, nil)
+}
+
+// Close sends a close message with [StatusNormalClosure] and closes the
+// underlying connection.
+func (c *Conn) Close() error {
+	return c.CloseWithStatus(StatusNormalClosure, "")
+}
+
+// CloseWithStatus sends a close message with the given status and closes
+// the underlying connection.
+func (c *Conn) CloseWithStatus(code int, reason string) error {
+	c.writeClose(code, reason)
+	return c.conn.Close()
+}
+
+func (c *Conn) writeClose(code int, reason string) {
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	payload := make([]byte, 2, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	payload = append(payload, reason...)
+
+	c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+	c.writeFrame(opClose, payload)
+}
+
+func (c *Conn) fail(code int, reason string) error {
+	c.writeClose(code, reason)
+	c.conn.Close()
+	return fmt.Errorf("ws: %s", reason)
+}
+
+func (c *Conn) handleClose(payload []byte) error {
+	code, reason := StatusNoStatus, ""
+	if len(payload) >= 2 {
+		code = int(binary.BigEndian.Uint16(payload))
+		reason = string(payload[2:])
+	} else if len(payload) == 1 {
+		return c.fail(StatusProtocolError, "invalid close frame")
+	}
+
+	// echo the status back, as required by RFC 6455 section 5.5.1
+	echo := code
+	if echo == StatusNoStatus {
+		echo = StatusNormalClosure
+	}
+	c.writeClose(echo, "")
+	c.conn.Close()
+
+	if code == StatusNormalClosure || code == StatusGoingAway || code == StatusNoStatus {
+		return io.EOF
+	}
+	return &CloseError{Code: code, Reason: reason}
+}
+
+func (c *Conn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var h [2]byte
+	if _, err = io.ReadFull(c.br, h[:]); err != nil {
+		return
+	}
+	fin = h[0]&0x80 != 0
+	opcode = h[0] & 0x0f
+	if h[0]&0x70 != 0 {
+		return false, 0, nil, c.fail(StatusProtocolError, "unexpected reserved bits")
+	}
+	masked := h[1]&0x80 != 0
+	if masked == c.client {
+		if c.client {
+			return false, 0, nil, c.fail(StatusProtocolError, "server frames must not be masked")
+		}
+		return false, 0, nil, c.fail(StatusProtocolError, "client frames must be masked")
+	}
+
+	length := uint64(h[1] & 0x7f)
+	switch length {
+	case 126:
+		var b [2]byte
+		if _, err = io.ReadFull(c.br, b[:]); err != nil {
+			return
+		}
+		length = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err = io.ReadFull(c.br, b[:]); err != nil {
+			return
+		}
+		length = binary.BigEndian.Uint64(b[:])
+	}
+
+	if opcode >= opClose {
+		if !fin || length > 125 {
+			return false, 0, nil, c.fail(StatusProtocolError, "invalid control frame")
+		}
+	} else if length > uint64(c.MaxMessageSize) {
+		return false, 0, nil, c.fail(StatusMessageTooBig, "message too big")
+	}
+
+	var mask [4]byte
+	if masked {
+		if _, err = io.ReadFull(c.br, mask[:]); err != nil {
+			return
+		}
+	}
+
+	payload = make([]byte, length)
+	if _, err = io.ReadFull(c.br, payload); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return
+	}
+	if masked {
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+	}
+	return fin, opcode, payload, nil
+}
+
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	c.wm.Lock()
+	defer c.wm.Unlock()
+	if c.closed {
+		return ErrClosed
+	}
+	if opcode == opClose {
+		c.closed = true
+	}
+
+	buf := make([]byte, 0, 14+len(payload))
+	buf = append(buf, 0x80|opcode)
+
+	maskBit := byte(0)
+	if c.client {
+		maskBit = 0x80
+	}
+	switch n := len(payload); {
+	case n <= 125:
+		buf = append(buf, maskBit|byte(n))
+	case n <= 0xffff:
+		buf = append(buf, maskBit|126)
+		buf = binary.BigEndian.AppendUint16(buf, uint16(n))
+	default:
+		buf = append(buf, maskBit|127)
+		buf = binary.BigEndian.AppendUint64(buf, uint64(n))
+	}
+
+	if c.client {
+		var mask [4]byte
+		if _, err := rand.Read(mask[:]); err != nil {
+			return err
+		}
+		buf = append(buf, mask[:]...)
+		start := len(buf)
+		buf = append(buf, payload...)
+		for i := range buf[start:] {
+			buf[start+i] ^= mask[i%4]
+		}
+	} else {
+		buf = append(buf, payload...)
+	}
+
+	_, err := c.conn.Write(buf)
+	return err
+}
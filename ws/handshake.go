@@ -0,0 +1,243 @@
+package ws
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+func acceptKey(key string) string {
+	h := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
+
+// Upgrader upgrades HTTP requests to WebSocket connections
+type Upgrader struct {
+	// CheckOrigin returns true if a request from the given origin should be
+	// accepted. If nil, requests are accepted if they have no Origin header,
+	// or if the host of the Origin matches the Host header.
+	CheckOrigin func(r *http.Request) bool
+	// MaxMessageSize is copied to [Conn.MaxMessageSize].
+	// Defaults to [DefaultMaxMessageSize].
+	MaxMessageSize int
+}
+
+// Upgrade completes the WebSocket handshake. If the request is not a valid
+// WebSocket request an error response is written to w and an error is returned.
+func (u *Upgrader) Upgrade(w http.ResponseWriter, r *http.Request) (*Conn, error) {
+	fail := func(status int, msg string) (*Conn, error) {
+		http.Error(w, msg, status)
+		return nil, fmt.Errorf("ws: %s", msg)
+	}
+
+	if r.Method != http.MethodGet {
+		return fail(http.StatusMethodNotAllowed, "websocket handshake must use GET")
+	}
+	if !headerContains(r.Header, "Connection", "upgrade") || !headerContains(r.Header, "Upgrade", "websocket") {
+		return fail(http.StatusUpgradeRequired, "expected websocket upgrade")
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return fail(http.StatusUpgradeRequired, "unsupported websocket version")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if b, err := base64.StdEncoding.DecodeString(key); err != nil || len(b) != 16 {
+		return fail(http.StatusBadRequest, "invalid Sec-WebSocket-Key")
+	}
+	checkOrigin := u.CheckOrigin
+	if checkOrigin == nil {
+		checkOrigin = sameOrigin
+	}
+	if !checkOrigin(r) {
+		return fail(http.StatusForbidden, "origin not allowed")
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		return fail(http.StatusInternalServerError, "response does not support hijacking")
+	}
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n\r\n"
+	// the handshake is small, so ignore any deadline set by the server
+	conn.SetDeadline(time.Time{})
+	if _, err := conn.Write([]byte(resp)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	c := newConn(conn, brw.Reader, false)
+	if u.MaxMessageSize != 0 {
+		c.MaxMessageSize = u.MaxMessageSize
+	}
+	return c, nil
+}
+
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// DialOptions configure [Dial]
+type DialOptions struct {
+	// Header is added to the handshake request, for example to set an
+	// Authorization or Origin header.
+	Header http.Header
+	// TLSConfig is used for wss:// URLs. If nil the default configuration is used.
+	TLSConfig *tls.Config
+	// MaxMessageSize is copied to [Conn.MaxMessageSize].
+	// Defaults to [DefaultMaxMessageSize].
+	MaxMessageSize int
+}
+
+// Dial opens a WebSocket connection to a ws:// or wss:// URL. The context
+// only applies to establishing the connection.
+func Dial(ctx context.Context, rawURL string, opts ...DialOptions) (*Conn, error) {
+	o := DialOptions{}
+	for _, opt := range opts {
+		if opt.Header != nil {
+			o.Header = opt.Header
+		}
+		if opt.TLSConfig != nil {
+			o.TLSConfig = opt.TLSConfig
+		}
+		if opt.MaxMessageSize != 0 {
+			o.MaxMessageSize = opt.MaxMessageSize
+		}
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	host := u.Host
+	var conn net.Conn
+	switch u.Scheme {
+	case "ws":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "80")
+		}
+		conn, err = (&net.Dialer{}).DialContext(ctx, "tcp", host)
+	case "wss":
+		if u.Port() == "" {
+			host = net.JoinHostPort(u.Hostname(), "443")
+		}
+		conn, err = (&tls.Dialer{Config: o.TLSConfig}).DialContext(ctx, "tcp", host)
+	default:
+		return nil, fmt.Errorf("ws: unsupported scheme %q", u.Scheme)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := handshake(ctx, conn, u, o)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func handshake(ctx context.Context, conn net.Conn, u *url.URL, o DialOptions) (*Conn, error) {
+	// abort the handshake if ctx is done, and reset the deadline afterwards
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+	done, exited := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-done:
+		}
+	}()
+	defer func() {
+		close(done)
+		<-exited
+		conn.SetDeadline(time.Time{})
+	}()
+
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	key := base64.StdEncoding.EncodeToString(b)
+
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        &url.URL{Path: u.Path, RawPath: u.RawPath, RawQuery: u.RawQuery},
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{},
+		Host:       u.Host,
+	}
+	if req.URL.Path == "" {
+		req.URL.Path = "/"
+	}
+	for k, v := range o.Header {
+		req.Header[k] = v
+	}
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	if err := req.Write(conn); err != nil {
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		resp.Body.Close()
+		return nil, fmt.Errorf("ws: handshake failed with status %s", resp.Status)
+	}
+	if resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(key) {
+		return nil, fmt.Errorf("ws: invalid Sec-WebSocket-Accept")
+	}
+
+	c := newConn(conn, br, true)
+	if o.MaxMessageSize != 0 {
+		c.MaxMessageSize = o.MaxMessageSize
+	}
+	return c, nil
+}
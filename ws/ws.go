@@ -0,0 +1,132 @@
+// Package ws syncs automerge documents over WebSockets.
+//
+// It contains a small implementation of RFC 6455 built on the standard library,
+// a [Handler] that syncs documents with each connection using [automerge.SyncMessageConn],
+// and a [RepoHandler] that connects JavaScript automerge-repo clients to a [repo.Repo].
+//
+// To serve a single document:
+//
+//	http.Handle("/sync", ws.NewHandler(doc))
+//
+// and to sync with it from another process:
+//
+//	conn, err := ws.Dial(ctx, "ws://localhost:8080/sync")
+//	defer conn.Close()
+//	err = automerge.SyncMessageConn(ctx, doc, conn)
+//
+// Each WebSocket message contains exactly one sync message, as produced by
+// [automerge.SyncMessage.Bytes].
+package ws
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/automerge/automerge-go"
+	"github.com/automerge/automerge-go/repo"
+)
+
+// Handler accepts WebSocket connections and syncs a document with each one.
+type Handler struct {
+	Upgrader
+
+	// Doc returns the document to sync for a request (for example based on
+	// the URL path). If it returns an error the request fails with
+	// 404 Not Found and the error is not reported to OnError.
+	Doc func(r *http.Request) (*automerge.Doc, error)
+
+	// OnError is called if a connection ends with an error
+	OnError func(r *http.Request, err error)
+}
+
+// NewHandler returns a handler that syncs doc with every connection
+func NewHandler(doc *automerge.Doc) *Handler {
+	return &Handler{Doc: func(*http.Request) (*automerge.Doc, error) { return doc, nil }}
+}
+
+// ServeHTTP implements [http.Handler]
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	doc, err := h.Doc(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	conn, err := h.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	h.reportError(r, automerge.SyncMessageConn(r.Context(), doc, conn))
+}
+
+func (h *Handler) reportError(r *http.Request, err error) {
+	if err == nil || errors.Is(err, io.EOF) || errors.Is(err, context.Canceled) {
+		return
+	}
+	if h.OnError != nil {
+		h.OnError(r, err)
+	}
+}
+
+// RepoHandler accepts WebSocket connections from automerge-repo peers, for
+// example the JavaScript BrowserWebSocketClientAdapter, and connects them
+// to a [repo.Repo].
+type RepoHandler struct {
+	Upgrader
+
+	Repo *repo.Repo
+	// PeerMetadata is sent to each peer during the handshake
+	PeerMetadata repo.PeerMetadata
+
+	// OnError is called if a connection ends with an error
+	OnError func(r *http.Request, err error)
+}
+
+// ServeHTTP implements [http.Handler]
+func (h *RepoHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := h.Upgrade(w, r)
+	if err != nil {
+		return
+	}
+
+	adapter := repo.NewConnAdapter(conn, repo.ConnOptions{Server: true, PeerMetadata: h.PeerMetadata})
+	if err := h.Repo.Connect(adapter); err != nil {
+		conn.CloseWithStatus(StatusGoingAway, "")
+		h.reportError(r, err)
+		return
+	}
+
+	select {
+	case <-adapter.Done():
+	case <-r.Context().Done():
+	}
+	err = adapter.Err()
+	h.Repo.Disconnect(adapter)
+	h.reportError(r, err)
+}
+
+func (h *RepoHandler) reportError(r *http.Request, err error) {
+	if err != nil && h.OnError != nil {
+		h.OnError(r, err)
+	}
+}
+
+// DialRepo connects to an automerge-repo WebSocket server (for example
+// automerge-repo-sync-server, or a [RepoHandler]) and adds the connection
+// to r.
+func DialRepo(ctx context.Context, r *repo.Repo, rawURL string, opts ...DialOptions) (*repo.ConnAdapter, error) {
+	conn, err := Dial(ctx, rawURL, opts...)
+	if err != nil {
+		return nil, err
+	}
+	adapter := repo.NewConnAdapter(conn, repo.ConnOptions{})
+	if err := r.Connect(adapter); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return adapter, nil
+}
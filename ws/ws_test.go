@@ -0,0 +1,201 @@
+package ws_test
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/automerge/automerge-go"
+	"github.com/automerge/automerge-go/repo"
+	"github.com/automerge/automerge-go/ws"
+	"github.com/stretchr/testify/require"
+)
+
+func wsURL(s *httptest.Server, path string) string {
+	return "ws" + strings.TrimPrefix(s.URL, "http") + path
+}
+
+func getString(d *automerge.Doc, key string) string {
+	v, _ := automerge.As[string](d.Path(key).Get())
+	return v
+}
+
+func TestHandler(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	docs := map[string]*automerge.Doc{"/a": automerge.New(), "/b": automerge.New()}
+	for name, doc := range docs {
+		require.NoError(t, doc.Path("name").Set(name))
+		_, err := doc.Commit("set name")
+		require.NoError(t, err)
+	}
+
+	errs := make(chan error, 10)
+	s := httptest.NewServer(&ws.Handler{
+		Doc: func(r *http.Request) (*automerge.Doc, error) {
+			if d, ok := docs[r.URL.Path]; ok {
+				return d, nil
+			}
+			return nil, fmt.Errorf("no such document")
+		},
+		OnError: func(r *http.Request, err error) { errs <- err },
+	})
+	defer s.Close()
+
+	local := automerge.New()
+	conn, err := ws.Dial(ctx, wsURL(s, "/b"))
+	require.NoError(t, err)
+
+	done := make(chan error, 1)
+	go func() { done <- automerge.SyncMessageConn(ctx, local, conn) }()
+
+	require.Eventually(t, func() bool { return getString(local, "name") == "/b" }, 5*time.Second, time.Millisecond)
+
+	require.NoError(t, local.Path("client").Set("hello"))
+	_, err = local.Commit("client")
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return getString(docs["/b"], "client") == "hello" }, 5*time.Second, time.Millisecond)
+	require.Equal(t, "", getString(docs["/a"], "client"))
+
+	require.NoError(t, conn.Close())
+	<-done
+
+	_, err = ws.Dial(ctx, wsURL(s, "/c"))
+	require.EqualError(t, err, "ws: handshake failed with status 404 Not Found")
+
+	_, err = ws.Dial(ctx, wsURL(s, "/a"), ws.DialOptions{Header: http.Header{"Origin": {"http://example.com"}}})
+	require.EqualError(t, err, "ws: handshake failed with status 403 Forbidden")
+
+	resp, err := http.Get(s.URL + "/a")
+	require.NoError(t, err)
+	resp.Body.Close()
+	require.Equal(t, http.StatusUpgradeRequired, resp.StatusCode)
+
+	select {
+	case err := <-errs:
+		t.Fatal(err)
+	default:
+	}
+}
+
+func TestRepoHandler(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	server, err := repo.New(repo.Options{PeerID: "server"})
+	require.NoError(t, err)
+	defer server.Close()
+	client, err := repo.New(repo.Options{PeerID: "client"})
+	require.NoError(t, err)
+	defer client.Close()
+
+	s := httptest.NewServer(&ws.RepoHandler{Repo: server, PeerMetadata: repo.PeerMetadata{StorageID: "s1"}})
+	defer s.Close()
+
+	h, err := server.Create()
+	require.NoError(t, err)
+	require.NoError(t, h.Doc().Path("title").Set("hello"))
+	_, err = h.Doc().Commit("title")
+	require.NoError(t, err)
+
+	adapter, err := ws.DialRepo(ctx, client, wsURL(s, "/"))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return len(client.Peers()) == 1 }, 5*time.Second, time.Millisecond)
+	_, meta := adapter.RemotePeer()
+	require.Equal(t, "s1", meta.StorageID)
+
+	h2, err := client.Find(ctx, h.ID())
+	require.NoError(t, err)
+	require.Eventually(t, func() bool { return getString(h2.Doc(), "title") == "hello" }, 5*time.Second, time.Millisecond)
+
+	require.NoError(t, client.Disconnect(adapter))
+	require.Eventually(t, func() bool { return len(server.Peers()) == 0 }, 5*time.Second, time.Millisecond)
+}
+
+func TestConn(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	raw := make(chan *ws.Conn, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&ws.Upgrader{MaxMessageSize: 1 << 20}).Upgrade(w, r)
+		if err != nil {
+			return
+		}
+		if r.URL.Path == "/raw" {
+			raw <- conn
+			return
+		}
+		defer conn.Close()
+		for {
+			msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if err := conn.WriteMessage(msg); err != nil {
+				return
+			}
+		}
+	}))
+	defer s.Close()
+
+	// echo messages of each length encoding
+	conn, err := ws.Dial(ctx, wsURL(s, "/echo"))
+	require.NoError(t, err)
+	for _, n := range []int{0, 125, 126, 65535, 65536, 1 << 20} {
+		msg := bytes.Repeat([]byte{byte(n)}, n)
+		require.NoError(t, conn.WriteMessage(msg))
+		got, err := conn.ReadMessage()
+		require.NoError(t, err)
+		require.Equal(t, msg, got)
+	}
+	require.NoError(t, conn.Ping())
+
+	// messages larger than the limit close the connection
+	require.NoError(t, conn.WriteMessage(make([]byte, 1<<20+1)))
+	_, err = conn.ReadMessage()
+	require.EqualError(t, err, "ws: closed with status 1009: message too big")
+
+	// fragmented messages with an interleaved ping
+	conn, err = ws.Dial(ctx, wsURL(s, "/raw"))
+	require.NoError(t, err)
+	server := <-raw
+	_, err = server.NetConn().Write([]byte{
+		0x01, 3, 'h', 'e', 'l', // text, not final
+		0x89, 1, 'p', // ping
+		0x80, 2, 'l', 'o', // continuation, final
+	})
+	require.NoError(t, err)
+	msg, err := conn.ReadMessage()
+	require.NoError(t, err)
+	require.Equal(t, "hello", string(msg))
+
+	pong := make([]byte, 7)
+	_, err = io.ReadFull(server.NetConn(), pong)
+	require.NoError(t, err)
+	require.Equal(t, []byte{0x8a, 0x81}, pong[:2])
+	require.Equal(t, byte('p'), pong[6]^pong[2])
+
+	// a normal close is reported as io.EOF
+	require.NoError(t, server.Close())
+	_, err = conn.ReadMessage()
+	require.Equal(t, io.EOF, err)
+
+	// unmasked frames from the client are rejected
+	conn, err = ws.Dial(ctx, wsURL(s, "/raw"))
+	require.NoError(t, err)
+	server = <-raw
+	_, err = conn.NetConn().Write([]byte{0x82, 1, 'x'})
+	require.NoError(t, err)
+	_, err = server.ReadMessage()
+	require.EqualError(t, err, "ws: client frames must be masked")
+	_, err = conn.ReadMessage()
+	require.EqualError(t, err, "ws: closed with status 1002: client frames must be masked")
+}